@@ -6,8 +6,11 @@ import (
 )
 
 // Deque is a head-tail linked list data structure implementation.
-// It is based on a doubly linked list container, so that every
-// operations time complexity is O(1).
+// It is based on a doubly linked list container, so that the operations
+// on its ends are O(1). The index-based operations, At, InsertAt,
+// RemoveAt and Swap, walk the list from its nearest end, in a
+// O(min(i, n-i)) time complexity: use generic.Deque, based on a ring
+// buffer, for constant time indexing.
 //
 // every operations over an instiated Deque are synchronized and
 // safe for concurrent usage.
@@ -115,3 +118,195 @@ func (s *Deque) Empty() bool {
 
 	return s.container.Len() == 0
 }
+
+// At returns the i-th value stored in the deque, counting from its
+// front. The boolean is false if i is out of range. Elements are
+// reached from the nearest end of the deque, in a O(min(i, n-i)) time
+// complexity.
+func (s *Deque) At(i int) (interface{}, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	element := s.element(i)
+	if element == nil {
+		return nil, false
+	}
+
+	return element.Value, true
+}
+
+// InsertAt inserts element at position i of the deque, counting from
+// its front, so that At(i) returns it. i may be equal to the deque size
+// to insert at its back. The boolean is false if i is out of range. The
+// position is reached as At does, in a O(min(i, n-i)) time complexity.
+func (s *Deque) InsertAt(i int, item interface{}) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if i == s.container.Len() {
+		s.container.PushBack(item)
+		return true
+	}
+
+	element := s.element(i)
+	if element == nil {
+		return false
+	}
+
+	s.container.InsertBefore(item, element)
+
+	return true
+}
+
+// RemoveAt removes and returns the i-th value stored in the deque,
+// counting from its front. The boolean is false if i is out of range.
+// The value is reached as At does, in a O(min(i, n-i)) time complexity.
+func (s *Deque) RemoveAt(i int) (interface{}, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	element := s.element(i)
+	if element == nil {
+		return nil, false
+	}
+
+	return s.container.Remove(element), true
+}
+
+// Swap exchanges the i-th and j-th values stored in the deque. The
+// boolean is false, and the deque left untouched, if either index is
+// out of range. Both values are reached as At does.
+func (s *Deque) Swap(i, j int) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	first, second := s.element(i), s.element(j)
+	if first == nil || second == nil {
+		return false
+	}
+
+	first.Value, second.Value = second.Value, first.Value
+
+	return true
+}
+
+// AppendBatch inserts the elements at the back of the Deque holding the
+// lock once, as successive Append calls would.
+func (s *Deque) AppendBatch(items []interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range items {
+		s.container.PushBack(item)
+	}
+}
+
+// PrependBatch inserts the elements at the Deque front holding the lock
+// once, as successive Prepend calls would: the last element ends up
+// first.
+func (s *Deque) PrependBatch(items []interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range items {
+		s.container.PushFront(item)
+	}
+}
+
+// PopN removes up to n elements from the back of the deque holding the
+// lock once, and returns them in the order successive Pop calls would.
+func (s *Deque) PopN(n int) []interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeN(n, (*list.List).Back)
+}
+
+// ShiftN removes up to n elements from the front of the deque holding
+// the lock once, and returns them in the order successive Shift calls
+// would.
+func (s *Deque) ShiftN(n int) []interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeN(n, (*list.List).Front)
+}
+
+// Drain removes every element of the deque at once, and returns them
+// from its front to its back.
+func (s *Deque) Drain() []interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeN(s.container.Len(), (*list.List).Front)
+}
+
+// ToSlice returns a snapshot of the deque elements, from its front to
+// its back.
+func (s *Deque) ToSlice() []interface{} {
+	s.RLock()
+	defer s.RUnlock()
+
+	items := make([]interface{}, 0, s.container.Len())
+	for element := s.container.Front(); element != nil; element = element.Next() {
+		items = append(items, element.Value)
+	}
+
+	return items
+}
+
+// Iter calls fn with the elements of a snapshot of the deque, from its
+// front to its back, until fn returns false. As fn is called once the
+// deque is unlocked, it may call the deque methods, which don't change
+// the iterated elements.
+func (s *Deque) Iter(fn func(item interface{}) bool) {
+	for _, item := range s.ToSlice() {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
+// removeN removes up to n elements, each of them being the one end
+// returns, and returns them in removal order. It must be called holding
+// the lock.
+func (s *Deque) removeN(n int, end func(*list.List) *list.Element) []interface{} {
+	if n > s.container.Len() {
+		n = s.container.Len()
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	items := make([]interface{}, 0, n)
+	for ; n > 0; n-- {
+		items = append(items, s.container.Remove(end(s.container)))
+	}
+
+	return items
+}
+
+// element returns the i-th element of the container, or nil if i is
+// out of range. It must be called holding the lock.
+func (s *Deque) element(i int) *list.Element {
+	size := s.container.Len()
+	if i < 0 || i >= size {
+		return nil
+	}
+
+	if i < size/2 {
+		element := s.container.Front()
+		for ; i > 0; i-- {
+			element = element.Next()
+		}
+
+		return element
+	}
+
+	element := s.container.Back()
+	for k := size - 1; k > i; k-- {
+		element = element.Prev()
+	}
+
+	return element
+}
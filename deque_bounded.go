@@ -0,0 +1,264 @@
+package lane
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// BoundedDeque is a head-tail linked list data structure implementation
+// holding up to a fixed capacity of elements. Besides the Deque
+// operations, which never block, it provides blocking operations
+// waiting for room or for an element to be available, so that it can
+// buffer work between producer and consumer goroutines with
+// backpressure. Every operations time complexity is O(1).
+//
+// every operations over an instiated BoundedDeque are synchronized and
+// safe for concurrent usage.
+type BoundedDeque struct {
+	sync.Mutex
+	container *list.List
+	capacity  int
+
+	// room and available are closed, and reset, when an element is
+	// removed and added respectively, waking the blocked callers up.
+	// They are only created once a caller blocks.
+	room      chan struct{}
+	available chan struct{}
+}
+
+// NewBoundedDeque creates a new bounded deque holding up to capacity
+// elements. It panics if capacity is not positive.
+func NewBoundedDeque(capacity int) *BoundedDeque {
+	if capacity < 1 {
+		panic(fmt.Sprintf("lane: bounded deque capacity must be positive, got %d", capacity))
+	}
+
+	return &BoundedDeque{
+		container: list.New(),
+		capacity:  capacity,
+	}
+}
+
+// Append inserts element at the back of the deque, and reports whether
+// it had room for it.
+func (s *BoundedDeque) Append(item interface{}) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.insert(item, false)
+}
+
+// Prepend inserts element at the deque front, and reports whether it
+// had room for it.
+func (s *BoundedDeque) Prepend(item interface{}) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.insert(item, true)
+}
+
+// AppendWait inserts element at the back of the deque, blocking until
+// it has room for it or the context is done, in which case the context
+// error is returned.
+func (s *BoundedDeque) AppendWait(ctx context.Context, item interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.await(ctx, s.hasRoom, &s.room); err != nil {
+		return err
+	}
+
+	s.insert(item, false)
+
+	return nil
+}
+
+// PrependWait inserts element at the deque front, blocking until it has
+// room for it or the context is done, in which case the context error
+// is returned.
+func (s *BoundedDeque) PrependWait(ctx context.Context, item interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.await(ctx, s.hasRoom, &s.room); err != nil {
+		return err
+	}
+
+	s.insert(item, true)
+
+	return nil
+}
+
+// Pop removes the last element of the deque, or returns nil if it is
+// empty.
+func (s *BoundedDeque) Pop() interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.remove(s.container.Back())
+}
+
+// Shift removes the first element of the deque, or returns nil if it is
+// empty.
+func (s *BoundedDeque) Shift() interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.remove(s.container.Front())
+}
+
+// PopWait removes the last element of the deque, blocking until there
+// is one or the context is done, in which case the context error is
+// returned.
+func (s *BoundedDeque) PopWait(ctx context.Context) (interface{}, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.await(ctx, s.hasElements, &s.available); err != nil {
+		return nil, err
+	}
+
+	return s.remove(s.container.Back()), nil
+}
+
+// ShiftWait removes the first element of the deque, blocking until
+// there is one or the context is done, in which case the context error
+// is returned.
+func (s *BoundedDeque) ShiftWait(ctx context.Context) (interface{}, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.await(ctx, s.hasElements, &s.available); err != nil {
+		return nil, err
+	}
+
+	return s.remove(s.container.Front()), nil
+}
+
+// First returns the first value stored in the deque, or nil if it is
+// empty.
+func (s *BoundedDeque) First() interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if item := s.container.Front(); item != nil {
+		return item.Value
+	}
+
+	return nil
+}
+
+// Last returns the last value stored in the deque, or nil if it is
+// empty.
+func (s *BoundedDeque) Last() interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if item := s.container.Back(); item != nil {
+		return item.Value
+	}
+
+	return nil
+}
+
+// Size returns the actual deque size
+func (s *BoundedDeque) Size() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.container.Len()
+}
+
+// Capacity returns the count of elements the deque can hold
+func (s *BoundedDeque) Capacity() int {
+	return s.capacity
+}
+
+// Empty checks if the deque is empty
+func (s *BoundedDeque) Empty() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.container.Len() == 0
+}
+
+// Full checks if the deque holds as many elements as its capacity
+func (s *BoundedDeque) Full() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return !s.hasRoom()
+}
+
+// await blocks until ready reports true, or the context is done, in
+// which case the context error is returned. It must be called holding
+// the lock, which is released while blocking. wake is the channel
+// closed when ready may have changed.
+func (s *BoundedDeque) await(ctx context.Context, ready func() bool, wake *chan struct{}) error {
+	for !ready() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if *wake == nil {
+			*wake = make(chan struct{})
+		}
+		ch := *wake
+
+		s.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+		}
+		s.Lock()
+	}
+
+	return nil
+}
+
+// insert inserts the element at the deque front or back if it has
+// room for it. It must be called holding the lock.
+func (s *BoundedDeque) insert(item interface{}, front bool) bool {
+	if !s.hasRoom() {
+		return false
+	}
+
+	if front {
+		s.container.PushFront(item)
+	} else {
+		s.container.PushBack(item)
+	}
+	wakeBlocked(&s.available)
+
+	return true
+}
+
+// remove removes the element, if not nil, and returns its value. It
+// must be called holding the lock.
+func (s *BoundedDeque) remove(element *list.Element) interface{} {
+	if element == nil {
+		return nil
+	}
+
+	wakeBlocked(&s.room)
+
+	return s.container.Remove(element)
+}
+
+func (s *BoundedDeque) hasRoom() bool {
+	return s.container.Len() < s.capacity
+}
+
+func (s *BoundedDeque) hasElements() bool {
+	return s.container.Len() > 0
+}
+
+// wakeBlocked closes, and resets, the channel if some caller is blocked on it
+func wakeBlocked(ch *chan struct{}) {
+	if *ch != nil {
+		close(*ch)
+		*ch = nil
+	}
+}
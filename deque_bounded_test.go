@@ -0,0 +1,198 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBoundedDeque_invalid_capacity(t *testing.T) {
+	assert.Panics(t, func() { NewBoundedDeque(0) })
+	assert.Panics(t, func() { NewBoundedDeque(-1) })
+}
+
+func TestBoundedDequeAppend_full(t *testing.T) {
+	deque := NewBoundedDeque(2)
+
+	assert.True(t, deque.Append("1"))
+	assert.True(t, deque.Prepend("0"))
+	assert.True(t, deque.Full())
+	assert.False(t, deque.Append("2"))
+	assert.False(t, deque.Prepend("-1"))
+
+	assert.Equal(t, deque.Size(), 2)
+	assert.Equal(t, deque.Capacity(), 2)
+	assert.Equal(t, deque.First(), "0")
+	assert.Equal(t, deque.Last(), "1")
+}
+
+func TestBoundedDequePop_and_Shift(t *testing.T) {
+	deque := NewBoundedDeque(3)
+	deque.Append("1")
+	deque.Append("2")
+	deque.Append("3")
+
+	assert.Equal(t, deque.Pop(), "3")
+	assert.Equal(t, deque.Shift(), "1")
+	assert.Equal(t, deque.Pop(), "2")
+	assert.Nil(t, deque.Pop())
+	assert.Nil(t, deque.Shift())
+	assert.Nil(t, deque.First())
+	assert.Nil(t, deque.Last())
+	assert.True(t, deque.Empty())
+}
+
+func TestBoundedDequeAppendWait_blocks_until_room(t *testing.T) {
+	deque := NewBoundedDeque(1)
+	deque.Append("1")
+
+	done := make(chan error)
+	go func() {
+		done <- deque.AppendWait(context.Background(), "2")
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("AppendWait returned while the deque was full")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	assert.Equal(t, deque.Shift(), "1")
+	assert.Nil(t, <-done)
+	assert.Equal(t, deque.First(), "2")
+}
+
+func TestBoundedDequePrependWait_blocks_until_room(t *testing.T) {
+	deque := NewBoundedDeque(1)
+	deque.Append("1")
+
+	done := make(chan error)
+	go func() {
+		done <- deque.PrependWait(context.Background(), "0")
+	}()
+
+	value, err := deque.PopWait(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, "1")
+	assert.Nil(t, <-done)
+	assert.Equal(t, deque.First(), "0")
+}
+
+func TestBoundedDequeShiftWait_blocks_until_element(t *testing.T) {
+	deque := NewBoundedDeque(1)
+
+	done := make(chan interface{})
+	go func() {
+		value, err := deque.ShiftWait(context.Background())
+		assert.Nil(t, err)
+		done <- value
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("ShiftWait returned while the deque was empty")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	deque.Append("1")
+	assert.Equal(t, <-done, "1")
+	assert.True(t, deque.Empty())
+}
+
+func TestBoundedDequeWait_context_done(t *testing.T) {
+	deque := NewBoundedDeque(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	value, err := deque.PopWait(ctx)
+	assert.Nil(t, value)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	_, err = deque.ShiftWait(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	deque.Append("1")
+	assert.True(t, errors.Is(deque.AppendWait(ctx, "2"), context.DeadlineExceeded))
+	assert.True(t, errors.Is(deque.PrependWait(ctx, "2"), context.DeadlineExceeded))
+	assert.Equal(t, deque.Size(), 1)
+
+	// Operations which don't need to block succeed whatever the context
+	value, err = deque.ShiftWait(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, value, "1")
+	assert.Nil(t, deque.AppendWait(ctx, "2"))
+}
+
+func TestBoundedDeque_producers_and_consumers(t *testing.T) {
+	const producers, consumers, perProducer, capacity = 4, 4, 500, 8
+
+	deque := NewBoundedDeque(capacity)
+
+	var producing sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		producing.Add(1)
+		go func(p int) {
+			defer producing.Done()
+			for i := 0; i < perProducer; i++ {
+				value := p*perProducer + i
+				if i%2 == 0 {
+					assert.Nil(t, deque.AppendWait(context.Background(), value))
+				} else {
+					assert.Nil(t, deque.PrependWait(context.Background(), value))
+				}
+			}
+		}(p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		mu       sync.Mutex
+		consumed []int
+		maxSize  int
+	)
+	var consuming sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		consuming.Add(1)
+		go func(c int) {
+			defer consuming.Done()
+			for {
+				wait := deque.PopWait
+				if c%2 == 0 {
+					wait = deque.ShiftWait
+				}
+
+				value, err := wait(ctx)
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				consumed = append(consumed, value.(int))
+				if size := deque.Size(); size > maxSize {
+					maxSize = size
+				}
+				mu.Unlock()
+			}
+		}(c)
+	}
+
+	producing.Wait()
+	for !deque.Empty() {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	consuming.Wait()
+
+	assert.True(t, maxSize <= capacity)
+	sort.Ints(consumed)
+	assert.Equal(t, len(consumed), producers*perProducer)
+	for i, value := range consumed {
+		assert.Equal(t, value, i)
+	}
+}
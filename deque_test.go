@@ -1,8 +1,11 @@
 package lane
 
 import (
-	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sync"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDequeAppend(t *testing.T) {
@@ -135,3 +138,156 @@ func TestDequeEmpty_empty_queue(t *testing.T) {
 	queue := NewDeque()
 	assert.True(t, queue.Empty())
 }
+
+func TestDequePositional_out_of_range(t *testing.T) {
+	deque := NewDeque()
+	deque.Append("1")
+
+	for _, i := range []int{-1, 1, 2} {
+		value, ok := deque.At(i)
+		assert.Nil(t, value)
+		assert.False(t, ok)
+
+		value, ok = deque.RemoveAt(i)
+		assert.Nil(t, value)
+		assert.False(t, ok)
+
+		assert.False(t, deque.Swap(0, i))
+		assert.False(t, deque.Swap(i, 0))
+	}
+
+	assert.False(t, deque.InsertAt(-1, "x"))
+	assert.False(t, deque.InsertAt(2, "x"))
+	assert.True(t, deque.InsertAt(1, "2"))
+	assert.Equal(t, deque.Last(), "2")
+	assert.Equal(t, deque.Size(), 2)
+}
+
+func TestDequePositional_matches_slice_model(t *testing.T) {
+	deque := NewDeque()
+	var model []interface{}
+	random := rand.New(rand.NewSource(11))
+
+	for step := 0; step < 5000; step++ {
+		i := random.Intn(len(model)+3) - 1
+		inRange := i >= 0 && i < len(model)
+
+		switch random.Intn(6) {
+		case 0, 1:
+			ok := deque.InsertAt(i, step)
+			assert.Equal(t, ok, i >= 0 && i <= len(model))
+			if ok {
+				model = append(model[:i], append([]interface{}{step}, model[i:]...)...)
+			}
+		case 2:
+			value, ok := deque.RemoveAt(i)
+			assert.Equal(t, ok, inRange)
+			if ok {
+				assert.Equal(t, value, model[i])
+				model = append(model[:i], model[i+1:]...)
+			}
+		case 3:
+			j := random.Intn(len(model) + 1)
+			ok := deque.Swap(i, j)
+			assert.Equal(t, ok, inRange && j < len(model))
+			if ok {
+				model[i], model[j] = model[j], model[i]
+			}
+		case 4:
+			deque.Append(step)
+			model = append(model, step)
+		case 5:
+			if value := deque.Shift(); len(model) > 0 {
+				assert.Equal(t, value, model[0])
+				model = model[1:]
+			}
+		}
+
+		value, ok := deque.At(i)
+		assert.Equal(t, ok, i >= 0 && i < len(model))
+		if ok {
+			assert.Equal(t, value, model[i])
+		}
+	}
+
+	assert.Equal(t, deque.Size(), len(model))
+	for i, expected := range model {
+		value, _ := deque.At(i)
+		assert.Equal(t, value, expected)
+	}
+}
+
+func TestDequePositional_concurrent_traffic(t *testing.T) {
+	deque := NewDeque()
+	for i := 0; i < 100; i++ {
+		deque.Append(i)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			deque.Append(i)
+			deque.Shift()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			deque.InsertAt(i%50, i)
+			deque.RemoveAt(i % 50)
+			deque.Swap(i%50, 99-i%50)
+			deque.At(i % 100)
+		}
+	}()
+	wg.Wait()
+
+	assert.Equal(t, deque.Size(), 100)
+}
+
+func TestDequeAppendBatch(t *testing.T) {
+	deque := NewDeque()
+	deque.Append("0")
+
+	deque.AppendBatch([]interface{}{"1", "2"})
+	deque.PrependBatch([]interface{}{"-1", "-2"})
+
+	assert.Equal(t, deque.ToSlice(), []interface{}{"-2", "-1", "0", "1", "2"})
+}
+
+func TestDequePopN_and_ShiftN(t *testing.T) {
+	deque := NewDeque()
+	deque.AppendBatch([]interface{}{1, 2, 3, 4, 5})
+
+	assert.Equal(t, deque.PopN(2), []interface{}{5, 4})
+	assert.Equal(t, deque.ShiftN(2), []interface{}{1, 2})
+	assert.Equal(t, deque.ShiftN(-1), []interface{}{})
+	assert.Equal(t, deque.PopN(10), []interface{}{3})
+	assert.Equal(t, deque.ShiftN(1), []interface{}{})
+	assert.True(t, deque.Empty())
+}
+
+func TestDequeDrain(t *testing.T) {
+	deque := NewDeque()
+	deque.AppendBatch([]interface{}{1, 2, 3})
+
+	assert.Equal(t, deque.Drain(), []interface{}{1, 2, 3})
+	assert.True(t, deque.Empty())
+	assert.Equal(t, deque.Drain(), []interface{}{})
+}
+
+func TestDequeIter(t *testing.T) {
+	deque := NewDeque()
+	deque.AppendBatch([]interface{}{1, 2, 3, 4})
+
+	// fn may use the deque, whose snapshot is iterated
+	var items []interface{}
+	deque.Iter(func(item interface{}) bool {
+		items = append(items, item)
+		deque.Shift()
+		return item.(int) < 3
+	})
+	assert.Equal(t, items, []interface{}{1, 2, 3})
+	assert.Equal(t, deque.Size(), 1)
+}
@@ -0,0 +1,266 @@
+package lane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+func ExampleNewPQueue_maxpq() {
+	// A MAXPQ queue pops its highest priority items first
+	pqueue := NewPQueue(MAXPQ)
+
+	pqueue.Push("low", 1)
+	pqueue.Push("high", 3)
+	pqueue.Push("medium", 2)
+
+	for pqueue.Size() > 0 {
+		value, priority := pqueue.Pop()
+		fmt.Println(value, priority)
+	}
+
+	// Output:
+	// high 3
+	// medium 2
+	// low 1
+}
+
+func ExampleNewPQueue_minpq() {
+	// A MINPQ queue pops its lowest priority items first, such as the
+	// earliest deadlines
+	pqueue := NewPQueue(MINPQ)
+
+	pqueue.Push("in an hour", 60)
+	pqueue.Push("now", 0)
+	pqueue.Push("in a minute", 1)
+
+	for pqueue.Size() > 0 {
+		value, priority := pqueue.Pop()
+		fmt.Println(value, priority)
+	}
+
+	// Output:
+	// now 0
+	// in a minute 1
+	// in an hour 60
+}
+
+func ExamplePQueue_Pop_empty() {
+	pqueue := NewPQueue(MAXPQ)
+
+	// Popping an empty queue returns a nil value, rather than blocking
+	value, priority := pqueue.Pop()
+	fmt.Println(value, priority)
+
+	// Which is why nil values can't be told apart from an empty queue:
+	// PopRelease tells them apart
+	pqueue.Push(nil, 1)
+	value, priority, ok := pqueue.PopRelease()
+	fmt.Println(value, priority, ok)
+
+	_, _, ok = pqueue.PopRelease()
+	fmt.Println(ok)
+
+	// Output:
+	// <nil> 0
+	// <nil> 1 true
+	// false
+}
+
+func ExampleWithStableOrder() {
+	// Items of equal priority are popped in an unspecified order, unless
+	// the queue keeps their push order
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+
+	for _, job := range []string{"first", "second", "third"} {
+		pqueue.Push(job, 1)
+	}
+	pqueue.Push("urgent", 2)
+
+	for pqueue.Size() > 0 {
+		value, _ := pqueue.Pop()
+		fmt.Println(value)
+	}
+
+	// Output:
+	// urgent
+	// first
+	// second
+	// third
+}
+
+func ExamplePQueue_WaitPop() {
+	pqueue := NewPQueue(MAXPQ)
+
+	// Consumers block in WaitPop until an item is available, and return
+	// ErrClosed once the queue is closed and drained
+	var (
+		mu       sync.Mutex
+		consumed []int
+		wg       sync.WaitGroup
+	)
+	for c := 0; c < 3; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				value, _, err := pqueue.WaitPop(context.Background())
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+
+				mu.Lock()
+				consumed = append(consumed, value.(int))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var producers sync.WaitGroup
+	for p := 0; p < 2; p++ {
+		producers.Add(1)
+		go func(p int) {
+			defer producers.Done()
+
+			for i := 0; i < 5; i++ {
+				pqueue.Push(p*5+i, i)
+			}
+		}(p)
+	}
+
+	producers.Wait()
+	pqueue.Close()
+	wg.Wait()
+
+	// Which consumer got which item, and when, depends on scheduling
+	sort.Ints(consumed)
+	fmt.Println(consumed)
+
+	// Output:
+	// [0 1 2 3 4 5 6 7 8 9]
+}
+
+func ExampleWithMaxItems() {
+	// A bounded queue rejects the pushes which don't fit in, so that
+	// producers back off until the consumers catch up
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithMaxItems(2))
+
+	for _, job := range []string{"a", "b", "c"} {
+		if err := pqueue.Push(job, 1); errors.Is(err, ErrFull) {
+			fmt.Println("backing off on", job)
+
+			value, _ := pqueue.Pop()
+			fmt.Println("consumed", value)
+
+			if err := pqueue.Push(job, 1); err == nil {
+				fmt.Println("pushed", job)
+			}
+		}
+	}
+	fmt.Println(pqueue.Size())
+
+	// Output:
+	// backing off on c
+	// consumed a
+	// pushed c
+	// 2
+}
+
+func ExampleWithOverflowPolicy() {
+	// Or a bounded queue evicts its lowest precedence items to make room
+	// for higher precedence ones
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithMaxItems(2), WithOverflowPolicy(EvictWhenFull))
+
+	pqueue.Push("low", 1)
+	pqueue.Push("medium", 2)
+	fmt.Println(pqueue.Push("high", 3))
+	fmt.Println(errors.Is(pqueue.Push("lowest", 0), ErrFull))
+
+	for pqueue.Size() > 0 {
+		value, _ := pqueue.Pop()
+		fmt.Println(value)
+	}
+
+	// Output:
+	// <nil>
+	// true
+	// high
+	// medium
+}
+
+func ExamplePQueue_MarshalJSON() {
+	pqueue := NewPQueue(MINPQ)
+	pqueue.Push("b", 2)
+	pqueue.Push("a", 1)
+
+	data, _ := json.Marshal(pqueue)
+
+	// Values are decoded as encoding/json decodes interface{} values by
+	// default, WithJSONValueDecoder customizes their decoding
+	restored := NewPQueue(MAXPQ)
+	if err := json.Unmarshal(data, restored); err != nil {
+		fmt.Println(err)
+	}
+
+	// The restored queue has the ordering of the marshalled one
+	for restored.Size() > 0 {
+		value, priority := restored.Pop()
+		fmt.Println(value, priority)
+	}
+
+	// Output:
+	// a 1
+	// b 2
+}
+
+func ExamplePQueue_Txn() {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("build", 2)
+	pqueue.Push("deploy", 1)
+
+	// Replace the head item with the steps it splits into, at once
+	pqueue.Txn(func(tx *PQueueTxn) error {
+		value, priority, _ := tx.Pop()
+		for _, step := range []string{"compile", "link"} {
+			tx.Push(value.(string)+": "+step, priority)
+		}
+		return nil
+	})
+
+	// Or leave the queue untouched if something fails midway
+	err := pqueue.Txn(func(tx *PQueueTxn) error {
+		tx.Pop()
+		return errors.New("no worker available")
+	})
+	fmt.Println(err, pqueue.Size())
+
+	// Output:
+	// no worker available 3
+}
+
+func ExamplePQueue_ProcessAll() {
+	pqueue := NewPQueue(MAXPQ)
+	for i := 1; i <= 10; i++ {
+		pqueue.Push(i, i)
+	}
+
+	var (
+		mu  sync.Mutex
+		sum int
+	)
+	err := pqueue.ProcessAll(context.Background(), 4, func(ctx context.Context, value interface{}, priority int) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sum += value.(int)
+		return nil
+	})
+	fmt.Println(sum, err, pqueue.Size())
+
+	// Output:
+	// 55 <nil> 0
+}
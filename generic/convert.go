@@ -0,0 +1,74 @@
+package generic
+
+import "github.com/oleiade/lane"
+
+// DequeFromPQueue creates a new Deque holding the priority queue
+// values, from the first one to be popped to the last one. The
+// priority queue is left untouched.
+func DequeFromPQueue[T any, P Ordered](pq *PQueue[T, P]) *Deque[T] {
+	deque := NewDeque[T]()
+	for _, value := range pq.sorted() {
+		deque.Append(value)
+	}
+
+	return deque
+}
+
+// QueueFromPQueue creates a new Queue holding the priority queue
+// values, to be dequeued in the order they would have been popped
+// from the priority queue. The priority queue is left untouched.
+func QueueFromPQueue[T any, P Ordered](pq *PQueue[T, P]) *Queue[T] {
+	queue := NewQueue[T]()
+	for _, value := range pq.sorted() {
+		queue.Enqueue(value)
+	}
+
+	return queue
+}
+
+// StackFromPQueue creates a new Stack holding the priority queue
+// values, the first one to be popped from the priority queue being
+// on top of the stack. The priority queue is left untouched.
+func StackFromPQueue[T any, P Ordered](pq *PQueue[T, P]) *Stack[T] {
+	values := pq.sorted()
+
+	stack := NewStack[T]()
+	for i := len(values) - 1; i >= 0; i-- {
+		stack.Push(values[i])
+	}
+
+	return stack
+}
+
+// PQueueFromDeque creates a new priority queue with the provided
+// pqtype ordering type holding the deque values, each of them pushed
+// with the priority computed by the priority function. The deque is
+// left untouched.
+func PQueueFromDeque[T any, P Ordered](deque *Deque[T], pqType lane.PQType, priority func(T) P) *PQueue[T, P] {
+	return pqueueFromValues(deque.ToSlice(), pqType, priority)
+}
+
+// PQueueFromQueue creates a new priority queue with the provided
+// pqtype ordering type holding the queue values, each of them pushed
+// with the priority computed by the priority function. The queue is
+// left untouched.
+func PQueueFromQueue[T any, P Ordered](queue *Queue[T], pqType lane.PQType, priority func(T) P) *PQueue[T, P] {
+	return pqueueFromValues(queue.ToSlice(), pqType, priority)
+}
+
+// PQueueFromStack creates a new priority queue with the provided
+// pqtype ordering type holding the stack values, each of them pushed
+// with the priority computed by the priority function. The stack is
+// left untouched.
+func PQueueFromStack[T any, P Ordered](stack *Stack[T], pqType lane.PQType, priority func(T) P) *PQueue[T, P] {
+	return pqueueFromValues(stack.ToSlice(), pqType, priority)
+}
+
+func pqueueFromValues[T any, P Ordered](values []T, pqType lane.PQType, priority func(T) P) *PQueue[T, P] {
+	pq := NewPQueue[T, P](pqType)
+	for _, value := range values {
+		pq.Push(value, priority(value))
+	}
+
+	return pq
+}
@@ -0,0 +1,116 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/oleiade/lane"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPQueue() *PQueue[string, int] {
+	pqueue := NewPQueue[string, int](lane.MINPQ)
+
+	pqueue.Push("easy as", 3)
+	pqueue.Push("123", 2)
+	pqueue.Push("do re mi", 4)
+	pqueue.Push("abc", 1)
+
+	return pqueue
+}
+
+var jacksonFive = []string{"abc", "123", "easy as", "do re mi"}
+
+func TestDequeFromPQueue(t *testing.T) {
+	pqueue := newTestPQueue()
+	deque := DequeFromPQueue(pqueue)
+
+	assert.Equal(t, deque.ToSlice(), jacksonFive)
+	assert.Equal(t, pqueue.Size(), 4)
+}
+
+func TestQueueFromPQueue(t *testing.T) {
+	pqueue := newTestPQueue()
+	queue := QueueFromPQueue(pqueue)
+
+	for _, expected := range jacksonFive {
+		value, ok := queue.Dequeue()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+	}
+	assert.Equal(t, pqueue.Size(), 4)
+}
+
+func TestStackFromPQueue(t *testing.T) {
+	pqueue := newTestPQueue()
+	stack := StackFromPQueue(pqueue)
+
+	for _, expected := range jacksonFive {
+		value, ok := stack.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+	}
+	assert.Equal(t, pqueue.Size(), 4)
+}
+
+func TestPQueueFromDeque(t *testing.T) {
+	deque := NewDeque[string]()
+	for _, word := range []string{"do re mi", "abc", "easy as", "123"} {
+		deque.Append(word)
+	}
+
+	rank := map[string]int{"abc": 1, "123": 2, "easy as": 3, "do re mi": 4}
+	pqueue := PQueueFromDeque(deque, lane.MAXPQ, func(value string) int {
+		return rank[value]
+	})
+
+	for i := len(jacksonFive) - 1; i >= 0; i-- {
+		value, priority, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, jacksonFive[i])
+		assert.Equal(t, priority, rank[value])
+	}
+	assert.Equal(t, deque.Size(), 4)
+}
+
+func TestPQueueFromQueue(t *testing.T) {
+	queue := NewQueue[int]()
+	for _, value := range []int{4, 1, 3, 2} {
+		queue.Enqueue(value)
+	}
+
+	pqueue := PQueueFromQueue(queue, lane.MINPQ, func(value int) int { return value })
+
+	for expected := 1; expected <= 4; expected++ {
+		value, _, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+	}
+	assert.Equal(t, queue.Size(), 4)
+}
+
+func TestPQueueFromStack(t *testing.T) {
+	stack := NewStack[int]()
+	for _, value := range []int{4, 1, 3, 2} {
+		stack.Push(value)
+	}
+
+	pqueue := PQueueFromStack(stack, lane.MAXPQ, func(value int) int { return value })
+
+	for expected := 4; expected >= 1; expected-- {
+		value, _, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+	}
+	assert.Equal(t, stack.Size(), 4)
+}
+
+func TestPQueueRoundTrip_through_queue(t *testing.T) {
+	pqueue := newTestPQueue()
+
+	rank := map[string]int{"abc": 1, "123": 2, "easy as": 3, "do re mi": 4}
+	back := PQueueFromQueue(QueueFromPQueue(pqueue), lane.MINPQ, func(value string) int {
+		return rank[value]
+	})
+
+	assert.Equal(t, back.sorted(), pqueue.sorted())
+}
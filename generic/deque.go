@@ -0,0 +1,370 @@
+package generic
+
+import "sync"
+
+// minDequeCapacity is the capacity a Deque buffer is allocated with
+// on first insertion.
+const minDequeCapacity = 8
+
+// Deque is a head-tail data structure implementation. It is based
+// on a ring buffer, so that every operations time complexity is
+// amortized O(1).
+//
+// every operations over a Deque are synchronized and safe for
+// concurrent usage.
+type Deque[T any] struct {
+	sync.RWMutex
+	buffer []T
+	head   int
+	count  int
+}
+
+// NewDeque creates a new empty Deque
+func NewDeque[T any]() *Deque[T] {
+	return &Deque[T]{}
+}
+
+// Append inserts element at the back of the Deque
+func (s *Deque[T]) Append(item T) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.grow()
+	s.buffer[s.index(s.count)] = item
+	s.count++
+}
+
+// Prepend inserts element at the Deque front
+func (s *Deque[T]) Prepend(item T) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.grow()
+	s.head = s.index(len(s.buffer) - 1)
+	s.buffer[s.head] = item
+	s.count++
+}
+
+// Pop removes and returns the last element of the deque. The boolean
+// is false if the deque is empty.
+func (s *Deque[T]) Pop() (T, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.count == 0 {
+		var item T
+		return item, false
+	}
+
+	return s.popBack(), true
+}
+
+// Shift removes and returns the first element of the deque. The boolean
+// is false if the deque is empty.
+func (s *Deque[T]) Shift() (T, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.count == 0 {
+		var item T
+		return item, false
+	}
+
+	return s.popFront(), true
+}
+
+// First returns the first value stored in the deque. The boolean
+// is false if the deque is empty.
+func (s *Deque[T]) First() (T, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var item T
+	if s.count == 0 {
+		return item, false
+	}
+
+	return s.buffer[s.head], true
+}
+
+// Last returns the last value stored in the deque. The boolean
+// is false if the deque is empty.
+func (s *Deque[T]) Last() (T, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var item T
+	if s.count == 0 {
+		return item, false
+	}
+
+	return s.buffer[s.index(s.count-1)], true
+}
+
+// Size returns the actual deque size
+func (s *Deque[T]) Size() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.count
+}
+
+// Empty checks if the deque is empty
+func (s *Deque[T]) Empty() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.count == 0
+}
+
+// At returns the i-th value stored in the deque, counting from its
+// front, in a O(1) time complexity. The boolean is false if i is out
+// of range.
+func (s *Deque[T]) At(i int) (T, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var item T
+	if i < 0 || i >= s.count {
+		return item, false
+	}
+
+	return s.buffer[s.index(i)], true
+}
+
+// InsertAt inserts element at position i of the deque, counting from
+// its front, so that At(i) returns it. i may be equal to the deque size
+// to insert at its back. The elements on the shortest side of i are
+// shifted to make room. The boolean is false if i is out of range.
+func (s *Deque[T]) InsertAt(i int, item T) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if i < 0 || i > s.count {
+		return false
+	}
+
+	s.grow()
+
+	if i < s.count/2 {
+		// Shift the elements before i one position towards the front
+		s.head = s.index(len(s.buffer) - 1)
+		for k := 0; k < i; k++ {
+			s.buffer[s.index(k)] = s.buffer[s.index(k+1)]
+		}
+	} else {
+		// Shift the elements from i one position towards the back
+		for k := s.count; k > i; k-- {
+			s.buffer[s.index(k)] = s.buffer[s.index(k-1)]
+		}
+	}
+
+	s.buffer[s.index(i)] = item
+	s.count++
+
+	return true
+}
+
+// RemoveAt removes and returns the i-th value stored in the deque,
+// counting from its front. The elements on the shortest side of i are
+// shifted to fill its position. The boolean is false if i is out of
+// range.
+func (s *Deque[T]) RemoveAt(i int) (T, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	var zero T
+	if i < 0 || i >= s.count {
+		return zero, false
+	}
+
+	item := s.buffer[s.index(i)]
+
+	if i < s.count/2 {
+		// Shift the elements before i one position towards the back
+		for k := i; k > 0; k-- {
+			s.buffer[s.index(k)] = s.buffer[s.index(k-1)]
+		}
+		s.buffer[s.head] = zero
+		s.head = s.index(1)
+	} else {
+		// Shift the elements after i one position towards the front
+		for k := i; k < s.count-1; k++ {
+			s.buffer[s.index(k)] = s.buffer[s.index(k+1)]
+		}
+		s.buffer[s.index(s.count-1)] = zero
+	}
+
+	s.count--
+
+	return item, true
+}
+
+// Swap exchanges the i-th and j-th values stored in the deque, in a
+// O(1) time complexity. The boolean is false, and the deque left
+// untouched, if either index is out of range.
+func (s *Deque[T]) Swap(i, j int) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if i < 0 || i >= s.count || j < 0 || j >= s.count {
+		return false
+	}
+
+	x, y := s.index(i), s.index(j)
+	s.buffer[x], s.buffer[y] = s.buffer[y], s.buffer[x]
+
+	return true
+}
+
+// AppendBatch inserts the elements at the back of the Deque holding the
+// lock once, as successive Append calls would.
+func (s *Deque[T]) AppendBatch(items []T) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range items {
+		s.grow()
+		s.buffer[s.index(s.count)] = item
+		s.count++
+	}
+}
+
+// PrependBatch inserts the elements at the Deque front holding the lock
+// once, as successive Prepend calls would: the last element ends up
+// first.
+func (s *Deque[T]) PrependBatch(items []T) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range items {
+		s.grow()
+		s.head = s.index(len(s.buffer) - 1)
+		s.buffer[s.head] = item
+		s.count++
+	}
+}
+
+// PopN removes up to n elements from the back of the deque holding the
+// lock once, and returns them in the order successive Pop calls would.
+func (s *Deque[T]) PopN(n int) []T {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeN(n, (*Deque[T]).popBack)
+}
+
+// ShiftN removes up to n elements from the front of the deque holding
+// the lock once, and returns them in the order successive Shift calls
+// would.
+func (s *Deque[T]) ShiftN(n int) []T {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeN(n, (*Deque[T]).popFront)
+}
+
+// Drain removes every element of the deque at once, and returns them
+// from its front to its back.
+func (s *Deque[T]) Drain() []T {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeN(s.count, (*Deque[T]).popFront)
+}
+
+// ToSlice returns a snapshot of the deque elements, from its front to
+// its back.
+func (s *Deque[T]) ToSlice() []T {
+	s.RLock()
+	defer s.RUnlock()
+
+	items := make([]T, s.count)
+	for i := range items {
+		items[i] = s.buffer[s.index(i)]
+	}
+
+	return items
+}
+
+// Iter calls fn with the elements of a snapshot of the deque, from its
+// front to its back, until fn returns false. As fn is called once the
+// deque is unlocked, it may call the deque methods, which don't change
+// the iterated elements.
+func (s *Deque[T]) Iter(fn func(item T) bool) {
+	for _, item := range s.ToSlice() {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
+// removeN removes up to n elements, each of them with remove, and
+// returns them in removal order. It must be called holding the write
+// lock.
+func (s *Deque[T]) removeN(n int, remove func(*Deque[T]) T) []T {
+	if n > s.count {
+		n = s.count
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	items := make([]T, n)
+	for i := range items {
+		items[i] = remove(s)
+	}
+
+	return items
+}
+
+// popBack removes and returns the last element. It must be called
+// holding the write lock on a non empty deque.
+func (s *Deque[T]) popBack() T {
+	var item T
+
+	last := s.index(s.count - 1)
+	item, s.buffer[last] = s.buffer[last], item
+	s.count--
+
+	return item
+}
+
+// popFront removes and returns the first element. It must be called
+// holding the write lock on a non empty deque.
+func (s *Deque[T]) popFront() T {
+	var item T
+
+	item, s.buffer[s.head] = s.buffer[s.head], item
+	s.head = s.index(1)
+	s.count--
+
+	return item
+}
+
+// index returns the buffer position of the i-th element of the
+// deque. It must be called holding the lock.
+func (s *Deque[T]) index(i int) int {
+	return (s.head + i) % len(s.buffer)
+}
+
+// grow ensures there is room for one more element in the buffer.
+// It must be called holding the write lock.
+func (s *Deque[T]) grow() {
+	if s.count < len(s.buffer) {
+		return
+	}
+
+	capacity := 2 * len(s.buffer)
+	if capacity < minDequeCapacity {
+		capacity = minDequeCapacity
+	}
+
+	buffer := make([]T, capacity)
+	for i := 0; i < s.count; i++ {
+		buffer[i] = s.buffer[s.index(i)]
+	}
+
+	s.buffer = buffer
+	s.head = 0
+}
@@ -0,0 +1,215 @@
+package generic
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeque_zero_value_is_usable(t *testing.T) {
+	var deque Deque[int]
+
+	deque.Append(1)
+	deque.Prepend(0)
+
+	first, ok := deque.First()
+	assert.True(t, ok)
+	assert.Equal(t, first, 0)
+	assert.Equal(t, deque.Size(), 2)
+}
+
+func TestDequeAppendPrepend_wraps_around(t *testing.T) {
+	deque := NewDeque[int]()
+
+	// Interleave operations on both ends so that the ring buffer
+	// head wraps around and the buffer grows several times.
+	for i := 0; i < 100; i++ {
+		deque.Prepend(-i - 1)
+		deque.Append(i)
+	}
+
+	assert.Equal(t, deque.Size(), 200)
+
+	for i := 100; i > 0; i-- {
+		value, ok := deque.Shift()
+		assert.True(t, ok)
+		assert.Equal(t, value, -i)
+	}
+
+	for i := 99; i >= 0; i-- {
+		value, ok := deque.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, i)
+	}
+
+	assert.True(t, deque.Empty())
+}
+
+func TestDequeFirstLast(t *testing.T) {
+	deque := NewDeque[string]()
+
+	deque.Append("1")
+	deque.Append("2")
+	deque.Append("3")
+
+	first, ok := deque.First()
+	assert.True(t, ok)
+	assert.Equal(t, first, "1")
+
+	last, ok := deque.Last()
+	assert.True(t, ok)
+	assert.Equal(t, last, "3")
+
+	assert.Equal(t, deque.Size(), 3)
+}
+
+func TestDequePop_releases_references(t *testing.T) {
+	deque := NewDeque[*int]()
+
+	value := 1
+	deque.Append(&value)
+	deque.Pop()
+
+	for _, slot := range deque.buffer {
+		assert.Nil(t, slot)
+	}
+}
+
+func TestDequePositional_out_of_range(t *testing.T) {
+	var deque Deque[string]
+	deque.Append("1")
+
+	for _, i := range []int{-1, 1, 2} {
+		_, ok := deque.At(i)
+		assert.False(t, ok)
+
+		_, ok = deque.RemoveAt(i)
+		assert.False(t, ok)
+
+		assert.False(t, deque.Swap(0, i))
+		assert.False(t, deque.Swap(i, 0))
+	}
+
+	assert.False(t, deque.InsertAt(-1, "x"))
+	assert.False(t, deque.InsertAt(2, "x"))
+	assert.True(t, deque.InsertAt(1, "2"))
+	assert.Equal(t, deque.ToSlice(), []string{"1", "2"})
+}
+
+func TestDequePositional_matches_slice_model(t *testing.T) {
+	var deque Deque[int]
+	var model []int
+	random := rand.New(rand.NewSource(11))
+
+	for step := 0; step < 5000; step++ {
+		i := random.Intn(len(model)+3) - 1
+		inRange := i >= 0 && i < len(model)
+
+		switch random.Intn(7) {
+		case 0, 1:
+			ok := deque.InsertAt(i, step)
+			assert.Equal(t, ok, i >= 0 && i <= len(model))
+			if ok {
+				model = append(model[:i], append([]int{step}, model[i:]...)...)
+			}
+		case 2:
+			value, ok := deque.RemoveAt(i)
+			assert.Equal(t, ok, inRange)
+			if ok {
+				assert.Equal(t, value, model[i])
+				model = append(model[:i], model[i+1:]...)
+			}
+		case 3:
+			j := random.Intn(len(model) + 1)
+			ok := deque.Swap(i, j)
+			assert.Equal(t, ok, inRange && j < len(model))
+			if ok {
+				model[i], model[j] = model[j], model[i]
+			}
+		case 4:
+			deque.Append(step)
+			model = append(model, step)
+		case 5:
+			deque.Prepend(step)
+			model = append([]int{step}, model...)
+		case 6:
+			if value, ok := deque.Shift(); ok {
+				assert.Equal(t, value, model[0])
+				model = model[1:]
+			}
+		}
+
+		value, ok := deque.At(i)
+		assert.Equal(t, ok, i >= 0 && i < len(model))
+		if ok {
+			assert.Equal(t, value, model[i])
+		}
+	}
+
+	assert.Equal(t, deque.ToSlice(), model)
+}
+
+func TestDequeRemoveAt_releases_references(t *testing.T) {
+	deque := NewDeque[*int]()
+
+	values := []int{1, 2, 3, 4}
+	for i := range values {
+		deque.Append(&values[i])
+	}
+
+	deque.RemoveAt(0)
+	deque.RemoveAt(2)
+	deque.RemoveAt(1)
+	deque.RemoveAt(0)
+
+	for _, slot := range deque.buffer {
+		assert.Nil(t, slot)
+	}
+}
+
+func TestDequeBatch(t *testing.T) {
+	var deque Deque[int]
+
+	// Prepending 5 elements to a deque of capacity 8 wraps around
+	deque.AppendBatch([]int{4, 5, 6})
+	deque.PrependBatch([]int{3, 2, 1, 0})
+	assert.Equal(t, deque.ToSlice(), []int{0, 1, 2, 3, 4, 5, 6})
+
+	assert.Equal(t, deque.PopN(2), []int{6, 5})
+	assert.Equal(t, deque.ShiftN(2), []int{0, 1})
+	assert.Equal(t, deque.Drain(), []int{2, 3, 4})
+	assert.Empty(t, deque.PopN(1))
+	assert.Empty(t, deque.ShiftN(-1))
+	assert.Equal(t, deque.Size(), 0)
+}
+
+func TestDequeDrain_releases_references(t *testing.T) {
+	deque := NewDeque[*int]()
+
+	values := []int{1, 2, 3}
+	for i := range values {
+		deque.Append(&values[i])
+	}
+	assert.Equal(t, len(deque.Drain()), 3)
+
+	for _, slot := range deque.buffer {
+		assert.Nil(t, slot)
+	}
+}
+
+func TestDequeIter(t *testing.T) {
+	var deque Deque[string]
+	deque.AppendBatch([]string{"a", "b", "c"})
+
+	var seen []string
+	deque.Iter(func(item string) bool {
+		// The iterated elements are a snapshot of the deque
+		deque.Append(item)
+		seen = append(seen, item)
+		return item != "b"
+	})
+
+	assert.Equal(t, seen, []string{"a", "b"})
+	assert.Equal(t, deque.ToSlice(), []string{"a", "b", "c", "a", "b"})
+}
@@ -0,0 +1,14 @@
+/*
+Package generic provides type parameterized versions of the lane
+priority queue, queue, stack and deque data structures. Values are
+stored and returned as their own type instead of interface{}, so that
+no type assertion is needed when items move from one structure to
+another. PQueueFunc orders its items by priorities of any type, using a
+comparison function.
+
+The zero value of every structure but PQueueFunc, which needs a
+comparison function, is an empty structure ready to use, a zero PQueue
+being max ordered. Like their lane counterparts, every operation over
+a structure is synchronized and safe for concurrent usage.
+*/
+package generic
@@ -0,0 +1,183 @@
+package generic
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/oleiade/lane"
+	"github.com/stretchr/testify/assert"
+)
+
+// container adapts every structure of the package to a common put/take
+// interface, so that the same scenarios exercise all of them with the
+// same element types.
+type container[T any] interface {
+	put(value T)
+	take() (T, bool)
+	peek() (T, bool)
+	size() int
+}
+
+type dequeContainer[T any] struct{ Deque[T] }
+
+func (c *dequeContainer[T]) put(value T)     { c.Append(value) }
+func (c *dequeContainer[T]) take() (T, bool) { return c.Shift() }
+func (c *dequeContainer[T]) peek() (T, bool) { return c.First() }
+func (c *dequeContainer[T]) size() int       { return c.Size() }
+
+type queueContainer[T any] struct{ Queue[T] }
+
+func (c *queueContainer[T]) put(value T)     { c.Enqueue(value) }
+func (c *queueContainer[T]) take() (T, bool) { return c.Dequeue() }
+func (c *queueContainer[T]) peek() (T, bool) { return c.Head() }
+func (c *queueContainer[T]) size() int       { return c.Size() }
+
+type stackContainer[T any] struct{ Stack[T] }
+
+func (c *stackContainer[T]) put(value T)     { c.Push(value) }
+func (c *stackContainer[T]) take() (T, bool) { return c.Pop() }
+func (c *stackContainer[T]) peek() (T, bool) { return c.Head() }
+func (c *stackContainer[T]) size() int       { return c.Size() }
+
+// pqueueContainer pushes values with an increasing priority on a
+// min ordered queue, so that it behaves as a FIFO.
+type pqueueContainer[T any] struct {
+	PQueue[T, int]
+	mu   sync.Mutex
+	next int
+}
+
+func newPQueueContainer[T any]() *pqueueContainer[T] {
+	return &pqueueContainer[T]{PQueue: PQueue[T, int]{pqType: lane.MINPQ}}
+}
+
+func (c *pqueueContainer[T]) put(value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Push(value, c.next)
+	c.next++
+}
+
+func (c *pqueueContainer[T]) take() (T, bool) {
+	value, _, ok := c.Pop()
+	return value, ok
+}
+
+func (c *pqueueContainer[T]) peek() (T, bool) {
+	value, _, ok := c.Head()
+	return value, ok
+}
+
+func (c *pqueueContainer[T]) size() int { return c.Size() }
+
+type containerFactory[T any] struct {
+	name string
+	lifo bool
+	new  func() container[T]
+}
+
+func containerFactories[T any]() []containerFactory[T] {
+	return []containerFactory[T]{
+		{"Deque", false, func() container[T] { return &dequeContainer[T]{} }},
+		{"Queue", false, func() container[T] { return &queueContainer[T]{} }},
+		{"Stack", true, func() container[T] { return &stackContainer[T]{} }},
+		{"PQueue", false, func() container[T] { return newPQueueContainer[T]() }},
+	}
+}
+
+// runContainerSuite runs the shared scenarios against every structure
+// using the provided values, which must hold at least two elements.
+func runContainerSuite[T any](t *testing.T, values []T) {
+	for _, factory := range containerFactories[T]() {
+		factory := factory
+
+		t.Run(factory.name, func(t *testing.T) {
+			t.Run("empty", func(t *testing.T) {
+				testContainerEmpty(t, factory.new())
+			})
+			t.Run("order", func(t *testing.T) {
+				testContainerOrder(t, factory.new(), values, factory.lifo)
+			})
+			t.Run("concurrent", func(t *testing.T) {
+				testContainerConcurrent(t, factory.new(), values)
+			})
+		})
+	}
+}
+
+func testContainerEmpty[T any](t *testing.T, c container[T]) {
+	var zero T
+
+	value, ok := c.take()
+	assert.False(t, ok)
+	assert.Equal(t, zero, value)
+
+	value, ok = c.peek()
+	assert.False(t, ok)
+	assert.Equal(t, zero, value)
+
+	assert.Equal(t, 0, c.size())
+}
+
+func testContainerOrder[T any](t *testing.T, c container[T], values []T, lifo bool) {
+	for _, value := range values {
+		c.put(value)
+	}
+	assert.Equal(t, len(values), c.size())
+
+	for i := range values {
+		expected := values[i]
+		if lifo {
+			expected = values[len(values)-1-i]
+		}
+
+		head, ok := c.peek()
+		assert.True(t, ok)
+		assert.Equal(t, expected, head)
+
+		value, ok := c.take()
+		assert.True(t, ok)
+		assert.Equal(t, expected, value)
+	}
+
+	_, ok := c.take()
+	assert.False(t, ok)
+}
+
+func testContainerConcurrent[T any](t *testing.T, c container[T], values []T) {
+	const workers = 8
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, value := range values {
+				c.put(value)
+				if _, ok := c.take(); !ok {
+					t.Error("expected a value to be available")
+				}
+				c.put(value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, workers*len(values), c.size())
+}
+
+func TestContainers_int(t *testing.T) {
+	runContainerSuite(t, []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9})
+}
+
+func TestContainers_string(t *testing.T) {
+	runContainerSuite(t, []string{"abc", "123", "easy as", "do re mi"})
+}
+
+type point struct{ x, y int }
+
+func TestContainers_struct(t *testing.T) {
+	runContainerSuite(t, []point{{1, 2}, {3, 4}, {0, 0}, {-1, 7}})
+}
@@ -0,0 +1,238 @@
+package generic
+
+import (
+	"sync"
+
+	"github.com/oleiade/lane"
+)
+
+// Ordered is the set of types which can be used as PQueue priorities,
+// those supporting the < operator.
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 |
+		~string
+}
+
+// Item is a priority queue item, as returned by the PQueue batch
+// operations. Values are stored inline, without boxing.
+type Item[T any, P Ordered] struct {
+	Value    T
+	Priority P
+}
+
+// PQueue is a heap priority queue data structure implementation.
+// It can be whether max (lane.MAXPQ) or min (lane.MINPQ) ordered and
+// it is synchronized and is safe for concurrent operations.
+//
+// The zero value PQueue is an empty max ordered priority queue.
+type PQueue[T any, P Ordered] struct {
+	sync.RWMutex
+	items  []Item[T, P]
+	pqType lane.PQType
+}
+
+// NewPQueue creates a new priority queue with the provided pqtype
+// ordering type
+func NewPQueue[T any, P Ordered](pqType lane.PQType) *PQueue[T, P] {
+	return &PQueue[T, P]{
+		pqType: pqType,
+	}
+}
+
+// Push the value item into the priority queue with provided priority.
+func (pq *PQueue[T, P]) Push(value T, priority P) {
+	pq.Lock()
+	defer pq.Unlock()
+
+	pq.items = append(pq.items, Item[T, P]{Value: value, Priority: priority})
+	pq.swim(len(pq.items) - 1)
+}
+
+// Pop removes and returns the highest/lowest priority item (depending
+// on whether you're using a MINPQ or MAXPQ) from the priority queue.
+// The boolean is false if the queue is empty.
+func (pq *PQueue[T, P]) Pop() (T, P, bool) {
+	pq.Lock()
+	defer pq.Unlock()
+
+	if len(pq.items) == 0 {
+		var head Item[T, P]
+		return head.Value, head.Priority, false
+	}
+
+	head := pq.pop()
+
+	return head.Value, head.Priority, true
+}
+
+// Head returns the highest/lowest priority item (depending on whether
+// you're using a MINPQ or MAXPQ) from the priority queue. The boolean
+// is false if the queue is empty.
+func (pq *PQueue[T, P]) Head() (T, P, bool) {
+	pq.RLock()
+	defer pq.RUnlock()
+
+	var head Item[T, P]
+	if len(pq.items) == 0 {
+		return head.Value, head.Priority, false
+	}
+
+	head = pq.items[0]
+
+	return head.Value, head.Priority, true
+}
+
+// Size returns the elements present in the priority queue count
+func (pq *PQueue[T, P]) Size() int {
+	pq.RLock()
+	defer pq.RUnlock()
+
+	return len(pq.items)
+}
+
+// Empty checks if the priority queue is empty
+func (pq *PQueue[T, P]) Empty() bool {
+	return pq.Size() == 0
+}
+
+// PushBatch pushes the items into the priority queue holding the lock
+// once, as successive Push calls would.
+func (pq *PQueue[T, P]) PushBatch(items []Item[T, P]) {
+	pq.Lock()
+	defer pq.Unlock()
+
+	for _, item := range items {
+		pq.items = append(pq.items, item)
+		pq.swim(len(pq.items) - 1)
+	}
+}
+
+// Drain removes every item from the priority queue and returns them
+// in pop order. The items are returned in a single allocation.
+func (pq *PQueue[T, P]) Drain() []Item[T, P] {
+	pq.Lock()
+	defer pq.Unlock()
+
+	return pq.popN(len(pq.items))
+}
+
+// PopN removes up to n items from the priority queue and returns them
+// in pop order. The items are returned in a single allocation.
+func (pq *PQueue[T, P]) PopN(n int) []Item[T, P] {
+	pq.Lock()
+	defer pq.Unlock()
+
+	return pq.popN(n)
+}
+
+// ToSlice returns a snapshot of the priority queue items, in pop order.
+// The queue is only locked while copying its items, and is left
+// untouched.
+func (pq *PQueue[T, P]) ToSlice() []Item[T, P] {
+	pq.RLock()
+	snapshot := &PQueue[T, P]{
+		items:  append([]Item[T, P](nil), pq.items...),
+		pqType: pq.pqType,
+	}
+	pq.RUnlock()
+
+	return snapshot.popN(len(snapshot.items))
+}
+
+// Iter calls fn with the items of a snapshot of the priority queue, in
+// pop order, until fn returns false. As fn is called once the queue is
+// unlocked, it may call the queue methods, which don't change the
+// iterated items.
+func (pq *PQueue[T, P]) Iter(fn func(value T, priority P) bool) {
+	for _, item := range pq.ToSlice() {
+		if !fn(item.Value, item.Priority) {
+			return
+		}
+	}
+}
+
+// sorted returns the queue values in pop order, leaving the queue
+// untouched.
+func (pq *PQueue[T, P]) sorted() []T {
+	items := pq.ToSlice()
+
+	values := make([]T, len(items))
+	for i, item := range items {
+		values[i] = item.Value
+	}
+
+	return values
+}
+
+// popN removes up to n items from the heap head. It must be called
+// holding the write lock.
+func (pq *PQueue[T, P]) popN(n int) []Item[T, P] {
+	if n > len(pq.items) {
+		n = len(pq.items)
+	}
+
+	if n <= 0 {
+		return nil
+	}
+
+	popped := make([]Item[T, P], n)
+	for i := range popped {
+		popped[i] = pq.pop()
+	}
+
+	return popped
+}
+
+// pop removes the heap head. It must be called holding the write lock
+// on a non empty queue.
+func (pq *PQueue[T, P]) pop() Item[T, P] {
+	last := len(pq.items) - 1
+	head := pq.items[0]
+
+	pq.items[0] = pq.items[last]
+	pq.items[last] = Item[T, P]{}
+	pq.items = pq.items[:last]
+	pq.sink(0)
+
+	return head
+}
+
+// less reports whether the item at index i has a lower precedence
+// than the one at index j.
+func (pq *PQueue[T, P]) less(i, j int) bool {
+	if pq.pqType == lane.MINPQ {
+		return pq.items[j].Priority < pq.items[i].Priority
+	}
+
+	return pq.items[i].Priority < pq.items[j].Priority
+}
+
+func (pq *PQueue[T, P]) swim(k int) {
+	for k > 0 && pq.less((k-1)/2, k) {
+		parent := (k - 1) / 2
+		pq.items[parent], pq.items[k] = pq.items[k], pq.items[parent]
+		k = parent
+	}
+}
+
+func (pq *PQueue[T, P]) sink(k int) {
+	for {
+		j := 2*k + 1
+		if j >= len(pq.items) {
+			return
+		}
+
+		if j+1 < len(pq.items) && pq.less(j, j+1) {
+			j++
+		}
+
+		if !pq.less(k, j) {
+			return
+		}
+
+		pq.items[k], pq.items[j] = pq.items[j], pq.items[k]
+		k = j
+	}
+}
@@ -0,0 +1,147 @@
+package generic
+
+import "sync"
+
+// PQueueFunc is a heap priority queue data structure implementation
+// ordering its items with a comparison function, so that priorities can
+// be of any type, such as time.Time deadlines or structs compared field
+// by field. Items of equal priority pop in the order they were pushed
+// in. It is synchronized and is safe for concurrent operations.
+//
+// A PQueueFunc must be created with NewPQueueFunc: its zero value has
+// no comparison function, and pushing into it panics.
+type PQueueFunc[T any, P any] struct {
+	sync.RWMutex
+	items    []funcItem[T, P]
+	less     func(a, b P) bool
+	sequence uint64
+}
+
+// funcItem is a PQueueFunc item, along with its push sequence number
+// breaking the priority ties.
+type funcItem[T any, P any] struct {
+	value    T
+	priority P
+	seq      uint64
+}
+
+// NewPQueueFunc creates a new priority queue ordered by less, which
+// reports whether priority a pops before priority b. For instance,
+// func(a, b time.Time) bool { return a.Before(b) } pops the earliest
+// deadlines first. It panics if less is nil.
+func NewPQueueFunc[T any, P any](less func(a, b P) bool) *PQueueFunc[T, P] {
+	if less == nil {
+		panic("lane: nil priority queue comparison function")
+	}
+
+	return &PQueueFunc[T, P]{less: less}
+}
+
+// Push the value item into the priority queue with provided priority.
+func (pq *PQueueFunc[T, P]) Push(value T, priority P) {
+	pq.Lock()
+	defer pq.Unlock()
+
+	if pq.less == nil {
+		panic("lane: push into a PQueueFunc not created with NewPQueueFunc")
+	}
+
+	pq.sequence++
+	pq.items = append(pq.items, funcItem[T, P]{value: value, priority: priority, seq: pq.sequence})
+	pq.swim(len(pq.items) - 1)
+}
+
+// Pop removes and returns the first item in the comparison function
+// order from the priority queue. The boolean is false if the queue is
+// empty.
+func (pq *PQueueFunc[T, P]) Pop() (T, P, bool) {
+	pq.Lock()
+	defer pq.Unlock()
+
+	if len(pq.items) == 0 {
+		var head funcItem[T, P]
+		return head.value, head.priority, false
+	}
+
+	last := len(pq.items) - 1
+	head := pq.items[0]
+
+	pq.items[0] = pq.items[last]
+	pq.items[last] = funcItem[T, P]{}
+	pq.items = pq.items[:last]
+	pq.sink(0)
+
+	return head.value, head.priority, true
+}
+
+// Head returns the first item in the comparison function order from the
+// priority queue. The boolean is false if the queue is empty.
+func (pq *PQueueFunc[T, P]) Head() (T, P, bool) {
+	pq.RLock()
+	defer pq.RUnlock()
+
+	var head funcItem[T, P]
+	if len(pq.items) == 0 {
+		return head.value, head.priority, false
+	}
+
+	head = pq.items[0]
+
+	return head.value, head.priority, true
+}
+
+// Size returns the elements present in the priority queue count
+func (pq *PQueueFunc[T, P]) Size() int {
+	pq.RLock()
+	defer pq.RUnlock()
+
+	return len(pq.items)
+}
+
+// Empty checks if the priority queue is empty
+func (pq *PQueueFunc[T, P]) Empty() bool {
+	return pq.Size() == 0
+}
+
+// before reports whether the item at index i pops before the one at
+// index j.
+func (pq *PQueueFunc[T, P]) before(i, j int) bool {
+	a, b := pq.items[i], pq.items[j]
+	if pq.less(a.priority, b.priority) {
+		return true
+	}
+
+	if pq.less(b.priority, a.priority) {
+		return false
+	}
+
+	return a.seq < b.seq
+}
+
+func (pq *PQueueFunc[T, P]) swim(k int) {
+	for k > 0 && pq.before(k, (k-1)/2) {
+		parent := (k - 1) / 2
+		pq.items[parent], pq.items[k] = pq.items[k], pq.items[parent]
+		k = parent
+	}
+}
+
+func (pq *PQueueFunc[T, P]) sink(k int) {
+	for {
+		j := 2*k + 1
+		if j >= len(pq.items) {
+			return
+		}
+
+		if j+1 < len(pq.items) && pq.before(j+1, j) {
+			j++
+		}
+
+		if !pq.before(j, k) {
+			return
+		}
+
+		pq.items[k], pq.items[j] = pq.items[j], pq.items[k]
+		k = j
+	}
+}
@@ -0,0 +1,114 @@
+package generic
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPQueueFunc_nil_comparison_function(t *testing.T) {
+	assert.Panics(t, func() { NewPQueueFunc[string, int](nil) })
+}
+
+func TestPQueueFunc_zero_value(t *testing.T) {
+	var pqueue PQueueFunc[string, int]
+
+	_, _, ok := pqueue.Pop()
+	assert.False(t, ok)
+	assert.True(t, pqueue.Empty())
+	assert.PanicsWithValue(t, "lane: push into a PQueueFunc not created with NewPQueueFunc", func() {
+		pqueue.Push("a", 1)
+	})
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueFunc_time_priorities(t *testing.T) {
+	pqueue := NewPQueueFunc[string](func(a, b time.Time) bool { return a.Before(b) })
+
+	now := time.Now()
+	pqueue.Push("later", now.Add(time.Hour))
+	pqueue.Push("now", now)
+	pqueue.Push("sooner", now.Add(time.Minute))
+
+	head, deadline, ok := pqueue.Head()
+	assert.True(t, ok)
+	assert.Equal(t, head, "now")
+	assert.True(t, deadline.Equal(now))
+
+	for _, expected := range []string{"now", "sooner", "later"} {
+		value, _, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+	}
+
+	_, _, ok = pqueue.Pop()
+	assert.False(t, ok)
+	_, _, ok = pqueue.Head()
+	assert.False(t, ok)
+	assert.True(t, pqueue.Empty())
+}
+
+func TestPQueueFunc_float_scores_descending(t *testing.T) {
+	pqueue := NewPQueueFunc[int](func(a, b float64) bool { return a > b })
+	for i, score := range []float64{0.5, 2.25, -1, 1.5} {
+		pqueue.Push(i, score)
+	}
+
+	var scores []float64
+	for !pqueue.Empty() {
+		_, score, _ := pqueue.Pop()
+		scores = append(scores, score)
+	}
+	assert.Equal(t, scores, []float64{2.25, 1.5, 0.5, -1})
+}
+
+func TestPQueueFunc_multi_field_priorities(t *testing.T) {
+	type rank struct {
+		Tier  int
+		Score float64
+	}
+
+	pqueue := NewPQueueFunc[string](func(a, b rank) bool {
+		if a.Tier != b.Tier {
+			return a.Tier < b.Tier
+		}
+		return a.Score > b.Score
+	})
+	pqueue.Push("c", rank{Tier: 2, Score: 9})
+	pqueue.Push("b", rank{Tier: 1, Score: 1})
+	pqueue.Push("a", rank{Tier: 1, Score: 5})
+
+	for _, expected := range []string{"a", "b", "c"} {
+		value, _, _ := pqueue.Pop()
+		assert.Equal(t, value, expected)
+	}
+}
+
+func TestPQueueFunc_equal_priorities_in_push_order(t *testing.T) {
+	pqueue := NewPQueueFunc[int](func(a, b int) bool { return a < b })
+
+	rng := rand.New(rand.NewSource(1))
+	priorities := make([]int, 1000)
+	for i := range priorities {
+		priorities[i] = rng.Intn(10)
+		pqueue.Push(i, priorities[i])
+	}
+
+	expected := make([]int, len(priorities))
+	for i := range expected {
+		expected[i] = i
+	}
+	sort.SliceStable(expected, func(i, j int) bool {
+		return priorities[expected[i]] < priorities[expected[j]]
+	})
+
+	for _, index := range expected {
+		value, priority, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, index)
+		assert.Equal(t, priority, priorities[index])
+	}
+}
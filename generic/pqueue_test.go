@@ -0,0 +1,212 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/oleiade/lane"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueue_zero_value_is_max_ordered(t *testing.T) {
+	var pqueue PQueue[string, int]
+
+	pqueue.Push("1", 1)
+	pqueue.Push("3", 3)
+	pqueue.Push("2", 2)
+
+	value, priority, ok := pqueue.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, value, "3")
+	assert.Equal(t, priority, 3)
+}
+
+func TestMaxPQueuePop_protects_max_order(t *testing.T) {
+	pqueue := NewPQueue[int, int](lane.MAXPQ)
+
+	for _, priority := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6} {
+		pqueue.Push(priority*10, priority)
+	}
+
+	for expected := 9; expected > 0; expected-- {
+		value, priority, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected*10)
+		assert.Equal(t, priority, expected)
+	}
+
+	_, _, ok := pqueue.Pop()
+	assert.False(t, ok)
+}
+
+func TestMinPQueuePop_protects_min_order(t *testing.T) {
+	pqueue := NewPQueue[string, float64](lane.MINPQ)
+
+	pqueue.Push("c", 2.5)
+	pqueue.Push("a", -1.25)
+	pqueue.Push("b", 0.5)
+
+	for _, expected := range []string{"a", "b", "c"} {
+		head, _, ok := pqueue.Head()
+		assert.True(t, ok)
+		assert.Equal(t, head, expected)
+
+		value, _, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+	}
+
+	assert.True(t, pqueue.Empty())
+}
+
+func TestPQueue_string_priorities(t *testing.T) {
+	pqueue := NewPQueue[int, string](lane.MINPQ)
+
+	pqueue.Push(2, "beta")
+	pqueue.Push(1, "alpha")
+	pqueue.Push(3, "gamma")
+
+	for expected := 1; expected <= 3; expected++ {
+		value, _, ok := pqueue.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+	}
+}
+
+func TestPQueueDrain(t *testing.T) {
+	pqueue := NewPQueue[int, int](lane.MINPQ)
+	for _, priority := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6} {
+		pqueue.Push(priority*10, priority)
+	}
+
+	drained := pqueue.Drain()
+	assert.Equal(t, len(drained), 9)
+	for i, item := range drained {
+		assert.Equal(t, item, Item[int, int]{Value: (i + 1) * 10, Priority: i + 1})
+	}
+
+	assert.True(t, pqueue.Empty())
+	assert.Nil(t, pqueue.Drain())
+}
+
+func TestPQueuePopN(t *testing.T) {
+	pqueue := NewPQueue[string, int](lane.MAXPQ)
+	pqueue.Push("a", 1)
+	pqueue.Push("c", 3)
+	pqueue.Push("b", 2)
+
+	assert.Equal(t, pqueue.PopN(2), []Item[string, int]{{"c", 3}, {"b", 2}})
+	assert.Equal(t, pqueue.PopN(5), []Item[string, int]{{"a", 1}})
+	assert.Nil(t, pqueue.PopN(1))
+	assert.Nil(t, pqueue.PopN(-1))
+}
+
+func TestPQueuePushBatch(t *testing.T) {
+	var pqueue PQueue[string, int]
+	pqueue.Push("b", 2)
+	pqueue.PushBatch([]Item[string, int]{{"a", 1}, {"d", 4}, {"c", 3}})
+
+	assert.Equal(t, pqueue.Size(), 4)
+	assert.Equal(t, pqueue.Drain(), []Item[string, int]{{"d", 4}, {"c", 3}, {"b", 2}, {"a", 1}})
+}
+
+func TestPQueueToSlice_leaves_queue_untouched(t *testing.T) {
+	pqueue := NewPQueue[string, int](lane.MINPQ)
+	pqueue.PushBatch([]Item[string, int]{{"c", 3}, {"a", 1}, {"b", 2}})
+
+	assert.Equal(t, pqueue.ToSlice(), []Item[string, int]{{"a", 1}, {"b", 2}, {"c", 3}})
+	assert.Equal(t, pqueue.Size(), 3)
+
+	value, _, ok := pqueue.Head()
+	assert.True(t, ok)
+	assert.Equal(t, value, "a")
+}
+
+func TestPQueueIter(t *testing.T) {
+	pqueue := NewPQueue[string, int](lane.MAXPQ)
+	pqueue.PushBatch([]Item[string, int]{{"a", 1}, {"c", 3}, {"b", 2}})
+
+	var seen []string
+	pqueue.Iter(func(value string, priority int) bool {
+		// The iterated items are a snapshot of the queue
+		pqueue.Push(value, priority)
+		seen = append(seen, value)
+		return priority > 2
+	})
+
+	assert.Equal(t, seen, []string{"c", "b"})
+	assert.Equal(t, pqueue.Size(), 5)
+}
+
+func TestPQueueDrain_allocates_once(t *testing.T) {
+	pqueue := NewPQueue[int, int](lane.MAXPQ)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		pqueue.items = pqueue.items[:0]
+		// Items sorted by decreasing priority make a valid max heap
+		for i := 1000; i > 0; i-- {
+			pqueue.items = append(pqueue.items, Item[int, int]{Value: i, Priority: i})
+		}
+
+		pqueue.Drain()
+	})
+	assert.Equal(t, allocs, float64(1))
+}
+
+func BenchmarkPQueueDrain(b *testing.B) {
+	pqueue := NewPQueue[int, int](lane.MAXPQ)
+	pqueue.items = make([]Item[int, int], 0, 1<<20)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for value := 1 << 20; value > 0; value-- {
+			pqueue.items = append(pqueue.items, Item[int, int]{Value: value, Priority: value})
+		}
+		b.StartTimer()
+
+		pqueue.Drain()
+	}
+}
+
+func TestPQueue_push_pop_does_not_allocate(t *testing.T) {
+	pqueue := NewPQueue[int, int](lane.MINPQ)
+	for i := 0; i < 64; i++ {
+		pqueue.Push(i, i)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		value, priority, _ := pqueue.Pop()
+		pqueue.Push(value, priority+64)
+	})
+	assert.Equal(t, allocs, float64(0))
+}
+
+// BenchmarkPQueuePushPop compares the steady state push and pop of
+// values of their own type to the boxing of lane.PQueue.
+func BenchmarkPQueuePushPop(b *testing.B) {
+	pqueue := NewPQueue[int, int](lane.MINPQ)
+	for i := 0; i < 1024; i++ {
+		pqueue.Push(i, i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		value, priority, _ := pqueue.Pop()
+		pqueue.Push(value+1024, priority+1024)
+	}
+}
+
+func BenchmarkPQueuePushPop_boxed(b *testing.B) {
+	pqueue := lane.NewPQueue(lane.MINPQ)
+	for i := 0; i < 1024; i++ {
+		pqueue.Push(i, i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		value, priority := pqueue.Pop()
+		pqueue.Push(value.(int)+1024, priority+1024)
+	}
+}
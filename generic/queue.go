@@ -0,0 +1,76 @@
+package generic
+
+// Queue is a FIFO (First in first out) data structure implementation.
+// It is based on a deque container and focuses its API on core
+// functionalities: Enqueue, Dequeue, Head, Size, Empty. Every operations
+// time complexity is amortized O(1).
+//
+// As it is implemented using a Deque container, every operations
+// over a Queue are synchronized and safe for concurrent usage.
+type Queue[T any] struct {
+	Deque[T]
+}
+
+// NewQueue creates a new empty Queue
+func NewQueue[T any]() *Queue[T] {
+	return &Queue[T]{}
+}
+
+// Enqueue adds an item at the back of the queue
+func (q *Queue[T]) Enqueue(item T) {
+	q.Prepend(item)
+}
+
+// Dequeue removes and returns the front queue item. The boolean is
+// false if the queue is empty.
+func (q *Queue[T]) Dequeue() (T, bool) {
+	return q.Deque.Pop()
+}
+
+// Head returns the front queue item. The boolean is false if the
+// queue is empty.
+func (q *Queue[T]) Head() (T, bool) {
+	return q.Last()
+}
+
+// EnqueueBatch adds the items at the back of the queue holding the lock
+// once, as successive Enqueue calls would.
+func (q *Queue[T]) EnqueueBatch(items []T) {
+	q.PrependBatch(items)
+}
+
+// DequeueN removes up to n items from the front of the queue holding
+// the lock once, and returns them in dequeue order.
+func (q *Queue[T]) DequeueN(n int) []T {
+	return q.PopN(n)
+}
+
+// Drain removes every item of the queue at once, and returns them in
+// dequeue order.
+func (q *Queue[T]) Drain() []T {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.removeN(q.count, (*Deque[T]).popBack)
+}
+
+// ToSlice returns a snapshot of the queue items, in dequeue order
+func (q *Queue[T]) ToSlice() []T {
+	items := q.Deque.ToSlice()
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+
+	return items
+}
+
+// Iter calls fn with the items of a snapshot of the queue, in dequeue
+// order, until fn returns false. As fn is called once the queue is
+// unlocked, it may call the queue methods.
+func (q *Queue[T]) Iter(fn func(item T) bool) {
+	for _, item := range q.ToSlice() {
+		if !fn(item) {
+			return
+		}
+	}
+}
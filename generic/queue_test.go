@@ -0,0 +1,43 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue_zero_value_is_usable(t *testing.T) {
+	var queue Queue[string]
+
+	queue.Enqueue("grumpyClient")
+	queue.Enqueue("happyClient")
+
+	head, ok := queue.Head()
+	assert.True(t, ok)
+	assert.Equal(t, head, "grumpyClient")
+
+	value, ok := queue.Dequeue()
+	assert.True(t, ok)
+	assert.Equal(t, value, "grumpyClient")
+	assert.Equal(t, queue.Size(), 1)
+}
+
+func TestQueueBatch(t *testing.T) {
+	var queue Queue[int]
+
+	queue.EnqueueBatch([]int{1, 2, 3, 4, 5})
+	queue.Enqueue(6)
+	assert.Equal(t, queue.ToSlice(), []int{1, 2, 3, 4, 5, 6})
+
+	var seen []int
+	queue.Iter(func(item int) bool {
+		seen = append(seen, item)
+		return item < 3
+	})
+	assert.Equal(t, seen, []int{1, 2, 3})
+
+	assert.Equal(t, queue.DequeueN(2), []int{1, 2})
+	assert.Equal(t, queue.PopN(1), []int{3})
+	assert.Equal(t, queue.Drain(), []int{4, 5, 6})
+	assert.True(t, queue.Empty())
+}
@@ -0,0 +1,46 @@
+package generic
+
+// Stack is a LIFO (Last in first out) data structure implementation.
+// It is based on a deque container and focuses its API on core
+// functionalities: Push, Pop, Head, Size, Empty. Every operations
+// time complexity is amortized O(1).
+//
+// As it is implemented using a Deque container, every operations
+// over a Stack are synchronized and safe for concurrent usage.
+type Stack[T any] struct {
+	Deque[T]
+}
+
+// NewStack creates a new empty Stack
+func NewStack[T any]() *Stack[T] {
+	return &Stack[T]{}
+}
+
+// Push adds on an item on the top of the Stack
+func (s *Stack[T]) Push(item T) {
+	s.Prepend(item)
+}
+
+// Pop removes and returns the item on the top of the Stack. The
+// boolean is false if the stack is empty.
+func (s *Stack[T]) Pop() (T, bool) {
+	return s.Shift()
+}
+
+// Head returns the item on the top of the stack. The boolean is
+// false if the stack is empty.
+func (s *Stack[T]) Head() (T, bool) {
+	return s.First()
+}
+
+// PushBatch adds the items on the top of the Stack holding the lock
+// once, as successive Push calls would: the last item ends up on top.
+func (s *Stack[T]) PushBatch(items []T) {
+	s.PrependBatch(items)
+}
+
+// PopN removes up to n items from the top of the Stack holding the lock
+// once, and returns them in pop order.
+func (s *Stack[T]) PopN(n int) []T {
+	return s.ShiftN(n)
+}
@@ -0,0 +1,42 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStack_zero_value_is_usable(t *testing.T) {
+	var stack Stack[string]
+
+	stack.Push("redPlate")
+	stack.Push("bluePlate")
+
+	head, ok := stack.Head()
+	assert.True(t, ok)
+	assert.Equal(t, head, "bluePlate")
+
+	value, ok := stack.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, value, "bluePlate")
+	assert.Equal(t, stack.Size(), 1)
+}
+
+func TestStackBatch(t *testing.T) {
+	var stack Stack[int]
+
+	stack.PushBatch([]int{1, 2, 3, 4})
+	stack.Push(5)
+	assert.Equal(t, stack.ToSlice(), []int{5, 4, 3, 2, 1})
+
+	var seen []int
+	stack.Iter(func(item int) bool {
+		seen = append(seen, item)
+		return item > 4
+	})
+	assert.Equal(t, seen, []int{5, 4})
+
+	assert.Equal(t, stack.PopN(2), []int{5, 4})
+	assert.Equal(t, stack.Drain(), []int{3, 2, 1})
+	assert.True(t, stack.Empty())
+}
@@ -0,0 +1,13 @@
+package lane
+
+// noCopy may be embedded into structs which must not be copied after
+// the first use, so that go vet's copylocks checker reports copies.
+//
+// See https://golang.org/issues/8005#issuecomment-190753527
+type noCopy struct{}
+
+// Lock is a no-op used by the copylocks checker
+func (*noCopy) Lock() {}
+
+// Unlock is a no-op used by the copylocks checker
+func (*noCopy) Unlock() {}
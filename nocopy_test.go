@@ -0,0 +1,41 @@
+package lane
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoCopy_vet_reports_copies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go vet run in short mode")
+	}
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	output, err := exec.Command(gobin, "vet", "-copylocks", "./testdata/copylocks").CombinedOutput()
+	assert.NotNil(t, err)
+	assert.Contains(t, string(output), "passes lock by value: github.com/oleiade/lane.PQueue")
+}
+
+func TestNoCopy_pointer_usage(t *testing.T) {
+	type scheduler struct {
+		PQueue
+		jobs *PQueue
+	}
+
+	s := &scheduler{jobs: NewPQueue(MINPQ)}
+	s.Push("a", 1)
+	s.jobs.Push("b", 2)
+	s.jobs.Push("c", 1)
+
+	value, _ := s.Pop()
+	assert.Equal(t, value, "a")
+
+	value, _ = s.jobs.Pop()
+	assert.Equal(t, value, "c")
+}
@@ -1,8 +1,14 @@
 package lane
 
 import (
+	"container/list"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // PQType represents a priority queue ordering kind (see MAXPQ and MINPQ)
@@ -13,36 +19,227 @@ const (
 	MINPQ
 )
 
+// ErrInvalidOption is returned by NewPQueueWithOptions when one of
+// the provided options holds an out of range argument.
+var ErrInvalidOption = errors.New("lane: invalid option")
+
+// ErrIncompatibleOptions is returned by NewPQueueWithOptions when two
+// of the provided options can't be used together.
+var ErrIncompatibleOptions = errors.New("lane: incompatible options")
+
+// Item is a value stored in a priority queue along with its priority,
+// and its secondary priority, see Push2.
+type Item struct {
+	Value     interface{}
+	Priority  int
+	Secondary int
+}
+
 type item struct {
-	value    interface{}
-	priority int
+	value     interface{}
+	priority  int64
+	secondary int64
+
+	// seq and token break ties between items of equal priority,
+	// respectively in stable order or random order modes.
+	seq   uint64
+	token int64
+
+	// deadline is the unix nanoseconds time the item expires at, zero
+	// if it doesn't, see PushWithDeadline.
+	deadline int64
+
+	// index is the item position in the heap, zero when the item
+	// isn't part of the heap.
+	index int
+
+	// size is the value size estimation, see WithSizeEstimator.
+	size int
+
+	// gen is incremented every time the item is recycled by the queue
+	// arena, so that stale references to it can be detected.
+	gen uint32
+}
+
+// ItemRef references an item pushed into a PQueue using PushRef. It
+// can be used to notify the queue that the item ordering changed,
+// see Fix.
+type ItemRef struct {
+	item  *item
+	gen   uint32
+	value interface{}
+}
+
+// Value returns the referenced item value
+func (r *ItemRef) Value() interface{} {
+	return r.value
 }
 
 // PQueue is a heap priority queue data structure implementation.
 // It can be whether max or min ordered and it is synchronized
 // and is safe for concurrent operations.
+//
+// The queue is deterministic: a given sequence of operations always
+// pops the items in the same order, including the items of equal
+// priority, whose order is otherwise unspecified. Options departing
+// from it, such as WithRandomTieBreak, are opt-in and seeded.
+//
+// The zero value for a PQueue is an empty max priority queue ready to
+// use. A PQueue must not be copied after first use: use a pointer to
+// share it, or to embed it in a struct which gets copied. Building
+// with the lanedebug tag makes mutating a copied queue panic.
 type PQueue struct {
+	// The 64 bits fields accessed atomically come first, so that they
+	// are 64 bits aligned on 32 bits platforms.
+	metrics   queueMetrics
+	sequence  uint64
+	evictions uint64
+	expired   uint64
+	handoffs  uint64
+	editor    int64
+
+	noCopy noCopy
+	guard  copyGuard
+
 	sync.RWMutex
+	name       string
 	items      []*item
 	elemsCount int
-	comparator func(int, int) bool
+	pqType     PQType
+	comparator func(int64, int64) bool
+
+	secondaryOrder   PQType
+	secondaryOrdered bool
+
+	valueLess  func(a, b interface{}) bool
+	priorityFn func(value interface{}) int
+	buffer     *writeBuffer
+	arena      *itemArena
+	options    []PQueueOption
+
+	jsonValueDecoder func(json.RawMessage) (interface{}, error)
+
+	waiters    *list.List
+	waiting    int32
+	pacing     int
+	maxWaiters int
+	waitSpins  int
+	batches    int
+
+	closed  bool
+	closing int32
+	drained chan struct{}
+
+	bulkChunkSize int
+
+	capacityHint  int
+	shrink        *shrinkPolicy
+	reallocations uint64
+
+	maxItems      int
+	maxBytes      int64
+	sizeEstimator func(value interface{}) int
+	overflow      OverflowPolicy
+	bytes         int64
+
+	stable bool
+
+	clock     func() time.Time
+	monotonic func() time.Duration
+	dedup     *recentDedup
+
+	contention *contentionProfile
+	index      *valueIndex
+	intern     *internTable
+	coalesce   *coalescing
+	bounds     priorityBounds
+	rate       *popRateLimit
+	paced      chan struct{}
+	room       chan struct{}
+	repair     *loadRepair
+	prefetch   *prefetchSlot
+	history    *headHistory
+	fair       *fairMutex
+	watermarks *watermarks
+	expiry     *expiry
+	oplog      *opLog
+	wal        *writeAheadLog
+
+	randomMu sync.Mutex
+	random   *rand.Rand
+}
+
+// PriorityQueue is the interface of the priority queues, such as PQueue
+// and TieredPQueue.
+type PriorityQueue interface {
+	Push(value interface{}, priority int) error
+	Pop() (interface{}, int)
+	Head() (interface{}, int)
+	Size() int
 }
 
-func newItem(value interface{}, priority int) *item {
-	return &item{
-		value:    value,
-		priority: priority,
+// PQueueOption configures an optional PQueue behaviour. Options
+// are applied by NewPQueueWithOptions.
+type PQueueOption func(pq *PQueue) error
+
+// newItem creates a new item holding the tie break and accounting
+// information required by the queue options.
+func (pq *PQueue) newItem(value interface{}, priority int64) *item {
+	item := pq.allocItem()
+	item.value = value
+	item.priority = priority
+
+	if pq.sizeEstimator != nil {
+		item.size = pq.sizeEstimator(value)
+	}
+
+	if pq.stable || pq.coalesce != nil || pq.wal != nil {
+		item.seq = atomic.AddUint64(&pq.sequence, 1)
 	}
+
+	if pq.random != nil {
+		pq.randomMu.Lock()
+		item.token = pq.random.Int63()
+		pq.randomMu.Unlock()
+	}
+
+	return item
+}
+
+// advanceSequence makes the stable order sequence continue past seq, so
+// that the items created from then on follow the restored items carrying
+// it.
+func (pq *PQueue) advanceSequence(seq uint64) {
+	for {
+		current := atomic.LoadUint64(&pq.sequence)
+		if current >= seq || atomic.CompareAndSwapUint64(&pq.sequence, current, seq) {
+			return
+		}
+	}
+}
+
+// allocItem returns a zero item, allocated from the queue arena if
+// it uses one.
+func (pq *PQueue) allocItem() *item {
+	if pq.arena != nil {
+		return pq.arena.alloc()
+	}
+
+	return &item{}
 }
 
 func (i *item) String() string {
 	return fmt.Sprintf("<item value:%s priority:%d>", i.value, i.priority)
 }
 
+func (i *item) export() Item {
+	return Item{Value: i.value, Priority: int(i.priority), Secondary: int(i.secondary)}
+}
+
 // NewPQueue creates a new priority queue with the provided pqtype
 // ordering type
 func NewPQueue(pqType PQType) *PQueue {
-	var cmp func(int, int) bool
+	var cmp func(int64, int64) bool
 
 	if pqType == MAXPQ {
 		cmp = max
@@ -56,50 +253,312 @@ func NewPQueue(pqType PQType) *PQueue {
 	return &PQueue{
 		items:      items,
 		elemsCount: 0,
+		pqType:     pqType,
 		comparator: cmp,
 	}
 }
 
+// NewPQueueWithOptions creates a new priority queue with the provided
+// pqtype ordering type and applies the provided options to it. An
+// error is returned if any of the options is invalid.
+func NewPQueueWithOptions(pqType PQType, options ...PQueueOption) (*PQueue, error) {
+	pq := NewPQueue(pqType)
+
+	for _, option := range options {
+		if err := option(pq); err != nil {
+			return nil, err
+		}
+	}
+
+	if pq.stable && pq.random != nil {
+		return nil, fmt.Errorf("%w: stable order and random tie break", ErrIncompatibleOptions)
+	}
+
+	if err := pq.validateLimits(); err != nil {
+		return nil, err
+	}
+
+	if err := pq.validateCoalescing(); err != nil {
+		return nil, err
+	}
+
+	if err := pq.validatePrefetch(); err != nil {
+		return nil, err
+	}
+
+	if err := pq.startOpLog(); err != nil {
+		return nil, err
+	}
+
+	if err := pq.startWAL(); err != nil {
+		return nil, err
+	}
+
+	pq.options = options
+
+	return pq, nil
+}
+
+// WithStableOrder makes items of equal priority pop in the order
+// they were pushed in. The order is kept by the queue JSON
+// representation, its write-ahead log, its clones and Merge: the items
+// pushed once the queue is restored pop after the restored ones.
+func WithStableOrder() PQueueOption {
+	return func(pq *PQueue) error {
+		pq.stable = true
+		return nil
+	}
+}
+
+// WithRandomTieBreak makes items of equal priority pop in a random
+// order. The order is drawn from a pseudo-random generator initialized
+// with seed, so that it is reproducible from one run to another.
+func WithRandomTieBreak(seed int64) PQueueOption {
+	return func(pq *PQueue) error {
+		pq.random = rand.New(rand.NewSource(seed))
+		return nil
+	}
+}
+
+// WithValueComparator orders the queue items by comparing their values
+// using less instead of their priorities. less reports whether value a
+// has a lower priority than value b. Items whose values are equal
+// according to less are ordered by priority.
+func WithValueComparator(less func(a, b interface{}) bool) PQueueOption {
+	return func(pq *PQueue) error {
+		if less == nil {
+			return fmt.Errorf("%w: nil value comparator", ErrInvalidOption)
+		}
+
+		pq.valueLess = less
+		return nil
+	}
+}
+
+// WithClock sets the function the queue uses to tell the current time,
+// time.Now by default.
+func WithClock(now func() time.Time) PQueueOption {
+	return func(pq *PQueue) error {
+		if now == nil {
+			return fmt.Errorf("%w: nil clock", ErrInvalidOption)
+		}
+
+		pq.clock = now
+		return nil
+	}
+}
+
+// now returns the current time according to the queue clock
+func (pq *PQueue) now() time.Time {
+	if pq.clock == nil {
+		return time.Now()
+	}
+
+	return pq.clock()
+}
+
 // Push the value item into the priority queue with provided priority.
-func (pq *PQueue) Push(value interface{}, priority int) {
-	item := newItem(value, priority)
+// ErrFull is returned if the queue limits don't allow it to fit in.
+func (pq *PQueue) Push(value interface{}, priority int) error {
+	return pq.push(pq.newItem(value, int64(priority)))
+}
 
-	pq.Lock()
-	pq.items = append(pq.items, item)
-	pq.elemsCount += 1
-	pq.swim(pq.Size())
-	pq.Unlock()
+// Push64 pushes the value item into the priority queue with provided 64
+// bits priority, so that priorities outside of the int range, such as
+// nanosecond timestamps on 32 bits platforms, keep their order. Such
+// priorities are truncated by the methods returning int priorities,
+// use Pop64 and Head64 to read them.
+func (pq *PQueue) Push64(value interface{}, priority int64) error {
+	return pq.push(pq.newItem(value, priority))
+}
+
+// PushRef pushes the value item into the priority queue with provided
+// priority and returns a reference to the pushed item.
+func (pq *PQueue) PushRef(value interface{}, priority int) (*ItemRef, error) {
+	item := pq.newItem(value, int64(priority))
+	ref := &ItemRef{item: item, gen: item.gen, value: value}
+
+	if err := pq.push(item); err != nil {
+		return nil, err
+	}
+
+	return ref, nil
+}
+
+func (pq *PQueue) push(item *item) error {
+	if err := pq.checkRecent(item.value); err != nil {
+		pq.release(item)
+		return err
+	}
+
+	if pq.buffer != nil {
+		if !pq.stage(item) {
+			return pq.lockedError("push", ErrClosed)
+		}
+
+		return nil
+	}
+
+	timing := pq.lockTimed()
+	err := pq.pushLocked(item)
+	pq.unlockTimed(pushLock, timing)
+
+	return err
+}
+
+// pushLocked admits and enqueues the item. The caller must hold the
+// write lock.
+func (pq *PQueue) pushLocked(item *item) error {
+	if pq.closed {
+		return pq.newError("push", ErrClosed)
+	}
+
+	if err := pq.admit(item); err != nil {
+		return pq.newError("push", err)
+	}
+
+	// The item is only pushed once recorded, see WithWAL
+	if err := pq.walPush(item); err != nil {
+		return pq.newError("push", err)
+	}
+
+	if err := pq.syncWAL(); err != nil {
+		return pq.newError("push", err)
+	}
+
+	pq.enqueue(item)
+	pq.restage()
+
+	return nil
 }
 
 // Pop and returns the highest/lowest priority item (depending on whether
 // you're using a MINPQ or MAXPQ) from the priority queue
 func (pq *PQueue) Pop() (interface{}, int) {
-	if pq.Size() < 1 {
+	value, priority := pq.Pop64()
+	return value, int(priority)
+}
+
+// Pop64 pops and returns the highest/lowest priority item (depending on
+// whether you're using a MINPQ or MAXPQ) from the priority queue, along
+// with its 64 bits priority, see Push64.
+func (pq *PQueue) Pop64() (interface{}, int64) {
+	if staged, ok := pq.popStaged(); ok {
+		pq.recordPopped(staged.value)
+		return staged.value, staged.priority
+	}
+
+	timing := pq.lockTimed()
+	pq.mergeStaged()
+	pq.dropExpired()
+
+	if !pq.headEligible() || !pq.takeToken() {
+		pq.unlockTimed(popLock, timing)
 		return nil, 0
 	}
 
-	pq.Lock()
+	value, priority, _ := pq.popHead()
+	pq.restage()
 
-	var max *item = pq.items[1]
+	pq.unlockTimed(popLock, timing)
+	pq.recordPopped(value)
 
-	pq.exch(1, pq.Size())
-	pq.items = pq.items[0:pq.Size()]
-	pq.elemsCount -= 1
-	pq.sink(1)
+	return value, priority
+}
+
+// PopRelease pops and returns the highest/lowest priority item (depending
+// on whether you're using a MINPQ or MAXPQ) from the priority queue. The
+// boolean is false if the queue is empty.
+//
+// Once PopRelease returns, the queue holds no reference to the returned
+// value: its heap slot is cleared, and neither the statistics, the
+// recent-pop deduplication, which only keeps the value key, nor any
+// other feature retains it. Values can thus safely be recycled, for
+// instance through a sync.Pool. ItemRef values returned by PushRef do
+// keep a reference to their value.
+func (pq *PQueue) PopRelease() (interface{}, int, bool) {
+	if staged, ok := pq.popStaged(); ok {
+		pq.recordPopped(staged.value)
+		return staged.value, int(staged.priority), true
+	}
+
+	timing := pq.lockTimed()
+	pq.mergeStaged()
+	pq.dropExpired()
+
+	if !pq.headEligible() || !pq.takeToken() {
+		pq.unlockTimed(popLock, timing)
+		return nil, 0, false
+	}
+
+	value, priority, _ := pq.popHead()
+	pq.restage()
+
+	pq.unlockTimed(popLock, timing)
+	pq.recordPopped(value)
 
-	pq.Unlock()
+	return value, int(priority), true
+}
+
+// PopPriorityGroup pops the highest/lowest priority item (depending on
+// whether you're using a MINPQ or MAXPQ) from the priority queue, along
+// with every following item sharing its priority, and returns their
+// values. The values are returned in push order if the queue uses the
+// stable order option, and in an unspecified order otherwise. The
+// boolean is false if the queue is empty.
+func (pq *PQueue) PopPriorityGroup() (int, []interface{}, bool) {
+	pq.lock()
+	pq.mergeStaged()
+	pq.dropExpired()
+
+	if pq.elemsCount < 1 {
+		pq.unlock()
+		return 0, nil, false
+	}
+
+	priority := pq.items[1].priority
+
+	var values []interface{}
+	for pq.elemsCount > 0 && pq.items[1].priority == priority {
+		head := pq.removeAt(1)
+		values = append(values, head.value)
+		pq.release(head)
+	}
+
+	pq.unlock()
 
-	return max.value, max.priority
+	for _, value := range values {
+		pq.recordPopped(value)
+	}
+
+	return int(priority), values, true
 }
 
 // Head returns the highest/lowest priority item (depending on whether
 // you're using a MINPQ or MAXPQ) from the priority queue
 func (pq *PQueue) Head() (interface{}, int) {
-	if pq.Size() < 1 {
+	value, priority := pq.Head64()
+	return value, int(priority)
+}
+
+// Head64 returns the highest/lowest priority item (depending on whether
+// you're using a MINPQ or MAXPQ) from the priority queue, along with its
+// 64 bits priority, see Push64.
+func (pq *PQueue) Head64() (interface{}, int64) {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	if value, priority, _, ok := pq.stagedHead(); ok {
+		return value, priority
+	}
+
+	pq.rlockHead()
+	if pq.elemsCount < 1 {
+		pq.RUnlock()
 		return nil, 0
 	}
 
-	pq.RLock()
 	headValue := pq.items[1].value
 	headPriority := pq.items[1].priority
 	pq.RUnlock()
@@ -107,21 +566,252 @@ func (pq *PQueue) Head() (interface{}, int) {
 	return headValue, headPriority
 }
 
-// Size returns the elements present in the priority queue count
+// Fix re-establishes the referenced item position in the queue after
+// its ordering changed, for instance because its value was mutated
+// while using a value comparator. It returns false if the item is not
+// part of the queue anymore.
+func (pq *PQueue) Fix(ref *ItemRef) bool {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	if ref.item.gen != ref.gen || !pq.contains(ref.item) {
+		return false
+	}
+
+	pq.logInt(opFix, ref.item.index)
+	pq.fix(ref.item.index)
+
+	return true
+}
+
+// Clone returns a new priority queue created with the same ordering and
+// options, and holding the same items.
+func (pq *PQueue) Clone() *PQueue {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	// The options were already validated when the queue was created
+	clone, _ := NewPQueueWithOptions(pq.pqType, pq.options...)
+	clone.sequence = pq.sequence
+	if pq.elemsCount > pq.capacityHint {
+		clone.items = make([]*item, 1, pq.elemsCount+1)
+	}
+
+	for k := 1; k <= pq.elemsCount; k++ {
+		source := pq.items[k]
+		copied := clone.allocItem()
+
+		copied.value = source.value
+		copied.priority = source.priority
+		copied.secondary = source.secondary
+		copied.seq = source.seq
+		copied.token = source.token
+		copied.deadline = source.deadline
+		copied.size = source.size
+		copied.index = k
+
+		clone.items = append(clone.items, copied)
+		clone.internAdd(copied)
+		clone.indexAdd(copied)
+	}
+
+	clone.elemsCount = pq.elemsCount
+	clone.bytes = pq.bytes
+	if pq.watermarks != nil {
+		clone.watermarks.above = pq.watermarks.above
+	}
+
+	// The clone records would be interleaved with the queue ones. Its
+	// buffered header is dropped along with its log.
+	clone.oplog = nil
+	clone.wal = nil
+	clone.seedHeadHistory()
+	clone.publishSize()
+
+	return clone
+}
+
+// Size returns the elements present in the priority queue count. It
+// doesn't acquire the queue lock: the count is the one published by the
+// last operation, as MetricsSnapshot reports it.
 func (pq *PQueue) Size() int {
-	return pq.elemsCount
+	return int(pq.publishedSize())
 }
 
-func max(i, j int) bool {
+func max(i, j int64) bool {
 	return i < j
 }
 
-func min(i, j int) bool {
+func min(i, j int64) bool {
 	return i > j
 }
 
+// reset empties the queue and sets its ordering type. The caller
+// must hold the write lock.
+func (pq *PQueue) reset(pqType PQType) {
+	pq.mergeStaged()
+	pq.logInt(opReset, int(pqType))
+	pq.walReset(pqType)
+	if pq.elemsCount > 0 {
+		pq.headCause(HeadPopped)
+	}
+
+	for k := 1; k <= pq.elemsCount; k++ {
+		pq.items[k].index = 0
+		pq.release(pq.items[k])
+	}
+	pq.indexReset()
+	pq.internReset()
+
+	fresh := NewPQueue(pqType)
+	pq.items = make([]*item, 1, pq.capacityHint+1)
+	pq.elemsCount = 0
+	pq.bytes = 0
+	pq.pqType = pqType
+	pq.checkWatermarks()
+	pq.comparator = fresh.comparator
+}
+
+// insert adds the item at the bottom of the heap and swims it up
+// to its position. The caller must hold the write lock.
+func (pq *PQueue) insert(item *item) {
+	pq.appendItem(item)
+	pq.logItem(opInsert, item)
+	pq.swim(pq.elemsCount)
+	if item.index == 1 {
+		pq.headCause(HeadPushed)
+	}
+	pq.checkWatermarks()
+	pq.checkShrink()
+}
+
+// appendItem adds the item at the bottom of the heap, leaving the heap
+// invariant to restore to the caller. The caller must hold the write
+// lock.
+func (pq *PQueue) appendItem(item *item) {
+	pq.lazyInit()
+	pq.items = append(pq.items, item)
+	pq.elemsCount += 1
+	item.index = pq.elemsCount
+	pq.bytes += int64(item.size)
+	pq.internAdd(item)
+	pq.indexAdd(item)
+}
+
+// lazyInit sets up the heap sentinel and the comparator of a
+// zero value queue. The caller must hold the write lock.
+func (pq *PQueue) lazyInit() {
+	if pq.items != nil {
+		return
+	}
+
+	pq.items = make([]*item, 1)
+	if pq.comparator == nil {
+		pq.comparator = NewPQueue(pq.pqType).comparator
+	}
+}
+
+// removeAt removes and returns the item at index k of the heap. The
+// caller must hold the write lock.
+func (pq *PQueue) removeAt(k int) *item {
+	removed := pq.unlink(k)
+	pq.walDelete(removed)
+
+	return removed
+}
+
+// unlink removes and returns the item at index k of the heap, leaving
+// the record of its removal to the caller, see WithWAL. The caller must
+// hold the write lock.
+func (pq *PQueue) unlink(k int) *item {
+	pq.logInt(opRemove, k)
+	removed := pq.items[k]
+	last := pq.elemsCount
+	if k == 1 {
+		pq.headCause(HeadPopped)
+	}
+
+	pq.exch(k, last)
+	// Clear the vacated slot, so that the backing array doesn't keep
+	// the removed item, and its value, reachable.
+	pq.items[last] = nil
+	pq.items = pq.items[0:last]
+	pq.elemsCount -= 1
+	if k < last {
+		pq.fix(k)
+	}
+	pq.checkDrained()
+	pq.checkWatermarks()
+	pq.checkShrink()
+
+	removed.index = 0
+	pq.bytes -= int64(removed.size)
+	pq.indexRemove(removed)
+	pq.internRemove(removed)
+
+	return removed
+}
+
+// heapify restores the heap invariant over the whole heap. The caller
+// must hold the write lock.
+func (pq *PQueue) heapify() {
+	if l := pq.logOp(opHeapify); l != nil {
+		l.end()
+	}
+
+	for k := pq.elemsCount / 2; k >= 1; k-- {
+		pq.sink(k)
+	}
+}
+
+// fix moves the item at index k up or down the heap to its position.
+func (pq *PQueue) fix(k int) {
+	if !pq.sink(k) {
+		pq.swim(k)
+	}
+}
+
 func (pq *PQueue) less(i, j int) bool {
-	return pq.comparator(pq.items[i].priority, pq.items[j].priority)
+	return pq.lessItems(pq.items[i], pq.items[j])
+}
+
+// lessItems reports whether item a has a lower precedence than item b.
+func (pq *PQueue) lessItems(a, b *item) bool {
+	if pq.valueLess != nil {
+		x, y := a.value, b.value
+		if pq.pqType == MINPQ {
+			x, y = y, x
+		}
+
+		if pq.valueLess(x, y) {
+			return true
+		}
+
+		if pq.valueLess(y, x) {
+			return false
+		}
+	}
+
+	if a.priority != b.priority {
+		return pq.comparator(a.priority, b.priority)
+	}
+
+	if a.secondary != b.secondary {
+		return pq.secondaryLess(a.secondary, b.secondary)
+	}
+
+	switch {
+	case pq.stable:
+		return a.seq > b.seq
+	case pq.random != nil:
+		return a.token < b.token
+	}
+
+	return false
 }
 
 func (pq *PQueue) exch(i, j int) {
@@ -129,21 +819,27 @@ func (pq *PQueue) exch(i, j int) {
 
 	pq.items[i] = pq.items[j]
 	pq.items[j] = tmpItem
+
+	pq.items[i].index = i
+	pq.items[j].index = j
 }
 
 func (pq *PQueue) swim(k int) {
 	for k > 1 && pq.less(k/2, k) {
 		pq.exch(k/2, k)
+		k = k / 2
 	}
-
-	k = k / 2
 }
 
-func (pq *PQueue) sink(k int) {
-	for 2*k <= pq.Size() {
+// sink moves the item at index k down the heap, and reports
+// whether it was moved.
+func (pq *PQueue) sink(k int) bool {
+	start := k
+
+	for 2*k <= pq.elemsCount {
 		var j int = 2 * k
 
-		if j < pq.Size() && pq.less(j, j+1) {
+		if j < pq.elemsCount && pq.less(j, j+1) {
 			j++
 		}
 
@@ -154,4 +850,6 @@ func (pq *PQueue) sink(k int) {
 		pq.exch(k, j)
 		k = j
 	}
+
+	return k > start
 }
@@ -0,0 +1,76 @@
+package lane
+
+import (
+	"fmt"
+	"sync"
+)
+
+// itemArena allocates items from large chunks, and recycles the
+// items leaving the queue, so that the garbage collector deals with
+// a few large chunks instead of millions of items.
+type itemArena struct {
+	sync.Mutex
+	chunkSize int
+	chunk     []item
+	free      []*item
+}
+
+// WithArena makes the queue allocate its items by chunks of chunkSize
+// items, and recycle the popped ones. Values are stored in the items
+// as usual, so the garbage collector keeps seeing them.
+func WithArena(chunkSize int) PQueueOption {
+	return func(pq *PQueue) error {
+		if chunkSize < 1 {
+			return fmt.Errorf("%w: arena chunk size must be positive, got %d", ErrInvalidOption, chunkSize)
+		}
+
+		pq.arena = &itemArena{chunkSize: chunkSize}
+		return nil
+	}
+}
+
+// alloc returns a zero item, recycled if possible
+func (a *itemArena) alloc() *item {
+	a.Lock()
+	defer a.Unlock()
+
+	if n := len(a.free); n > 0 {
+		recycled := a.free[n-1]
+		a.free[n-1] = nil
+		a.free = a.free[:n-1]
+
+		return recycled
+	}
+
+	if len(a.chunk) == 0 {
+		a.chunk = make([]item, a.chunkSize)
+	}
+
+	allocated := &a.chunk[0]
+	a.chunk = a.chunk[1:]
+
+	return allocated
+}
+
+// release clears the item, so that it doesn't retain its value, and
+// makes it available for reuse.
+func (a *itemArena) release(released *item) {
+	*released = item{gen: released.gen + 1}
+
+	a.Lock()
+	a.free = append(a.free, released)
+	a.Unlock()
+}
+
+// release hands the item, which must not be part of the queue anymore,
+// back to the arena if the queue uses one. Otherwise, the item value is
+// cleared, so that stale references to the item, such as the ones of
+// ItemRef, don't retain it. The caller must hold the write lock.
+func (pq *PQueue) release(item *item) {
+	if pq.arena != nil {
+		pq.arena.release(item)
+		return
+	}
+
+	item.value = nil
+}
@@ -0,0 +1,233 @@
+package lane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newArenaPQueue(t *testing.T, pqType PQType, options ...PQueueOption) *PQueue {
+	pqueue, err := NewPQueueWithOptions(pqType, append([]PQueueOption{WithArena(4)}, options...)...)
+	assert.Nil(t, err)
+
+	return pqueue
+}
+
+func TestNewPQueueWithOptions_invalid_arena(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithArena(0))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueueArena_recycles_popped_items(t *testing.T) {
+	pqueue := newArenaPQueue(t, MAXPQ)
+
+	pqueue.Push("1", 1)
+	popped := pqueue.items[1]
+	pqueue.Pop()
+
+	// The popped item doesn't retain its value
+	assert.Nil(t, popped.value)
+
+	pqueue.Push("2", 2)
+	assert.True(t, pqueue.items[1] == popped)
+	assert.Equal(t, popped.value, "2")
+}
+
+func TestPQueueArena_protects_order(t *testing.T) {
+	pqueue := newArenaPQueue(t, MINPQ)
+	random := rand.New(rand.NewSource(1))
+
+	var model []int
+	for i := 0; i < 5000; i++ {
+		if len(model) > 0 && random.Intn(3) == 0 {
+			sort.Ints(model)
+			value, priority := pqueue.Pop()
+			assert.Equal(t, priority, model[0])
+			assert.Equal(t, value, model[0])
+			model = model[1:]
+			continue
+		}
+
+		priority := random.Intn(1000)
+		pqueue.Push(priority, priority)
+		model = append(model, priority)
+	}
+
+	assert.Equal(t, pqueue.Size(), len(model))
+	assertHeapInvariant(t, pqueue)
+}
+
+func TestPQueueArena_detects_stale_refs(t *testing.T) {
+	pqueue := newArenaPQueue(t, MAXPQ)
+
+	ref, err := pqueue.PushRef("1", 1)
+	assert.Nil(t, err)
+	pqueue.Pop()
+
+	// The new item reuses the popped one memory
+	pqueue.Push("2", 2)
+	assert.True(t, pqueue.items[1] == ref.item)
+
+	assert.False(t, pqueue.Fix(ref))
+	assert.Equal(t, ref.Value(), "1")
+}
+
+func TestPQueueArena_wait_pop_concurrent_with_refs(t *testing.T) {
+	pqueue := newArenaPQueue(t, MAXPQ)
+
+	const count = 1000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < count; i++ {
+			pqueue.WaitPop(context.Background())
+		}
+	}()
+
+	// Fix reads the items the consumer is handed, or pops, meanwhile
+	var refs []*ItemRef
+	for i := 0; i < count; i++ {
+		ref, err := pqueue.PushRef(i, i)
+		assert.Nil(t, err)
+		refs = append(refs, ref)
+
+		for _, recent := range refs[maxInt(len(refs)-10, 0):] {
+			pqueue.Fix(recent)
+		}
+	}
+	<-done
+
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueArena_chunked_remove_ignores_recycled_items(t *testing.T) {
+	pqueue := newArenaPQueue(t, MAXPQ, WithBulkChunkSize(2))
+
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, i)
+	}
+
+	// Pop items while removing, and push new ones reusing the
+	// popped items memory.
+	examined := 0
+	pqueue.eachChunk(func(item *item) {
+		examined++
+		assert.True(t, item.value.(int) < 10)
+
+		if pqueue.elemsCount > 5 {
+			head := pqueue.removeAt(1)
+			pqueue.release(head)
+			pqueue.enqueue(pqueue.newItem(100+examined, int64(-examined)))
+		}
+	})
+
+	assert.True(t, examined < 10)
+	assertHeapInvariant(t, pqueue)
+}
+
+func TestPQueueClone(t *testing.T) {
+	for _, options := range [][]PQueueOption{nil, {WithArena(3)}, {WithStableOrder(), WithSizeEstimator(stringSize)}} {
+		pqueue, err := NewPQueueWithOptions(MINPQ, options...)
+		assert.Nil(t, err)
+
+		for _, value := range []string{"c", "a", "b", "aa", "bb"} {
+			pqueue.Push(value, len(value))
+		}
+
+		clone := pqueue.Clone()
+		assert.Equal(t, clone.Stats(), pqueue.Stats())
+		assertHeapInvariant(t, clone)
+
+		for k := 1; k <= clone.elemsCount; k++ {
+			assert.False(t, clone.items[k] == pqueue.items[k])
+		}
+		if pqueue.arena != nil {
+			assert.False(t, clone.arena == pqueue.arena)
+		}
+
+		expected, err := json.Marshal(pqueue)
+		assert.Nil(t, err)
+		actual, err := json.Marshal(clone)
+		assert.Nil(t, err)
+		assert.Equal(t, string(actual), string(expected))
+
+		// Both queues are independent
+		clone.Push("z", 0)
+		assert.Equal(t, pqueue.Size(), 5)
+		for i := 0; i < 5; i++ {
+			pqueue.Pop()
+		}
+		assert.Equal(t, clone.Size(), 6)
+	}
+}
+
+func TestPQueueClone_stable_order(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+
+	pqueue.Push("1", 1)
+	pqueue.Push("2", 1)
+	clone := pqueue.Clone()
+	clone.Push("3", 1)
+
+	for _, expected := range []string{"1", "2", "3"} {
+		value, _ := clone.Pop()
+		assert.Equal(t, value, expected)
+	}
+}
+
+// steadyStateSize is the count of items held by the queue in the
+// steady state benchmarks.
+const steadyStateSize = 10000000
+
+func benchmarkPQueueSteadyState(b *testing.B, options ...PQueueOption) {
+	size := steadyStateSize
+	if testing.Short() {
+		size /= 10
+	}
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, options...)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < size; i++ {
+		pqueue.Push(i, random.Int())
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pqueue.Pop()
+		pqueue.Push(i, random.Int())
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(after.NumGC-before.NumGC), "ns/gc-pause")
+	b.ReportMetric(float64(after.NumGC-before.NumGC)/time.Since(start).Seconds(), "gc/s")
+}
+
+func BenchmarkPQueueSteadyState(b *testing.B) {
+	benchmarkPQueueSteadyState(b)
+}
+
+func BenchmarkPQueueSteadyState_arena(b *testing.B) {
+	benchmarkPQueueSteadyState(b, WithArena(4096))
+}
@@ -0,0 +1,59 @@
+package lane
+
+// BeginBatch starts a batch of pushes, during which the consumers blocked
+// in WaitPop aren't handed the pushed items over, and thus aren't woken
+// up once per item. The items pushed during a batch, by any goroutine,
+// are inserted into the heap right away: Head, Pop and WaitPop calls see
+// them as soon as their Push returns.
+//
+// Batches may be nested, or overlap when started by several goroutines:
+// the blocked consumers are served when the last of them ends. Every
+// BeginBatch call must be matched by an EndBatch call.
+func (pq *PQueue) BeginBatch() {
+	pq.lock()
+	pq.batches++
+	pq.unlock()
+}
+
+// EndBatch ends a batch of pushes started by BeginBatch. When it ends the
+// last running batch, it hands the queued items over to the blocked
+// consumers, each of them being woken up once, and returns the count of
+// woken up consumers.
+func (pq *PQueue) EndBatch() int {
+	pq.lock()
+	defer pq.unlock()
+
+	if pq.batches < 1 {
+		panic("lane: EndBatch called without a matching BeginBatch")
+	}
+
+	pq.batches--
+	if pq.batches > 0 {
+		return 0
+	}
+
+	handoffs := pq.handoffs
+	pq.mergeStaged()
+
+	pq.serveWaiters()
+
+	return int(pq.handoffs - handoffs)
+}
+
+// Batch calls fn within a batch of pushes, see BeginBatch, and returns
+// the count of consumers woken up when it ended.
+func (pq *PQueue) Batch(fn func()) int {
+	pq.BeginBatch()
+
+	ended := false
+	defer func() {
+		if !ended {
+			pq.EndBatch()
+		}
+	}()
+
+	fn()
+	ended = true
+
+	return pq.EndBatch()
+}
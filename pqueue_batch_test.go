@@ -0,0 +1,194 @@
+package lane
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueBatch_defers_handoffs(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	pqueue.BeginBatch()
+	pqueue.Push("1", 1)
+	pqueue.Push("2", 2)
+
+	// Pushed items are visible right away, but not handed over
+	value, priority := pqueue.Head()
+	assert.Equal(t, value, "2")
+	assert.Equal(t, priority, 2)
+	assert.Equal(t, pqueue.Size(), 2)
+	assert.Equal(t, atomic.LoadInt32(&pqueue.waiting), int32(1))
+
+	assert.Equal(t, pqueue.EndBatch(), 1)
+	assert.Equal(t, <-done, "2")
+	assert.Equal(t, pqueue.Size(), 1)
+	assert.Equal(t, pqueue.Stats().Handoffs, uint64(1))
+}
+
+func TestPQueueBatch_nested(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	woken := pqueue.Batch(func() {
+		assert.Equal(t, pqueue.Batch(func() { pqueue.Push("1", 1) }), 0)
+		assert.Equal(t, pqueue.Size(), 1)
+	})
+	assert.Equal(t, woken, 1)
+	assert.Equal(t, <-done, "1")
+}
+
+func TestPQueueBatch_with_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(64, time.Hour))
+	assert.Nil(t, err)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	woken := pqueue.Batch(func() {
+		pqueue.Push("1", 1)
+	})
+	assert.Equal(t, woken, 1)
+	assert.Equal(t, <-done, "1")
+}
+
+func TestPQueueBatch_ends_on_panic(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	assert.Panics(t, func() {
+		pqueue.Batch(func() { panic("failed") })
+	})
+	assert.Equal(t, pqueue.batches, 0)
+}
+
+func TestPQueueEndBatch_without_batch(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	assert.Panics(t, func() { pqueue.EndBatch() })
+}
+
+func TestPQueueBatch_no_waiter_left_behind(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	const consumers = 8
+	const items = 1000
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var received int64
+	var wg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt64(&received) < items {
+				if _, _, err := pqueue.WaitPop(ctx); err != nil {
+					return
+				}
+
+				if atomic.AddInt64(&received, 1) == items {
+					pqueue.Close()
+				}
+			}
+		}()
+	}
+	waitForWaiters(t, pqueue, consumers)
+
+	for burst := 0; burst < 10; burst++ {
+		pqueue.Batch(func() {
+			for i := 0; i < items/10; i++ {
+				pqueue.Push(i, i)
+			}
+		})
+	}
+
+	wg.Wait()
+	assert.Nil(t, ctx.Err())
+	assert.Equal(t, atomic.LoadInt64(&received), int64(items))
+}
+
+// benchmarkPQueueBurst pushes bursts of items, optionally within batches,
+// while 8 consumers block in WaitPop. The producer yields the processor
+// regularly, as it would be preempted by the consumers running on other
+// processors.
+func benchmarkPQueueBurst(b *testing.B, batch bool) {
+	const consumers = 8
+	const burst = 10000
+
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		pqueue := NewPQueue(MAXPQ)
+
+		var received int64
+		var wg sync.WaitGroup
+		for c := 0; c < consumers; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if _, _, err := pqueue.WaitPop(context.Background()); err != nil {
+						return
+					}
+
+					if atomic.AddInt64(&received, 1) == burst {
+						pqueue.Close()
+					}
+				}
+			}()
+		}
+
+		for atomic.LoadInt32(&pqueue.waiting) < consumers {
+			time.Sleep(10 * time.Microsecond)
+		}
+		b.StartTimer()
+
+		push := func() {
+			for i := 0; i < burst; i++ {
+				pqueue.Push(i, i)
+				if i%64 == 63 {
+					runtime.Gosched()
+				}
+			}
+		}
+
+		if batch {
+			pqueue.Batch(push)
+		} else {
+			push()
+		}
+		wg.Wait()
+
+		b.ReportMetric(float64(pqueue.Stats().Handoffs), "wakeups/op")
+	}
+}
+
+func BenchmarkPQueueBurst(b *testing.B) {
+	benchmarkPQueueBurst(b, false)
+}
+
+func BenchmarkPQueueBurst_batch(b *testing.B) {
+	benchmarkPQueueBurst(b, true)
+}
@@ -0,0 +1,149 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// BridgeError is the error Bridge returns when the item in flight can't
+// be pushed back into src, as src was closed meanwhile: the item is
+// handed over to the caller instead.
+type BridgeError struct {
+	// Item is the item in flight, popped from src.
+	Item Item
+	// Err is the error which stopped the bridge.
+	Err error
+}
+
+func (e *BridgeError) Error() string {
+	return "lane: bridge stopped with an item in flight: " + strings.TrimPrefix(e.Err.Error(), "lane: ")
+}
+
+// Unwrap returns the error which stopped the bridge
+func (e *BridgeError) Unwrap() error {
+	return e.Err
+}
+
+// Bridge pumps the items of src into dst until src is closed and
+// drained, or the context is done. Each item popped from src is passed
+// to transform, which returns the item to push into dst, or false to
+// drop it. A nil transform pushes the items unchanged, 64 bits
+// priorities and deadlines included.
+//
+// When dst is full, see WithMaxItems, the pump blocks until it has room
+// for the item rather than dropping it, so that a slow dst consumer
+// slows the src consumption down. With the EvictWhenFull overflow
+// policy, items are only blocked when they can't evict any other.
+//
+// Bridge returns nil once src is closed and drained. Otherwise it
+// returns the context error, or the error dst rejected an item with,
+// such as ErrClosed or ErrDuplicate. In both cases the item in flight,
+// popped from src but not pushed into dst, is pushed back into src as it
+// was, so that no item is lost. If src was closed meanwhile, the item is
+// returned in a *BridgeError wrapping the error instead.
+func Bridge(ctx context.Context, src, dst *PQueue, transform func(Item) (Item, bool)) error {
+	for {
+		head, _, err := src.waitPop(ctx, nil, "bridge")
+		if err != nil {
+			if errors.Is(err, ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		pushed, keep := dst.bridged(head, transform)
+		if !keep {
+			src.claim(head)
+			continue
+		}
+
+		if err := dst.pushWait(ctx, pushed); err != nil {
+			return src.giveBack(head, err)
+		}
+
+		src.claim(head)
+	}
+}
+
+// bridged returns the item to push into the queue for the head item
+// popped from another queue, which is left untouched. The boolean is
+// false if transform drops the item.
+func (pq *PQueue) bridged(head *item, transform func(Item) (Item, bool)) (*item, bool) {
+	if transform == nil {
+		pushed := pq.newItem(head.value, head.priority)
+		pushed.secondary = head.secondary
+		pushed.deadline = head.deadline
+
+		return pushed, true
+	}
+
+	transformed, keep := transform(head.export())
+	if !keep {
+		return nil, false
+	}
+
+	pushed := pq.newItem(transformed.Value, int64(transformed.Priority))
+	pushed.secondary = int64(transformed.Secondary)
+
+	return pushed, true
+}
+
+// giveBack pushes the item in flight back into the queue it was popped
+// from, unless the queue was closed meanwhile, and returns the error
+// which stopped the bridge, see Bridge.
+func (pq *PQueue) giveBack(head *item, err error) error {
+	pq.lock()
+	defer pq.unlock()
+
+	// Closed queues must not grow, they may have been drained already
+	if pq.closed {
+		inFlight := head.export()
+		pq.release(head)
+
+		return &BridgeError{Item: inFlight, Err: err}
+	}
+
+	pq.requeue(head)
+
+	return err
+}
+
+// pushWait pushes the item, blocking while the queue is full until it
+// has room for it, or the context is done. The item is released if it
+// isn't pushed.
+func (pq *PQueue) pushWait(ctx context.Context, item *item) error {
+	// Queues buffering their writes have no limits
+	if pq.buffer != nil {
+		return pq.push(item)
+	}
+
+	if err := pq.checkRecent(item.value); err != nil {
+		pq.release(item)
+		return err
+	}
+
+	for {
+		timing := pq.lockTimed()
+		err := pq.pushLocked(item)
+		if !errors.Is(err, ErrFull) {
+			pq.unlockTimed(pushLock, timing)
+			if err != nil {
+				pq.release(item)
+			}
+
+			return err
+		}
+
+		room := pq.roomWake()
+		pq.unlockTimed(pushLock, timing)
+
+		select {
+		case <-room:
+		case <-ctx.Done():
+			pq.release(item)
+			return pq.lockedError("bridge", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueBridge_transforms_and_drops_items(t *testing.T) {
+	src, dst := NewPQueue(MINPQ), NewPQueue(MAXPQ)
+	for i := 0; i < 10; i++ {
+		src.Push(i, i)
+	}
+	src.Close()
+
+	err := Bridge(context.Background(), src, dst, func(item Item) (Item, bool) {
+		if item.Value.(int)%2 == 1 {
+			return Item{}, false
+		}
+
+		item.Priority *= 10
+		return item, true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(src.RawItems()), 0)
+
+	var values, priorities []int
+	for {
+		value, priority, ok := dst.PopRelease()
+		if !ok {
+			break
+		}
+		values = append(values, value.(int))
+		priorities = append(priorities, priority)
+	}
+	assert.Equal(t, values, []int{8, 6, 4, 2, 0})
+	assert.Equal(t, priorities, []int{80, 60, 40, 20, 0})
+}
+
+func TestPQueueBridge_nil_transform_pushes_items_unchanged(t *testing.T) {
+	src, dst := NewPQueue(MINPQ), NewPQueue(MINPQ)
+	src.Push("a", 1)
+	src.Push("b", 2)
+	src.Close()
+
+	assert.Nil(t, Bridge(context.Background(), src, dst, nil))
+
+	value, priority := dst.Pop()
+	assert.Equal(t, value, "a")
+	assert.Equal(t, priority, 1)
+	value, priority = dst.Pop()
+	assert.Equal(t, value, "b")
+	assert.Equal(t, priority, 2)
+}
+
+func TestPQueueBridge_blocks_while_dst_is_full(t *testing.T) {
+	src := NewPQueue(MINPQ)
+	dst, err := NewPQueueWithOptions(MINPQ, WithMaxItems(2))
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		src.Push(i, i)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Bridge(context.Background(), src, dst, nil)
+	}()
+
+	// Two items fill dst in, and a third one waits for room
+	waitFor(t, func() bool { return len(src.RawItems()) == 2 })
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, len(src.RawItems()), 2)
+	assert.Equal(t, len(dst.RawItems()), 2)
+
+	for i := 0; i < 5; i++ {
+		value, _, err := dst.WaitPop(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, value, i)
+	}
+
+	src.Close()
+	assert.Nil(t, <-done)
+}
+
+func TestPQueueBridge_cancellation_requeues_the_blocked_item(t *testing.T) {
+	src := NewPQueue(MINPQ)
+	dst, err := NewPQueueWithOptions(MINPQ, WithMaxItems(1))
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		src.Push(i, i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Bridge(ctx, src, dst, func(item Item) (Item, bool) {
+			item.Priority = -item.Priority
+			return item, true
+		})
+	}()
+
+	waitFor(t, func() bool { return len(src.RawItems()) == 1 })
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+
+	// The blocked item is pushed back untransformed
+	value, priority := src.Pop()
+	assert.Equal(t, value, 1)
+	assert.Equal(t, priority, 1)
+	value, priority = src.Pop()
+	assert.Equal(t, value, 2)
+	assert.Equal(t, priority, 2)
+	value, priority = dst.Pop()
+	assert.Equal(t, value, 0)
+	assert.Equal(t, priority, 0)
+}
+
+func TestPQueueBridge_closed_dst_requeues_the_item(t *testing.T) {
+	src, dst := NewPQueue(MINPQ), NewPQueue(MINPQ)
+	src.Push("a", 1)
+	src.Push("b", 2)
+	dst.Close()
+
+	err := Bridge(context.Background(), src, dst, nil)
+	assert.True(t, errors.Is(err, ErrClosed))
+	assert.Equal(t, len(src.RawItems()), 2)
+
+	value, _ := src.Pop()
+	assert.Equal(t, value, "a")
+}
+
+func TestPQueueBridge_keeps_64_bits_priorities_and_deadlines(t *testing.T) {
+	src, dst := NewPQueue(MAXPQ), NewPQueue(MAXPQ)
+	deadline := time.Now().Add(time.Hour)
+	assert.Nil(t, src.PushWithDeadline("a", 1, deadline))
+	src.Push64("b", 1<<40)
+	src.Close()
+
+	assert.Nil(t, Bridge(context.Background(), src, dst, nil))
+
+	dst.lock()
+	pushed := map[interface{}]item{}
+	for _, item := range dst.items[1:] {
+		pushed[item.value] = *item
+	}
+	dst.unlock()
+	assert.Equal(t, pushed["a"].deadline, deadline.UnixNano())
+	assert.Equal(t, pushed["b"].priority, int64(1<<40))
+}
+
+func TestPQueueBridge_closed_src_returns_the_item_in_flight(t *testing.T) {
+	src, dst := NewPQueue(MINPQ), NewPQueue(MINPQ)
+	src.Push("a", 1)
+	src.Push("b", 2)
+	src.Close()
+	dst.Close()
+
+	// The closed src may have been drained already, it doesn't take the
+	// item back
+	err := Bridge(context.Background(), src, dst, nil)
+	assert.True(t, errors.Is(err, ErrClosed))
+
+	var bridgeErr *BridgeError
+	assert.True(t, errors.As(err, &bridgeErr))
+	assert.Equal(t, bridgeErr.Item, Item{Value: "a", Priority: 1})
+	assert.Equal(t, len(src.RawItems()), 1)
+}
+
+func TestPQueueBridge_cancelled_context_returns_right_away(t *testing.T) {
+	src, dst := NewPQueue(MINPQ), NewPQueue(MINPQ)
+	src.Push("a", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Bridge(ctx, src, dst, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, len(src.RawItems())+len(dst.RawItems()), 1)
+}
+
+func TestPQueueBridge_conserves_items_under_churn(t *testing.T) {
+	const producers, perProducer, consumers = 4, 500, 3
+	const total = producers * perProducer
+
+	src := NewPQueue(MINPQ)
+	dst, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(8))
+	assert.Nil(t, err)
+
+	var (
+		mu       sync.Mutex
+		seen     = make(map[int]int)
+		consumed int64
+	)
+	record := func(value int) {
+		mu.Lock()
+		seen[value]++
+		mu.Unlock()
+	}
+
+	var producing sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		producing.Add(1)
+		go func(p int) {
+			defer producing.Done()
+			for i := 0; i < perProducer; i++ {
+				value := p*perProducer + i
+				src.Push(value, value)
+			}
+		}(p)
+	}
+
+	consumeCtx, stopConsumers := context.WithCancel(context.Background())
+	var consuming sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		consuming.Add(1)
+		go func(c int) {
+			defer consuming.Done()
+			for n := 0; ; n++ {
+				value, _, err := dst.WaitPop(consumeCtx)
+				if err != nil {
+					return
+				}
+				record(value.(int))
+				atomic.AddInt64(&consumed, 1)
+
+				// A slow consumer pushes back on the bridge
+				if n%50 == c {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(c)
+	}
+
+	bridgeCtx, stopBridge := context.WithCancel(context.Background())
+	var dropped int64
+	done := make(chan error)
+	go func() {
+		done <- Bridge(bridgeCtx, src, dst, func(item Item) (Item, bool) {
+			if item.Value.(int)%7 == 0 {
+				record(item.Value.(int))
+				atomic.AddInt64(&dropped, 1)
+				return Item{}, false
+			}
+
+			item.Priority = item.Value.(int) % 100
+			return item, true
+		})
+	}()
+
+	producing.Wait()
+	waitFor(t, func() bool { return atomic.LoadInt64(&consumed) >= total/2 })
+	stopBridge()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+	stopConsumers()
+	consuming.Wait()
+
+	left := 0
+	for _, queue := range []*PQueue{src, dst} {
+		for _, item := range queue.RawItems() {
+			record(item.Value.(int))
+			left++
+		}
+	}
+
+	assert.Equal(t, int(consumed+dropped)+left, total)
+	assert.Equal(t, len(seen), total)
+	for value, count := range seen {
+		if count != 1 {
+			t.Fatalf("value %d accounted for %d times", value, count)
+		}
+	}
+}
+
+// waitFor waits for cond to hold, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,117 @@
+package lane
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeBuffer stages pushed items under its own lock so that bursts
+// of Push calls don't contend on the priority queue lock. Staged
+// items are merged into the heap in batches.
+type writeBuffer struct {
+	sync.Mutex
+	items    []*item
+	maxItems int
+	maxDelay time.Duration
+	timer    *time.Timer
+
+	// spare is the previously merged staging slice, kept around so
+	// that it can be reused. It is only accessed while holding the
+	// priority queue write lock.
+	spare []*item
+}
+
+// WithWriteBuffer makes Push stage items in a buffer of up to maxItems
+// elements instead of inserting them in the heap right away. Staged
+// items are merged into the heap once the buffer is full, once maxDelay
+// has elapsed since the first staged item was pushed, or whenever an
+// operation needs an up to date view of the queue (Pop, Head). A zero
+// maxDelay disables the timed merge.
+func WithWriteBuffer(maxItems int, maxDelay time.Duration) PQueueOption {
+	return func(pq *PQueue) error {
+		if maxItems < 1 {
+			return fmt.Errorf("%w: write buffer size must be positive, got %d", ErrInvalidOption, maxItems)
+		}
+
+		if maxDelay < 0 {
+			return fmt.Errorf("%w: write buffer delay must not be negative, got %s", ErrInvalidOption, maxDelay)
+		}
+
+		pq.buffer = &writeBuffer{
+			items:    make([]*item, 0, maxItems),
+			spare:    make([]*item, 0, maxItems),
+			maxItems: maxItems,
+			maxDelay: maxDelay,
+		}
+
+		return nil
+	}
+}
+
+func (b *writeBuffer) len() int {
+	b.Lock()
+	defer b.Unlock()
+
+	return len(b.items)
+}
+
+// stage appends the item to the write buffer, merging the buffer
+// into the heap if it is full. It returns false if the queue is
+// closed.
+func (pq *PQueue) stage(item *item) bool {
+	b := pq.buffer
+
+	b.Lock()
+	if pq.Closed() {
+		b.Unlock()
+		return false
+	}
+
+	b.items = append(b.items, item)
+	full := len(b.items) >= b.maxItems
+	if !full && b.timer == nil && b.maxDelay > 0 {
+		b.timer = time.AfterFunc(b.maxDelay, pq.flush)
+	}
+	b.Unlock()
+
+	// Blocked consumers can't wait for the buffer to be merged
+	if full || atomic.LoadInt32(&pq.waiting) > 0 {
+		pq.flush()
+	}
+
+	return true
+}
+
+// flush merges the staged items into the heap.
+func (pq *PQueue) flush() {
+	pq.lock()
+	pq.mergeStaged()
+	pq.unlock()
+}
+
+// mergeStaged enqueues every staged item. The caller
+// must hold the write lock.
+func (pq *PQueue) mergeStaged() {
+	b := pq.buffer
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	staged := b.items
+	b.items = b.spare[:0]
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.Unlock()
+
+	for i, item := range staged {
+		pq.enqueue(item)
+		staged[i] = nil
+	}
+
+	b.spare = staged
+}
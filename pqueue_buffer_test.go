@@ -0,0 +1,158 @@
+package lane
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPQueueWithOptions_invalid_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(0, time.Millisecond))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	pqueue, err = NewPQueueWithOptions(MAXPQ, WithWriteBuffer(8, -time.Millisecond))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueueWriteBuffer_stages_pushes(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(8, 0))
+	assert.Nil(t, err)
+
+	pqueue.Push("1", 1)
+	pqueue.Push("2", 2)
+
+	assert.Equal(t, pqueue.elemsCount, 0)
+	assert.Equal(t, pqueue.Size(), 2)
+}
+
+func TestPQueueWriteBuffer_merges_when_full(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(3, 0))
+	assert.Nil(t, err)
+
+	pqueue.Push("1", 1)
+	pqueue.Push("2", 2)
+	pqueue.Push("3", 3)
+
+	assert.Equal(t, pqueue.elemsCount, 3)
+	assert.Equal(t, pqueue.buffer.len(), 0)
+	assert.Equal(t, pqueue.Size(), 3)
+}
+
+func TestPQueueWriteBuffer_merges_after_delay(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(64, 5*time.Millisecond))
+	assert.Nil(t, err)
+
+	pqueue.Push("1", 1)
+
+	assert.Eventually(t, func() bool {
+		pqueue.RLock()
+		defer pqueue.RUnlock()
+
+		return pqueue.elemsCount == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueWriteBuffer_head_sees_staged_items(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithWriteBuffer(64, 0))
+	assert.Nil(t, err)
+
+	pqueue.Push("3", 3)
+	pqueue.Push("1", 1)
+	pqueue.Push("2", 2)
+
+	value, priority := pqueue.Head()
+	assert.Equal(t, value, "1")
+	assert.Equal(t, priority, 1)
+	assert.Equal(t, pqueue.Size(), 3)
+}
+
+func TestPQueueWriteBuffer_pop_protects_order(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(4, 0))
+	assert.Nil(t, err)
+
+	for _, priority := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6} {
+		pqueue.Push(priority, priority)
+	}
+
+	for expected := 9; expected > 0; expected-- {
+		value, priority := pqueue.Pop()
+		assert.Equal(t, value, expected)
+		assert.Equal(t, priority, expected)
+	}
+
+	value, priority := pqueue.Pop()
+	assert.Nil(t, value)
+	assert.Equal(t, priority, 0)
+}
+
+func TestPQueueWriteBuffer_no_item_is_invisible_to_pop(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(16, time.Hour))
+	assert.Nil(t, err)
+
+	const workers = 8
+	const pushes = 1000
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	popped := make(map[int]int)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < pushes; i++ {
+				pqueue.Push(w*pushes+i, i)
+
+				// Every goroutine pops right after pushing, so the queue
+				// always holds at least one item when Pop is called.
+				value, _ := pqueue.Pop()
+				if !assert.NotNil(t, value) {
+					return
+				}
+
+				mu.Lock()
+				popped[value.(int)] += 1
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, pqueue.Size(), 0)
+	assert.Equal(t, len(popped), workers*pushes)
+	for _, count := range popped {
+		assert.Equal(t, count, 1)
+	}
+}
+
+func benchmarkPQueuePushBurst(b *testing.B, pqueue *PQueue) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			pqueue.Push(i, i)
+			i++
+		}
+	})
+}
+
+func BenchmarkPQueuePush_burst(b *testing.B) {
+	benchmarkPQueuePushBurst(b, NewPQueue(MAXPQ))
+}
+
+func BenchmarkPQueuePush_burst_write_buffer(b *testing.B) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(1024, time.Millisecond))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkPQueuePushBurst(b, pqueue)
+}
@@ -0,0 +1,577 @@
+package lane
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+)
+
+// WithBulkChunkSize makes the bulk operations (Drain, DrainFunc,
+// RemoveWhere, UpdatePriorities, MapValues and Merge) process the queue
+// items by chunks of n items, releasing the lock between chunks so that
+// concurrent operations don't stall for the whole operation duration.
+//
+// The bulk operations are then not atomic anymore, concurrent
+// operations being interleaved between chunks. Each operation
+// documents the guarantees it keeps in that case.
+func WithBulkChunkSize(n int) PQueueOption {
+	return func(pq *PQueue) error {
+		if n < 1 {
+			return fmt.Errorf("%w: bulk chunk size must be positive, got %d", ErrInvalidOption, n)
+		}
+
+		pq.bulkChunkSize = n
+		return nil
+	}
+}
+
+// Drain removes every item from the priority queue and returns them in
+// pop order.
+//
+// When the queue processes bulk operations by chunks, Drain pops at most
+// as many items as the queue held when it was called, and items pushed
+// between two chunks may be returned out of order with the items popped
+// before them.
+func (pq *PQueue) Drain() []Item {
+	if pq.bulkChunkSize < 1 {
+		pq.lock()
+		pq.mergeStaged()
+		drained := pq.popN(pq.elemsCount)
+		pq.unlock()
+
+		pq.recordDrained(drained)
+
+		return drained
+	}
+
+	remaining := pq.Size()
+	drained := make([]Item, 0, remaining)
+
+	for remaining > 0 {
+		pq.lock()
+		pq.mergeStaged()
+		chunk := pq.popN(minInt(remaining, pq.bulkChunkSize))
+		pq.unlock()
+		pq.recordDrained(chunk)
+		runtime.Gosched()
+
+		if len(chunk) == 0 {
+			break
+		}
+
+		drained = append(drained, chunk...)
+		remaining -= len(chunk)
+	}
+
+	return drained
+}
+
+// PushBatch pushes the items into the priority queue holding the lock
+// once, as successive Push2 calls would, and returns the count of
+// pushed items. The push stops at the first item the queue rejects, see
+// WithMaxItems and WithRecentDedup, whose error is returned.
+func (pq *PQueue) PushBatch(items []Item) (int, error) {
+	if pq.buffer != nil {
+		for i, pushed := range items {
+			if err := pq.push(pq.newBatchItem(pushed)); err != nil {
+				return i, err
+			}
+		}
+
+		return len(items), nil
+	}
+
+	timing := pq.lockTimed()
+	defer pq.unlockTimed(pushLock, timing)
+
+	for i, pushed := range items {
+		// checkRecent takes the lock the batch already holds
+		if pq.dedup != nil && pq.dedup.recent(pq.dedup.key(pushed.Value), pq.now()) {
+			return i, pq.newError("push", ErrDuplicate)
+		}
+
+		item := pq.newBatchItem(pushed)
+		if err := pq.pushLocked(item); err != nil {
+			pq.release(item)
+			return i, err
+		}
+	}
+
+	return len(items), nil
+}
+
+// newBatchItem creates a heap item out of the pushed item
+func (pq *PQueue) newBatchItem(pushed Item) *item {
+	item := pq.newItem(pushed.Value, int64(pushed.Priority))
+	item.secondary = int64(pushed.Secondary)
+
+	return item
+}
+
+// PopN removes up to n items from the priority queue holding the lock
+// once, and returns them in pop order.
+func (pq *PQueue) PopN(n int) []Item {
+	pq.lock()
+	pq.mergeStaged()
+	popped := pq.popN(maxInt(n, 0))
+	pq.unlock()
+
+	pq.recordDrained(popped)
+
+	return popped
+}
+
+// ToSlice returns a snapshot of the priority queue items, in pop order.
+// The queue is only locked while copying its items, and is left
+// untouched.
+func (pq *PQueue) ToSlice() []Item {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlock()
+
+	snapshot := make([]item, 0, pq.elemsCount)
+	for k := 1; k <= pq.elemsCount; k++ {
+		snapshot = append(snapshot, *pq.items[k])
+	}
+
+	// The ordering may change once the lock is released
+	ordering := &PQueue{
+		pqType:           pq.pqType,
+		comparator:       pq.comparator,
+		secondaryOrder:   pq.secondaryOrder,
+		secondaryOrdered: pq.secondaryOrdered,
+		valueLess:        pq.valueLess,
+		stable:           pq.stable,
+		random:           pq.random,
+	}
+
+	pq.RUnlock()
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		return ordering.lessItems(&snapshot[j], &snapshot[i])
+	})
+
+	items := make([]Item, len(snapshot))
+	for i := range snapshot {
+		items[i] = snapshot[i].export()
+	}
+
+	return items
+}
+
+// Iter calls fn with the items of a snapshot of the priority queue, in
+// pop order, until fn returns false. As fn is called once the queue is
+// unlocked, it may call the queue methods, which don't change the
+// iterated items.
+func (pq *PQueue) Iter(fn func(value interface{}, priority int) bool) {
+	for _, item := range pq.ToSlice() {
+		if !fn(item.Value, item.Priority) {
+			return
+		}
+	}
+}
+
+// DrainFunc removes every item from the priority queue and calls fn
+// with each of them, in pop order, without building a slice of them. fn
+// is called while holding the queue lock, and must not call the queue
+// methods.
+//
+// When the queue processes bulk operations by chunks, DrainFunc keeps
+// the same guarantees as Drain.
+func (pq *PQueue) DrainFunc(fn func(value interface{}, priority int)) {
+	if pq.dedup != nil {
+		drained := fn
+		fn = func(value interface{}, priority int) {
+			drained(value, priority)
+			pq.recordPopped(value)
+		}
+	}
+
+	if pq.bulkChunkSize < 1 {
+		pq.lock()
+		defer pq.unlock()
+
+		pq.mergeStaged()
+		pq.popEach(pq.elemsCount, fn)
+
+		return
+	}
+
+	for remaining := pq.Size(); remaining > 0; {
+		pq.lock()
+		pq.mergeStaged()
+		popped := pq.popEach(minInt(remaining, pq.bulkChunkSize), fn)
+		pq.unlock()
+		runtime.Gosched()
+
+		if popped == 0 {
+			break
+		}
+
+		remaining -= popped
+	}
+}
+
+// RemoveWhere removes every item for which the predicate returns true
+// from the priority queue, and returns the count of removed items. The
+// predicate is called while holding the queue lock, and must not call
+// the queue methods.
+//
+// When the queue processes bulk operations by chunks, only the items
+// queued when RemoveWhere was called are examined, each of them exactly
+// once unless it was popped concurrently.
+func (pq *PQueue) RemoveWhere(predicate func(value interface{}, priority int) bool) int {
+	if pq.bulkChunkSize < 1 {
+		pq.lock()
+		defer pq.unlock()
+
+		pq.mergeStaged()
+
+		return pq.filter(func(item *item) bool {
+			return !predicate(item.value, int(item.priority))
+		})
+	}
+
+	removed := 0
+	pq.eachChunk(func(item *item) {
+		if predicate(item.value, int(item.priority)) {
+			pq.removeAt(item.index)
+			pq.release(item)
+			removed++
+		}
+	})
+
+	return removed
+}
+
+// UpdatePriorities sets the priority of every item of the priority queue
+// to the one returned by fn for it, and returns the count of items whose
+// priority changed. fn is called while holding the queue lock, and must
+// not call the queue methods.
+//
+// When the queue processes bulk operations by chunks, only the items
+// queued when UpdatePriorities was called are updated, each of them
+// exactly once unless it was popped concurrently.
+func (pq *PQueue) UpdatePriorities(fn func(value interface{}, priority int) int) int {
+	if pq.bulkChunkSize < 1 {
+		pq.lock()
+		defer pq.unlock()
+
+		pq.mergeStaged()
+
+		updated := 0
+		for k := 1; k <= pq.elemsCount; k++ {
+			item := pq.items[k]
+			if priority := int64(fn(item.value, int(item.priority))); priority != item.priority {
+				pq.logPriority(k, priority)
+				pq.walPriority(item, priority)
+				item.priority = priority
+				updated++
+			}
+		}
+
+		if updated > 0 {
+			pq.heapify()
+		}
+
+		return updated
+	}
+
+	updated := 0
+	pq.eachChunk(func(item *item) {
+		if priority := int64(fn(item.value, int(item.priority))); priority != item.priority {
+			pq.logPriority(item.index, priority)
+			pq.walPriority(item, priority)
+			item.priority = priority
+			pq.logInt(opFix, item.index)
+			pq.fix(item.index)
+			updated++
+		}
+	})
+
+	return updated
+}
+
+// MapValues sets the value of every item of the priority queue to the
+// one returned by fn for it, priorities being left unchanged, and
+// returns the count of transformed items. A nil value returned by fn is
+// kept as the item value. fn is called while holding the queue lock,
+// and must not call the queue methods.
+//
+// When the queue tracks its estimated size in bytes, the new values are
+// accounted for, but don't cause any item to be rejected or evicted.
+//
+// When the queue processes bulk operations by chunks, only the items
+// queued when MapValues was called are transformed, each of them
+// exactly once unless it was popped concurrently.
+func (pq *PQueue) MapValues(fn func(value interface{}) interface{}) int {
+	if pq.bulkChunkSize < 1 {
+		pq.lock()
+		defer pq.unlock()
+
+		pq.mergeStaged()
+
+		for k := 1; k <= pq.elemsCount; k++ {
+			pq.setValue(pq.items[k], fn(pq.items[k].value))
+		}
+
+		// Values only matter to the ordering when using a value comparator
+		if pq.valueLess != nil {
+			pq.heapify()
+		}
+
+		return pq.elemsCount
+	}
+
+	mapped := 0
+	pq.eachChunk(func(item *item) {
+		pq.setValue(item, fn(item.value))
+		if pq.valueLess != nil {
+			pq.logInt(opFix, item.index)
+			pq.fix(item.index)
+		}
+		mapped++
+	})
+
+	return mapped
+}
+
+// setValue sets the value of a queued item, and updates the queue
+// estimated size. The caller must hold the write lock.
+func (pq *PQueue) setValue(item *item, value interface{}) {
+	if l := pq.logOp(opValue); l != nil {
+		l.int(int64(item.index)).value(value).end()
+	}
+
+	pq.walValue(item, value)
+	pq.indexRemove(item)
+	pq.internRemove(item)
+	item.value = value
+	pq.internAdd(item)
+	pq.indexAdd(item)
+	pq.headTouched(item)
+
+	if pq.sizeEstimator != nil {
+		size := pq.sizeEstimator(value)
+		pq.bytes += int64(size - item.size)
+		item.size = size
+	}
+}
+
+// Merge pushes a copy of every item of the other priority queue into
+// the priority queue, the other queue being left untouched. Items which
+// don't fit in the queue limits are skipped, and ErrFull is returned.
+//
+// When the queue processes bulk operations by chunks, the other queue
+// items are copied at once, and pushed by chunks.
+func (pq *PQueue) Merge(other *PQueue) error {
+	if other == pq {
+		return nil
+	}
+
+	if pq.Closed() {
+		return pq.lockedError("merge", ErrClosed)
+	}
+
+	other.lock()
+	other.mergeStaged()
+	sources := append([]*item(nil), other.items[1:other.elemsCount+1]...)
+
+	// The copies are numbered after the queue items, in the other queue
+	// stable order, see WithStableOrder.
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].seq < sources[j].seq
+	})
+
+	merged := make([]*item, 0, len(sources))
+	for _, source := range sources {
+		copied := pq.newItem(source.value, source.priority)
+		copied.secondary = source.secondary
+		copied.deadline = source.deadline
+		merged = append(merged, copied)
+	}
+	other.unlock()
+
+	chunkSize := pq.bulkChunkSize
+	if chunkSize < 1 {
+		chunkSize = len(merged)
+	}
+
+	var err error
+	for start := 0; start < len(merged); start += chunkSize {
+		end := minInt(start+chunkSize, len(merged))
+
+		pq.lock()
+		if pq.closed {
+			err = pq.newError("merge", ErrClosed)
+			pq.unlock()
+
+			break
+		}
+
+		for _, item := range merged[start:end] {
+			admitErr := pq.admit(item)
+			if admitErr == nil {
+				admitErr = pq.walPush(item)
+			}
+
+			if admitErr != nil {
+				if err == nil {
+					err = pq.newError("merge", admitErr)
+				}
+				continue
+			}
+
+			pq.enqueue(item)
+		}
+		pq.unlock()
+		runtime.Gosched()
+	}
+
+	return err
+}
+
+// popN pops up to n items from the heap. The caller must hold the write
+// lock.
+func (pq *PQueue) popN(n int) []Item {
+	n = minInt(n, pq.elemsCount)
+
+	popped := make([]Item, 0, n)
+	for i := 0; i < n; i++ {
+		head := pq.removeAt(1)
+		popped = append(popped, head.export())
+		pq.release(head)
+	}
+
+	return popped
+}
+
+// popEach pops up to n items from the heap, calls fn with each of
+// them, and returns the count of popped items. The caller must hold
+// the write lock.
+func (pq *PQueue) popEach(n int, fn func(value interface{}, priority int)) int {
+	n = minInt(n, pq.elemsCount)
+
+	for i := 0; i < n; i++ {
+		head := pq.removeAt(1)
+		fn(head.value, int(head.priority))
+		pq.release(head)
+	}
+
+	return n
+}
+
+// filter keeps the items for which keep returns true, removes the other
+// ones, and returns the count of removed items. The heap is rebuilt
+// once at the end. The caller must hold the write lock.
+func (pq *PQueue) filter(keep func(item *item) bool) int {
+	pq.lazyInit()
+
+	// The removed items indexes are recorded as they are found
+	l := pq.logOp(opFilter)
+
+	kept := 1
+	for k := 1; k <= pq.elemsCount; k++ {
+		item := pq.items[k]
+		if !keep(item) {
+			if k == 1 {
+				pq.headCause(HeadPopped)
+			}
+			if l != nil {
+				l.int(int64(k))
+			}
+
+			item.index = 0
+			pq.bytes -= int64(item.size)
+			pq.indexRemove(item)
+			pq.internRemove(item)
+			pq.walDelete(item)
+			pq.release(item)
+			continue
+		}
+
+		pq.items[kept] = item
+		item.index = kept
+		kept++
+	}
+
+	if l != nil {
+		l.end()
+	}
+
+	removed := pq.elemsCount - (kept - 1)
+	for k := kept; k <= pq.elemsCount; k++ {
+		pq.items[k] = nil
+	}
+
+	pq.items = pq.items[:kept]
+	pq.elemsCount = kept - 1
+	if removed > 0 {
+		pq.heapify()
+	}
+	pq.checkDrained()
+	pq.checkWatermarks()
+	pq.checkShrink()
+
+	return removed
+}
+
+// eachChunk calls fn, holding the write lock, for every item queued when
+// eachChunk is called and still queued when its chunk is processed. The
+// lock is released between chunks.
+func (pq *PQueue) eachChunk(fn func(item *item)) {
+	type entry struct {
+		item *item
+		gen  uint32
+	}
+
+	pq.lock()
+	pq.mergeStaged()
+	snapshot := make([]entry, pq.elemsCount)
+	for k := range snapshot {
+		snapshot[k] = entry{pq.items[k+1], pq.items[k+1].gen}
+	}
+	pq.unlock()
+
+	for start := 0; start < len(snapshot); start += pq.bulkChunkSize {
+		end := minInt(start+pq.bulkChunkSize, len(snapshot))
+
+		pq.lock()
+		for _, e := range snapshot[start:end] {
+			// Items recycled since the snapshot are new items
+			if e.item.gen == e.gen && pq.contains(e.item) {
+				fn(e.item)
+			}
+		}
+		pq.unlock()
+
+		// Let the goroutines blocked on the lock run before the next
+		// chunk is processed.
+		runtime.Gosched()
+	}
+}
+
+// contains reports whether the item is part of the heap. The caller
+// must hold the lock.
+func (pq *PQueue) contains(item *item) bool {
+	k := item.index
+	return k >= 1 && k <= pq.elemsCount && pq.items[k] == item
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,549 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forEachBulkMode runs the test against a queue processing bulk
+// operations at once, and one processing them by chunks.
+func forEachBulkMode(t *testing.T, pqType PQType, test func(t *testing.T, pqueue *PQueue)) {
+	t.Run("atomic", func(t *testing.T) {
+		test(t, NewPQueue(pqType))
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		pqueue, err := NewPQueueWithOptions(pqType, WithBulkChunkSize(3))
+		assert.Nil(t, err)
+
+		test(t, pqueue)
+	})
+}
+
+func TestNewPQueueWithOptions_invalid_bulk_chunk_size(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithBulkChunkSize(0))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueueDrain(t *testing.T) {
+	forEachBulkMode(t, MAXPQ, func(t *testing.T, pqueue *PQueue) {
+		for _, priority := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6} {
+			pqueue.Push(priority*10, priority)
+		}
+
+		drained := pqueue.Drain()
+		assert.Equal(t, len(drained), 9)
+		for i, item := range drained {
+			assert.Equal(t, item, Item{Value: (9 - i) * 10, Priority: 9 - i})
+		}
+
+		assert.Equal(t, pqueue.Size(), 0)
+		assert.Equal(t, pqueue.Drain(), []Item{})
+	})
+}
+
+func TestPQueueDrainFunc(t *testing.T) {
+	forEachBulkMode(t, MINPQ, func(t *testing.T, pqueue *PQueue) {
+		for _, priority := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6} {
+			pqueue.Push(priority*10, priority)
+		}
+
+		expected := 1
+		pqueue.DrainFunc(func(value interface{}, priority int) {
+			assert.Equal(t, value, expected*10)
+			assert.Equal(t, priority, expected)
+			expected++
+		})
+
+		assert.Equal(t, expected, 10)
+		assert.Equal(t, pqueue.Size(), 0)
+
+		pqueue.DrainFunc(func(value interface{}, priority int) {
+			t.Fatal("drained an empty queue")
+		})
+	})
+}
+
+func TestPQueueRemoveWhere(t *testing.T) {
+	forEachBulkMode(t, MINPQ, func(t *testing.T, pqueue *PQueue) {
+		for i := 0; i < 20; i++ {
+			pqueue.Push(i, i)
+		}
+
+		removed := pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+			return priority%2 == 1
+		})
+		assert.Equal(t, removed, 10)
+		assert.Equal(t, pqueue.Size(), 10)
+		assertHeapInvariant(t, pqueue)
+
+		for i := 0; i < 20; i += 2 {
+			value, _ := pqueue.Pop()
+			assert.Equal(t, value, i)
+		}
+	})
+}
+
+func TestPQueueRemoveWhere_releases_removed_refs(t *testing.T) {
+	forEachBulkMode(t, MINPQ, func(t *testing.T, pqueue *PQueue) {
+		ref, _ := pqueue.PushRef("1", 1)
+		pqueue.Push("2", 2)
+
+		pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+			return value == "1"
+		})
+
+		assert.False(t, pqueue.Fix(ref))
+	})
+}
+
+func TestPQueueRemoveWhere_chunked_examines_each_item_once(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithBulkChunkSize(7))
+	assert.Nil(t, err)
+
+	const initial = 2000
+	for i := 0; i < initial; i++ {
+		pqueue.Push(i, i%100)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := initial; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				pqueue.Push(i, i%100)
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	examined := make(map[int]int)
+	removed := pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+		examined[value.(int)]++
+		return value.(int) < initial
+	})
+
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, removed, initial)
+	assert.Equal(t, len(examined), initial)
+	for value, count := range examined {
+		assert.True(t, value < initial, "pushed item %d was examined", value)
+		assert.Equal(t, count, 1)
+	}
+	assertHeapInvariant(t, pqueue)
+}
+
+func TestPQueueUpdatePriorities(t *testing.T) {
+	forEachBulkMode(t, MAXPQ, func(t *testing.T, pqueue *PQueue) {
+		for i := 0; i < 10; i++ {
+			pqueue.Push(i, i)
+		}
+
+		// Reverse the ordering of even items
+		updated := pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+			if priority%2 == 0 {
+				return -priority
+			}
+			return priority
+		})
+		assert.Equal(t, updated, 4)
+		assertHeapInvariant(t, pqueue)
+
+		for _, expected := range []int{9, 7, 5, 3, 1, 0, 2, 4, 6, 8} {
+			value, _ := pqueue.Pop()
+			assert.Equal(t, value, expected)
+		}
+	})
+}
+
+type jobV1 struct{ name string }
+
+type jobV2 struct {
+	name    string
+	version int
+}
+
+func TestPQueueMapValues_migrates_values(t *testing.T) {
+	forEachBulkMode(t, MINPQ, func(t *testing.T, pqueue *PQueue) {
+		pqueue.Push(jobV1{"a"}, 1)
+		pqueue.Push("legacy", 4)
+		pqueue.Push(jobV1{"b"}, 2)
+		pqueue.Push(42, 3)
+		pqueue.Push(jobV1{"c"}, 5)
+
+		removed := pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+			_, isInt := value.(int)
+			return isInt
+		})
+		assert.Equal(t, removed, 1)
+
+		mapped := pqueue.MapValues(func(value interface{}) interface{} {
+			switch v := value.(type) {
+			case jobV1:
+				return jobV2{name: v.name, version: 2}
+			default:
+				return nil
+			}
+		})
+		assert.Equal(t, mapped, 4)
+		assert.Equal(t, pqueue.Size(), 4)
+
+		expected := []interface{}{jobV2{"a", 2}, jobV2{"b", 2}, nil, jobV2{"c", 2}}
+		for i, priority := range []int{1, 2, 4, 5} {
+			value, popped := pqueue.Pop()
+			assert.Equal(t, value, expected[i])
+			assert.Equal(t, popped, priority)
+		}
+	})
+}
+
+func TestPQueueMapValues_updates_bytes(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithSizeEstimator(stringSize))
+	assert.Nil(t, err)
+
+	pqueue.Push("aa", 1)
+	pqueue.Push("bbb", 2)
+	assert.Equal(t, pqueue.Stats().Bytes, int64(5))
+
+	pqueue.MapValues(func(value interface{}) interface{} {
+		return value.(string) + value.(string)
+	})
+	assert.Equal(t, pqueue.Stats().Bytes, int64(10))
+}
+
+func TestPQueueMapValues_value_comparator(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueComparator(func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}))
+	assert.Nil(t, err)
+
+	for _, value := range []int{1, 2, 3, 4, 5} {
+		pqueue.Push(value, 0)
+	}
+
+	pqueue.MapValues(func(value interface{}) interface{} {
+		return -value.(int)
+	})
+	assert.True(t, assertHeapInvariant(t, pqueue))
+
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, -1)
+}
+
+func TestPQueueMerge(t *testing.T) {
+	forEachBulkMode(t, MINPQ, func(t *testing.T, pqueue *PQueue) {
+		other := NewPQueue(MAXPQ)
+		for i := 0; i < 10; i += 2 {
+			pqueue.Push(i, i)
+			other.Push(i+1, i+1)
+		}
+
+		assert.Nil(t, pqueue.Merge(other))
+		assert.Nil(t, pqueue.Merge(pqueue))
+		assert.Equal(t, pqueue.Size(), 10)
+		assert.Equal(t, other.Size(), 5)
+
+		for i := 0; i < 10; i++ {
+			value, _ := pqueue.Pop()
+			assert.Equal(t, value, i)
+		}
+	})
+}
+
+func TestPQueueMerge_keeps_stable_order(t *testing.T) {
+	forEachBulkMode(t, MAXPQ, func(t *testing.T, pqueue *PQueue) {
+		pqueue.stable = true
+		other, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+		assert.Nil(t, err)
+
+		for i := 0; i < 5; i++ {
+			pqueue.Push(i, 1)
+			other.Push(i+5, 1)
+			other.Push(-1, 2)
+		}
+		other.Pop()
+
+		// The merged items follow the queue ones, in their push order
+		assert.Nil(t, pqueue.Merge(other))
+		pqueue.Push(10, 1)
+
+		popped := []interface{}{}
+		for pqueue.Size() > 0 {
+			if value, priority := pqueue.Pop(); priority == 1 {
+				popped = append(popped, value)
+			}
+		}
+		assert.Equal(t, popped, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	})
+}
+
+func TestPQueueMerge_skips_items_over_limits(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(3))
+	assert.Nil(t, err)
+	pqueue.Push("1", 1)
+
+	other := NewPQueue(MAXPQ)
+	for i := 2; i < 6; i++ {
+		other.Push(i, i)
+	}
+
+	assert.True(t, errors.Is(pqueue.Merge(other), ErrFull))
+	assert.Equal(t, pqueue.Size(), 3)
+}
+
+// maxPushStall returns the longest Push duration observed while the
+// operation runs, pushing at a steady pace.
+func maxPushStall(pqueue *PQueue, operation func()) time.Duration {
+	var stall time.Duration
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			start := time.Now()
+			pqueue.Push(-1, i)
+			if elapsed := time.Since(start); elapsed > stall {
+				stall = elapsed
+			}
+
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	// Let the pushing goroutine start before the operation
+	time.Sleep(time.Millisecond)
+	operation()
+	close(stop)
+	<-done
+
+	return stall
+}
+
+// BenchmarkPQueueRemoveWhere_push_stalls reports the worst push stall
+// met while removing half of a large queue, with and without chunks.
+func BenchmarkPQueueRemoveWhere_push_stalls(b *testing.B) {
+	const size = 1000000
+
+	fill := func(pqueue *PQueue) {
+		for i := 0; i < size; i++ {
+			pqueue.Push(i, i)
+		}
+	}
+	removeOdd := func(pqueue *PQueue) func() {
+		return func() {
+			pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+				return value.(int)%2 == 1
+			})
+		}
+	}
+
+	var atomicStall, chunkedStall time.Duration
+	for i := 0; i < b.N; i++ {
+		atomic := NewPQueue(MAXPQ)
+		fill(atomic)
+		if stall := maxPushStall(atomic, removeOdd(atomic)); stall > atomicStall {
+			atomicStall = stall
+		}
+
+		chunked, err := NewPQueueWithOptions(MAXPQ, WithBulkChunkSize(1000))
+		if err != nil {
+			b.Fatal(err)
+		}
+		fill(chunked)
+		if stall := maxPushStall(chunked, removeOdd(chunked)); stall > chunkedStall {
+			chunkedStall = stall
+		}
+	}
+
+	b.ReportMetric(float64(atomicStall.Nanoseconds()), "stall-ns")
+	b.ReportMetric(float64(chunkedStall.Nanoseconds()), "chunked-stall-ns")
+}
+
+func benchmarkPQueueDrain(b *testing.B, drain func(pqueue *PQueue)) {
+	const size = 1 << 16
+
+	pqueue := NewPQueue(MAXPQ)
+	values := make([]interface{}, size)
+	for i := range values {
+		values[i] = i
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for priority, value := range values {
+			pqueue.Push(value, priority)
+		}
+		b.StartTimer()
+
+		drain(pqueue)
+	}
+}
+
+func BenchmarkPQueueDrain(b *testing.B) {
+	benchmarkPQueueDrain(b, func(pqueue *PQueue) {
+		pqueue.Drain()
+	})
+}
+
+func BenchmarkPQueueDrainFunc(b *testing.B) {
+	benchmarkPQueueDrain(b, func(pqueue *PQueue) {
+		pqueue.DrainFunc(func(value interface{}, priority int) {})
+	})
+}
+
+func TestPQueuePushBatch(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithStableOrder())
+	assert.Nil(t, err)
+
+	pushed, err := pqueue.PushBatch([]Item{
+		{Value: "b", Priority: 2},
+		{Value: "a", Priority: 1},
+		{Value: "c", Priority: 2, Secondary: -1},
+		{Value: "d", Priority: 2},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, pushed, 4)
+
+	assert.Equal(t, pqueue.Drain(), []Item{
+		{Value: "a", Priority: 1},
+		{Value: "c", Priority: 2, Secondary: -1},
+		{Value: "b", Priority: 2},
+		{Value: "d", Priority: 2},
+	})
+}
+
+func TestPQueuePushBatch_stops_at_rejected_item(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(2))
+	assert.Nil(t, err)
+
+	pushed, err := pqueue.PushBatch([]Item{{Value: 1, Priority: 1}, {Value: 2, Priority: 2}, {Value: 3, Priority: 3}})
+	assert.True(t, errors.Is(err, ErrFull))
+	assert.Equal(t, pushed, 2)
+	assert.Equal(t, pqueue.Size(), 2)
+
+	pqueue.Close()
+	pushed, err = pqueue.PushBatch([]Item{{Value: 4, Priority: 4}})
+	assert.True(t, errors.Is(err, ErrClosed))
+	assert.Equal(t, pushed, 0)
+}
+
+func TestPQueuePushBatch_recently_popped_value(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithRecentDedup(time.Hour, func(value interface{}) string {
+		return fmt.Sprint(value)
+	}))
+	assert.Nil(t, err)
+	pqueue.Push("a", 1)
+	pqueue.Pop()
+
+	pushed, err := pqueue.PushBatch([]Item{{Value: "b", Priority: 1}, {Value: "a", Priority: 2}})
+	assert.True(t, errors.Is(err, ErrDuplicate))
+	assert.Equal(t, pushed, 1)
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueuePushBatch_with_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(2, 0))
+	assert.Nil(t, err)
+
+	pushed, err := pqueue.PushBatch([]Item{{Value: 1, Priority: 1}, {Value: 2, Priority: 2}, {Value: 3, Priority: 3}})
+	assert.Nil(t, err)
+	assert.Equal(t, pushed, 3)
+	assert.Equal(t, len(pqueue.Drain()), 3)
+}
+
+func TestPQueuePopN(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for _, priority := range []int{5, 3, 9, 1, 7} {
+		pqueue.Push(priority*10, priority)
+	}
+
+	assert.Equal(t, pqueue.PopN(2), []Item{{Value: 90, Priority: 9}, {Value: 70, Priority: 7}})
+	assert.Equal(t, pqueue.PopN(0), []Item{})
+	assert.Equal(t, pqueue.PopN(-1), []Item{})
+	assert.Equal(t, pqueue.PopN(10), []Item{{Value: 50, Priority: 5}, {Value: 30, Priority: 3}, {Value: 10, Priority: 1}})
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueToSlice(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for _, priority := range []int{5, 3, 9, 1, 7} {
+		pqueue.Push(priority*10, priority)
+	}
+
+	assert.Equal(t, pqueue.ToSlice(), []Item{
+		{Value: 10, Priority: 1},
+		{Value: 30, Priority: 3},
+		{Value: 50, Priority: 5},
+		{Value: 70, Priority: 7},
+		{Value: 90, Priority: 9},
+	})
+	assert.Equal(t, pqueue.Size(), 5)
+	assert.Equal(t, NewPQueue(MINPQ).ToSlice(), []Item{})
+}
+
+func TestPQueueIter(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for i := 1; i <= 5; i++ {
+		pqueue.Push(i, i)
+	}
+
+	// The iterated items are a snapshot, which fn may change the queue
+	// under.
+	var values []interface{}
+	pqueue.Iter(func(value interface{}, priority int) bool {
+		values = append(values, value)
+		pqueue.Pop()
+		return priority > 3
+	})
+	assert.Equal(t, values, []interface{}{5, 4, 3})
+	assert.Equal(t, pqueue.Size(), 2)
+}
+
+func BenchmarkPQueuePush_one_at_a_time(b *testing.B) {
+	pqueue := NewPQueue(MAXPQ)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1024; j++ {
+			pqueue.Push(j, j)
+		}
+		pqueue.Drain()
+	}
+}
+
+func BenchmarkPQueuePushBatch(b *testing.B) {
+	pqueue := NewPQueue(MAXPQ)
+	items := make([]Item, 1024)
+	for j := range items {
+		items[j] = Item{Value: j, Priority: j}
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pqueue.PushBatch(items)
+		pqueue.Drain()
+	}
+}
@@ -0,0 +1,181 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// ErrNoSources is returned when receiving from a channel mux whose
+// sources are all closed and drained.
+var ErrNoSources = errors.New("lane: channel mux has no open source")
+
+// ChanMux receives values from several source channels of differing
+// priorities, always reading from the highest/lowest priority source
+// channel (depending on whether you're using a MINPQ or MAXPQ) which has
+// data: a prioritized select. It is safe for concurrent operations.
+type ChanMux struct {
+	sync.Mutex
+	pqType PQType
+
+	// sources are ordered by precedence, sources of equal priority in
+	// the order they were added. The slice is replaced rather than
+	// modified, so that receivers can use it without holding the lock.
+	sources []muxSource
+
+	// added is closed, and replaced, when a source is added, so that
+	// blocked receivers start receiving from it.
+	added chan struct{}
+}
+
+type muxSource struct {
+	ch       <-chan interface{}
+	priority int
+}
+
+// NewChanMux creates a new channel mux with the provided pqtype
+// ordering type.
+func NewChanMux(pqType PQType) *ChanMux {
+	return &ChanMux{
+		pqType: pqType,
+		added:  make(chan struct{}),
+	}
+}
+
+// Add adds the ch source channel with provided priority. Closed sources
+// are removed once drained.
+func (m *ChanMux) Add(ch <-chan interface{}, priority int) {
+	m.Lock()
+	defer m.Unlock()
+
+	// Insert the source after the ones of equal or higher precedence
+	i := 0
+	for i < len(m.sources) && !m.precedes(priority, m.sources[i].priority) {
+		i++
+	}
+
+	sources := make([]muxSource, 0, len(m.sources)+1)
+	sources = append(sources, m.sources[:i]...)
+	sources = append(sources, muxSource{ch: ch, priority: priority})
+	m.sources = append(sources, m.sources[i:]...)
+
+	close(m.added)
+	m.added = make(chan struct{})
+}
+
+// Recv receives a value from the highest/lowest priority source channel
+// (depending on whether you're using a MINPQ or MAXPQ) which has data,
+// and returns it along with the source priority. When no source has
+// data, Recv blocks until one of them does, or the context is done.
+//
+// When several sources are ready, the value is received from the one of
+// highest precedence. When several sources get ready while Recv is
+// blocked, which of them is received from is unspecified.
+//
+// ErrNoSources is returned if every source is closed and drained, or if
+// no source was added.
+func (m *ChanMux) Recv(ctx context.Context) (interface{}, int, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		m.Lock()
+		sources, added := m.sources, m.added
+		m.Unlock()
+
+		if len(sources) == 0 {
+			return nil, 0, ErrNoSources
+		}
+
+		// Poll the sources in precedence order
+		ready, closed := -1, -1
+		var value interface{}
+
+		for i, source := range sources {
+			select {
+			case v, ok := <-source.ch:
+				if !ok {
+					closed = i
+				} else {
+					ready, value = i, v
+				}
+			default:
+				continue
+			}
+
+			break
+		}
+
+		if ready < 0 && closed < 0 {
+			ready, value, closed = m.wait(ctx, sources, added)
+		}
+
+		switch {
+		case ready >= 0:
+			return value, sources[ready].priority, nil
+		case closed >= 0:
+			m.remove(sources[closed].ch)
+		}
+	}
+}
+
+// wait blocks until one of the sources is ready or closed, a source is
+// added, or the context is done. It returns the index of the source a
+// value was received from, along with the value, or the index of the
+// closed source, -1 otherwise.
+func (m *ChanMux) wait(ctx context.Context, sources []muxSource, added chan struct{}) (int, interface{}, int) {
+	cases := make([]reflect.SelectCase, 0, len(sources)+2)
+	for _, source := range sources {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(source.ch)})
+	}
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(added)},
+	)
+
+	chosen, received, ok := reflect.Select(cases)
+	switch {
+	case chosen >= len(sources):
+		// The context is checked by the caller
+		return -1, nil, -1
+	case !ok:
+		return -1, nil, chosen
+	}
+
+	return chosen, received.Interface(), -1
+}
+
+// remove removes the ch source
+func (m *ChanMux) remove(ch <-chan interface{}) {
+	m.Lock()
+	defer m.Unlock()
+
+	sources := make([]muxSource, 0, len(m.sources))
+	for _, source := range m.sources {
+		if source.ch != ch {
+			sources = append(sources, source)
+		}
+	}
+
+	m.sources = sources
+}
+
+// Len returns the count of sources which were not removed yet
+func (m *ChanMux) Len() int {
+	m.Lock()
+	defer m.Unlock()
+
+	return len(m.sources)
+}
+
+// precedes reports whether a source of priority a is received from
+// before a source of priority b.
+func (m *ChanMux) precedes(a, b int) bool {
+	if m.pqType == MINPQ {
+		return a < b
+	}
+
+	return a > b
+}
@@ -0,0 +1,170 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanMuxRecv_no_sources(t *testing.T) {
+	mux := NewChanMux(MAXPQ)
+
+	_, _, err := mux.Recv(context.Background())
+	assert.True(t, errors.Is(err, ErrNoSources))
+}
+
+func TestChanMuxRecv_simultaneous_readiness(t *testing.T) {
+	testCases := map[PQType][]int{
+		MAXPQ: {3, 3, 2, 2, 1, 1},
+		MINPQ: {1, 1, 2, 2, 3, 3},
+	}
+
+	for pqType, expected := range testCases {
+		mux := NewChanMux(pqType)
+
+		for _, priority := range []int{2, 1, 3} {
+			ch := make(chan interface{}, 2)
+			ch <- priority
+			ch <- priority
+			close(ch)
+			mux.Add(ch, priority)
+		}
+
+		var priorities []int
+		for {
+			value, priority, err := mux.Recv(context.Background())
+			if errors.Is(err, ErrNoSources) {
+				break
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, value, priority)
+			priorities = append(priorities, priority)
+		}
+
+		assert.Equal(t, priorities, expected)
+		assert.Equal(t, mux.Len(), 0)
+	}
+}
+
+func TestChanMuxRecv_equal_priorities_in_add_order(t *testing.T) {
+	mux := NewChanMux(MAXPQ)
+
+	first, second := make(chan interface{}, 1), make(chan interface{}, 1)
+	second <- "second"
+	first <- "first"
+	mux.Add(first, 1)
+	mux.Add(second, 1)
+
+	value, _, err := mux.Recv(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, "first")
+}
+
+func TestChanMuxRecv_blocks_until_a_source_is_ready(t *testing.T) {
+	mux := NewChanMux(MAXPQ)
+
+	low, high := make(chan interface{}), make(chan interface{})
+	mux.Add(low, 1)
+	mux.Add(high, 2)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		low <- "low"
+	}()
+
+	value, priority, err := mux.Recv(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, "low")
+	assert.Equal(t, priority, 1)
+}
+
+func TestChanMuxRecv_source_removal_mid_stream(t *testing.T) {
+	mux := NewChanMux(MAXPQ)
+
+	low, high := make(chan interface{}, 4), make(chan interface{})
+	mux.Add(low, 1)
+	mux.Add(high, 2)
+
+	go func() {
+		high <- "high 1"
+		high <- "high 2"
+		close(high)
+		low <- "low 1"
+		close(low)
+	}()
+
+	var values []interface{}
+	for {
+		value, _, err := mux.Recv(context.Background())
+		if errors.Is(err, ErrNoSources) {
+			break
+		}
+
+		assert.Nil(t, err)
+		values = append(values, value)
+	}
+
+	assert.Equal(t, values, []interface{}{"high 1", "high 2", "low 1"})
+}
+
+func TestChanMuxRecv_closed_source_drained_first(t *testing.T) {
+	mux := NewChanMux(MAXPQ)
+
+	ch := make(chan interface{}, 2)
+	ch <- 1
+	ch <- nil
+	close(ch)
+	mux.Add(ch, 1)
+
+	value, _, err := mux.Recv(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, 1)
+
+	value, _, err = mux.Recv(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	assert.Equal(t, mux.Len(), 1)
+	_, _, err = mux.Recv(context.Background())
+	assert.True(t, errors.Is(err, ErrNoSources))
+	assert.Equal(t, mux.Len(), 0)
+}
+
+func TestChanMuxAdd_wakes_blocked_receivers(t *testing.T) {
+	mux := NewChanMux(MINPQ)
+	mux.Add(make(chan interface{}), 5)
+
+	received := make(chan interface{})
+	go func() {
+		value, _, err := mux.Recv(context.Background())
+		assert.Nil(t, err)
+		received <- value
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	ch := make(chan interface{}, 1)
+	ch <- "added"
+	mux.Add(ch, 1)
+
+	select {
+	case value := <-received:
+		assert.Equal(t, value, "added")
+	case <-time.After(time.Second):
+		t.Fatal("the receiver was not woken up")
+	}
+}
+
+func TestChanMuxRecv_context_done(t *testing.T) {
+	mux := NewChanMux(MAXPQ)
+	mux.Add(make(chan interface{}), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err := mux.Recv(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,110 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrClosed is the error returned when pushing to, or waiting on, a
+// closed priority queue.
+var ErrClosed = errors.New("lane: priority queue is closed")
+
+// Close closes the priority queue to new items: pushing returns
+// ErrClosed from then on. The queued items can still be popped, and
+// blocked WaitPop calls return ErrClosed once the queue is empty.
+// Closing a closed queue returns ErrClosed.
+func (pq *PQueue) Close() error {
+	pq.lock()
+	defer pq.unlock()
+
+	if pq.closed {
+		return pq.newError("close", ErrClosed)
+	}
+
+	pq.close()
+
+	return nil
+}
+
+// Closed reports whether the priority queue is closed
+func (pq *PQueue) Closed() bool {
+	return atomic.LoadInt32(&pq.closing) != 0
+}
+
+// CloseAndDrain closes the priority queue, see Close, and waits for the
+// consumers to empty it until the context is done. It then removes and
+// returns the items left in pop order, along with the context error.
+func (pq *PQueue) CloseAndDrain(ctx context.Context) ([]Item, error) {
+	pq.lock()
+	if !pq.closed {
+		pq.close()
+	}
+	drained := pq.drained
+	pq.unlock()
+
+	select {
+	case <-drained:
+		return nil, nil
+	case <-ctx.Done():
+	}
+
+	pq.lock()
+	pq.mergeStaged()
+	if pq.elemsCount == 0 {
+		pq.unlock()
+		return nil, nil
+	}
+
+	err := pq.newError("close and drain", ctx.Err())
+	left := pq.popN(pq.elemsCount)
+	pq.unlock()
+
+	// The items left are drained, as PopN does
+	pq.recordDrained(left)
+
+	return left, err
+}
+
+// close closes the queue, and wakes the blocked consumers up. The
+// caller must hold the write lock.
+func (pq *PQueue) close() {
+	pq.closed = true
+	atomic.StoreInt32(&pq.closing, 1)
+
+	if b := pq.buffer; b != nil {
+		// Items are staged holding the buffer lock, once it is acquired
+		// no push can stage an item anymore.
+		b.Lock()
+		b.Unlock()
+		pq.mergeStaged()
+	}
+
+	for pq.waiters != nil && pq.waiters.Len() > 0 {
+		w := pq.waiters.Front().Value.(*waiter)
+		pq.removeWaiter(w)
+		close(w.ch)
+		schedPoint("wait.wake")
+	}
+
+	pq.drained = make(chan struct{})
+	pq.checkDrained()
+
+	// The errors are kept for FlushOpLog and FlushWAL to return
+	pq.flushOpLog()
+	pq.flushWAL()
+}
+
+// checkDrained signals that a closed queue is empty. The caller must
+// hold the write lock.
+func (pq *PQueue) checkDrained() {
+	if pq.drained == nil || pq.elemsCount > 0 || pq.hasStaged() {
+		return
+	}
+
+	select {
+	case <-pq.drained:
+	default:
+		close(pq.drained)
+	}
+}
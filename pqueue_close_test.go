@@ -0,0 +1,181 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertNoGoroutineLeak checks the goroutines count gets back to
+// what it was before the test.
+func assertNoGoroutineLeak(t *testing.T, before int) {
+	// assert.Eventually runs the condition in its own goroutine
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+// consume starts n consumers popping from the queue until it is closed
+// and empty, and calling handle with each popped value.
+func consume(pqueue *PQueue, n int, handle func(value interface{})) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				value, _, err := pqueue.WaitPop(context.Background())
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+
+				handle(value)
+			}
+		}()
+	}
+
+	return &wg
+}
+
+func TestPQueueClose(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+
+	assert.False(t, pqueue.Closed())
+	assert.Nil(t, pqueue.Close())
+	assert.True(t, pqueue.Closed())
+	assert.True(t, errors.Is(pqueue.Close(), ErrClosed))
+
+	err := pqueue.Push("b", 2)
+	assert.True(t, errors.Is(err, ErrClosed))
+	assert.Equal(t, err.Error(), "lane: push on max priority queue (size 1): priority queue is closed")
+
+	_, err = pqueue.PushRef("b", 2)
+	assert.True(t, errors.Is(err, ErrClosed))
+	assert.True(t, errors.Is(pqueue.Merge(NewPQueue(MAXPQ)), ErrClosed))
+	assert.True(t, errors.Is(pqueue.UnmarshalJSON([]byte(`{"ordering":"max","items":[]}`)), ErrClosed))
+
+	// Queued items can still be popped
+	value, _, err := pqueue.WaitPop(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, "a")
+
+	_, _, err = pqueue.WaitPop(context.Background())
+	assert.True(t, errors.Is(err, ErrClosed))
+}
+
+func TestPQueueClose_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(16, 0))
+	assert.Nil(t, err)
+
+	pqueue.Push("a", 1)
+	assert.Nil(t, pqueue.Close())
+	assert.True(t, errors.Is(pqueue.Push("b", 2), ErrClosed))
+
+	// Staged items were merged by Close
+	assert.Equal(t, pqueue.elemsCount, 1)
+}
+
+func TestPQueueClose_wakes_waiters(t *testing.T) {
+	before := runtime.NumGoroutine()
+	pqueue := NewPQueue(MAXPQ)
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, _, err := pqueue.WaitPop(context.Background())
+			errs <- err
+		}()
+	}
+	waitForWaiters(t, pqueue, 3)
+
+	pqueue.Close()
+	for i := 0; i < 3; i++ {
+		assert.True(t, errors.Is(<-errs, ErrClosed))
+	}
+
+	assertNoGoroutineLeak(t, before)
+}
+
+func TestPQueueCloseAndDrain_consumers_finish(t *testing.T) {
+	before := runtime.NumGoroutine()
+	pqueue := NewPQueue(MAXPQ)
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+	}
+
+	var mu sync.Mutex
+	consumed := 0
+	wg := consume(pqueue, 4, func(value interface{}) {
+		mu.Lock()
+		consumed++
+		mu.Unlock()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	remaining, err := pqueue.CloseAndDrain(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, remaining)
+
+	wg.Wait()
+	assert.Equal(t, consumed, 100)
+	assertNoGoroutineLeak(t, before)
+}
+
+func TestPQueueCloseAndDrain_consumers_stall(t *testing.T) {
+	before := runtime.NumGoroutine()
+	pqueue := NewPQueue(MINPQ)
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, i)
+	}
+
+	unstall := make(chan struct{})
+	wg := consume(pqueue, 1, func(value interface{}) {
+		<-unstall
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	remaining, err := pqueue.CloseAndDrain(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	var queueErr *QueueError
+	assert.True(t, errors.As(err, &queueErr))
+	assert.Equal(t, queueErr.Op, "close and drain")
+	assert.Equal(t, queueErr.Size, len(remaining))
+
+	// The stalled consumer holds the first item
+	assert.Equal(t, len(remaining), 9)
+	for i, item := range remaining {
+		assert.Equal(t, item, Item{Value: i + 1, Priority: i + 1})
+	}
+	assert.Equal(t, pqueue.Size(), 0)
+
+	// The items left count as drained
+	assert.Equal(t, pqueue.MetricsSnapshot().Pops, uint64(10))
+
+	close(unstall)
+	wg.Wait()
+	assertNoGoroutineLeak(t, before)
+}
+
+func TestPQueueCloseAndDrain_empty_queue(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	remaining, err := pqueue.CloseAndDrain(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, remaining)
+	assert.True(t, pqueue.Closed())
+}
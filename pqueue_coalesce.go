@@ -0,0 +1,89 @@
+package lane
+
+import (
+	"fmt"
+	"sort"
+)
+
+// coalescing holds the WithCoalescing merge function, and the value
+// index it set up.
+type coalescing struct {
+	merge func(older, newer interface{}) interface{}
+	index *valueIndex
+}
+
+// WithCoalescing makes Pop, PopRelease and WaitPop coalesce the popped
+// head with every other queued item whose value has the same key: they
+// are all removed from the queue, and their values folded into the
+// returned one by merge, from the oldest pushed to the newest. The
+// returned priority is the head one.
+//
+// The queue maintains a value index by keyFn, see WithValueIndex, which
+// can't be used along with WithCoalescing. keyFn and merge are called
+// while holding the queue lock, and must not call the queue methods.
+func WithCoalescing(keyFn func(value interface{}) string, merge func(older, newer interface{}) interface{}) PQueueOption {
+	return func(pq *PQueue) error {
+		if keyFn == nil || merge == nil {
+			return fmt.Errorf("%w: nil coalescing function", ErrInvalidOption)
+		}
+
+		pq.index = &valueIndex{key: keyFn, items: make(map[string][]*item)}
+		pq.coalesce = &coalescing{merge: merge, index: pq.index}
+		return nil
+	}
+}
+
+// validateCoalescing checks the coalescing value index wasn't replaced
+func (pq *PQueue) validateCoalescing() error {
+	if pq.coalesce != nil && pq.coalesce.index != pq.index {
+		return fmt.Errorf("%w: coalescing and value index", ErrIncompatibleOptions)
+	}
+
+	return nil
+}
+
+// popHead removes the head item, coalescing it with the items sharing
+// its value key if the queue coalesces them, and returns its value and
+// priorities. The caller must hold the write lock, and make sure the
+// queue isn't empty.
+func (pq *PQueue) popHead() (interface{}, int64, int64) {
+	head := pq.popHeadItem()
+	value, priority, secondary := head.value, head.priority, head.secondary
+	pq.release(head)
+
+	return value, priority, secondary
+}
+
+// popHeadItem is popHead returning the removed item, which the caller
+// owns and must release. Coalesced items are merged into the oldest of
+// them. The caller must hold the write lock, and make sure the queue
+// isn't empty.
+func (pq *PQueue) popHeadItem() *item {
+	head := pq.items[1]
+	if pq.coalesce == nil {
+		pq.removeAt(1)
+
+		return head
+	}
+
+	group := append([]*item(nil), pq.index.items[pq.index.key(head.value)]...)
+	for _, item := range group {
+		pq.removeAt(item.index)
+	}
+
+	sort.Slice(group, func(i, j int) bool {
+		return group[i].seq < group[j].seq
+	})
+
+	oldest := group[0]
+	for _, item := range group[1:] {
+		oldest.value = pq.coalesce.merge(oldest.value, item.value)
+		pq.release(item)
+	}
+	oldest.priority, oldest.secondary = head.priority, head.secondary
+
+	// The coalesced items are counted as popped
+	pq.countPops(len(group) - 1)
+
+	return oldest
+}
@@ -0,0 +1,149 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// update is a value describing an entity update
+type update struct {
+	entity  string
+	changes string
+}
+
+func updateKey(value interface{}) string {
+	return value.(update).entity
+}
+
+// mergeUpdates concatenates the updates changes
+func mergeUpdates(older, newer interface{}) interface{} {
+	return update{entity: older.(update).entity, changes: older.(update).changes + newer.(update).changes}
+}
+
+func TestNewPQueueWithOptions_invalid_coalescing(t *testing.T) {
+	_, err := NewPQueueWithOptions(MAXPQ, WithCoalescing(nil, mergeUpdates))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithCoalescing(updateKey, nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithCoalescing(updateKey, mergeUpdates), WithValueIndex(updateKey))
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+}
+
+func TestPQueueCoalescing_merges_oldest_to_newest(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithCoalescing(updateKey, mergeUpdates))
+	assert.Nil(t, err)
+
+	pqueue.Push(update{"a", "1"}, 1)
+	pqueue.Push(update{"b", "x"}, 5)
+	pqueue.Push(update{"a", "2"}, 3)
+	pqueue.Push(update{"a", "3"}, 2)
+	assert.Equal(t, pqueue.Size(), 4)
+
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, update{"b", "x"})
+	assert.Equal(t, priority, 5)
+	assert.Equal(t, pqueue.Size(), 3)
+
+	value, priority = pqueue.Pop()
+	assert.Equal(t, value, update{"a", "123"})
+	assert.Equal(t, priority, 3)
+	assert.Equal(t, pqueue.Size(), 0)
+	assertIndexConsistent(t, pqueue)
+
+	metrics := pqueue.MetricsSnapshot()
+	assert.Equal(t, metrics.Pops, uint64(4))
+	assert.Equal(t, metrics.Size, int64(0))
+}
+
+func TestPQueueCoalescing_single_key(t *testing.T) {
+	merges := 0
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithStableOrder(), WithCoalescing(
+		func(value interface{}) string { return "same" },
+		func(older, newer interface{}) interface{} {
+			merges++
+			return older.(string) + newer.(string)
+		},
+	))
+	assert.Nil(t, err)
+
+	var expected strings.Builder
+	for i := 0; i < 100; i++ {
+		pqueue.Push(fmt.Sprint(i%10), 100-i)
+		expected.WriteString(fmt.Sprint(i % 10))
+	}
+
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, expected.String())
+	assert.Equal(t, priority, 1)
+	assert.Equal(t, merges, 99)
+	assert.Equal(t, pqueue.Size(), 0)
+
+	value, _ = pqueue.Pop()
+	assert.Nil(t, value)
+}
+
+func TestPQueueCoalescing_removed_items_are_not_merged(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithCoalescing(updateKey, mergeUpdates))
+	assert.Nil(t, err)
+
+	for i := 1; i <= 5; i++ {
+		pqueue.Push(update{"a", fmt.Sprint(i)}, i)
+	}
+
+	assert.Equal(t, pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+		return priority == 2
+	}), 1)
+
+	pqueue.Edit(func(c *Cursor) {
+		for c.Next() {
+			if c.Priority() == 4 {
+				c.Remove()
+			}
+		}
+	})
+	assert.Equal(t, pqueue.Size(), 3)
+
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, update{"a", "135"})
+	assert.Equal(t, priority, 5)
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueCoalescing_pop_variants(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithCoalescing(updateKey, mergeUpdates))
+	assert.Nil(t, err)
+
+	pqueue.Push(update{"a", "1"}, 1)
+	pqueue.Push(update{"a", "2"}, 2)
+	value, priority, ok := pqueue.PopRelease()
+	assert.True(t, ok)
+	assert.Equal(t, value, update{"a", "12"})
+	assert.Equal(t, priority, 2)
+
+	pqueue.Push(update{"b", "1"}, 1)
+	pqueue.Push(update{"b", "2"}, 2)
+	value, priority, err = pqueue.WaitPop(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, update{"b", "12"})
+	assert.Equal(t, priority, 2)
+}
+
+func TestPQueueCoalescing_clone(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithCoalescing(updateKey, mergeUpdates))
+	assert.Nil(t, err)
+
+	pqueue.Push(update{"a", "1"}, 1)
+	pqueue.Push(update{"a", "2"}, 2)
+
+	clone := pqueue.Clone()
+	value, _ := clone.Pop()
+	assert.Equal(t, value, update{"a", "12"})
+	assert.Equal(t, pqueue.Size(), 2)
+}
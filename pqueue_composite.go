@@ -0,0 +1,96 @@
+package lane
+
+import "fmt"
+
+// WithSecondaryOrder makes the items of equal priority be ordered by
+// their secondary priority, see Push2, according to the provided pqtype
+// ordering type rather than the queue one: a MAXPQ queue pops the items
+// of a given priority lowest secondary priority first when it is MINPQ,
+// for instance.
+func WithSecondaryOrder(pqType PQType) PQueueOption {
+	return func(pq *PQueue) error {
+		if pqType != MAXPQ && pqType != MINPQ {
+			return fmt.Errorf("%w: unknown secondary ordering type %d", ErrInvalidOption, pqType)
+		}
+
+		pq.secondaryOrder, pq.secondaryOrdered = pqType, true
+		return nil
+	}
+}
+
+// Push2 pushes the value item into the priority queue with provided
+// composite priority: items are ordered by their primary priority, and
+// items of equal primary priority by their secondary priority, following
+// the queue ordering type unless a secondary order is set, see
+// WithSecondaryOrder. Items pushed with Push have a zero secondary
+// priority.
+func (pq *PQueue) Push2(value interface{}, primary, secondary int) error {
+	item := pq.newItem(value, int64(primary))
+	item.secondary = int64(secondary)
+
+	return pq.push(item)
+}
+
+// Pop2 pops and returns the highest/lowest priority item (depending on
+// whether you're using a MINPQ or MAXPQ) from the priority queue, along
+// with its primary and secondary priorities, see Push2.
+func (pq *PQueue) Pop2() (interface{}, int, int) {
+	if staged, ok := pq.popStaged(); ok {
+		pq.recordPopped(staged.value)
+		return staged.value, int(staged.priority), int(staged.secondary)
+	}
+
+	timing := pq.lockTimed()
+	pq.mergeStaged()
+	pq.dropExpired()
+
+	if !pq.headEligible() || !pq.takeToken() {
+		pq.unlockTimed(popLock, timing)
+		return nil, 0, 0
+	}
+
+	value, primary, secondary := pq.popHead()
+	pq.restage()
+
+	pq.unlockTimed(popLock, timing)
+	pq.recordPopped(value)
+
+	return value, int(primary), int(secondary)
+}
+
+// Head2 returns the highest/lowest priority item (depending on whether
+// you're using a MINPQ or MAXPQ) from the priority queue, along with its
+// primary and secondary priorities, see Push2.
+func (pq *PQueue) Head2() (interface{}, int, int) {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	if value, primary, secondary, ok := pq.stagedHead(); ok {
+		return value, int(primary), int(secondary)
+	}
+
+	pq.rlockHead()
+	defer pq.RUnlock()
+
+	if pq.elemsCount < 1 {
+		return nil, 0, 0
+	}
+
+	head := pq.items[1]
+
+	return head.value, int(head.priority), int(head.secondary)
+}
+
+// secondaryLess compares secondary priorities as the queue comparator
+// compares priorities, see WithSecondaryOrder.
+func (pq *PQueue) secondaryLess(a, b int64) bool {
+	switch {
+	case !pq.secondaryOrdered:
+		return pq.comparator(a, b)
+	case pq.secondaryOrder == MINPQ:
+		return min(a, b)
+	}
+
+	return max(a, b)
+}
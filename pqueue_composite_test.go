@@ -0,0 +1,176 @@
+package lane
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// popAll2 pops every item using Pop2
+func popAll2(pqueue *PQueue) []Item {
+	popped := []Item{}
+	for pqueue.Size() > 0 {
+		value, primary, secondary := pqueue.Pop2()
+		popped = append(popped, Item{Value: value, Priority: primary, Secondary: secondary})
+	}
+
+	return popped
+}
+
+func TestPQueuePush2_equal_primary_max(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push2("a", 1, 5)
+	pqueue.Push2("b", 2, 1)
+	pqueue.Push2("c", 1, 7)
+	pqueue.Push2("d", 2, 3)
+
+	assert.Equal(t, popAll2(pqueue), []Item{
+		{Value: "d", Priority: 2, Secondary: 3},
+		{Value: "b", Priority: 2, Secondary: 1},
+		{Value: "c", Priority: 1, Secondary: 7},
+		{Value: "a", Priority: 1, Secondary: 5},
+	})
+}
+
+func TestPQueuePush2_equal_primary_min(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	pqueue.Push2("a", 1, 5)
+	pqueue.Push2("b", 2, 1)
+	pqueue.Push2("c", 1, 7)
+	pqueue.Push2("d", 2, 3)
+
+	assert.Equal(t, popAll2(pqueue), []Item{
+		{Value: "a", Priority: 1, Secondary: 5},
+		{Value: "c", Priority: 1, Secondary: 7},
+		{Value: "b", Priority: 2, Secondary: 1},
+		{Value: "d", Priority: 2, Secondary: 3},
+	})
+}
+
+func TestPQueuePush2_secondary_order(t *testing.T) {
+	// Highest class first, earliest deadline first within a class
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithSecondaryOrder(MINPQ))
+	assert.Nil(t, err)
+
+	pqueue.Push2("late", 1, 300)
+	pqueue.Push2("urgent", 2, 200)
+	pqueue.Push2("early", 1, 100)
+	pqueue.Push2("critical", 2, 50)
+
+	value, primary, secondary := pqueue.Head2()
+	assert.Equal(t, value, "critical")
+	assert.Equal(t, primary, 2)
+	assert.Equal(t, secondary, 50)
+
+	assert.Equal(t, popAll2(pqueue), []Item{
+		{Value: "critical", Priority: 2, Secondary: 50},
+		{Value: "urgent", Priority: 2, Secondary: 200},
+		{Value: "early", Priority: 1, Secondary: 100},
+		{Value: "late", Priority: 1, Secondary: 300},
+	})
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithSecondaryOrder(PQType(42)))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueuePush2_fully_equal_items(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		pqueue.Push2(i, 3, 8)
+	}
+	pqueue.Push(5, 3)
+	pqueue.Push2(6, 3, 0)
+
+	for i := 0; i < 5; i++ {
+		value, primary, secondary := pqueue.Pop2()
+		assert.Equal(t, value, i)
+		assert.Equal(t, primary, 3)
+		assert.Equal(t, secondary, 8)
+	}
+
+	// Push pushes a zero secondary priority
+	assert.Equal(t, popAll2(pqueue), []Item{
+		{Value: 5, Priority: 3},
+		{Value: 6, Priority: 3},
+	})
+
+	value, primary, secondary := pqueue.Pop2()
+	assert.Nil(t, value)
+	assert.Equal(t, primary, 0)
+	assert.Equal(t, secondary, 0)
+}
+
+func TestPQueuePush2_items_carry_secondary(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	pqueue.Push2("a", 1, 2)
+	pqueue.Push2("b", 1, 1)
+	pqueue.Push("c", 0)
+
+	expected := []Item{
+		{Value: "c", Priority: 0},
+		{Value: "b", Priority: 1, Secondary: 1},
+		{Value: "a", Priority: 1, Secondary: 2},
+	}
+	assert.Equal(t, pqueue.Clone().Drain(), expected)
+
+	rebuilt, err := NewPQueueFromHeap(MINPQ, pqueue.RawItems())
+	assert.Nil(t, err)
+	assert.Equal(t, rebuilt.Drain(), expected)
+
+	_, err = NewPQueueFromSorted(MINPQ, []Item{expected[0], expected[2], expected[1]})
+	assert.True(t, errors.Is(err, ErrUnsorted))
+
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `{"value":"a","priority":1,"secondary":2}`)
+	assert.Contains(t, string(data), `{"value":"c","priority":0}`)
+
+	decoded := NewPQueue(MAXPQ)
+	assert.Nil(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, decoded.Drain(), expected)
+}
+
+func TestPQueuePush2_wal(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+
+	pqueue.Push2(1, 1, 2)
+	pqueue.Push2(2, 1, 3)
+	pqueue.Push2(3, 0, 9)
+	pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+		return priority + value.(int)%2
+	})
+
+	recovered, err := RecoverFromWAL(&log, decodeInt)
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.Drain(), []Item{
+		{Value: 1, Priority: 2, Secondary: 2},
+		{Value: 3, Priority: 1, Secondary: 9},
+		{Value: 2, Priority: 1, Secondary: 3},
+	})
+}
+
+func TestPQueuePush2_oplog(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithOpLog(&log))
+	assert.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		pqueue.Push2(i, i%3, i%5)
+	}
+	pqueue.Pop()
+	assert.Nil(t, pqueue.FlushOpLog())
+
+	replayed, err := ReplayOpLog(&log, intValues(20))
+	assert.Nil(t, err)
+	assert.Equal(t, replayed.Drain(), pqueue.Drain())
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithOpLog(&log), WithSecondaryOrder(MINPQ))
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+}
@@ -0,0 +1,112 @@
+package lane
+
+import (
+	"sync"
+	"time"
+)
+
+// LockStats holds statistics about the priority queue lock usage by an
+// operation, see WithContentionProfiling.
+type LockStats struct {
+	// Count is the count of lock acquisitions.
+	Count uint64
+	// TotalWait is the total time spent waiting to acquire the lock.
+	TotalWait time.Duration
+	// MaxWait is the longest time spent waiting to acquire the lock.
+	MaxWait time.Duration
+	// TotalHold is the total time the lock was held for.
+	TotalHold time.Duration
+	// MaxHold is the longest time the lock was held for.
+	MaxHold time.Duration
+}
+
+// lockOp identifies the operation a profiled lock is acquired for
+type lockOp int
+
+const (
+	pushLock lockOp = iota
+	popLock
+)
+
+// contentionProfile aggregates the lock timings of the profiled
+// operations under its own lock.
+type contentionProfile struct {
+	sync.Mutex
+	ops [2]LockStats
+}
+
+// lockTiming records when a profiled lock acquisition started, and when
+// it succeeded.
+type lockTiming struct {
+	start    time.Time
+	acquired time.Time
+}
+
+// WithContentionProfiling makes the queue measure how long Push and Pop
+// (including WaitPop) wait to acquire the queue lock, and hold it. The
+// measures are aggregated in the PushLock and PopLock statistics, see
+// Stats. Without it, no measure is taken at all.
+func WithContentionProfiling() PQueueOption {
+	return func(pq *PQueue) error {
+		pq.contention = &contentionProfile{}
+		return nil
+	}
+}
+
+// lockTimed acquires the write lock, measuring the acquisition when
+// profiling contention.
+func (pq *PQueue) lockTimed() lockTiming {
+	if pq.contention == nil {
+		pq.lock()
+		return lockTiming{}
+	}
+
+	start := time.Now()
+	pq.lock()
+
+	return lockTiming{start: start, acquired: time.Now()}
+}
+
+// unlockTimed releases the write lock acquired by lockTimed, and records
+// the lock timings for op when profiling contention.
+func (pq *PQueue) unlockTimed(op lockOp, timing lockTiming) {
+	if pq.contention == nil {
+		pq.unlock()
+		return
+	}
+
+	hold := time.Since(timing.acquired)
+	pq.unlock()
+
+	pq.contention.record(op, timing.acquired.Sub(timing.start), hold)
+}
+
+func (p *contentionProfile) record(op lockOp, wait, hold time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	stats := &p.ops[op]
+	stats.Count++
+	stats.TotalWait += wait
+	stats.TotalHold += hold
+
+	if wait > stats.MaxWait {
+		stats.MaxWait = wait
+	}
+
+	if hold > stats.MaxHold {
+		stats.MaxHold = hold
+	}
+}
+
+// snapshot returns the push and pop lock statistics
+func (p *contentionProfile) snapshot() (LockStats, LockStats) {
+	if p == nil {
+		return LockStats{}, LockStats{}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	return p.ops[pushLock], p.ops[popLock]
+}
@@ -0,0 +1,68 @@
+package lane
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueContentionProfiling_disabled(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+	pqueue.Pop()
+
+	stats := pqueue.Stats()
+	assert.Equal(t, stats.PushLock, LockStats{})
+	assert.Equal(t, stats.PopLock, LockStats{})
+}
+
+func TestPQueueContentionProfiling(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithContentionProfiling())
+	assert.Nil(t, err)
+
+	const workers = 4
+	const operations = 500
+
+	var previous PQueueStats
+	for round := 0; round < 3; round++ {
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < operations; i++ {
+					pqueue.Push(i, i)
+					runtime.Gosched()
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < operations; i++ {
+					pqueue.WaitPop(context.Background())
+					runtime.Gosched()
+				}
+			}()
+		}
+		wg.Wait()
+
+		stats := pqueue.Stats()
+		assert.Equal(t, stats.PushLock.Count, uint64((round+1)*workers*operations))
+		assert.GreaterOrEqual(t, stats.PopLock.Count, uint64((round+1)*workers*operations))
+
+		for _, lock := range [][2]LockStats{{previous.PushLock, stats.PushLock}, {previous.PopLock, stats.PopLock}} {
+			before, after := lock[0], lock[1]
+			assert.Greater(t, after.Count, before.Count)
+			assert.GreaterOrEqual(t, after.TotalWait, before.TotalWait)
+			assert.GreaterOrEqual(t, after.MaxWait, before.MaxWait)
+			assert.Greater(t, after.TotalHold, before.TotalHold)
+			assert.GreaterOrEqual(t, after.MaxHold, before.MaxHold)
+			assert.LessOrEqual(t, after.MaxHold, after.TotalHold)
+			assert.LessOrEqual(t, after.MaxWait, after.TotalWait)
+		}
+
+		previous = stats
+	}
+}
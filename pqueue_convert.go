@@ -0,0 +1,31 @@
+package lane
+
+// ConvertTo switches the priority queue to the pqtype ordering type, so
+// that a MINPQ queue pops its highest priority items first from then on,
+// or the other way around. The queued items are reordered in place, in
+// linear time, holding the write lock: the queue keeps serving with the
+// new ordering right away, and the item references, such as the ones
+// returned by PushRef, remain valid.
+//
+// Secondary priorities, see Push2, follow the new ordering unless set
+// otherwise with WithSecondaryOrder. Items of equal priorities keep
+// popping in push order when using the stable order option. Consumers
+// blocked in WaitPop are handed the items the new ordering makes
+// eligible, see SetPriorityFloor.
+func (pq *PQueue) ConvertTo(pqType PQType) {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+	pq.lazyInit()
+	if pq.pqType == pqType {
+		return
+	}
+
+	pq.logInt(opOrder, int(pqType))
+	pq.walOrder(pqType)
+
+	pq.pqType = pqType
+	pq.comparator = NewPQueue(pqType).comparator
+	pq.heapify()
+}
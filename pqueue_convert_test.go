@@ -0,0 +1,118 @@
+package lane
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueConvertTo_reverses_pop_order(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	priorities := rand.New(rand.NewSource(42)).Perm(1000)
+	for _, priority := range priorities {
+		pqueue.Push(priority, priority)
+	}
+
+	// Half of the items are popped in the original order
+	for i := 0; i < 200; i++ {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, i)
+	}
+
+	pqueue.ConvertTo(MAXPQ)
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+
+	for i := 999; i >= 600; i-- {
+		value, priority := pqueue.Pop()
+		assert.Equal(t, value, i)
+		assert.Equal(t, priority, i)
+	}
+
+	pqueue.ConvertTo(MINPQ)
+	pqueue.ConvertTo(MINPQ)
+	for i := 200; i < 600; i++ {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, i)
+	}
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueConvertTo_keeps_references_valid(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(func(value interface{}) string {
+		return fmt.Sprint(value)
+	}))
+	assert.Nil(t, err)
+
+	refs := make([]*ItemRef, 0, 100)
+	for i := 0; i < 100; i++ {
+		ref, err := pqueue.PushRef(i, i)
+		assert.Nil(t, err)
+		refs = append(refs, ref)
+	}
+
+	pqueue.ConvertTo(MINPQ)
+
+	for i, ref := range refs {
+		assert.True(t, pqueue.Fix(ref))
+
+		priority, ok := pqueue.PriorityOf(i)
+		assert.True(t, ok)
+		assert.Equal(t, priority, i)
+	}
+
+	for i := 0; i < 100; i++ {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, i)
+	}
+}
+
+func TestPQueueConvertTo_wakes_blocked_waiters(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.SetPriorityCeiling(5)
+	pqueue.Push(9, 9)
+	pqueue.Push(3, 3)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, err := pqueue.WaitPop(context.Background())
+		assert.Nil(t, err)
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	// The new head meets the ceiling
+	pqueue.ConvertTo(MINPQ)
+	assert.Equal(t, <-done, 3)
+}
+
+func TestPQueueConvertTo_recorded(t *testing.T) {
+	var oplog, wal bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithStableOrder(), WithOpLog(&oplog), WithWAL(&wal, encodeInt))
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		pqueue.Push(i, i%10)
+	}
+	pqueue.ConvertTo(MAXPQ)
+	pqueue.Pop()
+	assert.Nil(t, pqueue.FlushOpLog())
+
+	expected := pqueue.Clone().Drain()
+	sorted := sort.SliceIsSorted(expected, func(i, j int) bool {
+		return expected[i].Priority > expected[j].Priority
+	})
+	assert.True(t, sorted)
+
+	replayed, err := ReplayOpLog(&oplog, intValues(50))
+	assert.Nil(t, err)
+	assert.Equal(t, replayed.Drain(), expected)
+
+	recovered, err := RecoverFromWAL(&wal, decodeInt, WithStableOrder())
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.Drain(), expected)
+}
@@ -0,0 +1,9 @@
+//go:build !lanedebug
+
+package lane
+
+// copyGuard is empty unless the lanedebug build tag is set.
+type copyGuard struct{}
+
+// copyCheck is a no-op unless the lanedebug build tag is set.
+func (pq *PQueue) copyCheck() {}
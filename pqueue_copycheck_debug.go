@@ -0,0 +1,29 @@
+//go:build lanedebug
+
+package lane
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// copyGuard records the address of the queue it belongs to, so that
+// copies made by value can be detected when they are locked.
+type copyGuard struct {
+	self unsafe.Pointer
+}
+
+// copyCheck panics if the queue is a copy of another queue made by
+// value, as both would otherwise share, and corrupt, the same heap
+// backing array. It is called before acquiring the write lock, so
+// that the copy lock is left untouched.
+func (pq *PQueue) copyCheck() {
+	self := atomic.LoadPointer(&pq.guard.self)
+	if self == nil && atomic.CompareAndSwapPointer(&pq.guard.self, nil, unsafe.Pointer(pq)) {
+		return
+	}
+
+	if atomic.LoadPointer(&pq.guard.self) != unsafe.Pointer(pq) {
+		panic("lane: illegal use of a PQueue copied by value")
+	}
+}
@@ -0,0 +1,41 @@
+//go:build lanedebug
+
+package lane
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyByValue copies the queue without go vet noticing.
+func copyByValue(pqueue *PQueue) *PQueue {
+	copied := reflect.New(reflect.TypeOf(pqueue).Elem()).Elem()
+	copied.Set(reflect.ValueOf(pqueue).Elem())
+
+	return copied.Addr().Interface().(*PQueue)
+}
+
+func TestPQueueCopyCheck_panics_on_copy(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+
+	copied := copyByValue(pqueue)
+
+	assert.PanicsWithValue(t, "lane: illegal use of a PQueue copied by value", func() { copied.Push("b", 2) })
+	assert.PanicsWithValue(t, "lane: illegal use of a PQueue copied by value", func() { copied.Pop() })
+
+	assert.NotPanics(t, func() { pqueue.Push("c", 3) })
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, "c")
+}
+
+func TestPQueueCopyCheck_clone(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+
+	clone := pqueue.Clone()
+	assert.NotPanics(t, func() { clone.Push("b", 2) })
+	assert.NotPanics(t, func() { clone.Pop() })
+}
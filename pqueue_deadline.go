@@ -0,0 +1,149 @@
+package lane
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expiry holds the expired items whose hook is yet to be called, see
+// WithOnExpire.
+type expiry struct {
+	onExpire func(value interface{}, priority int)
+
+	// pending holds the expired items in expiry order. delivering is set
+	// while a goroutine calls the hook.
+	mu         sync.Mutex
+	pending    []Item
+	delivering bool
+}
+
+// WithOnExpire makes the queue call onExpire with the items discarded
+// because their deadline passed, see PushWithDeadline. onExpire can for
+// instance push them into a dead-letter queue.
+//
+// onExpire is called once the queue lock is released, so that it can
+// call the queue methods. It is called with one item at a time, in
+// expiry order, possibly by another goroutine than the one whose
+// operation discarded the item.
+func WithOnExpire(onExpire func(value interface{}, priority int)) PQueueOption {
+	return func(pq *PQueue) error {
+		if onExpire == nil {
+			return fmt.Errorf("%w: nil expiry hook", ErrInvalidOption)
+		}
+
+		pq.expiry = &expiry{onExpire: onExpire}
+		return nil
+	}
+}
+
+// PushWithDeadline pushes the value item into the priority queue with
+// provided priority, to be processed before deadline, read with the
+// queue clock, see WithClock. The deadline doesn't alter the item
+// ordering.
+//
+// Pop, PopRelease, WaitPop and Head discard the items whose deadline
+// passed as they reach the queue head, calling the WithOnExpire hook with
+// them: an expired item stays queued, and counted by Size, until then.
+// Other methods, such as Drain or PopWorst, ignore the deadlines, which
+// are kept by the queue JSON representation and write-ahead log, but not
+// recorded by WithOpLog.
+func (pq *PQueue) PushWithDeadline(value interface{}, priority int, deadline time.Time) error {
+	item := pq.newItem(value, int64(priority))
+	item.deadline = deadline.UnixNano()
+
+	return pq.push(item)
+}
+
+// pastDeadline reports whether the item has a deadline, and whether it
+// passed by now, in unix nanoseconds.
+func (i *item) pastDeadline(now int64) bool {
+	return i.deadline != 0 && i.deadline <= now
+}
+
+// headExpired reports whether the queue head deadline passed. The caller
+// must hold the lock.
+func (pq *PQueue) headExpired() bool {
+	return pq.elemsCount > 0 && pq.items[1].pastDeadline(pq.now().UnixNano())
+}
+
+// dropExpired discards the queue head items whose deadline passed. The
+// caller must hold the write lock.
+func (pq *PQueue) dropExpired() {
+	if pq.elemsCount < 1 || pq.items[1].deadline == 0 {
+		return
+	}
+
+	now := pq.now().UnixNano()
+	for pq.elemsCount > 0 && pq.items[1].pastDeadline(now) {
+		pq.expire(pq.removeAt(1))
+	}
+}
+
+// rlockHead acquires the read lock, after discarding the queue head items
+// whose deadline passed.
+func (pq *PQueue) rlockHead() {
+	pq.rlock()
+	if !pq.headExpired() {
+		return
+	}
+	pq.RUnlock()
+
+	pq.lock()
+	pq.dropExpired()
+	pq.unlock()
+
+	pq.rlock()
+}
+
+// expire counts the item, which isn't part of the heap, as expired and
+// records it for the expiry hook. The caller must hold the write lock.
+func (pq *PQueue) expire(item *item) {
+	atomic.AddUint64(&pq.expired, 1)
+
+	if e := pq.expiry; e != nil {
+		e.mu.Lock()
+		e.pending = append(e.pending, item.export())
+		e.mu.Unlock()
+	}
+
+	pq.release(item)
+}
+
+// notifyExpired calls the expiry hook with the recorded expired items,
+// unless another goroutine is already calling it. It must be called
+// without holding the lock.
+func (pq *PQueue) notifyExpired() {
+	e := pq.expiry
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.delivering {
+		return
+	}
+
+	e.delivering = true
+	defer func() { e.delivering = false }()
+
+	for len(e.pending) > 0 {
+		expired := e.pending[0]
+		e.pending[0] = Item{}
+		e.pending = e.pending[1:]
+
+		e.call(expired)
+	}
+}
+
+// call calls the expiry hook without holding the expiry lock. The caller
+// must hold it.
+func (e *expiry) call(expired Item) {
+	e.mu.Unlock()
+	defer e.mu.Lock()
+
+	e.onExpire(expired.Value, expired.Priority)
+}
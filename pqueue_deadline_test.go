@@ -0,0 +1,189 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expiredItems records the items the expiry hook is called with
+type expiredItems struct {
+	mu    sync.Mutex
+	items []Item
+}
+
+func (e *expiredItems) record(value interface{}, priority int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.items = append(e.items, Item{Value: value, Priority: priority})
+}
+
+func (e *expiredItems) snapshot() []Item {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]Item(nil), e.items...)
+}
+
+func newDeadlinePQueue(t *testing.T, clock *fakeClock, expired *expiredItems) *PQueue {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithClock(clock.Now), WithOnExpire(expired.record))
+	assert.Nil(t, err)
+
+	return pqueue
+}
+
+func TestNewPQueueWithOptions_invalid_expiry_hook(t *testing.T) {
+	_, err := NewPQueueWithOptions(MAXPQ, WithOnExpire(nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueuePushWithDeadline_keeps_priority_order(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDeadlinePQueue(t, clock, &expiredItems{})
+
+	pqueue.PushWithDeadline("late", 3, clock.Now().Add(time.Hour))
+	pqueue.PushWithDeadline("soon", 1, clock.Now().Add(time.Second))
+	pqueue.Push("none", 2)
+
+	for _, expected := range []string{"late", "none", "soon"} {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, expected)
+	}
+	assert.Equal(t, pqueue.Stats().Expired, uint64(0))
+}
+
+func TestPQueuePushWithDeadline_expired_head(t *testing.T) {
+	clock := newFakeClock()
+	expired := &expiredItems{}
+	pqueue := newDeadlinePQueue(t, clock, expired)
+
+	pqueue.PushWithDeadline("urgent", 5, clock.Now().Add(time.Second))
+	pqueue.PushWithDeadline("eventually", 1, clock.Now().Add(time.Hour))
+
+	value, priority := pqueue.Head()
+	assert.Equal(t, value, "urgent")
+	assert.Equal(t, priority, 5)
+
+	clock.Advance(time.Second)
+
+	value, priority = pqueue.Head()
+	assert.Equal(t, value, "eventually")
+	assert.Equal(t, priority, 1)
+	assert.Equal(t, pqueue.Size(), 1)
+	assert.Equal(t, expired.snapshot(), []Item{{Value: "urgent", Priority: 5}})
+
+	clock.Advance(time.Hour)
+
+	value, _ = pqueue.Pop()
+	assert.Nil(t, value)
+	assert.Equal(t, pqueue.Size(), 0)
+	assert.Equal(t, pqueue.Stats().Expired, uint64(2))
+	assert.Equal(t, len(expired.snapshot()), 2)
+}
+
+func TestPQueuePushWithDeadline_expired_mid_heap(t *testing.T) {
+	clock := newFakeClock()
+	expired := &expiredItems{}
+	pqueue := newDeadlinePQueue(t, clock, expired)
+
+	for i := 10; i < 20; i++ {
+		if i != 15 {
+			pqueue.Push(i, i)
+		}
+	}
+	pqueue.PushWithDeadline("buried", 15, clock.Now().Add(time.Minute))
+	pqueue.PushWithDeadline("alive", 12, clock.Now().Add(time.Hour))
+
+	clock.Advance(time.Minute)
+
+	// The buried item is only discarded once it reaches the head
+	var popped []interface{}
+	for i := 0; i < 4; i++ {
+		value, _ := pqueue.Pop()
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{19, 18, 17, 16})
+	assert.Equal(t, pqueue.Stats().Expired, uint64(0))
+
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, 14)
+	assert.Equal(t, priority, 14)
+	assert.Equal(t, expired.snapshot(), []Item{{Value: "buried", Priority: 15}})
+	assert.Equal(t, pqueue.Stats().Expired, uint64(1))
+
+	values := []interface{}{}
+	for pqueue.Size() > 0 {
+		value, _ := pqueue.Pop()
+		values = append(values, value)
+	}
+	assert.Equal(t, len(values), 5)
+	assert.Contains(t, values, "alive")
+	assert.NotContains(t, values, "buried")
+}
+
+func TestPQueuePushWithDeadline_wait_pop(t *testing.T) {
+	clock := newFakeClock()
+	expired := &expiredItems{}
+	pqueue := newDeadlinePQueue(t, clock, expired)
+
+	pqueue.PushWithDeadline("stale", 2, clock.Now().Add(time.Second))
+	clock.Advance(time.Second)
+
+	popped := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		popped <- value
+	}()
+
+	waitForWaiters(t, pqueue, 1)
+
+	// Items already expired are not handed over
+	pqueue.PushWithDeadline("expired", 3, clock.Now().Add(-time.Second))
+	pqueue.Push("fresh", 1)
+
+	assert.Equal(t, <-popped, "fresh")
+	assert.Equal(t, expired.snapshot(), []Item{{Value: "stale", Priority: 2}, {Value: "expired", Priority: 3}})
+	assert.Equal(t, pqueue.Stats().Expired, uint64(2))
+}
+
+func TestPQueuePushWithDeadline_hook_may_call_queues(t *testing.T) {
+	clock := newFakeClock()
+	deadLetters := NewPQueue(MAXPQ)
+
+	var pqueue *PQueue
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithClock(clock.Now), WithOnExpire(func(value interface{}, priority int) {
+		deadLetters.Push(value, priority)
+		pqueue.Size()
+	}))
+	assert.Nil(t, err)
+
+	pqueue.PushWithDeadline("a", 1, clock.Now().Add(time.Second))
+	pqueue.PushWithDeadline("b", 2, clock.Now().Add(time.Second))
+	clock.Advance(time.Second)
+
+	value, _ := pqueue.Pop()
+	assert.Nil(t, value)
+
+	assert.Equal(t, deadLetters.Size(), 2)
+	value, _ = deadLetters.Pop()
+	assert.Equal(t, value, "b")
+}
+
+func TestPQueuePushWithDeadline_clone_keeps_deadlines(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDeadlinePQueue(t, clock, &expiredItems{})
+
+	pqueue.PushWithDeadline("a", 1, clock.Now().Add(time.Second))
+	clone := pqueue.Clone()
+	clock.Advance(time.Second)
+
+	value, _ := clone.Pop()
+	assert.Nil(t, value)
+	assert.Equal(t, clone.Stats().Expired, uint64(1))
+	assert.Equal(t, pqueue.Size(), 1)
+}
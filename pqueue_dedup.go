@@ -0,0 +1,174 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate is the error returned when pushing a value whose key
+// was popped within the recent deduplication window.
+var ErrDuplicate = errors.New("lane: value was recently popped")
+
+// defaultRecentDedupCapacity is the default count of recently popped
+// keys remembered by the deduplication window.
+const defaultRecentDedupCapacity = 1024
+
+// recentDedup remembers the keys of the recently popped values, under
+// its own lock so that it doesn't extend the queue critical sections.
+type recentDedup struct {
+	sync.Mutex
+	window time.Duration
+	key    func(value interface{}) string
+
+	// ring holds the recently popped keys in pop order, from its
+	// head, and popped maps each of them to its latest pop record.
+	// Records are numbered, as a key may be popped several times at
+	// the same time with a coarse clock.
+	ring   []recentKey
+	head   int
+	count  int
+	seq    uint64
+	popped map[string]recentKey
+}
+
+type recentKey struct {
+	key string
+	at  time.Time
+	seq uint64
+}
+
+// WithRecentDedup makes Push reject, returning ErrDuplicate, the values
+// whose key, as returned by keyFn, is the one of a value popped less
+// than window ago. Up to 1024 recently popped keys are remembered, see
+// WithRecentDedupCapacity.
+func WithRecentDedup(window time.Duration, keyFn func(value interface{}) string) PQueueOption {
+	return func(pq *PQueue) error {
+		if window <= 0 {
+			return fmt.Errorf("%w: dedup window must be positive, got %s", ErrInvalidOption, window)
+		}
+
+		if keyFn == nil {
+			return fmt.Errorf("%w: nil dedup key function", ErrInvalidOption)
+		}
+
+		capacity := defaultRecentDedupCapacity
+		if pq.dedup != nil {
+			capacity = len(pq.dedup.ring)
+		}
+
+		pq.dedup = &recentDedup{
+			window: window,
+			key:    keyFn,
+			ring:   make([]recentKey, capacity),
+			popped: make(map[string]recentKey),
+		}
+
+		return nil
+	}
+}
+
+// WithRecentDedupCapacity sets the count of recently popped keys the
+// deduplication window remembers, the oldest keys being forgotten
+// first. It must be set after WithRecentDedup.
+func WithRecentDedupCapacity(n int) PQueueOption {
+	return func(pq *PQueue) error {
+		if pq.dedup == nil {
+			return fmt.Errorf("%w: dedup capacity requires WithRecentDedup", ErrIncompatibleOptions)
+		}
+
+		if n < 1 {
+			return fmt.Errorf("%w: dedup capacity must be positive, got %d", ErrInvalidOption, n)
+		}
+
+		pq.dedup.ring = make([]recentKey, n)
+		return nil
+	}
+}
+
+// checkRecent returns ErrDuplicate if the value was recently popped
+func (pq *PQueue) checkRecent(value interface{}) error {
+	if pq.dedup == nil {
+		return nil
+	}
+
+	if pq.dedup.recent(pq.dedup.key(value), pq.now()) {
+		return pq.lockedError("push", ErrDuplicate)
+	}
+
+	return nil
+}
+
+// recordPopped counts the popped value, and remembers its key
+func (pq *PQueue) recordPopped(value interface{}) {
+	pq.countPops(1)
+	if pq.dedup == nil {
+		return
+	}
+
+	pq.dedup.record(pq.dedup.key(value), pq.now())
+}
+
+// recordDrained counts the drained items, and remembers their values
+// keys
+func (pq *PQueue) recordDrained(items []Item) {
+	pq.countPops(len(items))
+	if pq.dedup == nil {
+		return
+	}
+
+	for _, item := range items {
+		pq.dedup.record(pq.dedup.key(item.Value), pq.now())
+	}
+}
+
+// recent reports whether the key was popped within the window
+func (d *recentDedup) recent(key string, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	d.expire(now)
+
+	latest, ok := d.popped[key]
+
+	return ok && now.Sub(latest.at) < d.window
+}
+
+// record remembers the key was popped at the provided time
+func (d *recentDedup) record(key string, now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.expire(now)
+
+	if d.count == len(d.ring) {
+		d.forget()
+	}
+
+	d.seq++
+	record := recentKey{key: key, at: now, seq: d.seq}
+	d.ring[(d.head+d.count)%len(d.ring)] = record
+	d.count++
+	d.popped[key] = record
+}
+
+// expire forgets the keys popped out of the window. The caller must
+// hold the lock.
+func (d *recentDedup) expire(now time.Time) {
+	for d.count > 0 && now.Sub(d.ring[d.head].at) >= d.window {
+		d.forget()
+	}
+}
+
+// forget forgets the oldest key. The caller must hold the lock.
+func (d *recentDedup) forget() {
+	oldest := d.ring[d.head]
+	if d.popped[oldest.key].seq == oldest.seq {
+		delete(d.popped, oldest.key)
+	}
+
+	d.ring[d.head] = recentKey{}
+	d.head = (d.head + 1) % len(d.ring)
+	d.count--
+}
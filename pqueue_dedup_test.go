@@ -0,0 +1,173 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func stringKey(value interface{}) string {
+	return fmt.Sprint(value)
+}
+
+func newDedupPQueue(t *testing.T, clock *fakeClock, options ...PQueueOption) *PQueue {
+	options = append([]PQueueOption{WithClock(clock.Now), WithRecentDedup(time.Second, stringKey)}, options...)
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, options...)
+	assert.Nil(t, err)
+
+	return pqueue
+}
+
+func TestNewPQueueWithOptions_invalid_dedup(t *testing.T) {
+	_, err := NewPQueueWithOptions(MAXPQ, WithRecentDedup(0, stringKey))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithRecentDedup(time.Second, nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithRecentDedupCapacity(8))
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithRecentDedup(time.Second, stringKey), WithRecentDedupCapacity(0))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithClock(nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueueRecentDedup_window_edge(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDedupPQueue(t, clock)
+
+	assert.Nil(t, pqueue.Push("job", 1))
+	// Values are only deduplicated once popped
+	assert.Nil(t, pqueue.Push("job", 1))
+
+	pqueue.Pop()
+	pqueue.Pop()
+
+	clock.Advance(time.Second - time.Nanosecond)
+	err := pqueue.Push("job", 1)
+	assert.True(t, errors.Is(err, ErrDuplicate))
+	assert.Equal(t, err.Error(), "lane: push on max priority queue (size 0): value was recently popped")
+	assert.Nil(t, pqueue.Push("other", 1))
+
+	clock.Advance(time.Nanosecond)
+	assert.Nil(t, pqueue.Push("job", 1))
+	assert.Equal(t, pqueue.Size(), 2)
+}
+
+func TestPQueueRecentDedup_latest_pop_counts(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDedupPQueue(t, clock)
+
+	pqueue.Push("job", 1)
+	pqueue.Pop()
+
+	clock.Advance(time.Second)
+	pqueue.Push("job", 1)
+	pqueue.Pop()
+
+	clock.Advance(time.Second / 2)
+	assert.True(t, errors.Is(pqueue.Push("job", 1), ErrDuplicate))
+}
+
+func TestPQueueRecentDedup_pop_paths(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDedupPQueue(t, clock, WithStableOrder())
+
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		pqueue.Push(value, 1)
+	}
+	pqueue.Push("f", 0)
+	pqueue.Push("g", -1)
+
+	pqueue.Pop()
+	pqueue.View(1, 1).Pop()
+	pqueue.PopPriorityGroup()
+	pqueue.Drain()
+	pqueue.Push("h", 1)
+	pqueue.DrainFunc(func(interface{}, int) {})
+
+	for _, value := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		assert.True(t, errors.Is(pqueue.Push(value, 1), ErrDuplicate), value)
+	}
+}
+
+func TestPQueueRecentDedup_bounded_capacity(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDedupPQueue(t, clock, WithRecentDedupCapacity(2))
+
+	for _, value := range []string{"a", "b", "c"} {
+		pqueue.Push(value, 1)
+		pqueue.Pop()
+	}
+
+	assert.Equal(t, len(pqueue.dedup.popped), 2)
+
+	// The oldest key was forgotten
+	assert.Nil(t, pqueue.Push("a", 1))
+	assert.True(t, errors.Is(pqueue.Push("b", 1), ErrDuplicate))
+	assert.True(t, errors.Is(pqueue.Push("c", 1), ErrDuplicate))
+}
+
+func TestPQueueRecentDedup_keeps_keys_popped_again_at_the_same_time(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDedupPQueue(t, clock, WithRecentDedupCapacity(2))
+
+	// a is popped twice without the clock moving, the older record
+	// being forgotten to make room for b.
+	pqueue.Push("a", 1)
+	pqueue.Push("a", 1)
+	pqueue.Pop()
+	pqueue.Pop()
+	pqueue.Push("b", 1)
+	pqueue.Pop()
+
+	assert.True(t, errors.Is(pqueue.Push("a", 1), ErrDuplicate))
+	assert.True(t, errors.Is(pqueue.Push("b", 1), ErrDuplicate))
+}
+
+func TestPQueueRecentDedup_expires_keys(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newDedupPQueue(t, clock)
+
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+		pqueue.Pop()
+	}
+	assert.Equal(t, len(pqueue.dedup.popped), 100)
+
+	clock.Advance(time.Second)
+	pqueue.Push("a", 1)
+	assert.Equal(t, len(pqueue.dedup.popped), 0)
+}
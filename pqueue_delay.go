@@ -0,0 +1,354 @@
+package lane
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DelayedItem is a value stored in a delay queue along with the time it
+// becomes ready to be popped, and the count of times it was requeued.
+type DelayedItem struct {
+	Value   interface{}
+	ReadyAt time.Time
+	Attempt int
+}
+
+// DelayQueue is a queue whose items can only be popped once their ready
+// time has come, in ready time order. Items sharing a ready time are
+// popped in push order. It is synchronized and is safe for concurrent
+// operations.
+//
+// Ready times are turned into monotonic deadlines when pushed, so that
+// wall clock adjustments don't reorder the items nor change when they
+// are ready, see WithMonotonicClock.
+type DelayQueue struct {
+	pq *PQueue
+
+	// epoch is the origin of the monotonic deadlines, unless the queue
+	// uses a monotonic clock.
+	epoch time.Time
+
+	// randomMu serializes the use of the retry policies random
+	// generators.
+	randomMu sync.Mutex
+
+	// pushed is closed, and reset, when an item is pushed, waking the
+	// blocked Take callers up. It is guarded by pushedMu.
+	pushedMu sync.Mutex
+	pushed   chan struct{}
+}
+
+// NewDelayQueue creates a new delay queue, backed by a min priority
+// queue configured with the provided options. The queue uses the clock
+// set by WithClock to tell whether items are ready.
+//
+// The backing queue values are DelayedItem values: the options value
+// functions, such as the WithSizeEstimator one, are called with them.
+func NewDelayQueue(options ...PQueueOption) (*DelayQueue, error) {
+	options = append([]PQueueOption{WithStableOrder()}, options...)
+
+	pq, err := NewPQueueWithOptions(MINPQ, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DelayQueue{pq: pq, epoch: pq.now()}, nil
+}
+
+// WithMonotonicClock sets the function delay queues use to tell the time
+// elapsed since an arbitrary origin, which must never go backwards, see
+// DelayQueue. By default, delay queues use the monotonic clock reading
+// of the times returned by the queue clock, see WithClock, and fall back
+// to their wall clock reading if they have none.
+func WithMonotonicClock(elapsed func() time.Duration) PQueueOption {
+	return func(pq *PQueue) error {
+		if elapsed == nil {
+			return fmt.Errorf("%w: nil monotonic clock", ErrInvalidOption)
+		}
+
+		pq.monotonic = elapsed
+		return nil
+	}
+}
+
+// Push the value item into the delay queue, ready to be popped at
+// readyAt.
+func (dq *DelayQueue) Push(value interface{}, readyAt time.Time) error {
+	now := dq.pq.now()
+	return dq.push(DelayedItem{Value: value, ReadyAt: readyAt}, now, readyAt.Sub(now))
+}
+
+// PushAfter pushes the value item into the delay queue, ready to be
+// popped once delay has elapsed.
+func (dq *DelayQueue) PushAfter(value interface{}, delay time.Duration) error {
+	now := dq.pq.now()
+	return dq.push(DelayedItem{Value: value, ReadyAt: now.Add(delay)}, now, delay)
+}
+
+// push pushes the delayed item, ready once delay has elapsed from now
+func (dq *DelayQueue) push(delayed DelayedItem, now time.Time, delay time.Duration) error {
+	elapsed := int64(dq.elapsed(now))
+
+	// Saturate the deadline rather than wrapping around
+	deadline := elapsed + int64(delay)
+	switch {
+	case delay > 0 && deadline < elapsed:
+		deadline = math.MaxInt64
+	case delay < 0 && deadline > elapsed:
+		deadline = math.MinInt64
+	}
+
+	if err := dq.pq.Push64(delayed, deadline); err != nil {
+		return err
+	}
+
+	dq.pushedMu.Lock()
+	wakeBlocked(&dq.pushed)
+	dq.pushedMu.Unlock()
+
+	return nil
+}
+
+// elapsed returns the monotonic time elapsed since the queue epoch, now
+// being the current time, see WithMonotonicClock.
+func (dq *DelayQueue) elapsed(now time.Time) time.Duration {
+	if dq.pq.monotonic != nil {
+		return dq.pq.monotonic()
+	}
+
+	return now.Sub(dq.epoch)
+}
+
+// Pop removes and returns the item whose ready time is the earliest, if
+// it has come. The boolean is false if no item is ready.
+func (dq *DelayQueue) Pop() (DelayedItem, bool) {
+	pq := dq.pq
+	now := int64(dq.elapsed(pq.now()))
+
+	pq.lock()
+	pq.mergeStaged()
+
+	if pq.elemsCount < 1 || pq.items[1].priority > now {
+		pq.unlock()
+		return DelayedItem{}, false
+	}
+
+	head := pq.removeAt(1)
+	value := head.value
+	pq.release(head)
+	pq.unlock()
+
+	pq.recordPopped(value)
+
+	return value.(DelayedItem), true
+}
+
+// Take removes and returns the item whose ready time is the earliest,
+// blocking until its ready time has come, or the context is done. Items
+// pushed meanwhile with an earlier ready time are taken first.
+func (dq *DelayQueue) Take(ctx context.Context) (DelayedItem, error) {
+	for {
+		// The channel is taken before looking at the queue, so that no
+		// push goes unnoticed.
+		dq.pushedMu.Lock()
+		if dq.pushed == nil {
+			dq.pushed = make(chan struct{})
+		}
+		pushed := dq.pushed
+		dq.pushedMu.Unlock()
+
+		if item, ok := dq.Pop(); ok {
+			return item, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return DelayedItem{}, dq.pq.lockedError("take", err)
+		}
+
+		var timer *time.Timer
+		var ready <-chan time.Time
+		if in, ok := dq.HeadIn(); ok {
+			timer = time.NewTimer(in)
+			ready = timer.C
+		}
+
+		select {
+		case <-pushed:
+		case <-ready:
+		case <-ctx.Done():
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Next returns the earliest ready time of the queued items. The boolean
+// is false if the queue is empty.
+func (dq *DelayQueue) Next() (time.Time, bool) {
+	head, _ := dq.pq.Head()
+	if head == nil {
+		return time.Time{}, false
+	}
+
+	return head.(DelayedItem).ReadyAt, true
+}
+
+// HeadIn returns how long until the earliest ready item of the queue is
+// ready, zero if it already is. The boolean is false if the queue is
+// empty.
+func (dq *DelayQueue) HeadIn() (time.Duration, bool) {
+	now := dq.elapsed(dq.pq.now())
+
+	head, deadline := dq.pq.Head64()
+	if head == nil {
+		return 0, false
+	}
+
+	if in := time.Duration(deadline) - now; in > 0 {
+		return in, true
+	}
+
+	return 0, true
+}
+
+// PopDue removes and returns every ready item, in ready time order
+func (dq *DelayQueue) PopDue() []DelayedItem {
+	pq := dq.pq
+	now := int64(dq.elapsed(pq.now()))
+
+	pq.lock()
+	pq.mergeStaged()
+
+	var due []DelayedItem
+	for pq.elemsCount > 0 && pq.items[1].priority <= now {
+		head := pq.removeAt(1)
+		due = append(due, head.value.(DelayedItem))
+		pq.release(head)
+	}
+	pq.unlock()
+
+	for _, item := range due {
+		pq.recordPopped(item)
+	}
+
+	return due
+}
+
+// Size returns the count of queued items, ready or not
+func (dq *DelayQueue) Size() int {
+	return dq.pq.Size()
+}
+
+// RetryPolicy describes how items failing to be processed are retried,
+// see DelayQueue.Requeue.
+type RetryPolicy struct {
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// Multiplier is the factor the delay grows by at each retry.
+	// Values below 1 are treated as 1.
+	Multiplier float64
+	// MaxDelay caps the delay between two retries, if positive.
+	MaxDelay time.Duration
+	// MaxAttempts is the count of retries an item gets before giving
+	// up on it, zero meaning unlimited retries.
+	MaxAttempts int
+	// Jitter randomizes the delays by up to the provided fraction of
+	// them, in both directions. It must be between 0 and 1.
+	Jitter float64
+	// Rand is the source of the jitter randomness, the math/rand
+	// default source if nil. Requeue serializes its use.
+	Rand *rand.Rand
+	// DeadLetter, if not nil, is called with the items given up on.
+	DeadLetter func(item DelayedItem)
+}
+
+// Backoff returns the delay before the provided retry attempt, the first
+// retry being attempt 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	// A zero base delay isn't grown, as a huge attempt count would make
+	// it zero times infinity.
+	delay := float64(p.BaseDelay)
+	if delay > 0 {
+		delay *= math.Pow(math.Max(p.Multiplier, 1), float64(attempt-1))
+	}
+
+	// The delay is capped before being jittered, which would otherwise
+	// subtract infinities
+	maxDelay := float64(math.MaxInt64)
+	if p.MaxDelay > 0 {
+		maxDelay = float64(p.MaxDelay)
+	}
+	delay = math.Min(delay, maxDelay)
+
+	if p.Jitter > 0 {
+		random := rand.Float64
+		if p.Rand != nil {
+			random = p.Rand.Float64
+		}
+
+		delay += delay * p.Jitter * (2*random() - 1)
+	}
+
+	// Guard against overflows of huge attempt counts
+	if delay >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(delay)
+}
+
+// validate returns an error wrapping ErrInvalidOption if the policy
+// can't compute delays.
+func (p RetryPolicy) validate() error {
+	if !(p.Jitter >= 0 && p.Jitter <= 1) {
+		return fmt.Errorf("%w: retry policy jitter must be between 0 and 1, got %v", ErrInvalidOption, p.Jitter)
+	}
+
+	if math.IsNaN(p.Multiplier) {
+		return fmt.Errorf("%w: retry policy multiplier is NaN", ErrInvalidOption)
+	}
+
+	return nil
+}
+
+// Requeue pushes the item, usually a popped item whose processing failed,
+// back into the delay queue with its attempt count incremented, ready
+// after the delay the retry policy sets for that attempt, and reports
+// whether it was. Once the item used up its attempts, it is handed to
+// the policy dead letter function instead, and false is returned. An
+// error wrapping ErrInvalidOption is returned if the policy is invalid,
+// and the queue error if it rejects the item.
+func (dq *DelayQueue) Requeue(item DelayedItem, policy RetryPolicy) (bool, error) {
+	if err := policy.validate(); err != nil {
+		return false, err
+	}
+
+	item.Attempt++
+	if policy.MaxAttempts > 0 && item.Attempt > policy.MaxAttempts {
+		item.Attempt--
+		if policy.DeadLetter != nil {
+			policy.DeadLetter(item)
+		}
+
+		return false, nil
+	}
+
+	dq.randomMu.Lock()
+	delay := policy.Backoff(item.Attempt)
+	dq.randomMu.Unlock()
+
+	now := dq.pq.now()
+	item.ReadyAt = now.Add(delay)
+
+	if err := dq.push(item, now, delay); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
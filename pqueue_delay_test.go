@@ -0,0 +1,429 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDelayQueue(t *testing.T, clock *fakeClock, options ...PQueueOption) *DelayQueue {
+	options = append([]PQueueOption{WithClock(clock.Now)}, options...)
+
+	dq, err := NewDelayQueue(options...)
+	assert.Nil(t, err)
+
+	return dq
+}
+
+func TestNewDelayQueue_invalid_options(t *testing.T) {
+	dq, err := NewDelayQueue(WithMaxItems(0))
+	assert.Nil(t, dq)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestDelayQueuePop_waits_for_ready_time(t *testing.T) {
+	clock := newFakeClock()
+	dq := newTestDelayQueue(t, clock)
+
+	assert.Nil(t, dq.PushAfter("2", 2*time.Second))
+	assert.Nil(t, dq.PushAfter("1", time.Second))
+	assert.Equal(t, dq.Size(), 2)
+
+	_, ok := dq.Pop()
+	assert.False(t, ok)
+
+	next, ok := dq.Next()
+	assert.True(t, ok)
+	assert.Equal(t, next, clock.Now().Add(time.Second))
+
+	clock.Advance(time.Second)
+	item, ok := dq.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, item, DelayedItem{Value: "1", ReadyAt: next})
+
+	_, ok = dq.Pop()
+	assert.False(t, ok)
+
+	clock.Advance(time.Hour)
+	item, ok = dq.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, item.Value, "2")
+
+	_, ok = dq.Next()
+	assert.False(t, ok)
+	assert.Equal(t, dq.Size(), 0)
+}
+
+func TestDelayQueuePop_same_ready_time_in_push_order(t *testing.T) {
+	clock := newFakeClock()
+	dq := newTestDelayQueue(t, clock)
+
+	for i := 0; i < 10; i++ {
+		dq.Push(i, clock.Now())
+	}
+
+	for i := 0; i < 10; i++ {
+		item, ok := dq.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, item.Value, i)
+	}
+}
+
+// steppedClock is a manually advanced clock whose wall time can be
+// stepped independently from its monotonic time.
+type steppedClock struct {
+	fakeClock
+	elapsed time.Duration
+}
+
+func (c *steppedClock) Elapsed() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.elapsed
+}
+
+// Advance advances both the wall and monotonic times
+func (c *steppedClock) Advance(d time.Duration) {
+	c.fakeClock.Advance(d)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.elapsed += d
+}
+
+// StepWall steps the wall time only, as a clock adjustment does
+func (c *steppedClock) StepWall(d time.Duration) {
+	c.fakeClock.Advance(d)
+}
+
+func newSteppedDelayQueue(t *testing.T) (*DelayQueue, *steppedClock) {
+	clock := &steppedClock{fakeClock: fakeClock{now: newFakeClock().Now()}}
+
+	dq, err := NewDelayQueue(WithClock(clock.Now), WithMonotonicClock(clock.Elapsed))
+	assert.Nil(t, err)
+
+	return dq, clock
+}
+
+// dueValues returns the values of the delayed items
+func dueValues(items []DelayedItem) []interface{} {
+	values := []interface{}{}
+	for _, item := range items {
+		values = append(values, item.Value)
+	}
+
+	return values
+}
+
+func TestDelayQueuePushAfter_ignores_wall_clock_steps(t *testing.T) {
+	dq, clock := newSteppedDelayQueue(t)
+
+	dq.PushAfter("a", 3*time.Second)
+	clock.StepWall(-time.Hour)
+	dq.PushAfter("b", 2*time.Second)
+	clock.StepWall(2 * time.Hour)
+	dq.PushAfter("c", time.Second)
+
+	// Stepping the wall clock forward doesn't make items ready
+	assert.Nil(t, dq.PopDue())
+	in, ok := dq.HeadIn()
+	assert.True(t, ok)
+	assert.Equal(t, in, time.Second)
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, dueValues(dq.PopDue()), []interface{}{"c", "b"})
+
+	in, ok = dq.HeadIn()
+	assert.True(t, ok)
+	assert.Equal(t, in, time.Second)
+
+	// Stepping it backward doesn't delay them either
+	clock.StepWall(-2 * time.Hour)
+	clock.Advance(time.Minute)
+	in, ok = dq.HeadIn()
+	assert.True(t, ok)
+	assert.Equal(t, in, time.Duration(0))
+
+	item, ok := dq.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, item.Value, "a")
+
+	_, ok = dq.HeadIn()
+	assert.False(t, ok)
+	assert.Nil(t, dq.PopDue())
+}
+
+func TestDelayQueuePush_ready_time_anchored_when_pushed(t *testing.T) {
+	dq, clock := newSteppedDelayQueue(t)
+
+	dq.Push("a", clock.Now().Add(2*time.Second))
+	clock.StepWall(time.Hour)
+	dq.Push("b", clock.Now().Add(time.Second))
+
+	clock.Advance(time.Second)
+	assert.Equal(t, dueValues(dq.PopDue()), []interface{}{"b"})
+
+	clock.Advance(time.Second)
+	assert.Equal(t, dueValues(dq.PopDue()), []interface{}{"a"})
+
+	// Overflowing deadlines saturate
+	dq.PushAfter("never", time.Duration(math.MaxInt64))
+	dq.Push("long ago", time.Time{})
+	assert.Equal(t, dueValues(dq.PopDue()), []interface{}{"long ago"})
+	in, _ := dq.HeadIn()
+	assert.True(t, in > 100*365*24*time.Hour)
+
+	_, err := NewDelayQueue(WithMonotonicClock(nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestDelayQueuePopDue_real_clock(t *testing.T) {
+	dq, err := NewDelayQueue()
+	assert.Nil(t, err)
+
+	dq.PushAfter("later", time.Hour)
+	dq.PushAfter("now", 0)
+	dq.PushAfter("past", -time.Second)
+
+	assert.Equal(t, dueValues(dq.PopDue()), []interface{}{"past", "now"})
+
+	in, ok := dq.HeadIn()
+	assert.True(t, ok)
+	assert.True(t, in > 59*time.Minute && in <= time.Hour)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, Multiplier: 2, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, policy.Backoff(1), time.Second)
+	assert.Equal(t, policy.Backoff(2), 2*time.Second)
+	assert.Equal(t, policy.Backoff(3), 4*time.Second)
+	assert.Equal(t, policy.Backoff(4), 8*time.Second)
+	assert.Equal(t, policy.Backoff(5), 10*time.Second)
+
+	policy.MaxDelay = 0
+	assert.Equal(t, policy.Backoff(1000), time.Duration(1<<63-1))
+
+	// Multipliers below 1 keep the delay constant
+	policy.Multiplier = 0
+	assert.Equal(t, policy.Backoff(5), time.Second)
+
+	// A zero base delay stays zero, however many attempts were made
+	policy = RetryPolicy{Multiplier: 2}
+	assert.Equal(t, policy.Backoff(2000), time.Duration(0))
+
+	// Huge delays are capped before being jittered
+	policy = RetryPolicy{BaseDelay: time.Second, Multiplier: 2, Jitter: 1, Rand: rand.New(rand.NewSource(1))}
+	for i := 0; i < 10; i++ {
+		assert.GreaterOrEqual(t, policy.Backoff(2000), time.Duration(0))
+	}
+}
+
+func TestRetryPolicyBackoff_jitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, Multiplier: 2, Jitter: 0.5, Rand: rand.New(rand.NewSource(1))}
+
+	var delays []time.Duration
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(2)
+		assert.GreaterOrEqual(t, delay, time.Second)
+		assert.LessOrEqual(t, delay, 3*time.Second)
+
+		delays = append(delays, delay)
+	}
+
+	// Delays are reproducible from the random source seed
+	policy.Rand = rand.New(rand.NewSource(1))
+	for _, delay := range delays {
+		assert.Equal(t, policy.Backoff(2), delay)
+	}
+}
+
+func TestDelayQueueRequeue(t *testing.T) {
+	clock := newFakeClock()
+	dq := newTestDelayQueue(t, clock)
+	policy := RetryPolicy{BaseDelay: time.Second, Multiplier: 3}
+
+	dq.Push("job", clock.Now())
+	item, _ := dq.Pop()
+
+	for attempt, delay := range []time.Duration{time.Second, 3 * time.Second, 9 * time.Second} {
+		requeued, err := dq.Requeue(item, policy)
+		assert.Nil(t, err)
+		assert.True(t, requeued)
+
+		clock.Advance(delay - time.Nanosecond)
+		_, ok := dq.Pop()
+		assert.False(t, ok)
+
+		clock.Advance(time.Nanosecond)
+		item, ok = dq.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, item.Value, "job")
+		assert.Equal(t, item.Attempt, attempt+1)
+	}
+}
+
+func TestDelayQueueRequeue_gives_up(t *testing.T) {
+	clock := newFakeClock()
+	dq := newTestDelayQueue(t, clock)
+
+	var dead []DelayedItem
+	policy := RetryPolicy{
+		BaseDelay:   time.Second,
+		Multiplier:  2,
+		MaxAttempts: 3,
+		Jitter:      0.2,
+		Rand:        rand.New(rand.NewSource(42)),
+		DeadLetter: func(item DelayedItem) {
+			dead = append(dead, item)
+		},
+	}
+
+	dq.Push("job", clock.Now())
+
+	attempts := 0
+	for {
+		clock.Advance(time.Minute)
+		item, ok := dq.Pop()
+		assert.True(t, ok)
+
+		requeued, err := dq.Requeue(item, policy)
+		assert.Nil(t, err)
+		if !requeued {
+			break
+		}
+		attempts++
+	}
+
+	assert.Equal(t, attempts, 3)
+	assert.Equal(t, dq.Size(), 0)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, dead[0].Value, "job")
+	assert.Equal(t, dead[0].Attempt, 3)
+}
+
+func TestDelayQueueRequeue_invalid_policy(t *testing.T) {
+	dq := newTestDelayQueue(t, newFakeClock())
+
+	for _, policy := range []RetryPolicy{{Jitter: 2}, {Jitter: -1}, {Jitter: math.NaN()}, {Multiplier: math.NaN()}} {
+		requeued, err := dq.Requeue(DelayedItem{Value: "job"}, policy)
+		assert.False(t, requeued)
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+	}
+	assert.Equal(t, dq.Size(), 0)
+}
+
+func TestDelayQueueRequeue_rejected(t *testing.T) {
+	clock := newFakeClock()
+	dq := newTestDelayQueue(t, clock, WithMaxItems(1))
+
+	dq.Push("other", clock.Now())
+	requeued, err := dq.Requeue(DelayedItem{Value: "job"}, RetryPolicy{BaseDelay: time.Second})
+	assert.False(t, requeued)
+	assert.True(t, errors.Is(err, ErrFull))
+}
+
+func TestDelayQueueTake_waits_for_ready_time(t *testing.T) {
+	dq, err := NewDelayQueue()
+	assert.Nil(t, err)
+
+	start := time.Now()
+	assert.Nil(t, dq.PushAfter("1", 20*time.Millisecond))
+
+	item, err := dq.Take(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, item.Value, "1")
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(t, dq.Size(), 0)
+}
+
+func TestDelayQueueTake_wakes_up_for_earlier_item(t *testing.T) {
+	dq, err := NewDelayQueue()
+	assert.Nil(t, err)
+	assert.Nil(t, dq.PushAfter("later", time.Hour))
+
+	taken := make(chan DelayedItem)
+	go func() {
+		item, err := dq.Take(context.Background())
+		assert.Nil(t, err)
+		taken <- item
+	}()
+
+	// A blocked Take on an empty queue is woken up too
+	empty, err := NewDelayQueue()
+	assert.Nil(t, err)
+	emptyTaken := make(chan DelayedItem)
+	go func() {
+		item, err := empty.Take(context.Background())
+		assert.Nil(t, err)
+		emptyTaken <- item
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, dq.PushAfter("sooner", 10*time.Millisecond))
+	assert.Nil(t, empty.PushAfter("only", 0))
+
+	assert.Equal(t, (<-taken).Value, "sooner")
+	assert.Equal(t, (<-emptyTaken).Value, "only")
+	assert.Equal(t, dq.Size(), 1)
+}
+
+func TestDelayQueueTake_context_done(t *testing.T) {
+	dq, err := NewDelayQueue()
+	assert.Nil(t, err)
+	assert.Nil(t, dq.PushAfter("later", time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = dq.Take(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, dq.Size(), 1)
+
+	// Ready items are taken whatever the context
+	assert.Nil(t, dq.PushAfter("ready", -time.Second))
+	item, err := dq.Take(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, item.Value, "ready")
+}
+
+func TestDelayQueueTake_concurrent_takers(t *testing.T) {
+	dq, err := NewDelayQueue()
+	assert.Nil(t, err)
+
+	const takers, items = 4, 200
+	results := make(chan interface{}, items)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < takers; i++ {
+		go func() {
+			for {
+				item, err := dq.Take(ctx)
+				if err != nil {
+					return
+				}
+				results <- item.Value
+			}
+		}()
+	}
+
+	for i := 0; i < items; i++ {
+		assert.Nil(t, dq.PushAfter(i, time.Duration(i%10)*time.Millisecond))
+	}
+
+	seen := make(map[interface{}]bool)
+	for i := 0; i < items; i++ {
+		value := <-results
+		assert.False(t, seen[value])
+		seen[value] = true
+	}
+	assert.Equal(t, len(seen), items)
+}
@@ -0,0 +1,141 @@
+package lane
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pqueueOpKind int
+
+const (
+	opPush pqueueOpKind = iota
+	opPop
+	opPopGroup
+	opHead
+	opRemoveWhere
+	opUpdatePriorities
+	opClone
+	opJSONRoundTrip
+)
+
+// pqueueOp is an operation of a recorded operations log
+type pqueueOp struct {
+	kind     pqueueOpKind
+	value    int
+	priority int
+}
+
+// recordOps records a log of n random operations. Priorities are drawn
+// from a small range so that many items share the same priority.
+func recordOps(seed int64, n int) []pqueueOp {
+	random := rand.New(rand.NewSource(seed))
+
+	ops := make([]pqueueOp, 0, n)
+	for i := 0; i < n; i++ {
+		op := pqueueOp{kind: opPush, value: i, priority: random.Intn(4)}
+
+		switch draw := random.Intn(100); {
+		case draw < 25:
+			op.kind = opPop
+		case draw < 28:
+			op.kind = opPopGroup
+		case draw < 31:
+			op.kind = opHead
+		case draw < 33:
+			op.kind = opRemoveWhere
+		case draw < 35:
+			op.kind = opUpdatePriorities
+		case draw < 36:
+			op.kind = opClone
+		case draw < 37:
+			op.kind = opJSONRoundTrip
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// replayOps replays the operations log on the queue, and returns the
+// sequence of values the queue returned.
+func replayOps(t *testing.T, pqueue *PQueue, ops []pqueueOp) []interface{} {
+	var observed []interface{}
+
+	for _, op := range ops {
+		switch op.kind {
+		case opPush:
+			pqueue.Push(op.value, op.priority)
+		case opPop:
+			value, priority := pqueue.Pop()
+			observed = append(observed, value, priority)
+		case opPopGroup:
+			priority, values, _ := pqueue.PopPriorityGroup()
+			observed = append(observed, priority, values)
+		case opHead:
+			value, priority := pqueue.Head()
+			observed = append(observed, value, priority)
+		case opRemoveWhere:
+			removed := pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+				return value.(int)%7 == op.value%7
+			})
+			observed = append(observed, removed)
+		case opUpdatePriorities:
+			pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+				return (priority + value.(int)) % 4
+			})
+		case opClone:
+			pqueue = pqueue.Clone()
+		case opJSONRoundTrip:
+			data, err := pqueue.MarshalJSON()
+			assert.Nil(t, err)
+			assert.Nil(t, pqueue.UnmarshalJSON(data))
+		}
+	}
+
+	for _, item := range pqueue.Drain() {
+		observed = append(observed, item.Value, item.Priority)
+	}
+
+	return observed
+}
+
+func TestPQueue_deterministic_pop_order(t *testing.T) {
+	configurations := map[string][]PQueueOption{
+		"default":      nil,
+		"stable":       {WithStableOrder()},
+		"random":       {WithRandomTieBreak(42)},
+		"write buffer": {WithWriteBuffer(8, time.Microsecond)},
+		"arena":        {WithArena(16)},
+		"evict":        {WithMaxItems(32), WithOverflowPolicy(EvictWhenFull)},
+		"chunked":      {WithBulkChunkSize(5)},
+	}
+
+	ops := recordOps(1, 5000)
+
+	decodeInt := WithJSONValueDecoder(func(data json.RawMessage) (interface{}, error) {
+		var value int
+		err := json.Unmarshal(data, &value)
+
+		return value, err
+	})
+
+	for name, options := range configurations {
+		t.Run(name, func(t *testing.T) {
+			for _, pqType := range []PQType{MAXPQ, MINPQ} {
+				options := append([]PQueueOption{decodeInt}, options...)
+
+				first, err := NewPQueueWithOptions(pqType, options...)
+				assert.Nil(t, err)
+				second, err := NewPQueueWithOptions(pqType, options...)
+				assert.Nil(t, err)
+
+				assert.Equal(t, replayOps(t, first, ops), replayOps(t, second, ops))
+			}
+		})
+	}
+}
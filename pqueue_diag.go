@@ -0,0 +1,82 @@
+package lane
+
+import "math/bits"
+
+// PQueueDiag holds structural diagnostics of a priority queue heap, see
+// Diagnostics.
+type PQueueDiag struct {
+	// Len is the count of heap slots holding items.
+	Len int
+	// Cap is the capacity, in slots, of the heap backing array, its
+	// first slot being unused.
+	Cap int
+	// Height is the count of levels of the heap tree.
+	Height int
+	// Violations is the count of heap slots breaking the heap invariant:
+	// whose item precedes its parent item, or doesn't know its position.
+	Violations int
+	// NilSlots is the count of heap slots holding no item.
+	NilSlots int
+	// StaleSlots is the count of slots of the backing array past the
+	// heap end still referencing an item.
+	StaleSlots int
+}
+
+// Diagnostics returns structural diagnostics of the queue heap, verifying
+// its invariant. The heap is verified on a snapshot taken holding the
+// read lock, so that the queue keeps serving pushes and pops meanwhile.
+// Items staged in a write buffer are merged first.
+func (pq *PQueue) Diagnostics() PQueueDiag {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlock()
+
+	diag := PQueueDiag{Len: pq.elemsCount, Cap: cap(pq.items) - 1}
+	if diag.Cap < 0 {
+		diag.Cap = 0
+	}
+
+	snapshot := make([]item, pq.elemsCount+1)
+	present := make([]bool, pq.elemsCount+1)
+	for k := 1; k <= pq.elemsCount; k++ {
+		if source := pq.items[k]; source != nil {
+			snapshot[k], present[k] = *source, true
+		}
+	}
+
+	for _, stale := range pq.items[len(pq.items):cap(pq.items)] {
+		if stale != nil {
+			diag.StaleSlots++
+		}
+	}
+
+	// The ordering may change once the lock is released
+	ordering := &PQueue{
+		pqType:           pq.pqType,
+		comparator:       pq.comparator,
+		secondaryOrder:   pq.secondaryOrder,
+		secondaryOrdered: pq.secondaryOrdered,
+		valueLess:        pq.valueLess,
+		stable:           pq.stable,
+		random:           pq.random,
+	}
+
+	pq.RUnlock()
+
+	diag.Height = bits.Len(uint(diag.Len))
+
+	for k := 1; k < len(snapshot); k++ {
+		if !present[k] {
+			diag.NilSlots++
+			continue
+		}
+
+		if snapshot[k].index != k || k > 1 && present[k/2] && ordering.lessItems(&snapshot[k/2], &snapshot[k]) {
+			diag.Violations++
+		}
+	}
+
+	return diag
+}
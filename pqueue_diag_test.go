@@ -0,0 +1,91 @@
+package lane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueDiagnostics(t *testing.T) {
+	assert.Equal(t, NewPQueue(MAXPQ).Diagnostics(), PQueueDiag{})
+	assert.Equal(t, new(PQueue).Diagnostics(), PQueueDiag{})
+
+	pqueue := NewPQueue(MINPQ)
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, 100-i)
+	}
+	for i := 0; i < 50; i++ {
+		pqueue.Pop()
+	}
+
+	diag := pqueue.Diagnostics()
+	assert.Equal(t, diag.Len, 50)
+	assert.True(t, diag.Cap >= 100)
+	assert.Equal(t, diag.Height, 6)
+	assert.Equal(t, diag.Violations, 0)
+	assert.Equal(t, diag.NilSlots, 0)
+	assert.Equal(t, diag.StaleSlots, 0)
+}
+
+func TestPQueueDiagnostics_detects_corruption(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for i := 0; i < 31; i++ {
+		pqueue.Push(i, i)
+	}
+
+	// Corrupt a copy, leaving the queue untouched
+	corrupted := pqueue.Clone()
+	corrupted.items[2].priority = 100
+	corrupted.items[3].priority = 100
+	corrupted.items[7].index = 8
+	corrupted.items[20] = nil
+
+	// Shrink the heap without clearing the vacated slot
+	corrupted.items = corrupted.items[:31]
+	corrupted.elemsCount = 30
+
+	diag := corrupted.Diagnostics()
+	assert.Equal(t, diag.Len, 30)
+	assert.Equal(t, diag.Height, 5)
+	assert.Equal(t, diag.Violations, 3)
+	assert.Equal(t, diag.NilSlots, 1)
+	assert.Equal(t, diag.StaleSlots, 1)
+
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+}
+
+func TestPQueueDiagnostics_large_queue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large queue diagnostics in short mode")
+	}
+
+	items := make([]Item, 2000000)
+	for i := range items {
+		items[i] = Item{Value: i, Priority: len(items) - i}
+	}
+
+	pqueue, err := NewPQueueFromHeap(MAXPQ, items)
+	assert.Nil(t, err)
+
+	diag := pqueue.Diagnostics()
+	assert.Equal(t, diag.Len, len(items))
+	assert.Equal(t, diag.Height, 21)
+	assert.Equal(t, diag.Violations, 0)
+}
+
+func BenchmarkPQueueDiagnostics(b *testing.B) {
+	items := make([]Item, 2000000)
+	for i := range items {
+		items[i] = Item{Value: i, Priority: len(items) - i}
+	}
+
+	pqueue, err := NewPQueueFromHeap(MAXPQ, items)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pqueue.Diagnostics()
+	}
+}
@@ -0,0 +1,219 @@
+package lane
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrReentrantCall is the error an Edit or Txn function panics with when
+// it calls the methods of the queue it is editing.
+var ErrReentrantCall = errors.New("lane: priority queue method called from its Edit function")
+
+// Cursor walks the items of a priority queue from an Edit function, in
+// no particular order.
+type Cursor struct {
+	pq      *PQueue
+	k       int
+	current *item
+	dirty   bool
+}
+
+// Edit calls fn with a cursor walking the items of the priority queue,
+// all of it while holding the queue write lock. The cursor can remove
+// the items or change their priority as it goes, and the heap is only
+// rebuilt once fn returns.
+//
+// fn must only reach the queue through the cursor: calling the queue
+// methods from fn, or using the cursor once fn has returned, panics.
+func (pq *PQueue) Edit(fn func(c *Cursor)) {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	c := &Cursor{pq: pq}
+
+	atomic.StoreInt64(&pq.editor, goroutineID())
+	defer func() {
+		atomic.StoreInt64(&pq.editor, 0)
+		c.pq = nil
+
+		if c.dirty {
+			pq.heapify()
+		}
+	}()
+
+	fn(c)
+}
+
+// Next moves the cursor to the next item, and reports whether there is
+// one.
+func (c *Cursor) Next() bool {
+	pq := c.queue()
+
+	if c.k >= pq.elemsCount {
+		c.current = nil
+		return false
+	}
+
+	c.k++
+	c.current = pq.items[c.k]
+
+	return true
+}
+
+// Value returns the value of the current item
+func (c *Cursor) Value() interface{} {
+	return c.item().value
+}
+
+// Priority returns the priority of the current item
+func (c *Cursor) Priority() int {
+	return int(c.item().priority)
+}
+
+// Priority64 returns the 64 bits priority of the current item, see
+// Push64.
+func (c *Cursor) Priority64() int64 {
+	return c.item().priority
+}
+
+// SetPriority sets the priority of the current item
+func (c *Cursor) SetPriority(priority int) {
+	c.setPriority(int64(priority))
+}
+
+// SetPriority64 sets the 64 bits priority of the current item, see
+// Push64.
+func (c *Cursor) SetPriority64(priority int64) {
+	c.setPriority(priority)
+}
+
+func (c *Cursor) setPriority(priority int64) {
+	item := c.item()
+	if item.priority != priority {
+		c.pq.logPriority(c.k, priority)
+		c.pq.walPriority(item, priority)
+		item.priority = priority
+		c.dirty = true
+	}
+}
+
+// Remove removes the current item from the queue. The cursor has no
+// current item until Next is called again.
+func (c *Cursor) Remove() {
+	c.item()
+	c.pq.release(c.pq.cut(c.k))
+
+	// The last item took the place of the removed one, and is the
+	// next one the cursor visits.
+	c.k--
+	c.current = nil
+	c.dirty = true
+}
+
+// cut removes the item at index k of the heap, and puts the last item
+// in its place, without restoring the heap invariant. The caller must
+// hold the write lock.
+func (pq *PQueue) cut(k int) *item {
+	pq.logInt(opCut, k)
+
+	removed := pq.items[k]
+	last := pq.elemsCount
+	if k == 1 {
+		pq.headCause(HeadPopped)
+	}
+
+	pq.exch(k, last)
+	pq.items[last] = nil
+	pq.items = pq.items[:last]
+	pq.elemsCount--
+	pq.bytes -= int64(removed.size)
+	pq.indexRemove(removed)
+	pq.internRemove(removed)
+	pq.walDelete(removed)
+	pq.checkDrained()
+	pq.checkWatermarks()
+	pq.checkShrink()
+
+	removed.index = 0
+
+	return removed
+}
+
+func (c *Cursor) queue() *PQueue {
+	if c.pq == nil {
+		panic("lane: Cursor used after its Edit function returned")
+	}
+
+	return c.pq
+}
+
+func (c *Cursor) item() *item {
+	c.queue()
+
+	if c.current == nil {
+		panic("lane: Cursor has no current item, call Next first")
+	}
+
+	return c.current
+}
+
+// lock acquires the write lock, after checking the calling goroutine is
+// not editing the queue, and the queue is not a copy made by value.
+func (pq *PQueue) lock() {
+	schedPoint("lock")
+	pq.checkReentrant()
+	pq.copyCheck()
+	if pq.fair != nil {
+		pq.fair.lock()
+	}
+	pq.Lock()
+	pq.unstage()
+}
+
+// unlock hands the items the locked operations made eligible over to
+// the blocked consumers, publishes the queue size and releases the write
+// lock, and then calls the watermark callbacks and the expiry hook the
+// locked operations triggered, see WithWatermarks and WithOnExpire.
+func (pq *PQueue) unlock() {
+	pq.serveWaiters()
+	pq.recordHead()
+	// The error is kept for FlushWAL, and Push, to return
+	pq.syncWAL()
+	pq.publishSize()
+	pq.wakeRoom()
+	pq.Unlock()
+	if pq.fair != nil {
+		pq.fair.unlock()
+	}
+	pq.notifyWatermarks()
+	pq.notifyExpired()
+}
+
+// rlock acquires the read lock, after checking the calling goroutine is
+// not editing the queue. The prefetched item is moved back into the heap
+// first, see WithPrefetch.
+func (pq *PQueue) rlock() {
+	schedPoint("rlock")
+	pq.checkReentrant()
+	pq.RLock()
+
+	// Items are only staged holding the write lock
+	for pq.hasStaged() {
+		pq.RUnlock()
+		pq.lock()
+		pq.unlock()
+		pq.RLock()
+	}
+}
+
+// checkReentrant panics if the calling goroutine is editing the queue,
+// as it would otherwise deadlock waiting for its own lock. The goroutine
+// id is only looked up while an Edit or Txn function runs.
+func (pq *PQueue) checkReentrant() {
+	editor := atomic.LoadInt64(&pq.editor)
+	if editor != 0 && editor == goroutineID() {
+		panic(pq.newError("edit", ErrReentrantCall))
+	}
+}
@@ -0,0 +1,169 @@
+package lane
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueEdit_removes_and_reprioritizes(t *testing.T) {
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		pqueue := NewPQueue(pqType)
+		random := rand.New(rand.NewSource(42))
+
+		model := make(map[int]int)
+		for value := 0; value < 200; value++ {
+			priority := random.Intn(50)
+			pqueue.Push(value, priority)
+			model[value] = priority
+		}
+
+		visited := 0
+		pqueue.Edit(func(c *Cursor) {
+			for c.Next() {
+				visited++
+				value := c.Value().(int)
+				assert.Equal(t, c.Priority(), model[value])
+
+				switch value % 3 {
+				case 0:
+					c.Remove()
+					delete(model, value)
+				case 1:
+					c.SetPriority(c.Priority() * 2)
+					model[value] *= 2
+				}
+			}
+		})
+
+		assert.Equal(t, visited, 200)
+		assert.Equal(t, pqueue.Size(), len(model))
+		assert.True(t, assertHeapInvariant(t, pqueue))
+
+		expected := make([]int, 0, len(model))
+		for _, priority := range model {
+			expected = append(expected, priority)
+		}
+		sort.Ints(expected)
+		if pqType == MAXPQ {
+			sort.Sort(sort.Reverse(sort.IntSlice(expected)))
+		}
+
+		for _, priority := range expected {
+			value, popped := pqueue.Pop()
+			assert.Equal(t, popped, priority)
+			assert.Equal(t, model[value.(int)], priority)
+		}
+		assert.Equal(t, pqueue.Size(), 0)
+	}
+}
+
+func TestPQueueEdit_remove_all(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithSizeEstimator(stringSize))
+	assert.Nil(t, err)
+
+	pqueue.Push("a", 1)
+	pqueue.Push("bb", 2)
+	pqueue.Push("ccc", 3)
+
+	pqueue.Edit(func(c *Cursor) {
+		for c.Next() {
+			c.Remove()
+		}
+	})
+
+	assert.Equal(t, pqueue.Size(), 0)
+	assert.Equal(t, pqueue.Stats().Bytes, int64(0))
+
+	value, _ := pqueue.Pop()
+	assert.Nil(t, value)
+}
+
+func TestPQueueEdit_prevents_reentrancy(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithName("jobs"))
+	assert.Nil(t, err)
+	pqueue.Push("a", 1)
+
+	defer func() {
+		err, _ := recover().(error)
+		assert.True(t, errors.Is(err, ErrReentrantCall))
+
+		// The queue is left usable
+		pqueue.Push("b", 2)
+		assert.Equal(t, pqueue.Size(), 2)
+	}()
+
+	pqueue.Edit(func(c *Cursor) {
+		pqueue.Push("b", 2)
+	})
+}
+
+func TestPQueueEdit_other_goroutines_wait(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+
+	done := make(chan struct{})
+	pqueue.Edit(func(c *Cursor) {
+		go func() {
+			pqueue.Push("b", 2)
+			close(done)
+		}()
+
+		for c.Next() {
+			c.SetPriority(3)
+		}
+	})
+
+	<-done
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, "a")
+	assert.Equal(t, priority, 3)
+}
+
+func TestPQueueEdit_invalid_cursor_use(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+
+	var cursor *Cursor
+	pqueue.Edit(func(c *Cursor) {
+		cursor = c
+
+		assert.PanicsWithValue(t, "lane: Cursor has no current item, call Next first", func() { c.Value() })
+
+		assert.True(t, c.Next())
+		c.Remove()
+		assert.PanicsWithValue(t, "lane: Cursor has no current item, call Next first", func() { c.Remove() })
+		assert.False(t, c.Next())
+	})
+
+	assert.PanicsWithValue(t, "lane: Cursor used after its Edit function returned", func() { cursor.Next() })
+}
+
+func TestCursorSetPriority64(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("low", 1)
+	pqueue.Push("high", 2)
+
+	pqueue.Edit(func(c *Cursor) {
+		for c.Next() {
+			if c.Value() == "low" {
+				c.SetPriority64(1 << 40)
+			}
+		}
+	})
+
+	pqueue.Edit(func(c *Cursor) {
+		for c.Next() {
+			if c.Value() == "low" {
+				assert.Equal(t, c.Priority64(), int64(1<<40))
+			}
+		}
+	})
+
+	value, priority := pqueue.Pop64()
+	assert.Equal(t, value, "low")
+	assert.Equal(t, priority, int64(1<<40))
+}
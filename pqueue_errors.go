@@ -0,0 +1,74 @@
+package lane
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueueError records an error along with the priority queue operation
+// and state which caused it.
+type QueueError struct {
+	// Queue is the queue name, see WithName.
+	Queue string
+	// Op is the operation which failed.
+	Op string
+	// Size is the count of queued items when the operation failed.
+	Size int
+	// Ordering is the queue ordering type.
+	Ordering PQType
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *QueueError) Error() string {
+	queue := orderingName(e.Ordering) + " priority queue"
+	if e.Queue != "" {
+		queue += fmt.Sprintf(" %q", e.Queue)
+	}
+
+	return fmt.Sprintf("lane: %s on %s (size %d): %s", e.Op, queue, e.Size, strings.TrimPrefix(e.Err.Error(), "lane: "))
+}
+
+// Unwrap returns the underlying error
+func (e *QueueError) Unwrap() error {
+	return e.Err
+}
+
+// WithName sets the queue name, reported by the errors it returns.
+func WithName(name string) PQueueOption {
+	return func(pq *PQueue) error {
+		pq.name = name
+		return nil
+	}
+}
+
+// Name returns the queue name, see WithName
+func (pq *PQueue) Name() string {
+	return pq.name
+}
+
+// newError wraps err into a QueueError describing the operation and the
+// queue state. The caller must hold the lock.
+func (pq *PQueue) newError(op string, err error) error {
+	size := pq.elemsCount
+	if pq.buffer != nil {
+		size += pq.buffer.len()
+	}
+
+	return &QueueError{
+		Queue:    pq.name,
+		Op:       op,
+		Size:     size,
+		Ordering: pq.pqType,
+		Err:      err,
+	}
+}
+
+// lockedError is newError for callers which don't hold the lock, it
+// takes the read lock.
+func (pq *PQueue) lockedError(op string, err error) error {
+	pq.rlock()
+	defer pq.RUnlock()
+
+	return pq.newError(op, err)
+}
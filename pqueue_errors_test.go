@@ -0,0 +1,132 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueError_Error(t *testing.T) {
+	err := &QueueError{Queue: "jobs", Op: "push", Size: 3, Ordering: MAXPQ, Err: ErrFull}
+	assert.Equal(t, err.Error(), `lane: push on max priority queue "jobs" (size 3): priority queue is full`)
+
+	err = &QueueError{Op: "wait pop", Ordering: MINPQ, Err: context.Canceled}
+	assert.Equal(t, err.Error(), "lane: wait pop on min priority queue (size 0): context canceled")
+}
+
+func TestWithName(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithName("jobs"))
+	assert.Nil(t, err)
+	assert.Equal(t, pqueue.Name(), "jobs")
+	assert.Equal(t, NewPQueue(MAXPQ).Name(), "")
+}
+
+func TestPQueuePush_error_context(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithName("jobs"), WithMaxItems(1))
+	assert.Nil(t, err)
+	assert.Nil(t, pqueue.Push("1", 1))
+
+	err = pqueue.Push("2", 2)
+	assert.True(t, errors.Is(err, ErrFull))
+	assert.Equal(t, err.Error(), `lane: push on min priority queue "jobs" (size 1): priority queue is full`)
+
+	var queueErr *QueueError
+	if assert.True(t, errors.As(err, &queueErr)) {
+		assert.Equal(t, queueErr.Queue, "jobs")
+		assert.Equal(t, queueErr.Op, "push")
+		assert.Equal(t, queueErr.Size, 1)
+		assert.Equal(t, queueErr.Ordering, MINPQ)
+		assert.Equal(t, queueErr.Err, ErrFull)
+	}
+
+	_, err = pqueue.PushRef("2", 2)
+	assert.True(t, errors.As(err, &queueErr))
+	assert.Equal(t, queueErr.Op, "push")
+}
+
+func TestPQueueMerge_error_context(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithName("jobs"), WithMaxItems(1))
+	assert.Nil(t, err)
+
+	other := NewPQueue(MAXPQ)
+	other.Push("a", 1)
+	other.Push("b", 2)
+
+	err = pqueue.Merge(other)
+	assert.True(t, errors.Is(err, ErrFull))
+	assert.Equal(t, err.Error(), `lane: merge on max priority queue "jobs" (size 1): priority queue is full`)
+}
+
+func TestPQueueWaitPop_error_context(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithName("jobs"))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err = pqueue.WaitPop(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, err.Error(), `lane: wait pop on max priority queue "jobs" (size 0): context canceled`)
+}
+
+func TestPQueueUnmarshalJSON_error_context(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithName("jobs"))
+	assert.Nil(t, err)
+
+	err = pqueue.UnmarshalJSON([]byte(`{"ordering":"sideways","items":[]}`))
+	var queueErr *QueueError
+	if assert.True(t, errors.As(err, &queueErr)) {
+		assert.Equal(t, queueErr.Op, "unmarshal")
+		assert.Equal(t, queueErr.Queue, "jobs")
+	}
+
+	err = pqueue.UnmarshalJSON([]byte(`{`))
+	assert.True(t, errors.As(err, &queueErr))
+	assert.Equal(t, queueErr.Op, "unmarshal")
+}
+
+func TestPQueueMarshalJSON_error_context(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithName("jobs"))
+	assert.Nil(t, err)
+	pqueue.Push(make(chan int), 1)
+
+	_, err = pqueue.MarshalJSON()
+	var queueErr *QueueError
+	if assert.True(t, errors.As(err, &queueErr)) {
+		assert.Equal(t, queueErr.Op, "marshal")
+		assert.Equal(t, queueErr.Size, 1)
+	}
+}
+
+func TestPQueuePop_concurrent_with_last_item(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	for round := 0; round < 100; round++ {
+		pqueue.Push(round, round)
+
+		var wg sync.WaitGroup
+		results := make(chan interface{}, 4)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				value, _ := pqueue.Pop()
+				results <- value
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		popped := 0
+		for value := range results {
+			if value != nil {
+				popped++
+			}
+		}
+		assert.Equal(t, popped, 1)
+	}
+}
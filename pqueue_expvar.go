@@ -0,0 +1,47 @@
+package lane
+
+import (
+	"expvar"
+	"sync"
+)
+
+// expvarMu serializes the expvar publications, which panic when a name
+// is published twice.
+var expvarMu sync.Mutex
+
+// LenVar returns an expvar variable reporting the count of queued items,
+// read without acquiring the queue lock, see MetricsSnapshot.
+func (pq *PQueue) LenVar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return pq.MetricsSnapshot().Size
+	})
+}
+
+// MetricsVar returns an expvar variable reporting the queue counters and
+// gauges, see MetricsSnapshot.
+func (pq *PQueue) MetricsVar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return pq.MetricsSnapshot()
+	})
+}
+
+// PublishExpvar publishes the queue LenVar and MetricsVar variables
+// under the prefix followed by the queue name, see WithName, and
+// respectively ".len" and ".metrics": a queue named "jobs" published with
+// the "lane." prefix is reported as "lane.jobs.len" for instance. Names
+// already published are left as they are, so that publishing a queue
+// twice doesn't panic. It reports whether the variables were published.
+func (pq *PQueue) PublishExpvar(prefix string) bool {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	name := prefix + pq.Name()
+	if expvar.Get(name+".len") != nil || expvar.Get(name+".metrics") != nil {
+		return false
+	}
+
+	expvar.Publish(name+".len", pq.LenVar())
+	expvar.Publish(name+".metrics", pq.MetricsVar())
+
+	return true
+}
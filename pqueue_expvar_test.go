@@ -0,0 +1,67 @@
+package lane
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expvars returns the variables served by the expvar handler
+func expvars(t *testing.T) map[string]json.RawMessage {
+	recorder := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	vars := make(map[string]json.RawMessage)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &vars))
+
+	return vars
+}
+
+func TestPQueueLenVar(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	lenVar := pqueue.LenVar()
+	assert.Equal(t, lenVar.String(), "0")
+
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+	assert.Equal(t, lenVar.String(), "2")
+
+	pqueue.Pop()
+	assert.Equal(t, lenVar.String(), "1")
+}
+
+// publications counts the test publications, expvar names can't be
+// published twice in a process.
+var publications int
+
+func TestPQueuePublishExpvar(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithName("published"))
+	assert.Nil(t, err)
+
+	publications++
+	prefix := fmt.Sprintf("lane_test%d.", publications)
+	assert.True(t, pqueue.PublishExpvar(prefix))
+	assert.False(t, pqueue.PublishExpvar(prefix))
+
+	for i := 0; i < 3; i++ {
+		pqueue.Push(i, i)
+	}
+	pqueue.Pop()
+
+	vars := expvars(t)
+	assert.Equal(t, string(vars[prefix+"published.len"]), "2")
+
+	var metrics PQueueMetrics
+	assert.Nil(t, json.Unmarshal(vars[prefix+"published.metrics"], &metrics))
+	assert.Equal(t, metrics.Size, int64(2))
+	assert.Equal(t, metrics.Pushes, uint64(3))
+	assert.Equal(t, metrics.Pops, uint64(1))
+
+	pqueue.Pop()
+	pqueue.Pop()
+	assert.Equal(t, string(expvars(t)[prefix+"published.len"]), "0")
+}
@@ -0,0 +1,60 @@
+package lane
+
+import "sync"
+
+// fairMutex is a mutex handing itself over to the goroutines waiting for
+// it in their arrival order, see WithFairLock.
+type fairMutex struct {
+	mu      sync.Mutex
+	locked  bool
+	waiters []chan struct{}
+}
+
+// WithFairLock makes the queue operations acquire its write lock in
+// their arrival order, instead of letting the goroutine which releases
+// it, or a newly arrived one, acquire it again ahead of the waiting
+// ones. A producer contending with many consumers popping in tight
+// loops, or the other way around, then waits for the operations queued
+// before it only: the latency of each operation is bounded, at the cost
+// of throughput, the lock being handed over to a parked goroutine every
+// time it is contended.
+//
+// Read-only operations, such as Head or Stats, are not ordered.
+func WithFairLock() PQueueOption {
+	return func(pq *PQueue) error {
+		pq.fair = &fairMutex{}
+		return nil
+	}
+}
+
+func (m *fairMutex) lock() {
+	m.mu.Lock()
+	if !m.locked {
+		m.locked = true
+		m.mu.Unlock()
+		return
+	}
+
+	turn := make(chan struct{})
+	m.waiters = append(m.waiters, turn)
+	m.mu.Unlock()
+
+	// The mutex is handed over locked
+	<-turn
+}
+
+func (m *fairMutex) unlock() {
+	m.mu.Lock()
+	if len(m.waiters) == 0 {
+		m.locked = false
+		m.mu.Unlock()
+		return
+	}
+
+	next := m.waiters[0]
+	m.waiters[0] = nil
+	m.waiters = m.waiters[1:]
+	m.mu.Unlock()
+
+	close(next)
+}
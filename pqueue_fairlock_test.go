@@ -0,0 +1,132 @@
+package lane
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fairWaiters returns the count of goroutines waiting for the fair lock
+func fairWaiters(pq *PQueue) int {
+	pq.fair.mu.Lock()
+	defer pq.fair.mu.Unlock()
+
+	return len(pq.fair.waiters)
+}
+
+func TestPQueueFairLock_operations_acquire_lock_in_arrival_order(t *testing.T) {
+	const pushers = 16
+
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithFairLock(), WithStableOrder())
+
+	pqueue.lock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < pushers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pqueue.Push(i, 1)
+		}(i)
+
+		// Wait for the pusher to queue up before starting the next one
+		for fairWaiters(pqueue) < i+1 {
+			runtime.Gosched()
+		}
+	}
+
+	pqueue.unlock()
+	wg.Wait()
+
+	for i := 0; i < pushers; i++ {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, i)
+	}
+}
+
+func TestPQueueFairLock_concurrent_operations(t *testing.T) {
+	const producers, pushes = 4, 1000
+
+	pqueue, _ := NewPQueueWithOptions(MINPQ, WithFairLock())
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < pushes; i++ {
+				pqueue.Push(p*pushes+i, i)
+			}
+		}(p)
+	}
+
+	var popped int64
+	for c := 0; c < 4; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt64(&popped) < producers*pushes {
+				if value, _ := pqueue.Pop(); value != nil {
+					atomic.AddInt64(&popped, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, popped, int64(producers*pushes))
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+// benchmarkPQueuePushLatency measures the latency of a producer pushing
+// while 32 consumers pop in tight loops, and reports its percentiles.
+func benchmarkPQueuePushLatency(b *testing.B, options ...PQueueOption) {
+	const poppers = 32
+
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, options...)
+
+	var (
+		stop int32
+		wg   sync.WaitGroup
+	)
+	for p := 0; p < poppers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				pqueue.Pop()
+			}
+		}()
+	}
+
+	latencies := make([]time.Duration, b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		pqueue.Push(i, i)
+		latencies[i] = time.Since(start)
+	}
+	b.StopTimer()
+
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[b.N/2].Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(latencies[b.N*99/100].Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(latencies[b.N-1].Nanoseconds()), "max-ns")
+}
+
+func BenchmarkPQueuePushLatency(b *testing.B) {
+	benchmarkPQueuePushLatency(b)
+}
+
+func BenchmarkPQueuePushLatency_fair_lock(b *testing.B) {
+	benchmarkPQueuePushLatency(b, WithFairLock())
+}
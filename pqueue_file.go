@@ -0,0 +1,305 @@
+package lane
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ErrInvalidQueueFile is returned when a file backed priority queue file
+// isn't a queue snapshot, or holds a queue of another ordering.
+var ErrInvalidQueueFile = errors.New("lane: invalid priority queue file")
+
+// fileHeader starts the file backed priority queue files, followed by
+// the snapshot generation and the queue JSON representation.
+const fileHeader = "lane-file"
+
+// FileBackedPQueue is a priority queue stored in a file, shared by the
+// processes of a host opening the same file. Each operation takes an
+// advisory lock on a companion ".lock" file, loads the queue snapshot if
+// another handle changed it since it was last loaded, and writes the
+// changed snapshot back to a temporary file which is then renamed over
+// the queue file: a process killed midway leaves the previous snapshot
+// in place, never a torn one.
+//
+// Operations are slow, each one reading and writing the whole snapshot
+// in the worst case: FileBackedPQueue suits small queues shared by
+// processes which can't afford a broker. It is safe for concurrent
+// operations, including by several handles of a single process.
+type FileBackedPQueue struct {
+	mu   sync.Mutex
+	path string
+	lock *os.File
+
+	pqType  PQType
+	options []PQueueOption
+
+	// queue is the snapshot last loaded or written, of the generation
+	// counted in the file header.
+	queue      *PQueue
+	generation uint64
+}
+
+// OpenFileBackedPQueue opens the file backed priority queue stored at
+// path, creating it if needed, with the provided pqtype ordering type.
+// The options are those of the queue loaded from the file, see
+// NewPQueueWithOptions: values are stored as MarshalJSON encodes them,
+// and decoded as set with WithJSONValueDecoder.
+//
+// ErrInvalidQueueFile is returned if the file holds a queue of another
+// ordering, or isn't a queue snapshot.
+func OpenFileBackedPQueue(path string, pqType PQType, options ...PQueueOption) (*FileBackedPQueue, error) {
+	if _, err := NewPQueueWithOptions(pqType, options...); err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &FileBackedPQueue{
+		path:    path,
+		lock:    lock,
+		pqType:  pqType,
+		options: options,
+	}
+
+	// Validate the file right away rather than on first use
+	if err := q.view(func(*PQueue) {}); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Push pushes the value item with the priority into the queue file
+func (q *FileBackedPQueue) Push(value interface{}, priority int) error {
+	return q.update(func(pq *PQueue) (bool, error) {
+		return true, pq.Push(value, priority)
+	})
+}
+
+// Pop pops and returns the highest/lowest priority item (depending on
+// whether you're using a MINPQ or MAXPQ) from the queue file. The
+// boolean is false if the queue is empty.
+func (q *FileBackedPQueue) Pop() (interface{}, int, bool, error) {
+	var (
+		value    interface{}
+		priority int
+		ok       bool
+	)
+
+	err := q.update(func(pq *PQueue) (bool, error) {
+		value, priority, ok = pq.PopRelease()
+		return ok, nil
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return value, priority, ok, nil
+}
+
+// Head returns the highest/lowest priority item (depending on whether
+// you're using a MINPQ or MAXPQ) from the queue file, without removing
+// it. The boolean is false if the queue is empty.
+func (q *FileBackedPQueue) Head() (interface{}, int, bool, error) {
+	var (
+		value    interface{}
+		priority int
+		ok       bool
+	)
+
+	err := q.view(func(pq *PQueue) {
+		value, priority = pq.Head()
+		ok = pq.Size() > 0
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return value, priority, ok, nil
+}
+
+// Size returns the count of items in the queue file
+func (q *FileBackedPQueue) Size() (int, error) {
+	var size int
+	err := q.view(func(pq *PQueue) {
+		size = pq.Size()
+	})
+
+	return size, err
+}
+
+// Close closes the handle. The queue file is left in place.
+func (q *FileBackedPQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.lock == nil {
+		return ErrClosed
+	}
+
+	err := q.lock.Close()
+	q.lock, q.queue = nil, nil
+
+	return err
+}
+
+// view calls fn with the current snapshot, holding the shared file lock
+func (q *FileBackedPQueue) view(fn func(pq *PQueue)) error {
+	return q.locked(false, func() error {
+		if err := q.load(); err != nil {
+			return err
+		}
+
+		fn(q.queue)
+		return nil
+	})
+}
+
+// update calls fn with the current snapshot, holding the exclusive file
+// lock, and writes the snapshot back if fn reports it changed it.
+func (q *FileBackedPQueue) update(fn func(pq *PQueue) (bool, error)) error {
+	return q.locked(true, func() error {
+		if err := q.load(); err != nil {
+			return err
+		}
+
+		changed, err := fn(q.queue)
+		if err != nil || !changed {
+			return err
+		}
+
+		return q.store()
+	})
+}
+
+// locked calls fn holding the handle mutex and the file lock
+func (q *FileBackedPQueue) locked(exclusive bool, fn func() error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.lock == nil {
+		return ErrClosed
+	}
+
+	if err := lockFile(q.lock, exclusive); err != nil {
+		return err
+	}
+	defer unlockFile(q.lock)
+
+	return fn()
+}
+
+// load loads the queue file snapshot unless its generation is the one
+// already loaded. A missing file holds an empty queue. The caller must
+// hold the file lock.
+func (q *FileBackedPQueue) load() error {
+	f, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		q.generation = 0
+		q.queue, err = NewPQueueWithOptions(q.pqType, q.options...)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("%w: missing header", ErrInvalidQueueFile)
+	}
+
+	var generation uint64
+	fields := bytes.Fields([]byte(header))
+	if len(fields) == 2 && string(fields[0]) == fileHeader {
+		generation, err = strconv.ParseUint(string(fields[1]), 10, 64)
+	}
+	if len(fields) != 2 || string(fields[0]) != fileHeader || err != nil {
+		return fmt.Errorf("%w: malformed header %q", ErrInvalidQueueFile, header)
+	}
+
+	if q.queue != nil && generation == q.generation {
+		return nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	queue, err := NewPQueueWithOptions(q.pqType, q.options...)
+	if err != nil {
+		return err
+	}
+
+	if err := queue.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQueueFile, err)
+	}
+
+	if queue.pqType != q.pqType {
+		return fmt.Errorf("%w: %s queue file", ErrInvalidQueueFile, orderingName(queue.pqType))
+	}
+
+	q.queue, q.generation = queue, generation
+
+	return nil
+}
+
+// store writes the snapshot as the next generation, to a temporary file
+// synced and then renamed over the queue file. The caller must hold the
+// exclusive file lock.
+func (q *FileBackedPQueue) store() error {
+	data, err := q.queue.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	generation := q.generation + 1
+
+	// The temporary file is only written holding the exclusive lock, a
+	// leftover of a killed process is truncated.
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%s %d\n", fileHeader, generation)
+	w.Write(data)
+
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, q.path)
+	}
+	if err == nil {
+		err = syncDir(q.path)
+	}
+
+	if err != nil {
+		// The snapshot is reloaded from the file by the next operation
+		q.queue = nil
+		return err
+	}
+
+	q.generation = generation
+
+	return nil
+}
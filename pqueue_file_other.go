@@ -0,0 +1,22 @@
+//go:build !unix && !windows
+
+package lane
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile fails: advisory file locks are not supported on this
+// platform, so neither are file backed queues.
+func lockFile(f *os.File, exclusive bool) error {
+	return errors.ErrUnsupported
+}
+
+func unlockFile(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func syncDir(path string) error {
+	return nil
+}
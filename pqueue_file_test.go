@@ -0,0 +1,303 @@
+package lane
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeFileInt decodes the file backed queue values as ints
+var decodeFileInt = WithJSONValueDecoder(func(data json.RawMessage) (interface{}, error) {
+	var value int
+	err := json.Unmarshal(data, &value)
+
+	return value, err
+})
+
+func TestFileBackedPQueue_handles_share_the_queue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+
+	producer, err := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+	assert.Nil(t, err)
+	defer producer.Close()
+	consumer, err := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+	assert.Nil(t, err)
+	defer consumer.Close()
+
+	assert.Nil(t, producer.Push(1, 1))
+	assert.Nil(t, producer.Push(3, 3))
+	assert.Nil(t, producer.Push(2, 2))
+
+	size, err := consumer.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size, 3)
+
+	value, priority, ok, err := consumer.Head()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, value, 3)
+	assert.Equal(t, priority, 3)
+
+	for _, expected := range []int{3, 2, 1} {
+		value, priority, ok, err := consumer.Pop()
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, value, expected)
+		assert.Equal(t, priority, expected)
+	}
+
+	_, _, ok, err = producer.Pop()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestFileBackedPQueue_reopened_queue_keeps_its_items(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+
+	pqueue, _ := OpenFileBackedPQueue(path, MINPQ, decodeFileInt, WithStableOrder())
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, pqueue.Push(i, i%2))
+	}
+	assert.Nil(t, pqueue.Close())
+	assert.True(t, errors.Is(pqueue.Push(5, 0), ErrClosed))
+
+	pqueue, err := OpenFileBackedPQueue(path, MINPQ, decodeFileInt, WithStableOrder())
+	assert.Nil(t, err)
+	defer pqueue.Close()
+
+	var popped []interface{}
+	for {
+		value, _, ok, err := pqueue.Pop()
+		assert.Nil(t, err)
+		if !ok {
+			break
+		}
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{0, 2, 4, 1, 3})
+}
+
+func TestFileBackedPQueue_ignores_leftover_temporary_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+
+	pqueue, _ := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+	defer pqueue.Close()
+	assert.Nil(t, pqueue.Push(1, 1))
+
+	// A process killed while writing the next snapshot
+	assert.Nil(t, os.WriteFile(path+".tmp", []byte("lane-file 2\n{\"vers"), 0o644))
+
+	other, err := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+	assert.Nil(t, err)
+	defer other.Close()
+
+	assert.Nil(t, other.Push(2, 2))
+	size, err := pqueue.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size, 2)
+}
+
+func TestOpenFileBackedPQueue_invalid_file(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"empty":     "",
+		"header":    "lane-oplog 2 0\n",
+		"truncated": "lane-file 1\n{\"version\":2,",
+		"json":      "lane-file 1\n[]",
+	} {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+
+		_, err := OpenFileBackedPQueue(path, MAXPQ)
+		assert.True(t, errors.Is(err, ErrInvalidQueueFile), name)
+	}
+
+	path := filepath.Join(dir, "minpq")
+	pqueue, _ := OpenFileBackedPQueue(path, MINPQ)
+	assert.Nil(t, pqueue.Push("a", 1))
+	pqueue.Close()
+
+	_, err := OpenFileBackedPQueue(path, MAXPQ)
+	assert.True(t, errors.Is(err, ErrInvalidQueueFile))
+}
+
+// hammerFileBackedPQueue pushes count items numbered from first into the
+// queue file, popping an item after each push, and returns the popped
+// values.
+func hammerFileBackedPQueue(pqueue *FileBackedPQueue, first, count int) ([]int, error) {
+	var popped []int
+	for i := first; i < first+count; i++ {
+		if err := pqueue.Push(i, i%10); err != nil {
+			return nil, err
+		}
+
+		value, _, ok, err := pqueue.Pop()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			popped = append(popped, value.(int))
+		}
+	}
+
+	return popped, nil
+}
+
+// checkFileBackedPQueuePops checks the popped values, along with those
+// left in the queue file, are the pushed values 0 to count, once each.
+func checkFileBackedPQueuePops(t *testing.T, path string, popped []int, count int) {
+	pqueue, err := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+	assert.Nil(t, err)
+	defer pqueue.Close()
+
+	for {
+		value, _, ok, err := pqueue.Pop()
+		assert.Nil(t, err)
+		if !ok {
+			break
+		}
+		popped = append(popped, value.(int))
+	}
+
+	sort.Ints(popped)
+	expected := make([]int, count)
+	for i := range expected {
+		expected[i] = i
+	}
+	assert.Equal(t, popped, expected)
+}
+
+func TestFileBackedPQueue_concurrent_handles_lose_nothing(t *testing.T) {
+	const handles, perHandle = 4, 200
+
+	path := filepath.Join(t.TempDir(), "queue")
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		popped []int
+	)
+	for h := 0; h < handles; h++ {
+		pqueue, err := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+		assert.Nil(t, err)
+		defer pqueue.Close()
+
+		wg.Add(1)
+		go func(h int) {
+			defer wg.Done()
+
+			values, err := hammerFileBackedPQueue(pqueue, h*perHandle, perHandle)
+			assert.Nil(t, err)
+
+			mu.Lock()
+			popped = append(popped, values...)
+			mu.Unlock()
+		}(h)
+	}
+	wg.Wait()
+
+	checkFileBackedPQueuePops(t, path, popped, handles*perHandle)
+}
+
+// TestFileBackedPQueue_helper_process is run by the processes hammering
+// the queue file of TestFileBackedPQueue_concurrent_processes_lose_nothing.
+func TestFileBackedPQueue_helper_process(t *testing.T) {
+	spec := os.Getenv("LANE_FILE_QUEUE_HAMMER")
+	if spec == "" {
+		t.Skip("helper process")
+	}
+
+	var (
+		path         string
+		first, count int
+	)
+	fields := strings.Split(spec, ",")
+	path = fields[0]
+	first, _ = strconv.Atoi(fields[1])
+	count, _ = strconv.Atoi(fields[2])
+
+	pqueue, err := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pqueue.Close()
+
+	popped, err := hammerFileBackedPQueue(pqueue, first, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(popped)
+	fmt.Fprintf(os.Stdout, "popped %s\n", data)
+}
+
+func TestFileBackedPQueue_concurrent_processes_lose_nothing(t *testing.T) {
+	const processes, perProcess = 3, 200
+
+	path := filepath.Join(t.TempDir(), "queue")
+
+	commands := make([]*exec.Cmd, processes)
+	outputs := make([]strings.Builder, processes)
+	for p := range commands {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestFileBackedPQueue_helper_process$", "-test.count=1")
+		cmd.Env = append(os.Environ(), fmt.Sprintf("LANE_FILE_QUEUE_HAMMER=%s,%d,%d", path, p*perProcess, perProcess))
+		cmd.Stdout = &outputs[p]
+		cmd.Stderr = &outputs[p]
+		assert.Nil(t, cmd.Start())
+		commands[p] = cmd
+	}
+
+	var popped []int
+	for p, cmd := range commands {
+		assert.Nil(t, cmd.Wait(), outputs[p].String())
+
+		for _, line := range strings.Split(outputs[p].String(), "\n") {
+			if data := strings.TrimPrefix(line, "popped "); data != line {
+				var values []int
+				assert.Nil(t, json.Unmarshal([]byte(data), &values))
+				popped = append(popped, values...)
+			}
+		}
+	}
+
+	checkFileBackedPQueuePops(t, path, popped, processes*perProcess)
+}
+
+func TestFileBackedPQueue_killed_process_leaves_valid_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileBackedPQueue_helper_process$", "-test.count=1")
+	cmd.Env = append(os.Environ(), fmt.Sprintf("LANE_FILE_QUEUE_HAMMER=%s,0,1000000", path))
+	assert.Nil(t, cmd.Start())
+
+	// Kill the process while it hammers the queue file
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, cmd.Process.Kill())
+	cmd.Wait()
+
+	pqueue, err := OpenFileBackedPQueue(path, MAXPQ, decodeFileInt)
+	assert.Nil(t, err)
+	defer pqueue.Close()
+
+	size, err := pqueue.Size()
+	assert.Nil(t, err)
+	assert.True(t, size <= 1)
+}
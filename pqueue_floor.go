@@ -0,0 +1,91 @@
+package lane
+
+// priorityBounds holds the priority floor and ceiling the popped items
+// must meet, see SetPriorityFloor and SetPriorityCeiling.
+type priorityBounds struct {
+	floor      int64
+	ceiling    int64
+	hasFloor   bool
+	hasCeiling bool
+}
+
+// admits reports whether an item of the provided priority may be popped
+func (b *priorityBounds) admits(priority int64) bool {
+	return (!b.hasFloor || priority >= b.floor) && (!b.hasCeiling || priority <= b.ceiling)
+}
+
+// SetPriorityFloor makes Pop, PopRelease and WaitPop only return items
+// whose priority is at least p, leaving the other ones queued: while the
+// queue head doesn't meet the floor, Pop and PopRelease return nothing,
+// and WaitPop blocks, as if the queue was empty. Other methods, such as
+// Head, Drain or PopWorst, ignore the floor.
+//
+// Only the queue head is considered: a floor is meant for MAXPQ queues,
+// see SetPriorityCeiling for MINPQ ones. Consumers blocked in WaitPop
+// are handed the items the new floor makes eligible.
+func (pq *PQueue) SetPriorityFloor(p int) {
+	pq.setBounds(func(b *priorityBounds) {
+		b.floor, b.hasFloor = int64(p), true
+	})
+}
+
+// ClearPriorityFloor removes the priority floor set by SetPriorityFloor,
+// handing the queued items over to the consumers blocked in WaitPop.
+func (pq *PQueue) ClearPriorityFloor() {
+	pq.setBounds(func(b *priorityBounds) {
+		b.hasFloor = false
+	})
+}
+
+// SetPriorityCeiling makes Pop, PopRelease and WaitPop only return items
+// whose priority is at most p, leaving the other ones queued. It is the
+// MINPQ counterpart of SetPriorityFloor.
+func (pq *PQueue) SetPriorityCeiling(p int) {
+	pq.setBounds(func(b *priorityBounds) {
+		b.ceiling, b.hasCeiling = int64(p), true
+	})
+}
+
+// ClearPriorityCeiling removes the priority ceiling set by
+// SetPriorityCeiling, handing the queued items over to the consumers
+// blocked in WaitPop.
+func (pq *PQueue) ClearPriorityCeiling() {
+	pq.setBounds(func(b *priorityBounds) {
+		b.hasCeiling = false
+	})
+}
+
+// EligibleHead returns the highest/lowest priority item (depending on
+// whether you're using a MINPQ or MAXPQ) from the priority queue, if it
+// meets the priority floor and ceiling, see SetPriorityFloor. The
+// boolean is false otherwise, or if the queue is empty.
+func (pq *PQueue) EligibleHead() (interface{}, int, bool) {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlockHead()
+	defer pq.RUnlock()
+
+	if !pq.headEligible() {
+		return nil, 0, false
+	}
+
+	return pq.items[1].value, int(pq.items[1].priority), true
+}
+
+// setBounds updates the priority bounds, and hands the items they make
+// eligible over to the blocked consumers.
+func (pq *PQueue) setBounds(update func(b *priorityBounds)) {
+	pq.lock()
+	defer pq.unlock()
+
+	update(&pq.bounds)
+	pq.mergeStaged()
+}
+
+// headEligible reports whether the queue head may be popped. The caller
+// must hold the lock.
+func (pq *PQueue) headEligible() bool {
+	return pq.elemsCount > 0 && pq.bounds.admits(pq.items[1].priority)
+}
@@ -0,0 +1,155 @@
+package lane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueSetPriorityFloor(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for i := 1; i <= 5; i++ {
+		pqueue.Push(i, i)
+	}
+
+	pqueue.SetPriorityFloor(4)
+
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, 5)
+	value, _, ok := pqueue.PopRelease()
+	assert.True(t, ok)
+	assert.Equal(t, value, 4)
+
+	value, _ = pqueue.Pop()
+	assert.Nil(t, value)
+	_, _, ok = pqueue.PopRelease()
+	assert.False(t, ok)
+	_, _, ok = pqueue.EligibleHead()
+	assert.False(t, ok)
+
+	// The items below the floor stay queued, and visible to Head
+	assert.Equal(t, pqueue.Size(), 3)
+	value, _ = pqueue.Head()
+	assert.Equal(t, value, 3)
+
+	pqueue.ClearPriorityFloor()
+	value, priority, ok := pqueue.EligibleHead()
+	assert.True(t, ok)
+	assert.Equal(t, value, 3)
+	assert.Equal(t, priority, 3)
+
+	value, _ = pqueue.Pop()
+	assert.Equal(t, value, 3)
+}
+
+func TestPQueueSetPriorityCeiling(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for i := 1; i <= 5; i++ {
+		pqueue.Push(i, i)
+	}
+
+	pqueue.SetPriorityCeiling(2)
+
+	var popped []interface{}
+	for {
+		value, _ := pqueue.Pop()
+		if value == nil {
+			break
+		}
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{1, 2})
+	assert.Equal(t, pqueue.Size(), 3)
+
+	pqueue.ClearPriorityCeiling()
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, 3)
+}
+
+func TestPQueueSetPriorityFloor_blocked_consumers(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.SetPriorityFloor(10)
+
+	delivered := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, priority, err := pqueue.WaitPop(context.Background())
+			assert.Nil(t, err)
+			delivered <- priority
+		}()
+	}
+	waitForWaiters(t, pqueue, 3)
+
+	// Items below the floor are queued, not delivered
+	for i := 1; i <= 5; i++ {
+		pqueue.Push(i, i)
+	}
+
+	select {
+	case priority := <-delivered:
+		t.Fatalf("item of priority %d delivered below the floor", priority)
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, pqueue.Size(), 5)
+
+	pqueue.Push(10, 10)
+	pqueue.Push(11, 11)
+	assert.True(t, <-delivered >= 10)
+	assert.True(t, <-delivered >= 10)
+
+	// Lowering the floor wakes the last consumer up
+	pqueue.SetPriorityFloor(4)
+	assert.Equal(t, <-delivered, 5)
+	assert.Equal(t, pqueue.Size(), 4)
+
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, 4)
+	value, _ = pqueue.Pop()
+	assert.Nil(t, value)
+
+	pqueue.ClearPriorityFloor()
+	value, _ = pqueue.Pop()
+	assert.Equal(t, value, 3)
+}
+
+func TestPQueueSetPriorityFloor_reprioritized_items_wake_consumers(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("low", 1)
+	pqueue.SetPriorityFloor(10)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+		return priority + 10
+	})
+
+	assert.Equal(t, <-done, "low")
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueSetPriorityFloor_batch(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.SetPriorityFloor(10)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	assert.Equal(t, pqueue.Batch(func() {
+		pqueue.Push("low", 1)
+		pqueue.Push("high", 10)
+		pqueue.SetPriorityFloor(1)
+	}), 1)
+
+	assert.Equal(t, <-done, "high")
+}
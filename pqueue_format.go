@@ -0,0 +1,23 @@
+package lane
+
+import "errors"
+
+// ErrUnsupportedVersion is the error returned when reading a serialized
+// queue whose format version the package can't read.
+var ErrUnsupportedVersion = errors.New("lane: unsupported serialization format version")
+
+// formatVersion is the version of the formats the queues are serialized
+// in: their JSON representation, write-ahead log and operation log.
+//
+// Version 2 records the item deadlines, see PushWithDeadline, into the
+// JSON representation and the write-ahead log. Version 1 is the first,
+// the JSON representation having no version field.
+const formatVersion = 2
+
+// FormatVersion returns the version of the formats the queues are
+// serialized in: their JSON representation, write-ahead log and
+// operation log, which embed it. Queues serialized with the previous
+// format version can still be read.
+func FormatVersion() int {
+	return formatVersion
+}
@@ -0,0 +1,193 @@
+package lane
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The fixtures were written by the package at each format version: the
+// same operations serialized as JSON, into a write-ahead log and into an
+// operation log, version 2 fixtures including items with a deadline of
+// fixtureDeadline.
+var fixtureDeadline = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+func readFixture(t *testing.T, version int, format string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", "formats", fmt.Sprintf("pqueue_v%d.%s", version, format)))
+	assert.Nil(t, err)
+
+	return data
+}
+
+func encodeString(value interface{}) ([]byte, error) {
+	return []byte(fmt.Sprint(value)), nil
+}
+
+func decodeString(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+// popAll pops the items until the queue is empty
+func popAll(pqueue *PQueue) []Item {
+	var items []Item
+	for pqueue.Size() > 0 {
+		value, primary, secondary := pqueue.Pop2()
+		items = append(items, Item{Value: value, Priority: primary, Secondary: secondary})
+	}
+
+	return items
+}
+
+func TestFormatVersion(t *testing.T) {
+	assert.Equal(t, FormatVersion(), 2)
+
+	pqueue := NewPQueue(MAXPQ)
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), `{"version":2,"ordering":"max","items":[]}`)
+
+	var wal bytes.Buffer
+	pqueue, err = NewPQueueWithOptions(MINPQ, WithWAL(&wal, encodeString))
+	assert.Nil(t, err)
+	assert.Nil(t, pqueue.FlushWAL())
+	assert.Equal(t, wal.Bytes(), []byte("LANEWAL\x02\x01"))
+
+	var oplog bytes.Buffer
+	pqueue, err = NewPQueueWithOptions(MAXPQ, WithOpLog(&oplog))
+	assert.Nil(t, err)
+	assert.Nil(t, pqueue.FlushOpLog())
+	assert.Equal(t, oplog.String(), "lane-oplog 2 0 none\n")
+}
+
+func TestPQueueUnmarshalJSON_fixtures(t *testing.T) {
+	expected := []Item{
+		{Value: "first", Priority: 3},
+		{Value: "third", Priority: 3},
+		{Value: "fifth", Priority: 2},
+		{Value: "second", Priority: 1, Secondary: 2},
+	}
+
+	for version, withDeadline := range map[int]bool{1: false, 2: true} {
+		clock := newFakeClock()
+		pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder(), WithClock(clock.Now))
+		assert.Nil(t, err)
+
+		data := readFixture(t, version, "json")
+		assert.Nil(t, json.Unmarshal(data, pqueue))
+
+		// The sequence resumes after the restored items
+		pqueue.Push("pushed", 3)
+
+		if withDeadline {
+			assert.Equal(t, pqueue.Size(), 6)
+			value, _ := pqueue.Head()
+			assert.Equal(t, value, "sixth")
+
+			clock.Advance(24 * time.Hour)
+		}
+
+		items := popAll(pqueue)
+		assert.Equal(t, len(items), 5)
+		assert.Equal(t, items[:2], expected[:2])
+		assert.Equal(t, items[2], Item{Value: "pushed", Priority: 3})
+		assert.Equal(t, items[3:], expected[2:])
+	}
+}
+
+func TestRecoverFromWAL_fixtures(t *testing.T) {
+	for version, withDeadline := range map[int]bool{1: false, 2: true} {
+		clock := newFakeClock()
+		data := readFixture(t, version, "wal")
+
+		pqueue, err := RecoverFromWAL(bytes.NewReader(data), decodeString, WithStableOrder(), WithClock(clock.Now))
+		assert.Nil(t, err)
+
+		expected := []Item{{Value: "B", Priority: 20}, {Value: "C", Priority: 20, Secondary: 9}}
+		if withDeadline {
+			assert.Equal(t, pqueue.Size(), 4)
+			expected = append(expected, Item{Value: "F", Priority: 30})
+		}
+		expected = append(expected, Item{Value: "A", Priority: 40})
+
+		assert.Equal(t, pqueue.Clone().Drain(), expected)
+
+		if withDeadline {
+			clock.Advance(24 * time.Hour)
+			pqueue.Pop()
+			pqueue.Pop()
+			value, _ := pqueue.Pop()
+			assert.Equal(t, value, "A")
+			assert.Equal(t, pqueue.Stats().Expired, uint64(1))
+		}
+	}
+}
+
+func TestReplayOpLog_fixtures(t *testing.T) {
+	values := make(map[string]interface{})
+	for i := 0; i < 6; i++ {
+		values[Fingerprint(i)] = i
+	}
+
+	for _, version := range []int{1, 2} {
+		data := readFixture(t, version, "oplog")
+
+		replayed, err := ReplayOpLog(bytes.NewReader(data), func(fingerprint string) interface{} {
+			return values[fingerprint]
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, replayed.Drain(), []Item{
+			{Value: 1, Priority: 1},
+			{Value: 4, Priority: 1},
+			{Value: 0, Priority: 0},
+			{Value: 3, Priority: 0},
+		})
+	}
+}
+
+func TestPQueueWithDeadline_round_trips(t *testing.T) {
+	clock := newFakeClock()
+	var wal bytes.Buffer
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithClock(clock.Now), WithWAL(&wal, encodeString))
+	assert.Nil(t, err)
+	pqueue.PushWithDeadline("expiring", 2, clock.Now().Add(time.Second))
+	pqueue.Push("kept", 1)
+
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+
+	unmarshaled, err := NewPQueueWithOptions(MAXPQ, WithClock(clock.Now))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, unmarshaled))
+
+	recovered, err := RecoverFromWAL(&wal, decodeString, WithClock(clock.Now))
+	assert.Nil(t, err)
+
+	clock.Advance(time.Second)
+
+	for _, restored := range []*PQueue{unmarshaled, recovered} {
+		value, _ := restored.Pop()
+		assert.Equal(t, value, "kept")
+		assert.Equal(t, restored.Stats().Expired, uint64(1))
+	}
+}
+
+func TestUnsupportedFormatVersions(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	err := json.Unmarshal([]byte(`{"version":3,"ordering":"max","items":[]}`), pqueue)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+
+	_, err = RecoverFromWAL(strings.NewReader("LANEWAL\x03\x01"), decodeString)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+
+	_, err = ReplayOpLog(strings.NewReader("lane-oplog 3 0 none"), func(string) interface{} { return nil })
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
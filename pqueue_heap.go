@@ -0,0 +1,111 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidHeap is the error returned when building a priority queue
+// from items which are not heap ordered.
+var ErrInvalidHeap = errors.New("lane: items are not heap ordered")
+
+// ErrUnsorted is the error returned when building a priority queue from
+// items which are not sorted in pop order.
+var ErrUnsorted = errors.New("lane: items are not sorted")
+
+// RawItems returns a copy of the priority queue heap, in index order.
+//
+// The heap layout is stable: the first item is the queue head, and the
+// children of the item at index i are at indexes 2i+1 and 2i+2. No
+// item has a higher/lower priority (depending on whether the queue is a
+// MAXPQ or MINPQ) than its parent. See NewPQueueFromHeap.
+func (pq *PQueue) RawItems() []Item {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlock()
+	defer pq.RUnlock()
+
+	items := make([]Item, 0, pq.elemsCount)
+	for k := 1; k <= pq.elemsCount; k++ {
+		items = append(items, pq.items[k].export())
+	}
+
+	return items
+}
+
+// NewPQueueFromHeap creates a new priority queue with the provided pqtype
+// ordering type, holding the provided heap ordered items, as returned by
+// RawItems. The items are used as is, after checking in linear time
+// that they are heap ordered: ErrInvalidHeap is returned otherwise.
+func NewPQueueFromHeap(pqType PQType, items []Item) (*PQueue, error) {
+	pq := newPQueueFromItems(pqType, items)
+
+	if err := pq.checkHeap(); err != nil {
+		return nil, err
+	}
+
+	return pq, nil
+}
+
+// NewPQueueFromSorted creates a new priority queue with the provided
+// pqtype ordering type, holding the provided items sorted in pop order,
+// such as the items returned by Drain. As sorted items are heap ordered,
+// they are used as is, after checking in linear time that they are
+// sorted: an ErrUnsorted error holding the first unsorted item index is
+// returned otherwise.
+func NewPQueueFromSorted(pqType PQType, items []Item) (*PQueue, error) {
+	pq := newPQueueFromItems(pqType, items)
+
+	for k := 2; k <= pq.elemsCount; k++ {
+		if pq.less(k-1, k) {
+			return nil, fmt.Errorf("%w: item %d has a %s priority than the previous item",
+				ErrUnsorted, k-1, precedenceWord(pq.pqType))
+		}
+	}
+
+	return pq, nil
+}
+
+// newPQueueFromItems creates a new priority queue holding the items, in
+// their order.
+func newPQueueFromItems(pqType PQType, items []Item) *PQueue {
+	pq := NewPQueue(pqType)
+
+	pq.items = make([]*item, 1, len(items)+1)
+	for _, raw := range items {
+		pq.items = append(pq.items, &item{
+			value:     raw.Value,
+			priority:  int64(raw.Priority),
+			secondary: int64(raw.Secondary),
+			index:     len(pq.items),
+		})
+	}
+	pq.elemsCount = len(items)
+	pq.publishSize()
+
+	return pq
+}
+
+// checkHeap returns an ErrInvalidHeap error if the heap is not ordered.
+// The caller must hold the lock.
+func (pq *PQueue) checkHeap() error {
+	for k := 2; k <= pq.elemsCount; k++ {
+		if pq.less(k/2, k) {
+			return fmt.Errorf("%w: item %d has a %s priority than its parent item %d",
+				ErrInvalidHeap, k-1, precedenceWord(pq.pqType), k/2-1)
+		}
+	}
+
+	return nil
+}
+
+// precedenceWord describes a higher precedence in the pqType ordering
+func precedenceWord(pqType PQType) string {
+	if pqType == MINPQ {
+		return "lower"
+	}
+
+	return "higher"
+}
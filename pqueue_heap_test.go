@@ -0,0 +1,108 @@
+package lane
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueRawItems(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for _, priority := range []int{1, 2, 3} {
+		pqueue.Push(priority*10, priority)
+	}
+
+	// Pushing 1, 2, 3 moves 3 up to the head, and 1 to its right child.
+	assert.Equal(t, pqueue.RawItems(), []Item{{Value: 30, Priority: 3}, {Value: 10, Priority: 1}, {Value: 20, Priority: 2}})
+	assert.Equal(t, NewPQueue(MINPQ).RawItems(), []Item{})
+}
+
+func TestNewPQueueFromHeap_round_trip(t *testing.T) {
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		pqueue := NewPQueue(pqType)
+		for _, priority := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6, 5, 3} {
+			pqueue.Push(priority*10, priority)
+		}
+
+		raw := pqueue.RawItems()
+		restored, err := NewPQueueFromHeap(pqType, raw)
+		assert.Nil(t, err)
+		assert.Equal(t, restored.RawItems(), raw)
+		assert.True(t, assertHeapInvariant(t, restored))
+
+		for pqueue.Size() > 0 {
+			expectedValue, expectedPriority := pqueue.Pop()
+			value, priority := restored.Pop()
+			assert.Equal(t, value, expectedValue)
+			assert.Equal(t, priority, expectedPriority)
+		}
+		assert.Equal(t, restored.Size(), 0)
+	}
+}
+
+func TestNewPQueueFromHeap_empty(t *testing.T) {
+	pqueue, err := NewPQueueFromHeap(MAXPQ, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, pqueue.Size(), 0)
+
+	pqueue.Push("a", 1)
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, "a")
+}
+
+func TestNewPQueueFromHeap_corrupted(t *testing.T) {
+	pqueue, err := NewPQueueFromHeap(MAXPQ, []Item{{Value: "a", Priority: 3}, {Value: "b", Priority: 1}, {Value: "c", Priority: 2}, {Value: "d", Priority: 4}})
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidHeap))
+	assert.Equal(t, err.Error(), "lane: items are not heap ordered: item 3 has a higher priority than its parent item 1")
+
+	pqueue, err = NewPQueueFromHeap(MINPQ, []Item{{Value: "a", Priority: 3}, {Value: "b", Priority: 1}})
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidHeap))
+	assert.Equal(t, err.Error(), "lane: items are not heap ordered: item 1 has a lower priority than its parent item 0")
+}
+
+func TestNewPQueueFromSorted_pops_as_heapified(t *testing.T) {
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		heapified := NewPQueue(pqType)
+		for _, priority := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6, 5, 3} {
+			heapified.Push(priority, priority)
+		}
+
+		sorted := heapified.Clone().Drain()
+		restored, err := NewPQueueFromSorted(pqType, sorted)
+		assert.Nil(t, err)
+		assert.Equal(t, restored.RawItems(), sorted)
+		assert.True(t, assertHeapInvariant(t, restored))
+
+		restored.Push(0, 0)
+		heapified.Push(0, 0)
+		for heapified.Size() > 0 {
+			expectedValue, expectedPriority := heapified.Pop()
+			value, priority := restored.Pop()
+			assert.Equal(t, value, expectedValue)
+			assert.Equal(t, priority, expectedPriority)
+		}
+		assert.Equal(t, restored.Size(), 0)
+	}
+}
+
+func TestNewPQueueFromSorted_empty(t *testing.T) {
+	pqueue, err := NewPQueueFromSorted(MINPQ, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestNewPQueueFromSorted_unsorted(t *testing.T) {
+	// Heap ordered, but not sorted
+	pqueue, err := NewPQueueFromSorted(MAXPQ, []Item{{Value: "a", Priority: 4}, {Value: "b", Priority: 1}, {Value: "c", Priority: 3}, {Value: "d", Priority: 0}})
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrUnsorted))
+	assert.Equal(t, err.Error(), "lane: items are not sorted: item 2 has a higher priority than the previous item")
+
+	pqueue, err = NewPQueueFromSorted(MINPQ, []Item{{Value: "a", Priority: 1}, {Value: "b", Priority: 1}, {Value: "c", Priority: 0}})
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrUnsorted))
+	assert.Equal(t, err.Error(), "lane: items are not sorted: item 2 has a lower priority than the previous item")
+}
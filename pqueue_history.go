@@ -0,0 +1,187 @@
+package lane
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HeadChangeCause tells which kind of operation changed the queue head,
+// see HeadChange.
+type HeadChangeCause int
+
+const (
+	// HeadUpdated means the head changed as items were updated: their
+	// priority or value changed, or the queue ordering did.
+	HeadUpdated HeadChangeCause = iota
+	// HeadPushed means a pushed item became the head.
+	HeadPushed
+	// HeadPopped means the head was popped or otherwise removed.
+	HeadPopped
+)
+
+// String returns the cause name
+func (c HeadChangeCause) String() string {
+	switch c {
+	case HeadPushed:
+		return "push"
+	case HeadPopped:
+		return "pop"
+	default:
+		return "update"
+	}
+}
+
+// HeadChange records a change of the queue head, see WithHeadHistory.
+type HeadChange struct {
+	// Time is when the head changed, as told by the queue clock.
+	Time time.Time
+	// Fingerprint is the FNV-1a hash of the new head value, formatted
+	// with the %v verb, so that the history doesn't keep values
+	// reachable.
+	Fingerprint uint64
+	// Priority is the new head priority.
+	Priority int
+	// Empty is true if the queue became empty, the fingerprint and
+	// priority being zero.
+	Empty bool
+	// Cause is the kind of operation which changed the head.
+	Cause HeadChangeCause
+}
+
+// headHistory is a ring of the last head changes. The fields recording
+// the last seen head are guarded by the queue write lock, the ring by
+// its mutex.
+type headHistory struct {
+	head        *item
+	gen         uint32
+	priority    int64
+	secondary   int64
+	fingerprint uint64
+	cause       HeadChangeCause
+	caused      bool
+	touched     bool
+
+	mu      sync.Mutex
+	changes []HeadChange
+	next    int
+	full    bool
+}
+
+// WithHeadHistory makes the queue record its last n head changes, along
+// with when and why they happened, to be retrieved with HeadHistory.
+// The operations which leave the head unchanged record nothing, and
+// when a single operation changes the head several times, only its final
+// head is recorded along with the cause of its last change.
+func WithHeadHistory(n int) PQueueOption {
+	return func(pq *PQueue) error {
+		if n < 1 {
+			return fmt.Errorf("%w: head history length must be positive, got %d", ErrInvalidOption, n)
+		}
+
+		pq.history = &headHistory{changes: make([]HeadChange, n)}
+		return nil
+	}
+}
+
+// HeadHistory returns the recorded head changes, oldest first, see
+// WithHeadHistory. It doesn't acquire the queue lock, and returns nil if
+// the queue doesn't record its head changes.
+func (pq *PQueue) HeadHistory() []HeadChange {
+	h := pq.history
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]HeadChange(nil), h.changes[:h.next]...)
+	}
+
+	history := make([]HeadChange, 0, len(h.changes))
+	history = append(history, h.changes[h.next:]...)
+
+	return append(history, h.changes[:h.next]...)
+}
+
+// headCause records the cause of a change of the heap head, made by the
+// current locked operation. The caller must hold the write lock.
+func (pq *PQueue) headCause(cause HeadChangeCause) {
+	if h := pq.history; h != nil {
+		h.cause, h.caused = cause, true
+	}
+}
+
+// headTouched records that the value of the heap head changed. The
+// caller must hold the write lock.
+func (pq *PQueue) headTouched(item *item) {
+	if h := pq.history; h != nil && item.index == 1 {
+		h.touched = true
+	}
+}
+
+// recordHead records the heap head if the locked operation changed it.
+// The caller must hold the write lock.
+func (pq *PQueue) recordHead() {
+	h := pq.history
+	if h == nil {
+		return
+	}
+
+	cause, touched := h.cause, h.touched
+	if !h.caused {
+		cause = HeadUpdated
+	}
+	h.caused, h.touched = false, false
+
+	var head *item
+	if pq.elemsCount > 0 {
+		head = pq.items[1]
+	}
+
+	if head == h.head && (head == nil || head.gen == h.gen && head.priority == h.priority && head.secondary == h.secondary && !touched) {
+		return
+	}
+
+	change := HeadChange{Time: pq.now(), Cause: cause, Empty: head == nil}
+	if head != nil {
+		change.Fingerprint = fingerprint(head.value)
+		change.Priority = int(head.priority)
+
+		// The head value was set to an equal one
+		if head == h.head && head.gen == h.gen && head.priority == h.priority && head.secondary == h.secondary && change.Fingerprint == h.fingerprint {
+			return
+		}
+
+		h.gen, h.priority, h.secondary, h.fingerprint = head.gen, head.priority, head.secondary, change.Fingerprint
+	}
+	h.head = head
+
+	h.mu.Lock()
+	h.changes[h.next] = change
+	h.next++
+	if h.next == len(h.changes) {
+		h.next, h.full = 0, true
+	}
+	h.mu.Unlock()
+}
+
+// seedHeadHistory sets the heap head as the last recorded one, without
+// recording it. The caller must hold the write lock.
+func (pq *PQueue) seedHeadHistory() {
+	h := pq.history
+	if h == nil || pq.elemsCount < 1 {
+		return
+	}
+
+	head := pq.items[1]
+	h.head, h.gen, h.priority, h.secondary = head, head.gen, head.priority, head.secondary
+	h.fingerprint = fingerprint(head.value)
+}
+
+// fingerprint returns the FNV-1a hash of the value formatted with %v
+func fingerprint(value interface{}) uint64 {
+	return fnvHash(fmt.Sprintf("%v", value))
+}
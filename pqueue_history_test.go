@@ -0,0 +1,122 @@
+package lane
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPQueueWithOptions_invalid_head_history(t *testing.T) {
+	_, err := NewPQueueWithOptions(MAXPQ, WithHeadHistory(0))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithHeadHistory(8), WithPrefetch())
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+}
+
+// headChange is the head change expected after an operation
+func headChange(clock *fakeClock, value interface{}, priority int, cause HeadChangeCause) HeadChange {
+	return HeadChange{
+		Time:        clock.Now(),
+		Fingerprint: fingerprint(value),
+		Priority:    priority,
+		Cause:       cause,
+	}
+}
+
+func TestPQueueHeadHistory_records_head_transitions(t *testing.T) {
+	clock := newFakeClock()
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithClock(clock.Now), WithHeadHistory(16))
+	assert.Nil(t, err)
+
+	var expected []HeadChange
+	step := func(op func(), change ...HeadChange) {
+		clock.Advance(time.Second)
+		op()
+		for _, c := range change {
+			c.Time = clock.Now()
+			expected = append(expected, c)
+		}
+	}
+
+	step(func() { pqueue.Push("junk", 1) }, headChange(clock, "junk", 1, HeadPushed))
+	step(func() { pqueue.Push("more junk", 1) })
+	step(func() { pqueue.Push("urgent", 9) }, headChange(clock, "urgent", 9, HeadPushed))
+	step(func() { pqueue.Push("normal", 5) })
+	step(func() { pqueue.Pop() }, headChange(clock, "normal", 5, HeadPopped))
+	step(func() {
+		pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+			if value == "junk" {
+				return 7
+			}
+			return priority
+		})
+	}, headChange(clock, "junk", 7, HeadUpdated))
+	step(func() {
+		pqueue.MapValues(func(value interface{}) interface{} {
+			if value == "junk" {
+				return "junk!"
+			}
+			return value
+		})
+	}, headChange(clock, "junk!", 7, HeadUpdated))
+	step(func() { pqueue.MapValues(func(value interface{}) interface{} { return value }) })
+	step(func() { pqueue.RemoveWhere(func(value interface{}, priority int) bool { return value == "more junk" }) })
+	step(func() { pqueue.Pop() }, headChange(clock, "normal", 5, HeadPopped))
+	step(func() { pqueue.Pop() }, HeadChange{Empty: true, Cause: HeadPopped})
+	step(func() { pqueue.Pop() })
+
+	assert.Equal(t, pqueue.HeadHistory(), expected)
+}
+
+func TestPQueueHeadHistory_is_bounded(t *testing.T) {
+	clock := newFakeClock()
+	pqueue, _ := NewPQueueWithOptions(MINPQ, WithClock(clock.Now), WithHeadHistory(3))
+
+	var expected []HeadChange
+	for i := 10; i > 0; i-- {
+		clock.Advance(time.Second)
+		pqueue.Push(i, i)
+		expected = append(expected, headChange(clock, i, i, HeadPushed))
+	}
+
+	assert.Equal(t, pqueue.HeadHistory(), expected[len(expected)-3:])
+}
+
+func TestPQueueHeadHistory_concurrent_reads(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithHeadHistory(8))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			pqueue.Push(i, i)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, len(pqueue.HeadHistory()), 8)
+			return
+		default:
+			assert.True(t, len(pqueue.HeadHistory()) <= 8)
+		}
+	}
+}
+
+func TestPQueueHeadHistory_disabled(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+
+	assert.Nil(t, pqueue.HeadHistory())
+}
+
+func BenchmarkPQueuePush_head_history(b *testing.B) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithHeadHistory(1024))
+	for i := 0; i < b.N; i++ {
+		pqueue.Push(i, i)
+	}
+}
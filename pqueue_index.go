@@ -0,0 +1,121 @@
+package lane
+
+import "fmt"
+
+// valueIndex maps the queued values keys to the items holding them.
+// Items keep track of their own heap position, so that the index is
+// left untouched when items move within the heap.
+type valueIndex struct {
+	key   func(value interface{}) string
+	items map[string][]*item
+}
+
+// WithValueIndex makes the queue maintain an index of its items by the
+// key keyFn returns for their value, so that PriorityOf runs in constant
+// time instead of linear time. keyFn is called while holding the queue
+// lock, and must not call the queue methods.
+func WithValueIndex(keyFn func(value interface{}) string) PQueueOption {
+	return func(pq *PQueue) error {
+		if keyFn == nil {
+			return fmt.Errorf("%w: nil value index key function", ErrInvalidOption)
+		}
+
+		pq.index = &valueIndex{key: keyFn, items: make(map[string][]*item)}
+		return nil
+	}
+}
+
+// PriorityOf returns the priority of the queued value, the highest/lowest
+// one (depending on whether you're using a MINPQ or MAXPQ) if it was
+// pushed several times. The boolean is false if the value isn't queued.
+//
+// Using a value index, see WithValueIndex, values are looked up by key.
+// Otherwise, they are compared as Contains does, in linear time.
+func (pq *PQueue) PriorityOf(value interface{}) (int, bool) {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlock()
+	defer pq.RUnlock()
+
+	var priority int64
+	found := false
+	best := func(candidate *item) {
+		if !found || pq.comparator(priority, candidate.priority) {
+			priority = candidate.priority
+			found = true
+		}
+	}
+
+	if pq.index != nil {
+		for _, candidate := range pq.index.items[pq.index.key(value)] {
+			best(candidate)
+		}
+
+		return int(priority), found
+	}
+
+	if !comparableValue(value) {
+		return 0, false
+	}
+
+	for k := 1; k <= pq.elemsCount; k++ {
+		if candidate := pq.items[k]; candidate.value == value {
+			best(candidate)
+		}
+	}
+
+	return int(priority), found
+}
+
+// indexAdd adds the item, which was just inserted into the heap, to the
+// value index if the queue uses one. The caller must hold the write
+// lock.
+func (pq *PQueue) indexAdd(item *item) {
+	if pq.index == nil {
+		return
+	}
+
+	key := pq.index.key(item.value)
+	pq.index.items[key] = append(pq.index.items[key], item)
+}
+
+// indexRemove removes the item, which is about to leave the heap or to
+// change value, from the value index if the queue uses one. The caller
+// must hold the write lock.
+func (pq *PQueue) indexRemove(item *item) {
+	if pq.index == nil {
+		return
+	}
+
+	key := pq.index.key(item.value)
+	items := pq.index.items[key]
+
+	for i, candidate := range items {
+		if candidate != item {
+			continue
+		}
+
+		last := len(items) - 1
+		items[i] = items[last]
+		items[last] = nil
+		items = items[:last]
+		break
+	}
+
+	if len(items) == 0 {
+		delete(pq.index.items, key)
+		return
+	}
+
+	pq.index.items[key] = items
+}
+
+// indexReset empties the value index if the queue uses one. The caller
+// must hold the write lock.
+func (pq *PQueue) indexReset() {
+	if pq.index != nil {
+		pq.index.items = make(map[string][]*item)
+	}
+}
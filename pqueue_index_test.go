@@ -0,0 +1,234 @@
+package lane
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertIndexConsistent checks that the queue value index holds exactly
+// the heap items.
+func assertIndexConsistent(t *testing.T, pqueue *PQueue) {
+	t.Helper()
+
+	indexed := 0
+	for key, items := range pqueue.index.items {
+		assert.NotEmpty(t, items, "key %q", key)
+
+		for _, item := range items {
+			assert.True(t, pqueue.contains(item), "key %q", key)
+			assert.Equal(t, pqueue.index.key(item.value), key)
+		}
+		indexed += len(items)
+	}
+
+	assert.Equal(t, indexed, pqueue.elemsCount)
+}
+
+func TestNewPQueueWithOptions_invalid_value_index(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(nil))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueuePriorityOf(t *testing.T) {
+	indexed, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(stringKey))
+	assert.Nil(t, err)
+
+	testCases := map[string]*PQueue{
+		"scan":  NewPQueue(MAXPQ),
+		"index": indexed,
+	}
+
+	for name, pqueue := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, ok := pqueue.PriorityOf("a")
+			assert.False(t, ok)
+
+			pqueue.Push("a", 1)
+			pqueue.Push("b", 2)
+
+			priority, ok := pqueue.PriorityOf("a")
+			assert.True(t, ok)
+			assert.Equal(t, priority, 1)
+
+			_, ok = pqueue.PriorityOf("c")
+			assert.False(t, ok)
+
+			pqueue.Pop()
+			_, ok = pqueue.PriorityOf("b")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestPQueuePriorityOf_duplicates_best_priority(t *testing.T) {
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		for _, options := range [][]PQueueOption{nil, {WithValueIndex(stringKey)}} {
+			pqueue, err := NewPQueueWithOptions(pqType, options...)
+			assert.Nil(t, err)
+
+			pqueue.Push("a", 5)
+			pqueue.Push("a", 9)
+			pqueue.Push("a", 1)
+
+			best, worst := 9, 1
+			if pqType == MINPQ {
+				best, worst = 1, 9
+			}
+
+			priority, ok := pqueue.PriorityOf("a")
+			assert.True(t, ok)
+			assert.Equal(t, priority, best)
+
+			// Once the best one is popped, the next best one is found
+			pqueue.Pop()
+			priority, _ = pqueue.PriorityOf("a")
+			assert.Equal(t, priority, 5)
+
+			pqueue.Pop()
+			priority, _ = pqueue.PriorityOf("a")
+			assert.Equal(t, priority, worst)
+		}
+	}
+}
+
+func TestPQueuePriorityOf_uncomparable_values(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push([]int{1}, 1)
+
+	_, ok := pqueue.PriorityOf([]int{1})
+	assert.False(t, ok)
+
+	// A value index can find them
+	indexed, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(stringKey))
+	assert.Nil(t, err)
+	indexed.Push([]int{1}, 1)
+
+	priority, ok := indexed.PriorityOf([]int{1})
+	assert.True(t, ok)
+	assert.Equal(t, priority, 1)
+}
+
+func TestPQueuePriorityOf_with_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(64, 0), WithValueIndex(stringKey))
+	assert.Nil(t, err)
+
+	pqueue.Push("a", 1)
+
+	priority, ok := pqueue.PriorityOf("a")
+	assert.True(t, ok)
+	assert.Equal(t, priority, 1)
+}
+
+func TestPQueueValueIndex_follows_mutations(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(stringKey), WithArena(8))
+	assert.Nil(t, err)
+
+	random := rand.New(rand.NewSource(1))
+	for round := 0; round < 2000; round++ {
+		switch op := random.Intn(10); op {
+		case 0:
+			pqueue.Pop()
+		case 1:
+			pqueue.PopWorst()
+		case 2:
+			pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+				return priority%7 == 0
+			})
+		case 3:
+			pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+				return priority + random.Intn(3) - 1
+			})
+		case 4:
+			pqueue.MapValues(func(value interface{}) interface{} {
+				return value.(int) + 1
+			})
+		case 5:
+			pqueue.Edit(func(c *Cursor) {
+				for c.Next() {
+					if c.Priority()%5 == 0 {
+						c.Remove()
+					}
+				}
+			})
+		default:
+			pqueue.Push(random.Intn(20), random.Intn(100))
+		}
+
+		assertIndexConsistent(t, pqueue)
+	}
+
+	// Every queued value is found, at its best priority
+	best := make(map[interface{}]int)
+	for _, item := range pqueue.RawItems() {
+		if priority, ok := best[item.Value]; !ok || item.Priority > priority {
+			best[item.Value] = item.Priority
+		}
+	}
+
+	for value, expected := range best {
+		priority, ok := pqueue.PriorityOf(value)
+		assert.True(t, ok)
+		assert.Equal(t, priority, expected, "value %v", value)
+	}
+
+	clone := pqueue.Clone()
+	assertIndexConsistent(t, clone)
+
+	pqueue.Drain()
+	assertIndexConsistent(t, pqueue)
+	assert.Empty(t, pqueue.index.items)
+}
+
+func TestPQueueValueIndex_unmarshal(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(stringKey))
+	assert.Nil(t, err)
+	pqueue.Push("stale", 1)
+
+	data, err := json.Marshal(func() *PQueue {
+		source := NewPQueue(MAXPQ)
+		source.Push("a", 1)
+		source.Push("b", 2)
+		return source
+	}())
+	assert.Nil(t, err)
+
+	assert.Nil(t, json.Unmarshal(data, pqueue))
+	assertIndexConsistent(t, pqueue)
+
+	_, ok := pqueue.PriorityOf("stale")
+	assert.False(t, ok)
+
+	priority, ok := pqueue.PriorityOf("b")
+	assert.True(t, ok)
+	assert.Equal(t, priority, 2)
+}
+
+func BenchmarkPQueuePriorityOf(b *testing.B) {
+	for _, indexed := range []bool{false, true} {
+		var options []PQueueOption
+		if indexed {
+			options = append(options, WithValueIndex(stringKey))
+		}
+
+		pqueue, err := NewPQueueWithOptions(MAXPQ, options...)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for i := 0; i < 10000; i++ {
+			pqueue.Push(fmt.Sprint(i), i)
+		}
+
+		b.Run(fmt.Sprintf("indexed=%v", indexed), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pqueue.PriorityOf(fmt.Sprint(i % 10000))
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package lane
+
+import "fmt"
+
+// internTable shares a single reference to the equal values queued at
+// the same time, see WithInterning. It is guarded by the queue write
+// lock.
+type internTable struct {
+	key     func(value interface{}) (string, bool)
+	entries map[string]*internEntry
+	hits    uint64
+	misses  uint64
+}
+
+// internEntry is an interned value, along with the count of queued items
+// referencing it.
+type internEntry struct {
+	value interface{}
+	refs  int
+}
+
+// WithInterning makes the queue intern the values for which key returns
+// true: the queued items whose values share a key reference the value of
+// the first of them queued, so that a single copy of repeated values,
+// such as event type names, is retained. Values are interned as they
+// enter the heap, popping one of them returns the shared reference.
+//
+// The interned values are forgotten once no queued item references them
+// anymore. Values must not be mutated in place in a way that changes
+// their key while queued. Stats reports the interned values count and
+// the interning hits.
+func WithInterning(key func(value interface{}) (string, bool)) PQueueOption {
+	return func(pq *PQueue) error {
+		if key == nil {
+			return fmt.Errorf("%w: nil interning key function", ErrInvalidOption)
+		}
+
+		pq.intern = &internTable{key: key, entries: make(map[string]*internEntry)}
+		return nil
+	}
+}
+
+// internAdd makes the item, which is entering the heap, reference the
+// interned copy of its value if the queue interns values. The caller must
+// hold the write lock.
+func (pq *PQueue) internAdd(item *item) {
+	t := pq.intern
+	if t == nil {
+		return
+	}
+
+	key, ok := t.key(item.value)
+	if !ok {
+		return
+	}
+
+	if entry, found := t.entries[key]; found {
+		item.value = entry.value
+		entry.refs++
+		t.hits++
+
+		return
+	}
+
+	t.entries[key] = &internEntry{value: item.value, refs: 1}
+	t.misses++
+}
+
+// internRemove releases the interned copy of the value of the item, which
+// is about to leave the heap or to change value. The caller must hold the
+// write lock.
+func (pq *PQueue) internRemove(item *item) {
+	if key, ok := pq.internKey(item); ok {
+		pq.internForget(key)
+	}
+}
+
+// internKey returns the interning key of the item value. The boolean is
+// false if the queue doesn't intern the value. The caller must hold the
+// write lock.
+func (pq *PQueue) internKey(item *item) (string, bool) {
+	if pq.intern == nil {
+		return "", false
+	}
+
+	return pq.intern.key(item.value)
+}
+
+// internForget drops a reference to the value interned under the key.
+// The caller must hold the write lock.
+func (pq *PQueue) internForget(key string) {
+	t := pq.intern
+	if entry, found := t.entries[key]; found {
+		if entry.refs--; entry.refs == 0 {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// internReset forgets the interned values. The caller must hold the
+// write lock.
+func (pq *PQueue) internReset() {
+	if pq.intern != nil {
+		pq.intern.entries = make(map[string]*internEntry)
+	}
+}
@@ -0,0 +1,153 @@
+package lane
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// event is a value whose kind is repeated across events
+type event struct {
+	kind string
+}
+
+func internEventKind(value interface{}) (string, bool) {
+	e, ok := value.(*event)
+	if !ok {
+		return "", false
+	}
+
+	return e.kind, true
+}
+
+func TestNewPQueueWithOptions_invalid_interning(t *testing.T) {
+	_, err := NewPQueueWithOptions(MAXPQ, WithInterning(nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueueInterning_shares_equal_values(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithInterning(internEventKind))
+	assert.Nil(t, err)
+
+	first := &event{"click"}
+	pqueue.Push(first, 3)
+	pqueue.Push(&event{"click"}, 2)
+	pqueue.Push(&event{"scroll"}, 1)
+	pqueue.Push("not an event", 0)
+
+	value, _ := pqueue.Pop()
+	assert.True(t, value.(*event) == first)
+	value, _ = pqueue.Pop()
+	assert.True(t, value.(*event) == first)
+	value, _ = pqueue.Pop()
+	assert.Equal(t, value.(*event).kind, "scroll")
+	value, _ = pqueue.Pop()
+	assert.Equal(t, value, "not an event")
+
+	stats := pqueue.Stats()
+	assert.Equal(t, stats.Interned, 0)
+	assert.Equal(t, stats.InternHits, uint64(1))
+	assert.Equal(t, stats.InternMisses, uint64(2))
+}
+
+func TestPQueueInterning_prefetched_values(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithInterning(internEventKind), WithPrefetch())
+	assert.Nil(t, err)
+
+	pqueue.Push(&event{"click"}, 2)
+	pqueue.Push(&event{"click"}, 1)
+	for pqueue.Size() > 0 {
+		pqueue.Pop()
+	}
+
+	// The removal of the value popped last completes with the refill
+	waitFor(t, func() bool {
+		return pqueue.Stats().Interned == 0
+	})
+}
+
+func TestPQueueInterning_forgets_unreferenced_values(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithInterning(internEventKind))
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		pqueue.Push(&event{"click"}, i)
+		pqueue.Push(&event{"scroll"}, i)
+	}
+	pqueue.Push(&event{"key"}, 20)
+	assert.Equal(t, pqueue.Stats().Interned, 3)
+
+	pqueue.Pop()
+	assert.Equal(t, pqueue.Stats().Interned, 2)
+
+	removed := pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+		return value.(*event).kind == "scroll"
+	})
+	assert.Equal(t, removed, 10)
+	assert.Equal(t, pqueue.Stats().Interned, 1)
+
+	// A value set over the last reference to an interned value replaces it
+	pqueue.MapValues(func(value interface{}) interface{} {
+		return &event{"tap"}
+	})
+	assert.Equal(t, pqueue.Stats().Interned, 1)
+
+	drained := pqueue.Drain()
+	assert.Equal(t, len(drained), 10)
+	assert.True(t, drained[0].Value.(*event) == drained[9].Value.(*event))
+	assert.Equal(t, pqueue.Stats().Interned, 0)
+}
+
+func TestPQueueInterning_clone_has_its_own_table(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithInterning(internEventKind))
+	assert.Nil(t, err)
+
+	pqueue.Push(&event{"click"}, 2)
+	pqueue.Push(&event{"click"}, 1)
+
+	clone := pqueue.Clone()
+	assert.Equal(t, clone.Stats().Interned, 1)
+
+	clone.Drain()
+	assert.Equal(t, clone.Stats().Interned, 0)
+	assert.Equal(t, pqueue.Stats().Interned, 1)
+
+	original, _ := pqueue.Head()
+	pqueue.Push(&event{"click"}, 0)
+	pqueue.Pop()
+	pqueue.Pop()
+	value, _ := pqueue.Pop()
+	assert.True(t, value.(*event) == original.(*event))
+}
+
+func TestPQueueInterning_json_round_trip(t *testing.T) {
+	internString := func(value interface{}) (string, bool) {
+		s, ok := value.(string)
+		return s, ok
+	}
+
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithInterning(internString))
+	assert.Nil(t, err)
+	pqueue.Push("a", 1)
+	pqueue.Push("a", 2)
+	pqueue.Push("b", 3)
+
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+
+	restored, err := NewPQueueWithOptions(MINPQ, WithInterning(internString))
+	assert.Nil(t, err)
+	restored.Push("c", 0)
+	assert.Nil(t, json.Unmarshal(data, restored))
+	assert.Equal(t, restored.Size(), 3)
+
+	stats := restored.Stats()
+	assert.Equal(t, stats.Interned, 2)
+	assert.Equal(t, stats.InternHits, uint64(1))
+	assert.Equal(t, stats.InternMisses, uint64(3))
+
+	value, _ := restored.Pop()
+	assert.Equal(t, value, "a")
+}
@@ -0,0 +1,248 @@
+package lane
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// jsonPQueue is the PQueue JSON representation, items being stored
+// in heap order. Version 1 representations have no version field.
+type jsonPQueue struct {
+	Version  int        `json:"version"`
+	Ordering string     `json:"ordering"`
+	Items    []jsonItem `json:"items"`
+}
+
+type jsonItem struct {
+	Value     json.RawMessage `json:"value"`
+	Priority  int64           `json:"priority"`
+	Secondary int64           `json:"secondary,omitempty"`
+	Seq       uint64          `json:"seq,omitempty"`
+	Deadline  int64           `json:"deadline,omitempty"`
+}
+
+// WithJSONValueDecoder sets the function used by UnmarshalJSON to decode
+// each item value. By default values are decoded as encoding/json does
+// for interface{} values.
+func WithJSONValueDecoder(decode func(json.RawMessage) (interface{}, error)) PQueueOption {
+	return func(pq *PQueue) error {
+		if decode == nil {
+			return fmt.Errorf("%w: nil JSON value decoder", ErrInvalidOption)
+		}
+
+		pq.jsonValueDecoder = decode
+		return nil
+	}
+}
+
+// MarshalJSON implements the json.Marshaler interface. Values which are
+// json.RawMessage, or which implement json.Marshaler, are embedded
+// verbatim in the output. Note that encoding/json compacts the output
+// of Marshalers: call MarshalJSON directly to keep the values bytes
+// exactly as they are.
+func (pq *PQueue) MarshalJSON() ([]byte, error) {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	var buf bytes.Buffer
+
+	buf.WriteString(`{"version":`)
+	buf.WriteString(strconv.Itoa(formatVersion))
+	buf.WriteString(`,"ordering":`)
+	buf.WriteString(strconv.Quote(orderingName(pq.pqType)))
+	buf.WriteString(`,"items":[`)
+
+	for k := 1; k <= pq.elemsCount; k++ {
+		if k > 1 {
+			buf.WriteByte(',')
+		}
+
+		value, err := marshalJSONValue(pq.items[k].value)
+		if err != nil {
+			return nil, pq.newError("marshal", err)
+		}
+
+		buf.WriteString(`{"value":`)
+		buf.Write(value)
+		buf.WriteString(`,"priority":`)
+		buf.WriteString(strconv.FormatInt(pq.items[k].priority, 10))
+		if secondary := pq.items[k].secondary; secondary != 0 {
+			buf.WriteString(`,"secondary":`)
+			buf.WriteString(strconv.FormatInt(secondary, 10))
+		}
+		if seq := pq.items[k].seq; seq != 0 {
+			buf.WriteString(`,"seq":`)
+			buf.WriteString(strconv.FormatUint(seq, 10))
+		}
+		if deadline := pq.items[k].deadline; deadline != 0 {
+			buf.WriteString(`,"deadline":`)
+			buf.WriteString(strconv.FormatInt(deadline, 10))
+		}
+		buf.WriteByte('}')
+	}
+
+	buf.WriteString(`]}`)
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It replaces
+// the queue content and ordering with the decoded ones, or leaves them
+// untouched when it returns an error. Representations
+// of the current and previous format versions are decoded, see
+// FormatVersion.
+//
+// The decoded items must be heap ordered, as MarshalJSON encodes them:
+// an ErrInvalidHeap error identifying the first misplaced item is
+// returned otherwise, unless the queue repairs them, see
+// WithRepairOnLoad.
+func (pq *PQueue) UnmarshalJSON(data []byte) error {
+	pq.lock()
+	defer pq.unlock()
+
+	if pq.closed {
+		return pq.newError("unmarshal", ErrClosed)
+	}
+
+	var decoded jsonPQueue
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return pq.newError("unmarshal", err)
+	}
+
+	// Version 1 has no version field, nor deadlines, which decode as zero
+	if decoded.Version < 0 || decoded.Version > formatVersion {
+		return pq.newError("unmarshal", fmt.Errorf("%w: JSON version %d", ErrUnsupportedVersion, decoded.Version))
+	}
+
+	pqType, err := parseOrdering(decoded.Ordering)
+	if err != nil {
+		return pq.newError("unmarshal", err)
+	}
+
+	// Items encoded without their stable order sequence are numbered
+	// after the ones encoded with it.
+	for _, encoded := range decoded.Items {
+		pq.advanceSequence(encoded.Seq)
+	}
+
+	// The items are all decoded, checked and encoded into the write-ahead
+	// log before the queue content is replaced, so that a failure leaves
+	// it untouched.
+	items := make([]*item, 0, len(decoded.Items))
+	discard := func(err error) error {
+		for _, item := range items {
+			pq.release(item)
+		}
+
+		return pq.newError("unmarshal", err)
+	}
+
+	for _, encoded := range decoded.Items {
+		value, err := pq.unmarshalJSONValue(encoded.Value)
+		if err != nil {
+			return discard(err)
+		}
+
+		item := pq.newItem(value, encoded.Priority)
+		item.secondary = encoded.Secondary
+		item.deadline = encoded.Deadline
+		if encoded.Seq != 0 {
+			item.seq = encoded.Seq
+		}
+		items = append(items, item)
+	}
+
+	// The items are encoded in heap order, see WithRepairOnLoad
+	repaired, err := pq.checkLoaded(pqType, items)
+	if err != nil {
+		return discard(err)
+	}
+
+	payloads, err := pq.walEncode(items)
+	if err != nil {
+		return discard(err)
+	}
+
+	pq.reset(pqType)
+	if pq.repair != nil {
+		pq.repair.repaired += uint64(repaired)
+	}
+	for i, item := range items {
+		if payloads != nil {
+			pq.walPushEncoded(item, payloads[i])
+		}
+
+		pq.enqueue(item)
+	}
+
+	if pq.wal != nil && pq.wal.err != nil {
+		return pq.newError("unmarshal", pq.wal.err)
+	}
+
+	return nil
+}
+
+func (pq *PQueue) unmarshalJSONValue(data json.RawMessage) (interface{}, error) {
+	if pq.jsonValueDecoder != nil {
+		return pq.jsonValueDecoder(data)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func marshalJSONValue(value interface{}) ([]byte, error) {
+	marshaler, ok := value.(json.Marshaler)
+	if !ok {
+		return json.Marshal(value)
+	}
+
+	// Nil pointers are encoded as null, as encoding/json does, rather
+	// than calling their MarshalJSON method.
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+		return []byte("null"), nil
+	}
+
+	if raw, ok := value.(json.RawMessage); ok && raw == nil {
+		return []byte("null"), nil
+	}
+
+	data, err := marshaler.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("lane: invalid JSON value %q for type %T", data, value)
+	}
+
+	return data, nil
+}
+
+func orderingName(pqType PQType) string {
+	if pqType == MINPQ {
+		return "min"
+	}
+
+	return "max"
+}
+
+func parseOrdering(name string) (PQType, error) {
+	switch name {
+	case "max":
+		return MAXPQ, nil
+	case "min":
+		return MINPQ, nil
+	}
+
+	return MAXPQ, fmt.Errorf("lane: unknown priority queue ordering %q", name)
+}
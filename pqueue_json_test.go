@@ -0,0 +1,241 @@
+package lane
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type indentedPayload struct {
+	Name string
+}
+
+// MarshalJSON produces non compact JSON, which encoding/json would
+// have reformatted.
+func (p indentedPayload) MarshalJSON() ([]byte, error) {
+	return []byte("{ \"Name\": \"" + p.Name + "\" }"), nil
+}
+
+func TestPQueueMarshalJSON_round_trip(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	pqueue.Push("easy as", 3)
+	pqueue.Push(map[string]interface{}{"nested": []interface{}{1.0, "two"}}, 2)
+	pqueue.Push(4.5, 4)
+	pqueue.Push(nil, 1)
+
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+
+	decoded := NewPQueue(MAXPQ)
+	assert.Nil(t, json.Unmarshal(data, decoded))
+
+	assert.Equal(t, decoded.pqType, MINPQ)
+	assert.Equal(t, decoded.Size(), 4)
+
+	expected := []interface{}{nil, map[string]interface{}{"nested": []interface{}{1.0, "two"}}, "easy as", 4.5}
+	for i, value := range expected {
+		decodedValue, priority := decoded.Pop()
+		assert.Equal(t, decodedValue, value)
+		assert.Equal(t, priority, i+1)
+	}
+}
+
+func TestPQueueMarshalJSON_embeds_raw_messages_verbatim(t *testing.T) {
+	raw := []json.RawMessage{
+		json.RawMessage(`{"a": [1, 2,  {"b": null}], "c": "d"}`),
+		json.RawMessage(`[ "spaced" ,  true ]`),
+		json.RawMessage(`42`),
+	}
+
+	pqueue := NewPQueue(MAXPQ)
+	for i, value := range raw {
+		pqueue.Push(value, i)
+	}
+
+	data, err := pqueue.MarshalJSON()
+	assert.Nil(t, err)
+	for _, value := range raw {
+		assert.True(t, bytes.Contains(data, value), "%s not found in %s", value, data)
+	}
+
+	decoded, err := NewPQueueWithOptions(MAXPQ, WithJSONValueDecoder(func(data json.RawMessage) (interface{}, error) {
+		return append(json.RawMessage(nil), data...), nil
+	}))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, decoded))
+
+	for i := len(raw) - 1; i >= 0; i-- {
+		value, priority := decoded.Pop()
+		assert.Equal(t, []byte(value.(json.RawMessage)), []byte(raw[i]))
+		assert.Equal(t, priority, i)
+	}
+}
+
+func TestPQueueMarshalJSON_embeds_marshalers_verbatim(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push(indentedPayload{"lane"}, 1)
+
+	data, err := pqueue.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), `{"version":2,"ordering":"max","items":[{"value":{ "Name": "lane" },"priority":1}]}`)
+}
+
+func TestPQueueMarshalJSON_encodes_nil_marshalers_as_null(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push((*time.Time)(nil), 1)
+
+	data, err := pqueue.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), `{"version":2,"ordering":"max","items":[{"value":null,"priority":1}]}`)
+}
+
+func TestPQueueMarshalJSON_rejects_invalid_raw_messages(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push(json.RawMessage(`{"broken"`), 1)
+
+	_, err := json.Marshal(pqueue)
+	assert.NotNil(t, err)
+}
+
+type domainTask struct {
+	ID    int      `json:"id"`
+	Tags  []string `json:"tags"`
+	Owner struct {
+		Name string `json:"name"`
+	} `json:"owner"`
+}
+
+func TestPQueueUnmarshalJSON_value_decoder(t *testing.T) {
+	task := domainTask{ID: 7, Tags: []string{"urgent", "db"}}
+	task.Owner.Name = "ops"
+
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push(task, 10)
+
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+
+	decoded, err := NewPQueueWithOptions(MINPQ, WithJSONValueDecoder(func(data json.RawMessage) (interface{}, error) {
+		var task domainTask
+		err := json.Unmarshal(data, &task)
+		return task, err
+	}))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, decoded))
+
+	value, priority := decoded.Pop()
+	assert.Equal(t, value, task)
+	assert.Equal(t, priority, 10)
+}
+
+func TestPQueueUnmarshalJSON_unknown_ordering(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("1", 1)
+
+	err := json.Unmarshal([]byte(`{"ordering":"sideways","items":[]}`), pqueue)
+	assert.NotNil(t, err)
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueMarshalJSON_stores_64_bits_priorities(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push64("later", 1<<40)
+	pqueue.Push64("sooner", 1<<40-1)
+
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"priority":1099511627776`)
+
+	decoded := NewPQueue(MINPQ)
+	assert.Nil(t, json.Unmarshal(data, decoded))
+
+	value, priority := decoded.Pop64()
+	assert.Equal(t, value, "later")
+	assert.Equal(t, priority, int64(1<<40))
+}
+
+func TestPQueueUnmarshalJSON_keeps_stable_order(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, 1)
+	}
+	for i := 0; i < 3; i++ {
+		pqueue.Pop()
+	}
+
+	data, err := json.Marshal(pqueue)
+	assert.Nil(t, err)
+
+	// The restored queue numbers its pushes after the restored items
+	restored, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+	restored.Push("before", 1)
+	assert.Nil(t, json.Unmarshal(data, restored))
+	for i := 10; i < 13; i++ {
+		restored.Push(i, 1)
+	}
+
+	popped := []interface{}{}
+	for restored.Size() > 0 {
+		value, _ := restored.Pop()
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{3.0, 4.0, 5.0, 6.0, 7.0, 8.0, 9.0, 10, 11, 12})
+
+	// Items encoded without their sequence follow the ones encoded with it
+	restored = NewPQueue(MAXPQ)
+	restored.stable = true
+	assert.Nil(t, json.Unmarshal([]byte(`{"ordering":"max","items":[{"value":"b","priority":1,"seq":7},{"value":"c","priority":1},{"value":"a","priority":1,"seq":5}]}`), restored))
+	restored.Push("d", 1)
+
+	popped = popped[:0]
+	for restored.Size() > 0 {
+		value, _ := restored.Pop()
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{"a", "b", "c", "d"})
+}
+
+func TestPQueueUnmarshalJSON_leaves_queue_untouched_on_error(t *testing.T) {
+	data := []byte(`{"ordering":"min","items":[{"value":1,"priority":1},{"value":"two","priority":2}]}`)
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithJSONValueDecoder(func(data json.RawMessage) (interface{}, error) {
+		var value int
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}))
+	assert.Nil(t, err)
+	pqueue.Push("a", 1)
+
+	assert.NotNil(t, json.Unmarshal(data, pqueue))
+	assert.Equal(t, pqueue.Size(), 1)
+	assert.Equal(t, pqueue.pqType, MAXPQ)
+
+	// Values failing to be recorded into the write-ahead log abort the
+	// load before the queue is reset.
+	var log bytes.Buffer
+	failing := errors.New("unencodable")
+	pqueue, err = NewPQueueWithOptions(MAXPQ, WithWAL(&log, func(value interface{}) ([]byte, error) {
+		if value == "two" {
+			return nil, failing
+		}
+		return []byte(value.(string)), nil
+	}))
+	assert.Nil(t, err)
+	pqueue.Push("a", 1)
+	recorded := log.Len()
+
+	err = json.Unmarshal([]byte(`{"ordering":"max","items":[{"value":"one","priority":2},{"value":"two","priority":1}]}`), pqueue)
+	assert.True(t, errors.Is(err, failing))
+	assert.Equal(t, log.Len(), recorded)
+
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, "a")
+	assert.Equal(t, priority, 1)
+}
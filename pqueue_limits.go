@@ -0,0 +1,294 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrFull is returned when pushing an item into a priority queue
+// whose limits, see WithMaxItems and WithMaxBytes, don't allow it
+// to fit in.
+var ErrFull = errors.New("lane: priority queue is full")
+
+// OverflowPolicy represents the behaviour of a priority queue when a
+// pushed item doesn't fit in its limits (see RejectWhenFull and
+// EvictWhenFull)
+type OverflowPolicy int
+
+const (
+	// RejectWhenFull makes Push return ErrFull.
+	RejectWhenFull OverflowPolicy = iota
+	// EvictWhenFull makes Push evict the lowest precedence items
+	// until the pushed one fits in. ErrFull is still returned if
+	// the pushed item has a lower precedence than the evicted ones
+	// would have. Finding each evicted item scans the heap leaves, so
+	// that pushing into a full queue runs in linear time rather than
+	// logarithmic.
+	EvictWhenFull
+)
+
+// PQueueStats holds statistics about a priority queue usage.
+type PQueueStats struct {
+	// Size is the count of queued items.
+	Size int
+	// Bytes is the estimated size of the queued values, see
+	// WithSizeEstimator.
+	Bytes int64
+	// Evictions is the count of items evicted to make room for
+	// pushed ones.
+	Evictions uint64
+	// Handoffs is the count of items handed over to blocked WaitPop
+	// consumers, each of them waking a consumer up.
+	Handoffs uint64
+	// Reallocations is the count of times the heap backing array was
+	// reallocated to shrink it, see WithShrinkPolicy and Compact.
+	Reallocations uint64
+	// Expired is the count of items discarded because their deadline
+	// passed, see PushWithDeadline.
+	Expired uint64
+	// Waiters is the count of consumers blocked in WaitPop.
+	Waiters int
+	// Repaired is the count of loaded items which were moved to restore
+	// the heap order, see WithRepairOnLoad.
+	Repaired uint64
+	// Interned is the count of interned values, see WithInterning.
+	Interned int
+	// InternHits is the count of values which entered the queue as a
+	// reference to an interned value, InternMisses the count of the
+	// ones which were interned.
+	InternHits   uint64
+	InternMisses uint64
+	// PushLock is the lock usage by Push, see WithContentionProfiling.
+	PushLock LockStats
+	// PopLock is the lock usage by Pop and WaitPop, see
+	// WithContentionProfiling.
+	PopLock LockStats
+}
+
+// WithMaxItems limits the count of items the queue may hold to n.
+func WithMaxItems(n int) PQueueOption {
+	return func(pq *PQueue) error {
+		if n < 1 {
+			return fmt.Errorf("%w: max items must be positive, got %d", ErrInvalidOption, n)
+		}
+
+		pq.maxItems = n
+		return nil
+	}
+}
+
+// WithMaxBytes limits the estimated size of the values the queue may
+// hold to b bytes. It requires a size estimator, see WithSizeEstimator.
+func WithMaxBytes(b int64) PQueueOption {
+	return func(pq *PQueue) error {
+		if b < 1 {
+			return fmt.Errorf("%w: max bytes must be positive, got %d", ErrInvalidOption, b)
+		}
+
+		pq.maxBytes = b
+		return nil
+	}
+}
+
+// WithSizeEstimator sets the function estimating pushed values size in
+// bytes. It is called once per pushed item, and its result is kept
+// along with the item for accounting.
+func WithSizeEstimator(estimate func(value interface{}) int) PQueueOption {
+	return func(pq *PQueue) error {
+		if estimate == nil {
+			return fmt.Errorf("%w: nil size estimator", ErrInvalidOption)
+		}
+
+		pq.sizeEstimator = estimate
+		return nil
+	}
+}
+
+// WithOverflowPolicy sets the behaviour of Push when the pushed item
+// doesn't fit in the queue limits. It defaults to RejectWhenFull.
+func WithOverflowPolicy(policy OverflowPolicy) PQueueOption {
+	return func(pq *PQueue) error {
+		if policy != RejectWhenFull && policy != EvictWhenFull {
+			return fmt.Errorf("%w: unknown overflow policy %d", ErrInvalidOption, policy)
+		}
+
+		pq.overflow = policy
+		return nil
+	}
+}
+
+// Stats returns a snapshot of the priority queue usage statistics
+func (pq *PQueue) Stats() PQueueStats {
+	size := pq.Size()
+	pushLock, popLock := pq.contention.snapshot()
+
+	pq.rlock()
+	defer pq.RUnlock()
+
+	stats := PQueueStats{
+		Size:          size,
+		Bytes:         pq.bytes,
+		Evictions:     pq.evictions,
+		Handoffs:      pq.handoffs,
+		Expired:       pq.expired,
+		Reallocations: pq.reallocations,
+		Waiters:       pq.blockedCount(),
+		PushLock:      pushLock,
+		PopLock:       popLock,
+	}
+
+	if pq.repair != nil {
+		stats.Repaired = pq.repair.repaired
+	}
+
+	if pq.intern != nil {
+		stats.Interned = len(pq.intern.entries)
+		stats.InternHits, stats.InternMisses = pq.intern.hits, pq.intern.misses
+	}
+
+	return stats
+}
+
+func (pq *PQueue) validateLimits() error {
+	if pq.maxBytes > 0 && pq.sizeEstimator == nil {
+		return fmt.Errorf("%w: max bytes requires a size estimator", ErrInvalidOption)
+	}
+
+	if pq.buffer != nil && (pq.maxItems > 0 || pq.maxBytes > 0) {
+		return fmt.Errorf("%w: write buffer and queue limits", ErrIncompatibleOptions)
+	}
+
+	return nil
+}
+
+// roomWake returns the channel closed when the producers blocked on a
+// full queue must try pushing again, see Bridge. The caller must hold
+// the write lock.
+func (pq *PQueue) roomWake() chan struct{} {
+	if pq.room == nil {
+		pq.room = make(chan struct{})
+	}
+
+	return pq.room
+}
+
+// wakeRoom wakes the producers blocked on the roomWake channel up if the
+// queue has room for an item again, or was closed. The caller must hold
+// the write lock.
+func (pq *PQueue) wakeRoom() {
+	if pq.room != nil && (pq.closed || !pq.overflows(0, 0, 0)) {
+		close(pq.room)
+		pq.room = nil
+	}
+}
+
+// overflows reports whether pushing an item of the provided size
+// would exceed the queue limits once count items and bytes bytes
+// have been removed from it.
+func (pq *PQueue) overflows(size int, count int, bytes int64) bool {
+	if pq.maxItems > 0 && pq.elemsCount-count+1 > pq.maxItems {
+		return true
+	}
+
+	if pq.maxBytes > 0 && pq.bytes-bytes+int64(size) > pq.maxBytes {
+		return true
+	}
+
+	return false
+}
+
+// admit makes room for the item according to the queue limits and
+// overflow policy, or returns ErrFull. The caller must hold the write
+// lock.
+func (pq *PQueue) admit(pushed *item) error {
+	if !pq.overflows(pushed.size, 0, 0) {
+		return nil
+	}
+
+	if pq.overflow != EvictWhenFull {
+		return ErrFull
+	}
+
+	// Choose every victim before evicting any of them, so that
+	// the queue is left untouched when the item can't fit in.
+	var victims []*item
+	var victimsBytes int64
+
+	for pq.overflows(pushed.size, len(victims), victimsBytes) {
+		worst := pq.worst(victims)
+		if worst == nil || !pq.lessItems(worst, pushed) {
+			return ErrFull
+		}
+
+		victims = append(victims, worst)
+		victimsBytes += int64(worst.size)
+	}
+
+	for _, victim := range victims {
+		pq.removeAt(victim.index)
+		pq.release(victim)
+		atomic.AddUint64(&pq.evictions, 1)
+	}
+
+	return nil
+}
+
+// PopWorst pops and returns the lowest/highest priority item (depending
+// on whether you're using a MINPQ or MAXPQ) from the priority queue, the
+// item which would be popped last. It runs in linear time. The boolean
+// is false if the queue is empty.
+func (pq *PQueue) PopWorst() (interface{}, int, bool) {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	worst := pq.worst(nil)
+	if worst == nil {
+		return nil, 0, false
+	}
+
+	pq.removeAt(worst.index)
+	value, priority := worst.value, worst.priority
+	pq.release(worst)
+	pq.countPops(1)
+
+	return value, int(priority), true
+}
+
+// worst returns the lowest precedence item of the queue, ignoring
+// the excluded ones.
+func (pq *PQueue) worst(excluded []*item) *item {
+	var worst *item
+
+	// Every item precedes its children, so the lowest precedence one
+	// is a leaf, unless the excluded items are its children.
+	first := 1
+	if len(excluded) == 0 {
+		first = pq.elemsCount/2 + 1
+	}
+
+	for k := first; k <= pq.elemsCount; k++ {
+		candidate := pq.items[k]
+		if containsItem(excluded, candidate) {
+			continue
+		}
+
+		if worst == nil || pq.lessItems(candidate, worst) {
+			worst = candidate
+		}
+	}
+
+	return worst
+}
+
+func containsItem(items []*item, wanted *item) bool {
+	for _, candidate := range items {
+		if candidate == wanted {
+			return true
+		}
+	}
+
+	return false
+}
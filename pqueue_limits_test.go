@@ -0,0 +1,191 @@
+package lane
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stringSize(value interface{}) int {
+	return len(value.(string))
+}
+
+func TestNewPQueueWithOptions_invalid_limits(t *testing.T) {
+	for _, options := range [][]PQueueOption{
+		{WithMaxItems(0)},
+		{WithMaxBytes(-1), WithSizeEstimator(stringSize)},
+		{WithMaxBytes(10)},
+		{WithSizeEstimator(nil)},
+		{WithOverflowPolicy(OverflowPolicy(42))},
+	} {
+		pqueue, err := NewPQueueWithOptions(MAXPQ, options...)
+		assert.Nil(t, pqueue)
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+	}
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(2), WithWriteBuffer(8, time.Millisecond))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+}
+
+func TestPQueueMaxItems_rejects_when_full(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(3))
+	assert.Nil(t, err)
+
+	assert.Nil(t, pqueue.Push("1", 1))
+	assert.Nil(t, pqueue.Push("2", 2))
+	assert.Nil(t, pqueue.Push("3", 3))
+	assert.True(t, errors.Is(pqueue.Push("4", 4), ErrFull))
+	assert.Equal(t, pqueue.Size(), 3)
+
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, "3")
+	assert.Nil(t, pqueue.Push("4", 4))
+	assert.Equal(t, pqueue.Stats().Evictions, uint64(0))
+}
+
+func TestPQueueMaxItems_evicts_lowest_precedence(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(3), WithOverflowPolicy(EvictWhenFull))
+	assert.Nil(t, err)
+
+	pqueue.Push("5", 5)
+	pqueue.Push("3", 3)
+	pqueue.Push("9", 9)
+
+	assert.Nil(t, pqueue.Push("7", 7))
+	assert.Equal(t, pqueue.Stats().Evictions, uint64(1))
+
+	// The pushed item has the lowest precedence, so it is the
+	// one which doesn't fit.
+	assert.True(t, errors.Is(pqueue.Push("1", 1), ErrFull))
+	assert.Equal(t, pqueue.Stats().Evictions, uint64(1))
+
+	assertHeapInvariant(t, pqueue)
+	for _, expected := range []string{"9", "7", "5"} {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, expected)
+	}
+}
+
+func TestPQueueMaxBytes_rejects_when_full(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithSizeEstimator(stringSize), WithMaxBytes(10))
+	assert.Nil(t, err)
+
+	assert.Nil(t, pqueue.Push("aaaa", 1))
+	assert.Nil(t, pqueue.Push("bbbb", 2))
+	assert.Nil(t, pqueue.Push("cc", 3))
+	assert.True(t, errors.Is(pqueue.Push("d", 4), ErrFull))
+	assert.Equal(t, pqueue.Stats().Bytes, int64(10))
+
+	pqueue.Pop()
+	assert.Equal(t, pqueue.Stats().Bytes, int64(8))
+	assert.Nil(t, pqueue.Push("d", 4))
+	assert.Equal(t, pqueue.Stats().Bytes, int64(9))
+
+	for pqueue.Size() > 0 {
+		pqueue.Pop()
+	}
+	assert.Equal(t, pqueue.Stats(), PQueueStats{})
+}
+
+func TestPQueueMaxBytes_evicts_as_many_items_as_needed(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ,
+		WithSizeEstimator(stringSize),
+		WithMaxBytes(10),
+		WithOverflowPolicy(EvictWhenFull),
+	)
+	assert.Nil(t, err)
+
+	pqueue.Push("aaa", 1)
+	pqueue.Push("bbb", 2)
+	pqueue.Push("ccc", 3)
+
+	// Nothing can be evicted for an item larger than the limit
+	assert.True(t, errors.Is(pqueue.Push("xxxxxxxxxxx", 0), ErrFull))
+	assert.Equal(t, pqueue.Size(), 3)
+
+	// Making room for the item requires evicting the two lowest
+	// precedence items
+	assert.Nil(t, pqueue.Push("ddddddd", 0))
+	assert.Equal(t, pqueue.Stats(), PQueueStats{Size: 2, Bytes: 10, Evictions: 2})
+
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, "ddddddd")
+	value, _ = pqueue.Pop()
+	assert.Equal(t, value, "aaa")
+	assert.Equal(t, pqueue.Stats().Bytes, int64(0))
+}
+
+func TestPQueueMaxBytes_does_not_evict_when_item_cannot_fit(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ,
+		WithSizeEstimator(stringSize),
+		WithMaxBytes(10),
+		WithOverflowPolicy(EvictWhenFull),
+	)
+	assert.Nil(t, err)
+
+	pqueue.Push("aaaa", 1)
+	pqueue.Push("bbbb", 5)
+
+	// Making room would require evicting "aaaa", which has precedence
+	// over the pushed item.
+	assert.True(t, errors.Is(pqueue.Push("ccccccc", 3), ErrFull))
+	assert.Equal(t, pqueue.Stats(), PQueueStats{Size: 2, Bytes: 8})
+}
+
+func TestPQueueSizeEstimator_is_called_once_per_push(t *testing.T) {
+	calls := 0
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithSizeEstimator(func(value interface{}) int {
+		calls++
+		return 3
+	}))
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, i)
+	}
+	for i := 0; i < 4; i++ {
+		pqueue.Pop()
+	}
+
+	assert.Equal(t, calls, 10)
+	assert.Equal(t, pqueue.Stats().Bytes, int64(18))
+}
+
+func TestPQueuePopWorst(t *testing.T) {
+	for pqType, expected := range map[PQType][]int{MAXPQ: {1, 2, 3}, MINPQ: {3, 2, 1}} {
+		pqueue := NewPQueue(pqType)
+		for _, priority := range []int{2, 3, 1} {
+			pqueue.Push(priority*10, priority)
+		}
+
+		for _, priority := range expected {
+			value, popped, ok := pqueue.PopWorst()
+			assert.True(t, ok)
+			assert.Equal(t, value, priority*10)
+			assert.Equal(t, popped, priority)
+			assert.True(t, assertHeapInvariant(t, pqueue))
+		}
+
+		_, _, ok := pqueue.PopWorst()
+		assert.False(t, ok)
+	}
+}
+
+func BenchmarkPQueuePush_evict_when_full(b *testing.B) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(1024), WithOverflowPolicy(EvictWhenFull))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < 1024; i++ {
+		pqueue.Push(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pqueue.Push(i, 1024+i)
+	}
+}
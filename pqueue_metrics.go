@@ -0,0 +1,106 @@
+package lane
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// PQueueMetrics is a snapshot of the priority queue counters and gauges,
+// see MetricsSnapshot. It is meant to be exported by an adapter to a
+// metrics system, such as a Prometheus collector.
+type PQueueMetrics struct {
+	// Size is the count of queued items.
+	Size int64
+	// HighWatermark is the largest count of items the heap held at the
+	// end of an operation.
+	HighWatermark int64
+	// Pushes is the count of pushed items, counted once they entered
+	// the queue or were handed over to a blocked consumer.
+	Pushes uint64
+	// Pops is the count of items popped or drained from the queue,
+	// PopWorst included.
+	Pops uint64
+	// Evictions is the count of items evicted to make room for pushed
+	// ones.
+	Evictions uint64
+	// Handoffs is the count of items handed over to blocked WaitPop
+	// consumers.
+	Handoffs uint64
+	// WaitCount is the count of WaitPop calls which returned.
+	WaitCount uint64
+	// WaitTotal is the total time spent in the returned WaitPop calls.
+	WaitTotal time.Duration
+}
+
+// queueMetrics holds the counters and gauges of the queue. They are
+// updated under the write lock, and read with atomic operations.
+type queueMetrics struct {
+	size          int64
+	highWatermark int64
+	pushes        uint64
+	pops          uint64
+	waitCount     uint64
+	waitNanos     int64
+}
+
+// MetricsSnapshot returns the queue counters and gauges. It doesn't
+// acquire the queue lock, and can thus be called as often as needed
+// while the queue is used: counters never decrease from a snapshot to
+// the next. The values are read one after another though, a snapshot
+// taken during an operation may account for part of it only.
+func (pq *PQueue) MetricsSnapshot() PQueueMetrics {
+	m := &pq.metrics
+
+	return PQueueMetrics{
+		Size:          pq.publishedSize(),
+		HighWatermark: atomic.LoadInt64(&m.highWatermark),
+		Pushes:        atomic.LoadUint64(&m.pushes),
+		Pops:          atomic.LoadUint64(&m.pops),
+		Evictions:     atomic.LoadUint64(&pq.evictions),
+		Handoffs:      atomic.LoadUint64(&pq.handoffs),
+		WaitCount:     atomic.LoadUint64(&m.waitCount),
+		WaitTotal:     time.Duration(atomic.LoadInt64(&m.waitNanos)),
+	}
+}
+
+// publishedSize returns the size published by the last operation,
+// along with the staged and buffered items, see WithPrefetch and
+// WithWriteBuffer.
+func (pq *PQueue) publishedSize() int64 {
+	size := atomic.LoadInt64(&pq.metrics.size)
+	if pq.hasStaged() {
+		size++
+	}
+	if pq.buffer != nil {
+		size += int64(pq.buffer.len())
+	}
+
+	return size
+}
+
+// publishSize updates the size gauges. The size gauge leaves the staged
+// item out, as it is popped without the lock, see WithPrefetch. The
+// caller must hold the write lock.
+func (pq *PQueue) publishSize() {
+	m := &pq.metrics
+	atomic.StoreInt64(&m.size, int64(pq.elemsCount))
+
+	size := int64(pq.elemsCount)
+	if pq.hasStaged() {
+		size++
+	}
+	if size > m.highWatermark {
+		atomic.StoreInt64(&m.highWatermark, size)
+	}
+}
+
+// countPops adds n to the popped items count
+func (pq *PQueue) countPops(n int) {
+	atomic.AddUint64(&pq.metrics.pops, uint64(n))
+}
+
+// countWait records a WaitPop call which started at start
+func (pq *PQueue) countWait(start time.Time) {
+	atomic.AddInt64(&pq.metrics.waitNanos, int64(time.Since(start)))
+	atomic.AddUint64(&pq.metrics.waitCount, 1)
+}
@@ -0,0 +1,178 @@
+package lane
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueMetricsSnapshot(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(3), WithOverflowPolicy(EvictWhenFull))
+	assert.Nil(t, err)
+
+	for i := 1; i <= 5; i++ {
+		pqueue.Push(i, i)
+	}
+	pqueue.Pop()
+	pqueue.PopWorst()
+	assert.Equal(t, pqueue.MetricsSnapshot().Size, int64(1))
+	pqueue.Drain()
+
+	metrics := pqueue.MetricsSnapshot()
+	assert.Equal(t, metrics.Size, int64(0))
+	assert.Equal(t, metrics.HighWatermark, int64(3))
+	assert.Equal(t, metrics.Pushes, uint64(5))
+	assert.Equal(t, metrics.Pops, uint64(3))
+	assert.Equal(t, metrics.Evictions, uint64(2))
+	assert.Equal(t, metrics.WaitCount, uint64(0))
+}
+
+func TestPQueueMetricsSnapshot_agrees_with_size(t *testing.T) {
+	pqueue, err := NewPQueueFromHeap(MAXPQ, []Item{{Value: "a", Priority: 3}, {Value: "b", Priority: 1}})
+	assert.Nil(t, err)
+	assert.Equal(t, pqueue.Size(), 2)
+	assert.Equal(t, pqueue.MetricsSnapshot().Size, int64(2))
+
+	pqueue.Push("c", 2)
+	pqueue.Edit(func(c *Cursor) {
+		for c.Next() {
+			if c.Value() == "a" {
+				c.Remove()
+			}
+		}
+	})
+	assert.Equal(t, pqueue.Size(), 2)
+	assert.Equal(t, pqueue.MetricsSnapshot().Size, int64(2))
+
+	// Prefetched items are popped without the lock
+	prefetched, err := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+	assert.Nil(t, err)
+	prefetched.Push("a", 1)
+	assert.Equal(t, prefetched.Size(), 1)
+	prefetched.Pop()
+	assert.Equal(t, prefetched.Size(), 0)
+	assert.Equal(t, prefetched.MetricsSnapshot().Size, int64(0))
+}
+
+func TestPQueueMetricsSnapshot_wait_pop(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push(1, 1)
+
+	_, _, err := pqueue.WaitPop(context.Background())
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = pqueue.WaitPop(ctx)
+	assert.NotNil(t, err)
+
+	metrics := pqueue.MetricsSnapshot()
+	assert.Equal(t, metrics.WaitCount, uint64(2))
+	assert.True(t, metrics.WaitTotal >= 10*time.Millisecond)
+	assert.Equal(t, metrics.Pops, uint64(1))
+}
+
+func TestPQueueMetricsSnapshot_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(8, time.Hour))
+	assert.Nil(t, err)
+	defer pqueue.Close()
+
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+
+	assert.Equal(t, pqueue.MetricsSnapshot().Size, int64(2))
+}
+
+func TestPQueueMetricsSnapshot_monotonic_under_load(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(64), WithOverflowPolicy(EvictWhenFull))
+	assert.Nil(t, err)
+
+	var workers, snapshotter sync.WaitGroup
+	var pushes, waitPops uint64
+	stop := make(chan struct{})
+
+	snapshotter.Add(1)
+	go func() {
+		defer snapshotter.Done()
+
+		var previous PQueueMetrics
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			current := pqueue.MetricsSnapshot()
+			assert.True(t, current.Pushes >= previous.Pushes)
+			assert.True(t, current.Pops >= previous.Pops)
+			assert.True(t, current.Evictions >= previous.Evictions)
+			assert.True(t, current.Handoffs >= previous.Handoffs)
+			assert.True(t, current.WaitCount >= previous.WaitCount)
+			assert.True(t, current.WaitTotal >= previous.WaitTotal)
+			assert.True(t, current.HighWatermark >= previous.HighWatermark)
+			assert.True(t, current.HighWatermark <= 64)
+			previous = current
+			runtime.Gosched()
+		}
+	}()
+
+	for p := 0; p < 4; p++ {
+		workers.Add(2)
+
+		go func(p int) {
+			defer workers.Done()
+
+			for i := 0; i < 2000; i++ {
+				if pqueue.Push(i, (i*31+p)%100) == nil {
+					atomic.AddUint64(&pushes, 1)
+				}
+			}
+		}(p)
+
+		go func(p int) {
+			defer workers.Done()
+
+			for i := 0; i < 500; i++ {
+				switch i % 4 {
+				case 0:
+					pqueue.Pop()
+				case 1:
+					pqueue.PopWorst()
+				case 2:
+					ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+					pqueue.WaitPop(ctx)
+					cancel()
+					atomic.AddUint64(&waitPops, 1)
+				case 3:
+					pqueue.PopRelease()
+				}
+			}
+		}(p)
+	}
+
+	workers.Wait()
+	close(stop)
+	snapshotter.Wait()
+
+	metrics := pqueue.MetricsSnapshot()
+	assert.Equal(t, metrics.Pushes, atomic.LoadUint64(&pushes))
+	assert.Equal(t, metrics.Pushes, metrics.Pops+metrics.Evictions+uint64(metrics.Size))
+	assert.Equal(t, metrics.Size, int64(pqueue.Size()))
+	assert.Equal(t, metrics.Evictions, pqueue.Stats().Evictions)
+	assert.Equal(t, metrics.WaitCount, atomic.LoadUint64(&waitPops))
+}
+
+func BenchmarkPQueueMetricsSnapshot(b *testing.B) {
+	pqueue := NewPQueue(MAXPQ)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pqueue.MetricsSnapshot()
+	}
+}
@@ -0,0 +1,549 @@
+package lane
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidOpLog is the error returned when replaying a malformed
+// operation log.
+var ErrInvalidOpLog = errors.New("lane: invalid operation log")
+
+// opLogHeader starts the operation logs, followed by their format
+// version, see FormatVersion, the queue ordering and its tie break.
+const opLogHeader = "lane-oplog"
+
+// The operation log records describe the heap mutations, rather than the
+// queue methods calls, so that replaying them goes through the same heap
+// manipulations as the recorded session did.
+const (
+	opInsert   = "insert"
+	opAppend   = "append"
+	opRemove   = "remove"
+	opCut      = "cut"
+	opFilter   = "filter"
+	opPriority = "priority"
+	opValue    = "value"
+	opFix      = "fix"
+	opHeapify  = "heapify"
+	opReset    = "reset"
+	opOrder    = "order"
+)
+
+// opLog writes the operation log records. It is guarded by the queue
+// write lock.
+type opLog struct {
+	w      *bufio.Writer
+	record []byte
+	err    error
+}
+
+// WithOpLog makes the queue record its heap mutations into w, so that
+// they can be replayed with ReplayOpLog. Each record holds the mutation,
+// its timestamp, the calling goroutine id, and the involved indexes,
+// priorities and value fingerprints, see Fingerprint.
+//
+// Records are buffered, and written when the buffer is full, when the
+// queue is closed, or when FlushOpLog is called. Recording stops on the
+// first write error, which FlushOpLog returns. Recording doesn't
+// allocate, but telling the goroutine id costs a few microseconds per
+// record.
+//
+// Items handed over to blocked WaitPop consumers never enter the heap,
+// and are not recorded. Clones of the queue don't record their own
+// mutations, and queues using a value comparator, or a secondary order,
+// see WithSecondaryOrder, can't record theirs.
+func WithOpLog(w io.Writer) PQueueOption {
+	return func(pq *PQueue) error {
+		if w == nil {
+			return fmt.Errorf("%w: nil operation log writer", ErrInvalidOption)
+		}
+
+		pq.oplog = &opLog{w: bufio.NewWriter(w)}
+		return nil
+	}
+}
+
+// FlushOpLog writes the buffered operation log records, see WithOpLog,
+// and returns the first error recording met, if any.
+func (pq *PQueue) FlushOpLog() error {
+	pq.lock()
+	defer pq.unlock()
+
+	return pq.flushOpLog()
+}
+
+// flushOpLog writes the buffered operation log records. The caller must
+// hold the write lock.
+func (pq *PQueue) flushOpLog() error {
+	l := pq.oplog
+	if l == nil {
+		return nil
+	}
+
+	if l.err == nil {
+		l.err = l.w.Flush()
+	}
+
+	return l.err
+}
+
+// startOpLog writes the operation log header, describing the queue
+// ordering.
+func (pq *PQueue) startOpLog() error {
+	l := pq.oplog
+	if l == nil {
+		return nil
+	}
+
+	if pq.valueLess != nil {
+		return fmt.Errorf("%w: operation log and value comparator", ErrIncompatibleOptions)
+	}
+
+	if pq.secondaryOrdered {
+		return fmt.Errorf("%w: operation log and secondary order", ErrIncompatibleOptions)
+	}
+
+	tieBreak := "none"
+	switch {
+	case pq.stable:
+		tieBreak = "stable"
+	case pq.random != nil:
+		tieBreak = "random"
+	}
+
+	l.record = append(l.record[:0], opLogHeader...)
+	l.record = append(l.record, ' ')
+	l.record = strconv.AppendInt(l.record, formatVersion, 10)
+	l.record = append(l.record, ' ')
+	l.record = strconv.AppendInt(l.record, int64(pq.pqType), 10)
+	l.record = append(l.record, ' ')
+	l.record = append(l.record, tieBreak...)
+	l.write()
+
+	return nil
+}
+
+// logOp starts a new record of the op mutation, and returns the log to
+// append the record fields to, or nil if the queue doesn't record its
+// mutations. The caller must hold the write lock, and end the record.
+func (pq *PQueue) logOp(op string) *opLog {
+	l := pq.oplog
+	if l == nil || l.err != nil {
+		return nil
+	}
+
+	l.record = strconv.AppendInt(l.record[:0], pq.now().UnixNano(), 10)
+	l.record = append(l.record, ' ')
+	l.record = strconv.AppendInt(l.record, goroutineID(), 10)
+	l.record = append(l.record, ' ')
+	l.record = append(l.record, op...)
+
+	return l
+}
+
+// int appends an integer field to the record
+func (l *opLog) int(n int64) *opLog {
+	l.record = append(l.record, ' ')
+	l.record = strconv.AppendInt(l.record, n, 10)
+
+	return l
+}
+
+// value appends the fingerprint of the value to the record
+func (l *opLog) value(value interface{}) *opLog {
+	l.record = append(l.record, ' ')
+	l.record = appendFingerprint(l.record, value)
+
+	return l
+}
+
+// end writes the record
+func (l *opLog) end() {
+	l.write()
+}
+
+// logInt records the op mutation, having a single integer argument. The
+// caller must hold the write lock.
+func (pq *PQueue) logInt(op string, n int) {
+	if l := pq.logOp(op); l != nil {
+		l.int(int64(n)).end()
+	}
+}
+
+// logPriority records the priority change of the item at index k. The
+// caller must hold the write lock.
+func (pq *PQueue) logPriority(k int, priority int64) {
+	if l := pq.logOp(opPriority); l != nil {
+		l.int(int64(k)).int(priority).end()
+	}
+}
+
+// logPriorities records the primary and secondary priorities change of
+// the item at index k. The caller must hold the write lock.
+func (pq *PQueue) logPriorities(k int, priority, secondary int64) {
+	if l := pq.logOp(opPriority); l != nil {
+		l.int(int64(k)).int(priority).int(secondary).end()
+	}
+}
+
+// logItem records the op addition of the item to the heap, either an
+// insertion or an append. The caller must hold the write lock.
+func (pq *PQueue) logItem(op string, item *item) {
+	if l := pq.logOp(op); l != nil {
+		l.int(item.priority).int(pq.tieBreak(item))
+		if item.secondary != 0 {
+			l.int(item.secondary)
+		}
+		l.value(item.value).end()
+	}
+}
+
+func (l *opLog) write() {
+	l.record = append(l.record, '\n')
+	if _, err := l.w.Write(l.record); err != nil {
+		l.err = err
+	}
+}
+
+// tieBreak returns the item tie break field, according to the queue
+// ordering.
+func (pq *PQueue) tieBreak(item *item) int64 {
+	if pq.stable {
+		return int64(item.seq)
+	}
+
+	return item.token
+}
+
+// Fingerprint returns the fingerprint identifying the value in operation
+// logs, see WithOpLog. It is a hash of the value default format, as
+// formatted by the fmt package.
+func Fingerprint(value interface{}) string {
+	return string(appendFingerprint(nil, value))
+}
+
+// appendFingerprint appends the value fingerprint to buf. Strings and
+// integers are hashed without allocating.
+func appendFingerprint(buf []byte, value interface{}) []byte {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+
+	hash := uint64(offset)
+	hashBytes := func(b []byte) {
+		for _, c := range b {
+			hash ^= uint64(c)
+			hash *= prime
+		}
+	}
+
+	var scratch [20]byte
+	switch v := value.(type) {
+	case string:
+		for i := 0; i < len(v); i++ {
+			hash ^= uint64(v[i])
+			hash *= prime
+		}
+	case int:
+		hashBytes(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int64:
+		hashBytes(strconv.AppendInt(scratch[:0], v, 10))
+	case uint64:
+		hashBytes(strconv.AppendUint(scratch[:0], v, 10))
+	default:
+		hashBytes([]byte(fmt.Sprint(value)))
+	}
+
+	buf = strconv.AppendUint(buf, hash, 16)
+
+	return buf
+}
+
+// ReplayOpLog creates a new priority queue by replaying the operation log
+// read from r, as recorded by WithOpLog. valueFor returns the value, of
+// the recorded session, having the provided fingerprint.
+//
+// The heap invariant is checked after every mutation which should leave
+// the heap ordered: if one doesn't, ReplayOpLog returns the queue as
+// replayed so far, along with an ErrInvalidHeap error describing the
+// offending record. ErrInvalidOpLog is returned if the log is malformed,
+// and ErrUnsupportedVersion if its format version is neither the current
+// nor the previous one, see FormatVersion.
+func ReplayOpLog(r io.Reader, valueFor func(fingerprint string) interface{}) (*PQueue, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<30)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidOpLog)
+	}
+
+	pq, err := replayHeader(scanner.Text())
+	if err != nil {
+		return nil, err
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			return pq, fmt.Errorf("%w: line %d: truncated record", ErrInvalidOpLog, line)
+		}
+
+		// The replayed queue isn't shared yet, and is mutated without
+		// its lock
+		ordered, err := pq.replay(fields[2], fields[3:], valueFor)
+		pq.publishSize()
+		if err != nil {
+			return pq, fmt.Errorf("%w: line %d: %s: %v", ErrInvalidOpLog, line, fields[2], err)
+		}
+
+		if !ordered {
+			continue
+		}
+
+		if err := pq.checkHeap(); err != nil {
+			return pq, fmt.Errorf("%w, after line %d: %s", err, line, scanner.Text())
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return pq, err
+	}
+
+	return pq, nil
+}
+
+// replayHeader creates the replayed queue from the log header
+func replayHeader(header string) (*PQueue, error) {
+	fields := strings.Fields(strings.TrimPrefix(header, opLogHeader))
+	if !strings.HasPrefix(header, opLogHeader+" ") || len(fields) != 3 {
+		return nil, fmt.Errorf("%w: invalid header %q", ErrInvalidOpLog, header)
+	}
+
+	// Both versions share their records format
+	switch version, _ := strconv.Atoi(fields[0]); version {
+	case 1, formatVersion:
+	default:
+		return nil, fmt.Errorf("%w: operation log version %s", ErrUnsupportedVersion, fields[0])
+	}
+	fields = fields[1:]
+
+	pqType, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid header %q", ErrInvalidOpLog, header)
+	}
+
+	pq := NewPQueue(PQType(pqType))
+	pq.lazyInit()
+
+	switch fields[1] {
+	case "stable":
+		pq.stable = true
+	case "random":
+		// Tokens are read from the log, the generator only enables
+		// the random tie break.
+		pq.random = rand.New(rand.NewSource(0))
+	case "none":
+	default:
+		return nil, fmt.Errorf("%w: invalid header %q", ErrInvalidOpLog, header)
+	}
+
+	return pq, nil
+}
+
+// replay applies a recorded mutation, and reports whether it should
+// have left the heap ordered.
+func (pq *PQueue) replay(op string, args []string, valueFor func(string) interface{}) (bool, error) {
+	ints := make([]int64, len(args))
+	parseInts := func(n int) error {
+		if n >= 0 && len(args) != n {
+			return fmt.Errorf("%d arguments expected, got %d", n, len(args))
+		}
+
+		for i, arg := range args {
+			parsed, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return err
+			}
+			ints[i] = parsed
+		}
+
+		return nil
+	}
+
+	index := func(k int64) error {
+		if k < 1 || k > int64(pq.elemsCount) {
+			return fmt.Errorf("index %d out of the heap bounds [1, %d]", k, pq.elemsCount)
+		}
+
+		return nil
+	}
+
+	switch op {
+	case opInsert, opAppend:
+		// The secondary priority is only recorded when not zero
+		if len(args) != 3 && len(args) != 4 {
+			return false, fmt.Errorf("3 or 4 arguments expected, got %d", len(args))
+		}
+
+		value := valueFor(args[len(args)-1])
+		args = args[:len(args)-1]
+		if err := parseInts(-1); err != nil {
+			return false, err
+		}
+
+		inserted := pq.newItem(value, ints[0])
+		inserted.seq = uint64(ints[1])
+		inserted.token = ints[1]
+		pq.advanceSequence(inserted.seq)
+		if len(args) == 3 {
+			inserted.secondary = ints[2]
+		}
+
+		// Appended items are left for a later heapify to order
+		if op == opAppend {
+			pq.appendItem(inserted)
+			return false, nil
+		}
+		pq.insert(inserted)
+
+		return true, nil
+	case opRemove, opCut, opFix:
+		if err := parseInts(1); err != nil {
+			return false, err
+		}
+
+		if err := index(ints[0]); err != nil {
+			return false, err
+		}
+
+		switch op {
+		case opRemove:
+			pq.removeAt(int(ints[0]))
+		case opCut:
+			pq.cut(int(ints[0]))
+			return false, nil
+		case opFix:
+			pq.fix(int(ints[0]))
+		}
+
+		return true, nil
+	case opFilter:
+		if err := parseInts(-1); err != nil {
+			return false, err
+		}
+
+		removed := make(map[*item]bool, len(ints))
+		for _, k := range ints {
+			if err := index(k); err != nil {
+				return false, err
+			}
+			removed[pq.items[k]] = true
+		}
+
+		pq.filter(func(item *item) bool {
+			return !removed[item]
+		})
+
+		return true, nil
+	case opPriority:
+		// The secondary priority is only recorded when it changed too
+		if len(args) != 2 && len(args) != 3 {
+			return false, fmt.Errorf("2 or 3 arguments expected, got %d", len(args))
+		}
+
+		if err := parseInts(-1); err != nil {
+			return false, err
+		}
+
+		if err := index(ints[0]); err != nil {
+			return false, err
+		}
+		pq.items[ints[0]].priority = ints[1]
+		if len(args) == 3 {
+			pq.items[ints[0]].secondary = ints[2]
+		}
+
+		return false, nil
+	case opValue:
+		if len(args) != 2 {
+			return false, fmt.Errorf("2 arguments expected, got %d", len(args))
+		}
+
+		value := valueFor(args[1])
+		args = args[:1]
+		if err := parseInts(1); err != nil {
+			return false, err
+		}
+
+		if err := index(ints[0]); err != nil {
+			return false, err
+		}
+		pq.setValue(pq.items[ints[0]], value)
+
+		return false, nil
+	case opHeapify:
+		pq.heapify()
+		return true, nil
+	case opReset:
+		if err := parseInts(1); err != nil {
+			return false, err
+		}
+
+		pq.reset(PQType(ints[0]))
+
+		return true, nil
+	case opOrder:
+		if err := parseInts(1); err != nil {
+			return false, err
+		}
+
+		// The heap is reordered by the following heapify
+		pq.pqType = PQType(ints[0])
+		pq.comparator = NewPQueue(pq.pqType).comparator
+
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unknown operation")
+}
+
+// goroutineID returns the id of the calling goroutine, as found in its
+// stack trace header.
+func goroutineID() int64 {
+	buf := stackBuffers.Get().(*[64]byte)
+	defer stackBuffers.Put(buf)
+
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+
+	// Parsed by hand, so that no string is allocated
+	var id int64
+	for _, c := range header {
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + int64(c-'0')
+	}
+
+	return id
+}
+
+// stackBuffers holds the buffers goroutineID reads the stack header to,
+// which would otherwise escape to the heap.
+var stackBuffers = sync.Pool{
+	New: func() interface{} { return new([64]byte) },
+}
@@ -0,0 +1,295 @@
+package lane
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// intValues maps the fingerprints of the integers of [0, n) back to them
+func intValues(n int) func(fingerprint string) interface{} {
+	values := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		values[Fingerprint(i)] = i
+	}
+
+	return func(fingerprint string) interface{} {
+		return values[fingerprint]
+	}
+}
+
+// randomSession applies random operations to the queue, using values in
+// [0, 2000).
+func randomSession(t *testing.T, pqueue *PQueue, seed int64) {
+	random := rand.New(rand.NewSource(seed))
+
+	for round := 0; round < 1000; round++ {
+		switch op := random.Intn(20); op {
+		case 0, 1, 2:
+			pqueue.Pop()
+		case 3:
+			pqueue.PopWorst()
+		case 4:
+			pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+				return priority%11 == 0
+			})
+		case 5:
+			pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+				return priority + random.Intn(3) - 1
+			})
+		case 6:
+			pqueue.MapValues(func(value interface{}) interface{} {
+				return (value.(int) + 1) % 2000
+			})
+		case 7:
+			pqueue.Edit(func(c *Cursor) {
+				for c.Next() {
+					switch c.Priority() % 7 {
+					case 0:
+						c.Remove()
+					case 1:
+						c.SetPriority(c.Priority() * 2)
+					}
+				}
+			})
+		case 8:
+			ref, err := pqueue.PushRef(random.Intn(2000), random.Intn(100))
+			assert.Nil(t, err)
+			pqueue.Fix(ref)
+		case 9:
+			if random.Intn(10) == 0 {
+				pqueue.Drain()
+			}
+		case 10:
+			var desired []Item
+			for i, item := range pqueue.RawItems() {
+				switch i % 5 {
+				case 0:
+					continue
+				case 1:
+					item.Priority = random.Intn(100)
+				case 2:
+					item.Secondary = random.Intn(3)
+				}
+				desired = append(desired, item)
+			}
+			desired = append(desired, Item{Value: random.Intn(2000), Priority: random.Intn(100)})
+
+			pqueue.Reconcile(desired, func(value interface{}) string {
+				return strconv.Itoa(value.(int))
+			})
+		default:
+			pqueue.Push(random.Intn(2000), random.Intn(100))
+		}
+	}
+}
+
+func TestNewPQueueWithOptions_invalid_op_log(t *testing.T) {
+	_, err := NewPQueueWithOptions(MAXPQ, WithOpLog(nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithOpLog(io.Discard), WithValueComparator(func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	}))
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+}
+
+func TestReplayOpLog_reproduces_random_session(t *testing.T) {
+	testCases := map[string][]PQueueOption{
+		"max":    nil,
+		"min":    nil,
+		"stable": {WithStableOrder()},
+		"random": {WithRandomTieBreak(1)},
+		"chunks": {WithBulkChunkSize(3)},
+		"arena":  {WithArena(16)},
+	}
+
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pqType := MAXPQ
+			if name == "min" {
+				pqType = MINPQ
+			}
+
+			var log bytes.Buffer
+			pqueue, err := NewPQueueWithOptions(pqType, append(options, WithOpLog(&log))...)
+			assert.Nil(t, err)
+
+			randomSession(t, pqueue, 1)
+			assert.Nil(t, pqueue.FlushOpLog())
+
+			// Bulk operations processed by chunks don't filter the heap
+			if name != "chunks" {
+				for _, op := range []string{opInsert, opAppend, opRemove, opCut, opFilter, opPriority, opValue, opFix, opHeapify} {
+					assert.Contains(t, log.String(), " "+op)
+				}
+			}
+
+			replayed, err := ReplayOpLog(&log, intValues(2000))
+			assert.Nil(t, err)
+			assert.NotEmpty(t, pqueue.RawItems())
+			assert.Equal(t, replayed.RawItems(), pqueue.RawItems())
+			assert.Equal(t, replayed.Drain(), pqueue.Drain())
+		})
+	}
+}
+
+func TestReplayOpLog_unmarshal(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithOpLog(&log))
+	assert.Nil(t, err)
+	pqueue.Push(1, 1)
+
+	source := NewPQueue(MAXPQ)
+	source.Push(2, 2)
+	source.Push(3, 3)
+	data, err := json.Marshal(source)
+	assert.Nil(t, err)
+
+	assert.Nil(t, pqueue.UnmarshalJSON(data))
+	assert.Nil(t, pqueue.FlushOpLog())
+
+	replayed, err := ReplayOpLog(&log, func(fingerprint string) interface{} {
+		// JSON numbers are decoded as float64
+		for _, value := range []interface{}{1, 2.0, 3.0} {
+			if Fingerprint(value) == fingerprint {
+				return value
+			}
+		}
+
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, replayed.RawItems(), pqueue.RawItems())
+	assert.Equal(t, replayed.pqType, MAXPQ)
+}
+
+func TestReplayOpLog_reports_first_divergence(t *testing.T) {
+	log := strings.Join([]string{
+		"lane-oplog 1 0 none",
+		"1 1 insert 5 0 a",
+		"2 1 insert 3 0 b",
+		// The head priority is lowered without fixing its position
+		"3 1 priority 1 1",
+		"4 1 insert 2 0 c",
+		"5 1 insert 9 0 d",
+	}, "\n")
+
+	replayed, err := ReplayOpLog(strings.NewReader(log), func(fingerprint string) interface{} {
+		return fingerprint
+	})
+	assert.True(t, errors.Is(err, ErrInvalidHeap))
+	assert.Contains(t, err.Error(), "after line 5: 4 1 insert 2 0 c")
+
+	// The queue is returned as replayed up to the divergence
+	assert.Equal(t, replayed.Size(), 3)
+}
+
+func TestReplayOpLog_malformed(t *testing.T) {
+	testCases := map[string]string{
+		"empty":          "",
+		"header":         "lane-oplog 1 0",
+		"tie break":      "lane-oplog 1 0 sorted",
+		"truncated":      "lane-oplog 1 0 none\n1 1",
+		"unknown op":     "lane-oplog 1 0 none\n1 1 shuffle",
+		"arguments":      "lane-oplog 1 0 none\n1 1 insert 1",
+		"integer":        "lane-oplog 1 0 none\n1 1 insert x 0 a",
+		"out of bounds":  "lane-oplog 1 0 none\n1 1 insert 1 0 a\n2 1 remove 2",
+		"filter":         "lane-oplog 1 0 none\n1 1 filter 1",
+		"priority index": "lane-oplog 1 0 none\n1 1 priority 0 1",
+	}
+
+	for name, log := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReplayOpLog(strings.NewReader(log), func(string) interface{} { return nil })
+			assert.True(t, errors.Is(err, ErrInvalidOpLog), "%v", err)
+		})
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestPQueueFlushOpLog_write_error(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithOpLog(failingWriter{}))
+	assert.Nil(t, err)
+
+	pqueue.Push(1, 1)
+	assert.EqualError(t, pqueue.FlushOpLog(), "disk full")
+
+	// Recording stopped, the queue keeps working
+	pqueue.Push(2, 2)
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, 2)
+	assert.EqualError(t, pqueue.FlushOpLog(), "disk full")
+}
+
+func TestPQueueClose_flushes_op_log(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithOpLog(&log))
+	assert.Nil(t, err)
+
+	pqueue.Push(1, 1)
+	assert.Equal(t, log.Len(), 0)
+
+	assert.Nil(t, pqueue.Close())
+	assert.Equal(t, strings.Count(log.String(), "\n"), 2)
+}
+
+func TestPQueueOpLog_clone_does_not_record(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithOpLog(&log))
+	assert.Nil(t, err)
+	pqueue.Push(1, 1)
+
+	clone := pqueue.Clone()
+	clone.Push(2, 2)
+	assert.Nil(t, clone.FlushOpLog())
+	assert.Nil(t, pqueue.FlushOpLog())
+
+	assert.Equal(t, strings.Count(log.String(), "\n"), 2)
+}
+
+func TestPQueueOpLog_does_not_allocate(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	recorded, err := NewPQueueWithOptions(MAXPQ, WithOpLog(io.Discard))
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+		recorded.Push(i, i)
+	}
+
+	pushPop := func(pqueue *PQueue) func() {
+		return func() {
+			pqueue.Push("value", 50)
+			pqueue.Pop()
+		}
+	}
+
+	assert.Equal(t, testing.AllocsPerRun(100, pushPop(recorded)), testing.AllocsPerRun(100, pushPop(pqueue)))
+}
+
+func BenchmarkPQueuePushPop_op_log(b *testing.B) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithOpLog(io.Discard))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pqueue.Push(i, i)
+		pqueue.Pop()
+	}
+}
@@ -0,0 +1,208 @@
+package lane
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// prefetchSlot stages the queue head out of the heap, so that Pop can
+// take it with a single atomic exchange, see WithPrefetch.
+type prefetchSlot struct {
+	// slot points to the staged item, nil once taken by Pop. It is only
+	// stored to while holding the queue write lock.
+	slot atomic.Pointer[item]
+
+	// retiring is set while the item last staged is still accounted for
+	// by the queue, Pop may have taken it since. Its size and interning
+	// key are kept for retire, rather than the item, so that the queue
+	// holds no reference to a popped value, see PopRelease. They are
+	// guarded by the queue write lock.
+	retiring  bool
+	size      int
+	internKey string
+	interned  bool
+
+	// refilling is set while a refill of the slot is pending
+	refilling int32
+}
+
+// WithPrefetch makes the queue keep its head staged in a one item slot,
+// so that Pop returns it with a single atomic exchange, without taking
+// the queue lock. The slot is refilled in the background after such a
+// pop, and by Push, which moves the staged item back into the heap if
+// the pushed item precedes it: the pop order is the same as without
+// prefetching.
+//
+// The other methods move the staged item back into the heap first, so
+// that the next Pop takes the lock to stage the head again. Items
+// with a deadline, see PushWithDeadline, and queues with a priority
+// floor or ceiling, see SetPriorityFloor, are not prefetched.
+//
+// Prefetching can't be combined with options whose bookkeeping must
+// happen as items are popped: WithWriteBuffer, WithWAL, WithOpLog,
+// WithValueIndex, WithCoalescing, WithWatermarks, WithPopRateLimit,
+// WithHeadHistory and the queue limits.
+func WithPrefetch() PQueueOption {
+	return func(pq *PQueue) error {
+		pq.prefetch = &prefetchSlot{}
+		return nil
+	}
+}
+
+func (pq *PQueue) validatePrefetch() error {
+	if pq.prefetch == nil {
+		return nil
+	}
+
+	var other string
+	switch {
+	case pq.buffer != nil:
+		other = "write buffer"
+	case pq.wal != nil:
+		other = "write-ahead log"
+	case pq.oplog != nil:
+		other = "operation log"
+	case pq.index != nil:
+		other = "value index"
+	case pq.watermarks != nil:
+		other = "watermarks"
+	case pq.rate != nil:
+		other = "pop rate limit"
+	case pq.history != nil:
+		other = "head history"
+	case pq.maxItems > 0 || pq.maxBytes > 0:
+		other = "queue limits"
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("%w: prefetch and %s", ErrIncompatibleOptions, other)
+}
+
+// popStaged takes the staged item, if any, and schedules the refill of
+// the slot. The boolean is false if no item was staged.
+func (pq *PQueue) popStaged() (*item, bool) {
+	p := pq.prefetch
+	if p == nil {
+		return nil, false
+	}
+
+	staged := p.slot.Swap(nil)
+	if staged == nil {
+		return nil, false
+	}
+
+	if atomic.CompareAndSwapInt32(&p.refilling, 0, 1) {
+		go pq.refill()
+	}
+
+	return staged, true
+}
+
+// hasStaged reports whether an item is staged
+func (pq *PQueue) hasStaged() bool {
+	return pq.prefetch != nil && pq.prefetch.slot.Load() != nil
+}
+
+// stagedHead returns the staged item value and priority. The boolean is
+// false if no item is staged. The item may be popped meanwhile.
+func (pq *PQueue) stagedHead() (interface{}, int64, int64, bool) {
+	if pq.prefetch == nil {
+		return nil, 0, 0, false
+	}
+
+	staged := pq.prefetch.slot.Load()
+	if staged == nil {
+		return nil, 0, 0, false
+	}
+
+	return staged.value, staged.priority, staged.secondary, true
+}
+
+func (pq *PQueue) refill() {
+	pq.lock()
+	defer pq.unlock()
+
+	atomic.StoreInt32(&pq.prefetch.refilling, 0)
+	pq.restage()
+}
+
+// restage stages the heap head if no item is staged, or if it precedes
+// the staged one, which is moved back into the heap. The caller must hold
+// the write lock.
+func (pq *PQueue) restage() {
+	p := pq.prefetch
+	if p == nil {
+		return
+	}
+
+	pq.retire()
+
+	if staged := p.slot.Load(); staged != nil {
+		if pq.elemsCount < 1 || !pq.lessItems(staged, pq.items[1]) {
+			return
+		}
+
+		pq.unstage()
+	}
+
+	if pq.elemsCount < 1 || pq.items[1].deadline != 0 || pq.bounds.hasFloor || pq.bounds.hasCeiling {
+		return
+	}
+
+	// The staged item is still queued: the removal bookkeeping happens
+	// once it is popped, see retire.
+	head := pq.items[1]
+	last := pq.elemsCount
+	pq.exch(1, last)
+	pq.items[last] = nil
+	pq.items = pq.items[:last]
+	pq.elemsCount--
+	pq.sink(1)
+	head.index = 0
+
+	p.retiring, p.size = true, head.size
+	p.internKey, p.interned = pq.internKey(head)
+	p.slot.Store(head)
+}
+
+// unstage moves the staged item back into the heap, if it wasn't popped
+// yet, so that the locked operations find every queued item in the heap.
+// The caller must hold the write lock.
+func (pq *PQueue) unstage() {
+	p := pq.prefetch
+	if p == nil {
+		return
+	}
+
+	staged := p.slot.Swap(nil)
+	if staged == nil {
+		pq.retire()
+		return
+	}
+
+	p.retiring = false
+	pq.items = append(pq.items, staged)
+	pq.elemsCount++
+	staged.index = pq.elemsCount
+	pq.swim(pq.elemsCount)
+}
+
+// retire completes the removal of the item last staged, if Pop took it.
+// The item isn't released, Pop may still be reading it. The caller must
+// hold the write lock.
+func (pq *PQueue) retire() {
+	p := pq.prefetch
+	if !p.retiring || p.slot.Load() != nil {
+		return
+	}
+
+	p.retiring = false
+	pq.bytes -= int64(p.size)
+	if p.interned {
+		pq.internForget(p.internKey)
+		p.internKey = ""
+	}
+	pq.checkDrained()
+	pq.checkShrink()
+}
@@ -0,0 +1,275 @@
+package lane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPQueueWithOptions_prefetch_incompatible_options(t *testing.T) {
+	for _, options := range [][]PQueueOption{
+		{WithWriteBuffer(8, time.Millisecond)},
+		{WithValueIndex(func(value interface{}) string { return value.(string) })},
+		{WithPopRateLimit(10, 1)},
+		{WithMaxItems(10)},
+		{WithMaxBytes(100), WithSizeEstimator(func(value interface{}) int { return 1 })},
+	} {
+		_, err := NewPQueueWithOptions(MAXPQ, append(options, WithPrefetch())...)
+		assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+	}
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(pqueue.SetPopRateLimit(10, 1), ErrIncompatibleOptions))
+}
+
+func TestPQueuePrefetch_stages_head(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+
+	pqueue.Push("low", 1)
+	assert.True(t, pqueue.hasStaged())
+	assert.Equal(t, pqueue.Size(), 1)
+
+	value, priority := pqueue.Head()
+	assert.Equal(t, value, "low")
+	assert.Equal(t, priority, 1)
+
+	value, priority = pqueue.Pop()
+	assert.Equal(t, value, "low")
+	assert.Equal(t, priority, 1)
+
+	value, _ = pqueue.Pop()
+	assert.Nil(t, value)
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueuePrefetch_pushed_item_displaces_staged_one(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+
+	pqueue.Push("low", 1)
+	pqueue.Push("high", 3)
+	pqueue.Push("middle", 2)
+	assert.Equal(t, pqueue.Size(), 3)
+
+	for _, expected := range []string{"high", "middle", "low"} {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, expected)
+	}
+}
+
+func TestPQueuePrefetch_readers_see_staged_item(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+
+	pqueue.Push("a", 1)
+	pqueue.Push("b", 2)
+
+	priority, ok := pqueue.PriorityOf("b")
+	assert.True(t, ok)
+	assert.Equal(t, priority, 2)
+	assert.Equal(t, len(pqueue.RawItems()), 2)
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+
+	// Popping stages the head again
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, "b")
+	assert.True(t, pqueue.hasStaged())
+	assert.Equal(t, pqueue.Stats().Size, 1)
+}
+
+func TestPQueuePrefetch_pop_release_retains_no_reference(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+	pqueue.Push(&largeBuffer{data: make([]byte, 1<<20)}, 3)
+	pqueue.Push("1", 1)
+
+	// Holding the lock keeps the slot from being refilled
+	pqueue.Lock()
+	defer pqueue.Unlock()
+
+	collected := make(chan struct{})
+	func() {
+		value, _, ok := pqueue.PopRelease()
+		assert.True(t, ok)
+		runtime.SetFinalizer(value.(*largeBuffer), func(*largeBuffer) { close(collected) })
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for done := false; !done; {
+		runtime.GC()
+
+		select {
+		case <-collected:
+			done = true
+		case <-deadline:
+			t.Fatal("popped value was not collected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	runtime.KeepAlive(pqueue)
+}
+
+func TestPQueuePrefetch_deadlines_and_bounds_are_not_staged(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+
+	pqueue.PushWithDeadline("expiring", 1, time.Now().Add(time.Hour))
+	assert.False(t, pqueue.hasStaged())
+
+	pqueue.Pop()
+	pqueue.SetPriorityFloor(2)
+	pqueue.Push("below", 1)
+	assert.False(t, pqueue.hasStaged())
+
+	value, _ := pqueue.Pop()
+	assert.Nil(t, value)
+}
+
+func TestPQueuePrefetch_close_and_drain_waits_for_staged_item(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithPrefetch())
+
+	pqueue.Push("a", 1)
+	pqueue.Push("b", 2)
+	pqueue.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		items, err := pqueue.CloseAndDrain(context.Background())
+		assert.Nil(t, err)
+		assert.Empty(t, items)
+	}()
+
+	for popped := 0; popped < 2; {
+		if value, _ := pqueue.Pop(); value != nil {
+			popped++
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue never drained")
+	}
+}
+
+func TestPQueuePrefetch_matches_plain_queue(t *testing.T) {
+	ops := recordOps(7, 5000)
+
+	decodeInt := WithJSONValueDecoder(func(data json.RawMessage) (interface{}, error) {
+		var value int
+		err := json.Unmarshal(data, &value)
+
+		return value, err
+	})
+
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		plain, _ := NewPQueueWithOptions(pqType, decodeInt, WithStableOrder())
+		prefetched, _ := NewPQueueWithOptions(pqType, decodeInt, WithStableOrder(), WithPrefetch())
+
+		assert.Equal(t, replayOps(t, prefetched, ops), replayOps(t, plain, ops))
+	}
+}
+
+func TestPQueuePrefetch_concurrent_pops_lose_nothing(t *testing.T) {
+	const producers, perProducer, consumers = 4, 2000, 4
+
+	pqueue, _ := NewPQueueWithOptions(MINPQ, WithPrefetch())
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				pqueue.Push(p*perProducer+i, i)
+			}
+		}(p)
+	}
+
+	var remaining int64 = producers * perProducer
+	results := make(chan []int, consumers)
+	for c := 0; c < consumers; c++ {
+		go func() {
+			var popped []int
+			deadline := time.Now().Add(10 * time.Second)
+			for atomic.LoadInt64(&remaining) > 0 && time.Now().Before(deadline) {
+				if value, _ := pqueue.Pop(); value != nil {
+					atomic.AddInt64(&remaining, -1)
+					popped = append(popped, value.(int))
+				}
+			}
+			results <- popped
+		}()
+	}
+
+	wg.Wait()
+
+	seen := make(map[int]bool, producers*perProducer)
+	for c := 0; c < consumers; c++ {
+		for _, value := range <-results {
+			assert.False(t, seen[value], "popped twice: %d", value)
+			seen[value] = true
+		}
+	}
+	assert.Equal(t, len(seen), producers*perProducer)
+}
+
+func benchmarkPQueuePop(b *testing.B, options ...PQueueOption) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, options...)
+	for i := 0; i < 100000; i++ {
+		pqueue.Push(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if value, _ := pqueue.Pop(); value == nil {
+			b.StopTimer()
+			for j := 0; j < 100000; j++ {
+				pqueue.Push(j, j)
+			}
+			b.StartTimer()
+		}
+	}
+}
+
+// benchmarkPQueuePopIdle measures the pops of a consumer popping once
+// the queue had time to stage its head.
+func benchmarkPQueuePopIdle(b *testing.B, options ...PQueueOption) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, options...)
+	for i := 0; i < b.N; i++ {
+		pqueue.Push(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for pqueue.prefetch != nil && !pqueue.hasStaged() {
+			runtime.Gosched()
+		}
+		b.StartTimer()
+
+		pqueue.Pop()
+	}
+}
+
+func BenchmarkPQueuePop(b *testing.B) {
+	benchmarkPQueuePop(b)
+}
+
+func BenchmarkPQueuePop_prefetch(b *testing.B) {
+	benchmarkPQueuePop(b, WithPrefetch())
+}
+
+func BenchmarkPQueuePop_idle(b *testing.B) {
+	benchmarkPQueuePopIdle(b)
+}
+
+func BenchmarkPQueuePop_idle_prefetch(b *testing.B) {
+	benchmarkPQueuePopIdle(b, WithPrefetch())
+}
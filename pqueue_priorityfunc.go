@@ -0,0 +1,71 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoPriorityFunc is the error returned by PushValue when the queue has
+// no priority function, see WithPriorityFunc.
+var ErrNoPriorityFunc = errors.New("lane: priority queue has no priority function")
+
+// WithPriorityFunc sets the function deriving the priority of the values
+// pushed with PushValue, so that the prioritization policy lives with the
+// queue rather than with each of its producers. Push keeps pushing items
+// with the provided priority. The function can be changed with
+// SetPriorityFunc.
+func WithPriorityFunc(fn func(value interface{}) int) PQueueOption {
+	return func(pq *PQueue) error {
+		if fn == nil {
+			return fmt.Errorf("%w: nil priority function", ErrInvalidOption)
+		}
+
+		pq.priorityFn = fn
+		return nil
+	}
+}
+
+// SetPriorityFunc sets the function deriving the priority of the values
+// pushed with PushValue, see WithPriorityFunc. The queued items keep
+// their priority until Reprioritize is called.
+func (pq *PQueue) SetPriorityFunc(fn func(value interface{}) int) {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.priorityFn = fn
+}
+
+// PushValue pushes the value item into the priority queue with the
+// priority derived from it by the queue priority function, see
+// WithPriorityFunc. ErrNoPriorityFunc is returned if the queue has none.
+func (pq *PQueue) PushValue(value interface{}) error {
+	fn := pq.priorityFunc()
+	if fn == nil {
+		return pq.lockedError("push", ErrNoPriorityFunc)
+	}
+
+	return pq.Push(value, fn(value))
+}
+
+// Reprioritize sets the priority of every item of the priority queue to
+// the one derived from its value by the current priority function, see
+// WithPriorityFunc, and returns the count of items whose priority
+// changed. It behaves as UpdatePriorities does otherwise.
+func (pq *PQueue) Reprioritize() int {
+	fn := pq.priorityFunc()
+	if fn == nil {
+		return 0
+	}
+
+	return pq.UpdatePriorities(func(value interface{}, priority int) int {
+		return fn(value)
+	})
+}
+
+// priorityFunc returns the queue priority function, nil if it has none
+func (pq *PQueue) priorityFunc() func(value interface{}) int {
+	pq.rlock()
+	defer pq.RUnlock()
+
+	return pq.priorityFn
+}
@@ -0,0 +1,69 @@
+package lane
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueuePushValue(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithPriorityFunc(func(value interface{}) int {
+		return len(value.(string))
+	}))
+	assert.Nil(t, err)
+
+	assert.Nil(t, pqueue.PushValue("ab"))
+	assert.Nil(t, pqueue.PushValue("abcd"))
+	assert.Nil(t, pqueue.Push("a", 10))
+
+	// Explicit priorities bypass the priority function
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, "a")
+	assert.Equal(t, priority, 10)
+
+	value, priority = pqueue.Pop()
+	assert.Equal(t, value, "abcd")
+	assert.Equal(t, priority, 4)
+
+	_, err = NewPQueueWithOptions(MAXPQ, WithPriorityFunc(nil))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	err = NewPQueue(MAXPQ).PushValue("a")
+	assert.True(t, errors.Is(err, ErrNoPriorityFunc))
+}
+
+func TestPQueueReprioritize(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithPriorityFunc(func(value interface{}) int {
+		return value.(int)
+	}))
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		pqueue.PushValue(i)
+	}
+
+	// The policy changes, the queued items keep their priorities
+	pqueue.SetPriorityFunc(func(value interface{}) int {
+		return -value.(int)
+	})
+	for i := 5; i < 10; i++ {
+		pqueue.PushValue(i)
+	}
+
+	value, priority := pqueue.Head()
+	assert.Equal(t, value, 4)
+	assert.Equal(t, priority, 4)
+
+	// The value 0 priority is the same with both policies
+	assert.Equal(t, pqueue.Reprioritize(), 4)
+
+	drained := pqueue.Drain()
+	assert.Equal(t, len(drained), 10)
+	for i, item := range drained {
+		assert.Equal(t, item.Value, i)
+		assert.Equal(t, item.Priority, -i)
+	}
+
+	assert.Equal(t, NewPQueue(MAXPQ).Reprioritize(), 0)
+}
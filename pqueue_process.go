@@ -0,0 +1,116 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrPanicked is returned by ProcessAll when its function panicked
+var ErrPanicked = errors.New("lane: process function panicked")
+
+// ProcessAll pops the queue items and calls fn with each of them, using
+// up to workers goroutines, until the queue is empty. Items are started
+// in pop order: the highest/lowest priority ones (depending on whether
+// you're using a MINPQ or MAXPQ) first. Items pushed meanwhile, by fn
+// included, are processed too.
+//
+// On the first error returned by fn, the context passed to the running
+// calls is cancelled, no other item is started, and the error is
+// returned once they returned. A panic in fn is returned as an error
+// wrapping ErrPanicked. The items not started yet are left in the queue,
+// whereas the started ones are removed whether fn succeeded or not: fn
+// can push back those it wants retried.
+//
+// ProcessAll returns the context error if ctx is done before the queue
+// is empty and fn didn't fail.
+func (pq *PQueue) ProcessAll(ctx context.Context, workers int, fn func(ctx context.Context, value interface{}, priority int) error) error {
+	if workers < 1 {
+		return pq.lockedError("process all", fmt.Errorf("%w: workers must be positive, got %d", ErrInvalidOption, workers))
+	}
+
+	processCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		changed  = sync.NewCond(&mu)
+		running  int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	// next pops the next item to process. The boolean is false once the
+	// workers must stop: on error, or once the queue is empty and no
+	// running call can push an item anymore.
+	next := func() (interface{}, int, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for firstErr == nil && processCtx.Err() == nil {
+			if value, priority, ok := pq.PopRelease(); ok {
+				running++
+				return value, priority, true
+			}
+
+			if running == 0 {
+				break
+			}
+
+			changed.Wait()
+		}
+
+		changed.Broadcast()
+
+		return nil, 0, false
+	}
+
+	done := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		running--
+		if err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+
+		changed.Broadcast()
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				value, priority, ok := next()
+				if !ok {
+					return
+				}
+
+				done(callProcess(processCtx, fn, value, priority))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
+
+// callProcess calls fn, converting its panic into an error
+func callProcess(ctx context.Context, fn func(ctx context.Context, value interface{}, priority int) error, value interface{}, priority int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrPanicked, r)
+		}
+	}()
+
+	return fn(ctx, value, priority)
+}
@@ -0,0 +1,164 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueProcessAll_processes_every_item(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+	}
+
+	var (
+		mu        sync.Mutex
+		processed []int
+	)
+	err := pqueue.ProcessAll(context.Background(), 4, func(ctx context.Context, value interface{}, priority int) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		processed = append(processed, value.(int))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, pqueue.Size(), 0)
+
+	sort.Ints(processed)
+	assert.Equal(t, len(processed), 100)
+	for i, value := range processed {
+		assert.Equal(t, value, i)
+	}
+}
+
+func TestPQueueProcessAll_starts_items_in_pop_order(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for _, priority := range []int{3, 1, 4, 5, 2} {
+		pqueue.Push(priority, priority)
+	}
+
+	var started []interface{}
+	err := pqueue.ProcessAll(context.Background(), 1, func(ctx context.Context, value interface{}, priority int) error {
+		started = append(started, value)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, started, []interface{}{1, 2, 3, 4, 5})
+}
+
+func TestPQueueProcessAll_processes_items_pushed_meanwhile(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push(3, 3)
+
+	var (
+		mu        sync.Mutex
+		processed int
+	)
+	err := pqueue.ProcessAll(context.Background(), 4, func(ctx context.Context, value interface{}, priority int) error {
+		mu.Lock()
+		processed++
+		mu.Unlock()
+
+		// Each item pushes its smaller sibling
+		if n := value.(int); n > 0 {
+			pqueue.Push(n-1, n-1)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, processed, 4)
+}
+
+func TestPQueueProcessAll_first_error_leaves_remaining_items(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, i)
+	}
+
+	failure := errors.New("failure")
+	err := pqueue.ProcessAll(context.Background(), 1, func(ctx context.Context, value interface{}, priority int) error {
+		if value == 6 {
+			return failure
+		}
+		return nil
+	})
+	assert.Equal(t, err, failure)
+
+	var left []interface{}
+	for _, item := range pqueue.Drain() {
+		left = append(left, item.Value)
+	}
+	assert.Equal(t, left, []interface{}{5, 4, 3, 2, 1, 0})
+}
+
+func TestPQueueProcessAll_error_cancels_running_calls(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+	}
+
+	failure := errors.New("failure")
+	var started sync.WaitGroup
+	started.Add(3)
+
+	err := pqueue.ProcessAll(context.Background(), 4, func(ctx context.Context, value interface{}, priority int) error {
+		if value == 96 {
+			// Fail once the other workers are blocked
+			started.Wait()
+			return failure
+		}
+
+		started.Done()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Equal(t, err, failure)
+	assert.Equal(t, pqueue.Size(), 96)
+
+	value, _ := pqueue.Head()
+	assert.Equal(t, value, 95)
+}
+
+func TestPQueueProcessAll_panic_is_an_error(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 2)
+	pqueue.Push("b", 1)
+
+	err := pqueue.ProcessAll(context.Background(), 2, func(ctx context.Context, value interface{}, priority int) error {
+		if value == "a" {
+			panic("boom")
+		}
+		<-ctx.Done()
+		return nil
+	})
+	assert.True(t, errors.Is(err, ErrPanicked))
+	assert.Contains(t, err.Error(), "boom")
+}
+
+func TestPQueueProcessAll_context_done(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 2)
+	pqueue.Push("b", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := pqueue.ProcessAll(ctx, 1, func(ctx context.Context, value interface{}, priority int) error {
+		cancel()
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueProcessAll_invalid_workers(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	err := pqueue.ProcessAll(context.Background(), 0, func(ctx context.Context, value interface{}, priority int) error {
+		return nil
+	})
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
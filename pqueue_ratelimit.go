@@ -0,0 +1,152 @@
+package lane
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// popRateLimit is a token bucket pacing the pops, see WithPopRateLimit.
+// It is guarded by the queue write lock.
+type popRateLimit struct {
+	perSecond float64
+	burst     int
+	tokens    float64
+	last      time.Time
+}
+
+// WithPopRateLimit limits the pops to perSecond items per second on
+// average, allowing bursts of up to burst items after idle periods, using
+// a token bucket read with the queue clock, see WithClock.
+//
+// Pop, Pop64 and PopRelease return nothing when no token is available,
+// as if the queue was empty, and WaitPop blocks until one is. Other
+// removals, such as Drain, PopN or PopWorst, are not limited. The limit
+// can be changed with SetPopRateLimit. Rates whose token period doesn't
+// fit in a time.Duration, about one token every 292 years, are invalid.
+func WithPopRateLimit(perSecond float64, burst int) PQueueOption {
+	return func(pq *PQueue) error {
+		if err := validatePopRateLimit(perSecond, burst); err != nil {
+			return err
+		}
+
+		pq.rate = newPopRateLimit(perSecond, burst, pq.now())
+		return nil
+	}
+}
+
+// SetPopRateLimit sets the pop rate limit, see WithPopRateLimit. The
+// consumers blocked in WaitPop are paced at the new rate right away.
+func (pq *PQueue) SetPopRateLimit(perSecond float64, burst int) error {
+	if err := validatePopRateLimit(perSecond, burst); err != nil {
+		return pq.lockedError("set pop rate limit", err)
+	}
+
+	if pq.prefetch != nil {
+		return pq.lockedError("set pop rate limit", fmt.Errorf("%w: prefetch and pop rate limit", ErrIncompatibleOptions))
+	}
+
+	pq.lock()
+	defer pq.unlock()
+
+	now := pq.now()
+	if pq.rate == nil {
+		pq.rate = newPopRateLimit(perSecond, burst, now)
+		pq.wakePaced()
+
+		return nil
+	}
+
+	// The tokens gathered so far are gathered at the previous rate
+	pq.rate.refill(now)
+	pq.rate.perSecond, pq.rate.burst = perSecond, burst
+	pq.rate.tokens = math.Min(pq.rate.tokens, float64(burst))
+	pq.wakePaced()
+
+	return nil
+}
+
+func validatePopRateLimit(perSecond float64, burst int) error {
+	if !(perSecond > 0) || math.IsInf(perSecond, 1) {
+		return fmt.Errorf("%w: pop rate limit must be positive and finite, got %v", ErrInvalidOption, perSecond)
+	}
+
+	// The delay until the next token must fit in a time.Duration
+	if float64(time.Second)/perSecond >= math.MaxInt64 {
+		return fmt.Errorf("%w: pop rate limit token period overflows, got %v per second", ErrInvalidOption, perSecond)
+	}
+
+	if burst < 1 {
+		return fmt.Errorf("%w: pop rate limit burst must be positive, got %d", ErrInvalidOption, burst)
+	}
+
+	return nil
+}
+
+// newPopRateLimit creates a token bucket, full of burst tokens
+func newPopRateLimit(perSecond float64, burst int, now time.Time) *popRateLimit {
+	return &popRateLimit{
+		perSecond: perSecond,
+		burst:     burst,
+		tokens:    float64(burst),
+		last:      now,
+	}
+}
+
+// takeToken takes a pop token, and reports whether one was available. It
+// always succeeds if the pops aren't rate limited. The caller must hold
+// the write lock.
+func (pq *PQueue) takeToken() bool {
+	r := pq.rate
+	if r == nil {
+		return true
+	}
+
+	r.refill(pq.now())
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+
+	return true
+}
+
+// pacedWake returns the channel closed when the blocked consumers must
+// wait for the next pop token: when the rate changes, or when an item is
+// queued for lack of token. The caller must hold the write lock.
+func (pq *PQueue) pacedWake() chan struct{} {
+	if pq.paced == nil {
+		pq.paced = make(chan struct{})
+	}
+
+	return pq.paced
+}
+
+// wakePaced wakes the consumers blocked on the pacedWake channel up. The
+// caller must hold the write lock.
+func (pq *PQueue) wakePaced() {
+	if pq.paced != nil {
+		close(pq.paced)
+		pq.paced = nil
+	}
+}
+
+// refill adds the tokens gathered since the last refill. A clock going
+// backwards doesn't take tokens back.
+func (r *popRateLimit) refill(now time.Time) {
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.tokens = math.Min(r.tokens+elapsed.Seconds()*r.perSecond, float64(r.burst))
+		r.last = now
+	}
+}
+
+// delay returns the time left until the next token is available
+func (r *popRateLimit) delay(now time.Time) time.Duration {
+	r.refill(now)
+	if r.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil((1 - r.tokens) / r.perSecond * float64(time.Second)))
+}
@@ -0,0 +1,216 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRateLimitedPQueue(t *testing.T, clock *fakeClock, perSecond float64, burst int) *PQueue {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithClock(clock.Now), WithPopRateLimit(perSecond, burst))
+	assert.Nil(t, err)
+
+	for i := 0; i < 10000; i++ {
+		pqueue.Push(i, i)
+	}
+
+	return pqueue
+}
+
+// popAvailable pops items until none is available, and returns their count
+func popAvailable(pqueue *PQueue) int {
+	popped := 0
+	for {
+		if _, _, ok := pqueue.PopRelease(); !ok {
+			return popped
+		}
+		popped++
+	}
+}
+
+func TestNewPQueueWithOptions_invalid_pop_rate_limit(t *testing.T) {
+	for _, limit := range []struct {
+		perSecond float64
+		burst     int
+	}{{0, 1}, {-1, 1}, {1, 0}, {math.Inf(1), 1}, {1e-12, 1}} {
+		_, err := NewPQueueWithOptions(MAXPQ, WithPopRateLimit(limit.perSecond, limit.burst))
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+
+		err = NewPQueue(MAXPQ).SetPopRateLimit(limit.perSecond, limit.burst)
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+	}
+}
+
+func TestPopRateLimit_slowest_rate_delay(t *testing.T) {
+	now := time.Now()
+	limit := newPopRateLimit(1.1e-10, 1, now)
+	limit.tokens = 0
+
+	assert.Nil(t, validatePopRateLimit(limit.perSecond, limit.burst))
+	assert.True(t, limit.delay(now) > 0)
+}
+
+func TestPQueuePop_rate_limited(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newRateLimitedPQueue(t, clock, 100, 10)
+
+	assert.Equal(t, popAvailable(pqueue), 10)
+
+	// Items are left queued when no token is available
+	value, priority := pqueue.Pop()
+	assert.Nil(t, value)
+	assert.Equal(t, priority, 0)
+	assert.Equal(t, pqueue.Size(), 9990)
+
+	clock.Advance(10 * time.Millisecond)
+	value, priority = pqueue.Pop()
+	assert.Equal(t, value, 9989)
+	assert.Equal(t, priority, 9989)
+}
+
+func TestPQueuePop_rate_limit_converges(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newRateLimitedPQueue(t, clock, 100, 10)
+	popAvailable(pqueue)
+
+	// Greedy consumers polling every 3ms pop 100 items per second
+	popped := 0
+	for elapsed := time.Duration(0); elapsed < 20*time.Second; elapsed += 3 * time.Millisecond {
+		clock.Advance(3 * time.Millisecond)
+		popped += popAvailable(pqueue)
+	}
+
+	assert.InDelta(t, popped, 2000, 1)
+}
+
+func TestPQueuePop_rate_limit_burst_after_idle(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newRateLimitedPQueue(t, clock, 100, 10)
+	popAvailable(pqueue)
+
+	// Idle periods don't gather more than burst tokens
+	clock.Advance(time.Hour)
+	assert.Equal(t, popAvailable(pqueue), 10)
+
+	clock.Advance(50 * time.Millisecond)
+	assert.Equal(t, popAvailable(pqueue), 5)
+
+	// A clock going backwards doesn't take tokens back
+	clock.Advance(-time.Second)
+	assert.Equal(t, popAvailable(pqueue), 0)
+	clock.Advance(time.Second + 20*time.Millisecond)
+	assert.Equal(t, popAvailable(pqueue), 2)
+}
+
+func TestPQueueSetPopRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	pqueue := newRateLimitedPQueue(t, clock, 100, 10)
+	popAvailable(pqueue)
+
+	// The tokens left are capped to the new burst
+	clock.Advance(time.Second)
+	assert.Nil(t, pqueue.SetPopRateLimit(1000, 4))
+	assert.Equal(t, popAvailable(pqueue), 4)
+
+	clock.Advance(10 * time.Millisecond)
+	assert.Equal(t, popAvailable(pqueue), 4)
+
+	// Rate limiting can be enabled at runtime
+	pqueue = NewPQueue(MAXPQ)
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+	assert.Nil(t, pqueue.SetPopRateLimit(0.001, 1))
+	assert.Equal(t, popAvailable(pqueue), 1)
+}
+
+func TestPQueueWaitPop_rate_limited(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithPopRateLimit(10000, 3))
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		pqueue.Push(i, i)
+	}
+
+	for i := 49; i >= 0; i-- {
+		value, _, err := pqueue.WaitPop(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, value, i)
+	}
+
+	// Waiting for a token ends with the context
+	pqueue, err = NewPQueueWithOptions(MAXPQ, WithPopRateLimit(0.001, 1))
+	assert.Nil(t, err)
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+	pqueue.Pop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = pqueue.WaitPop(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueSetPopRateLimit_wakes_blocked_waiters(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithPopRateLimit(0.001, 1))
+	assert.Nil(t, err)
+	pqueue.Push(0, 0)
+	pqueue.Pop()
+
+	popped := make(chan interface{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			value, _, err := pqueue.WaitPop(context.Background())
+			assert.Nil(t, err)
+			popped <- value
+		}()
+	}
+	waitForWaiters(t, pqueue, 2)
+
+	// The pushed items are queued for lack of token, the waiters wait
+	// for the next one until the rate changes.
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+	waitForWaiters(t, pqueue, 0)
+
+	select {
+	case <-popped:
+		t.Fatal("item popped without token")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	assert.Nil(t, pqueue.SetPopRateLimit(1000, 1))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-popped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("blocked waiter not paced at the new rate")
+		}
+	}
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueWaitPop_rate_limited_handoff(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithPopRateLimit(0.001, 1))
+	assert.Nil(t, err)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	// The item is handed over using the available token
+	pqueue.Push(1, 1)
+	assert.Equal(t, <-done, 1)
+
+	pqueue.Push(2, 2)
+	_, _, ok := pqueue.PopRelease()
+	assert.False(t, ok)
+}
@@ -0,0 +1,122 @@
+package lane
+
+import "sync/atomic"
+
+// Reconcile makes the priority queue hold the desired items, matched to
+// the queued ones by the key keyFn returns for their value, and returns
+// the counts of pushed, removed and reprioritized items. It is applied
+// holding the queue lock once:
+//
+//   - the desired items whose key isn't queued are pushed, along with
+//     their secondary priority, see Push2,
+//   - the queued items whose key isn't desired are removed, as are the
+//     queued duplicates of a key but one,
+//   - the queued items whose primary or secondary priority differs from
+//     the desired one are reprioritized, their value being kept.
+//
+// When several desired items share a key, the last one wins. An empty
+// desired slice empties the queue. The heap is rebuilt once, rather than
+// as each item is updated or pushed. Pushes are subject to the queue
+// limits, and the desired items which don't fit in are not counted as
+// pushed.
+func (pq *PQueue) Reconcile(desired []Item, keyFn func(interface{}) string) (added, removed, reprioritized int) {
+	wanted := make(map[string]int, len(desired))
+	items := make([]Item, 0, len(desired))
+	for _, item := range desired {
+		key := keyFn(item.Value)
+		if i, ok := wanted[key]; ok {
+			items[i] = item
+			continue
+		}
+
+		wanted[key] = len(items)
+		items = append(items, item)
+	}
+
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+	pq.lazyInit()
+
+	// Match the queued items, by heap index, before removing any of them
+	queued := make([]bool, len(items))
+	stale := make([]bool, pq.elemsCount+1)
+	for k := 1; k <= pq.elemsCount; k++ {
+		item := pq.items[k]
+
+		i, ok := wanted[keyFn(item.value)]
+		if !ok || queued[i] {
+			stale[k] = true
+			continue
+		}
+		queued[i] = true
+
+		priority, secondary := int64(items[i].Priority), int64(items[i].Secondary)
+		if priority != item.priority || secondary != item.secondary {
+			pq.logPriorities(k, priority, secondary)
+			item.secondary = secondary
+			pq.walPriority(item, priority)
+			item.priority = priority
+			reprioritized++
+		}
+	}
+
+	// filter rebuilds the heap once it removed items
+	removed = pq.filter(func(item *item) bool {
+		return !stale[item.index]
+	})
+	ordered := removed > 0 || reprioritized == 0
+
+	// The missing items are appended, and ordered by a single heapify
+	var pushed []*item
+	for i, desired := range items {
+		if queued[i] || pq.closed {
+			continue
+		}
+
+		item := pq.newItem(desired.Value, int64(desired.Priority))
+		item.secondary = int64(desired.Secondary)
+
+		// Evictions pick their victims out of an ordered heap
+		if !ordered && pq.overflow == EvictWhenFull && pq.overflows(item.size, 0, 0) {
+			pq.heapify()
+			ordered = true
+		}
+
+		if err := pq.admit(item); err != nil {
+			pq.release(item)
+			continue
+		}
+
+		// The item is only pushed once recorded, see WithWAL
+		if err := pq.walPush(item); err != nil {
+			pq.release(item)
+			continue
+		}
+
+		pq.appendItem(item)
+		pq.logItem(opAppend, item)
+		pushed = append(pushed, item)
+		ordered = false
+	}
+
+	if !ordered {
+		pq.heapify()
+	}
+
+	if added = len(pushed); added > 0 {
+		atomic.AddUint64(&pq.metrics.pushes, uint64(added))
+		for _, item := range pushed {
+			if item.index == 1 {
+				pq.headCause(HeadPushed)
+			}
+		}
+
+		pq.checkWatermarks()
+		pq.checkShrink()
+		pq.restage()
+	}
+
+	return added, removed, reprioritized
+}
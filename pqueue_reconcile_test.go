@@ -0,0 +1,160 @@
+package lane
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reconcileKey keys the reconciled values by their name
+func reconcileKey(value interface{}) string {
+	return value.(string)
+}
+
+// popItems pops every item of the queue, in pop order
+func popItems(pqueue *PQueue) []Item {
+	var items []Item
+	for pqueue.Size() > 0 {
+		value, primary, secondary := pqueue.Pop2()
+		items = append(items, Item{Value: value, Priority: primary, Secondary: secondary})
+	}
+
+	return items
+}
+
+func TestPQueueReconcile_applies_the_difference(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("kept", 5)
+	pqueue.Push("stale", 4)
+	pqueue.Push("moved", 3)
+	pqueue.Push("duplicate", 2)
+	pqueue.Push("duplicate", 1)
+
+	added, removed, reprioritized := pqueue.Reconcile([]Item{
+		{Value: "kept", Priority: 5},
+		{Value: "moved", Priority: 9},
+		{Value: "new", Priority: 1},
+		{Value: "duplicate", Priority: 2},
+		{Value: "new", Priority: 6, Secondary: 1},
+	}, reconcileKey)
+
+	assert.Equal(t, added, 1)
+	assert.Equal(t, removed, 2)
+	assert.Equal(t, reprioritized, 1)
+
+	assert.Equal(t, popItems(pqueue), []Item{
+		{Value: "moved", Priority: 9},
+		{Value: "new", Priority: 6, Secondary: 1},
+		{Value: "kept", Priority: 5},
+		{Value: "duplicate", Priority: 2},
+	})
+}
+
+func TestPQueueReconcile_empty_desired_clears_queue(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	pqueue.Push("a", 1)
+	pqueue.Push("b", 2)
+
+	added, removed, reprioritized := pqueue.Reconcile(nil, reconcileKey)
+	assert.Equal(t, added, 0)
+	assert.Equal(t, removed, 2)
+	assert.Equal(t, reprioritized, 0)
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueReconcile_respects_limits(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithMaxItems(2))
+	pqueue.Push("a", 1)
+
+	added, removed, _ := pqueue.Reconcile([]Item{
+		{Value: "a", Priority: 1},
+		{Value: "b", Priority: 2},
+		{Value: "c", Priority: 3},
+	}, reconcileKey)
+	assert.Equal(t, added, 1)
+	assert.Equal(t, removed, 0)
+	assert.Equal(t, pqueue.Size(), 2)
+}
+
+func TestPQueueReconcile_matches_queue_built_from_scratch(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+
+	for round := 0; round < 50; round++ {
+		for _, pqType := range []PQType{MAXPQ, MINPQ} {
+			// Priorities are unique, so that the pop order is too
+			priorities := random.Perm(1000)
+
+			pqueue := NewPQueue(pqType)
+			for i := 0; i < random.Intn(200); i++ {
+				pqueue.Push(strconv.Itoa(random.Intn(150)), priorities[i])
+			}
+
+			var desired []Item
+			for i := 0; i < random.Intn(200); i++ {
+				desired = append(desired, Item{Value: strconv.Itoa(random.Intn(150)), Priority: priorities[500+i]})
+			}
+
+			// The last desired item of each key wins
+			last := make(map[string]Item)
+			for _, item := range desired {
+				last[item.Value.(string)] = item
+			}
+			reference := NewPQueue(pqType)
+			for _, item := range last {
+				reference.Push(item.Value, item.Priority)
+			}
+
+			pqueue.Reconcile(desired, reconcileKey)
+			assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+			assert.Equal(t, popItems(pqueue), popItems(reference))
+		}
+	}
+}
+
+func TestPQueueReconcile_updates_secondary_priority(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push2("a", 1, 1)
+	pqueue.Push2("b", 1, 2)
+
+	added, removed, reprioritized := pqueue.Reconcile([]Item{
+		{Value: "a", Priority: 1, Secondary: 3},
+		{Value: "b", Priority: 1, Secondary: 2},
+	}, reconcileKey)
+	assert.Equal(t, added, 0)
+	assert.Equal(t, removed, 0)
+	assert.Equal(t, reprioritized, 1)
+
+	assert.Equal(t, popItems(pqueue), []Item{
+		{Value: "a", Priority: 1, Secondary: 3},
+		{Value: "b", Priority: 1, Secondary: 2},
+	})
+}
+
+func TestPQueueReconcile_heapifies_pushed_items_once(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithOpLog(&log))
+	assert.Nil(t, err)
+	pqueue.Push("kept", 50)
+	assert.Nil(t, pqueue.FlushOpLog())
+	log.Reset()
+
+	desired := []Item{{Value: "kept", Priority: 50}}
+	for i := 0; i < 100; i++ {
+		desired = append(desired, Item{Value: strconv.Itoa(i), Priority: 100 - i})
+	}
+
+	added, _, _ := pqueue.Reconcile(desired, reconcileKey)
+	assert.Nil(t, pqueue.FlushOpLog())
+	assert.Equal(t, added, 100)
+	assert.Equal(t, strings.Count(log.String(), " "+opInsert), 0)
+	assert.Equal(t, strings.Count(log.String(), " "+opAppend), 100)
+	assert.Equal(t, strings.Count(log.String(), " "+opHeapify), 1)
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+	head, priority := pqueue.Head()
+	assert.Equal(t, head, "99")
+	assert.Equal(t, priority, 1)
+}
@@ -0,0 +1,80 @@
+package lane
+
+import "fmt"
+
+// loadRepair holds the repairs of the loaded items, see
+// WithRepairOnLoad. It is guarded by the queue write lock.
+type loadRepair struct {
+	repaired uint64
+}
+
+// WithRepairOnLoad makes UnmarshalJSON accept encoded items which are
+// not heap ordered, such as items whose priorities were corrupted, and
+// put them back in heap order. The count of repaired items, the ones the
+// repair moved, is reported by Stats.
+//
+// By default, UnmarshalJSON verifies the encoded items are heap ordered,
+// and returns an ErrInvalidHeap error identifying the first item having
+// a higher/lower priority (depending on whether the queue is a MAXPQ or
+// MINPQ) than its parent otherwise, the queue being left untouched.
+// Write-ahead logs record no heap layout and are always recovered in
+// heap order, see RecoverFromWAL.
+func WithRepairOnLoad() PQueueOption {
+	return func(pq *PQueue) error {
+		pq.repair = &loadRepair{}
+		return nil
+	}
+}
+
+// checkLoaded verifies the loaded items, in heap layout, are heap
+// ordered with the pqType ordering. It returns the count of items moved
+// to restore the heap order if the queue repairs them, see
+// WithRepairOnLoad, and an ErrInvalidHeap error describing the first
+// item preceding its parent otherwise.
+func (pq *PQueue) checkLoaded(pqType PQType, items []*item) (int, error) {
+	// Ties are broken by the sequences and tokens of the queue loading
+	// the items, see WithStableOrder and WithRandomTieBreak.
+	ordering := &PQueue{
+		pqType:           pqType,
+		comparator:       NewPQueue(pqType).comparator,
+		secondaryOrder:   pq.secondaryOrder,
+		secondaryOrdered: pq.secondaryOrdered,
+		valueLess:        pq.valueLess,
+	}
+
+	misplaced := false
+	for k := 1; k < len(items); k++ {
+		parent, child := items[(k-1)/2], items[k]
+		if !ordering.lessItems(parent, child) {
+			continue
+		}
+
+		if pq.repair == nil {
+			return 0, fmt.Errorf("%w: item %d of priority %d has a %s priority than its parent item %d of priority %d",
+				ErrInvalidHeap, k, child.priority, precedenceWord(pqType), (k-1)/2, parent.priority)
+		}
+		misplaced = true
+	}
+
+	if !misplaced {
+		return 0, nil
+	}
+
+	// A single misplaced item may break the order with its parent and
+	// its children: the items heapify moves are counted instead.
+	ordering.items = append([]*item{nil}, items...)
+	ordering.elemsCount = len(items)
+	for k, item := range items {
+		item.index = k + 1
+	}
+	ordering.heapify()
+
+	moved := 0
+	for k, item := range items {
+		if ordering.items[k+1] != item {
+			moved++
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,126 @@
+package lane
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// misorderedJSON holds a max heap whose item 3 priority was corrupted
+// from 2 to 9, above its parent item 1 priority.
+const misorderedJSON = `{"version":2,"ordering":"max","items":[` +
+	`{"value":"a","priority":8},{"value":"b","priority":5},{"value":"c","priority":7},` +
+	`{"value":"d","priority":9},{"value":"e","priority":1}]}`
+
+func TestPQueueUnmarshalJSON_rejects_misordered_items(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	pqueue.Push("kept", 1)
+
+	err := pqueue.UnmarshalJSON([]byte(misorderedJSON))
+	assert.True(t, errors.Is(err, ErrInvalidHeap))
+	assert.Equal(t, err.Error(), "lane: unmarshal on min priority queue (size 1): items are not heap ordered: "+
+		"item 3 of priority 9 has a higher priority than its parent item 1 of priority 5")
+
+	// The queue is left untouched
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, "kept")
+	assert.Equal(t, priority, 1)
+	assert.Equal(t, pqueue.Stats().Repaired, uint64(0))
+}
+
+func TestPQueueUnmarshalJSON_rejects_misordered_min_items(t *testing.T) {
+	data := `{"version":2,"ordering":"min","items":[{"value":"a","priority":3},{"value":"b","priority":1}]}`
+
+	err := NewPQueue(MINPQ).UnmarshalJSON([]byte(data))
+	assert.True(t, errors.Is(err, ErrInvalidHeap))
+	assert.Equal(t, err.Error(), "lane: unmarshal on min priority queue (size 0): items are not heap ordered: "+
+		"item 1 of priority 1 has a lower priority than its parent item 0 of priority 3")
+}
+
+func TestPQueueUnmarshalJSON_rejects_misordered_secondary_priorities(t *testing.T) {
+	data := `{"version":2,"ordering":"max","items":[` +
+		`{"value":"a","priority":3,"secondary":2},{"value":"b","priority":3,"secondary":1}]}`
+
+	assert.Nil(t, NewPQueue(MAXPQ).UnmarshalJSON([]byte(data)))
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithSecondaryOrder(MINPQ))
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(pqueue.UnmarshalJSON([]byte(data)), ErrInvalidHeap))
+}
+
+func TestPQueueUnmarshalJSON_repairs_misordered_items(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithRepairOnLoad())
+	assert.Nil(t, err)
+
+	// d precedes its parent b, which precedes its parent a
+	assert.Nil(t, pqueue.UnmarshalJSON([]byte(misorderedJSON)))
+	assert.Equal(t, pqueue.Stats().Repaired, uint64(3))
+
+	var values []interface{}
+	var priorities []int
+	for pqueue.Size() > 0 {
+		value, priority := pqueue.Pop()
+		values = append(values, value)
+		priorities = append(priorities, priority)
+	}
+	assert.Equal(t, values, []interface{}{"d", "a", "c", "b", "e"})
+	assert.Equal(t, priorities, []int{9, 8, 7, 5, 1})
+}
+
+func TestPQueueUnmarshalJSON_repairs_count_moved_items(t *testing.T) {
+	// Sorted in the reverse of pop order, every item but the first one
+	// precedes its parent, but only the first and last ones are moved.
+	data := `{"version":2,"ordering":"max","items":[` +
+		`{"value":1,"priority":1},{"value":2,"priority":2},{"value":3,"priority":3},{"value":4,"priority":4}]}`
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithRepairOnLoad())
+	assert.Nil(t, err)
+
+	assert.Nil(t, pqueue.UnmarshalJSON([]byte(data)))
+	assert.Nil(t, pqueue.UnmarshalJSON([]byte(data)))
+	assert.Equal(t, pqueue.Stats().Repaired, uint64(4))
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+
+	for priority := 4; priority > 0; priority-- {
+		_, popped := pqueue.Pop()
+		assert.Equal(t, popped, priority)
+	}
+}
+
+func TestPQueueUnmarshalJSON_accepts_marshaled_queues(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		pqueue.Push2(i, i%7, i%3)
+	}
+
+	data, err := pqueue.MarshalJSON()
+	assert.Nil(t, err)
+
+	decoded, err := NewPQueueWithOptions(MAXPQ, WithRepairOnLoad())
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.UnmarshalJSON(data))
+	assert.Equal(t, decoded.Stats().Repaired, uint64(0))
+	assert.Nil(t, NewPQueue(MAXPQ).UnmarshalJSON(data))
+}
+
+func TestFileBackedPQueue_repair_on_load(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	assert.Nil(t, os.WriteFile(path, []byte(fileHeader+" 1\n"+misorderedJSON), 0o644))
+
+	_, err := OpenFileBackedPQueue(path, MAXPQ)
+	assert.True(t, errors.Is(err, ErrInvalidQueueFile))
+
+	repairing, err := OpenFileBackedPQueue(path, MAXPQ, WithRepairOnLoad())
+	assert.Nil(t, err)
+	defer repairing.Close()
+
+	value, priority, ok, err := repairing.Pop()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, value, "d")
+	assert.Equal(t, priority, 9)
+}
@@ -0,0 +1,168 @@
+package lane
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// ShardedPQueue spreads its items over a fixed count of priority queues,
+// the shards, by key: the items pushed with a given key always land in
+// the same shard. Items are ordered within their shard only, so that
+// operations on distinct shards don't contend for the same lock.
+type ShardedPQueue struct {
+	shards []*PQueue
+}
+
+// NewShardedPQueue creates a new sharded priority queue made of the
+// provided count of shards, created with the provided pqtype ordering type
+// and options. Options such as the queue limits apply to each shard.
+func NewShardedPQueue(pqType PQType, shards int, options ...PQueueOption) (*ShardedPQueue, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("%w: shard count must be positive, got %d", ErrInvalidOption, shards)
+	}
+
+	sq := &ShardedPQueue{shards: make([]*PQueue, 0, shards)}
+	for i := 0; i < shards; i++ {
+		shard, err := NewPQueueWithOptions(pqType, options...)
+		if err != nil {
+			return nil, err
+		}
+
+		sq.shards = append(sq.shards, shard)
+	}
+
+	return sq, nil
+}
+
+// Push the value item into the shard of the key, with provided priority.
+func (sq *ShardedPQueue) Push(key string, value interface{}, priority int) error {
+	return sq.Shard(key).Push(value, priority)
+}
+
+// PopShard pops and returns the highest/lowest priority item (depending
+// on whether you're using a MINPQ or MAXPQ) from the shard of the key.
+// The boolean is false if the shard is empty.
+func (sq *ShardedPQueue) PopShard(key string) (interface{}, int, bool) {
+	return sq.Shard(key).PopRelease()
+}
+
+// Shard returns the shard the items pushed with the key land in. Keys are
+// mapped to shards using a consistent hash.
+func (sq *ShardedPQueue) Shard(key string) *PQueue {
+	return sq.shards[jumpHash(fnvHash(key), len(sq.shards))]
+}
+
+// Shards returns the shards, so that each of them can be consumed by its
+// own goroutine.
+func (sq *ShardedPQueue) Shards() []*PQueue {
+	return append([]*PQueue(nil), sq.shards...)
+}
+
+// Size returns the count of items queued in every shard
+func (sq *ShardedPQueue) Size() int {
+	size := 0
+	for _, shard := range sq.shards {
+		size += shard.Size()
+	}
+
+	return size
+}
+
+// Drain removes every item from every shard, and returns them in pop
+// order across shards, items of equal precedence being returned in shard
+// order. The shards are drained one after another, Drain is meant to be
+// called once the queue isn't used anymore, on shutdown for instance.
+func (sq *ShardedPQueue) Drain() []Item {
+	// The shards share their ordering
+	runs := &sortedRuns{less: sq.shards[0].lessItems}
+	total := 0
+
+	for i, shard := range sq.shards {
+		if drained := shard.Drain(); len(drained) > 0 {
+			runs.runs = append(runs.runs, sortedRun{items: drained, shard: i})
+			total += len(drained)
+		}
+	}
+
+	merged := make([]Item, 0, total)
+	heap.Init(runs)
+	for runs.Len() > 0 {
+		run := &runs.runs[0]
+		merged = append(merged, run.items[0])
+
+		if run.items = run.items[1:]; len(run.items) == 0 {
+			heap.Pop(runs)
+		} else {
+			heap.Fix(runs, 0)
+		}
+	}
+
+	return merged
+}
+
+// sortedRun is the items drained from a shard, in pop order
+type sortedRun struct {
+	items []Item
+	shard int
+}
+
+// sortedRuns is a heap of sorted runs, the run whose first item pops
+// first at its top, used to merge them.
+type sortedRuns struct {
+	runs []sortedRun
+	less func(a, b *item) bool
+}
+
+func (r *sortedRuns) Len() int { return len(r.runs) }
+
+func (r *sortedRuns) Less(i, j int) bool {
+	a, b := r.runs[i].items[0], r.runs[j].items[0]
+	x := &item{value: a.Value, priority: int64(a.Priority), secondary: int64(a.Secondary)}
+	y := &item{value: b.Value, priority: int64(b.Priority), secondary: int64(b.Secondary)}
+
+	switch {
+	case r.less(y, x):
+		return true
+	case r.less(x, y):
+		return false
+	}
+
+	return r.runs[i].shard < r.runs[j].shard
+}
+
+func (r *sortedRuns) Swap(i, j int) { r.runs[i], r.runs[j] = r.runs[j], r.runs[i] }
+
+func (r *sortedRuns) Push(x interface{}) { r.runs = append(r.runs, x.(sortedRun)) }
+
+func (r *sortedRuns) Pop() interface{} {
+	last := r.runs[len(r.runs)-1]
+	r.runs = r.runs[:len(r.runs)-1]
+
+	return last
+}
+
+// fnvHash returns the 64 bits FNV-1a hash of the key
+func fnvHash(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+
+	return hash
+}
+
+// jumpHash maps the key hash to one of n buckets using the jump
+// consistent hash algorithm of Lamping and Veach: growing the count of
+// buckets only moves the keys to the new buckets.
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
@@ -0,0 +1,142 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewShardedPQueue_invalid_options(t *testing.T) {
+	sq, err := NewShardedPQueue(MAXPQ, 0)
+	assert.Nil(t, sq)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	sq, err = NewShardedPQueue(MAXPQ, 4, WithMaxItems(0))
+	assert.Nil(t, sq)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestShardedPQueue_key_affinity(t *testing.T) {
+	sq, err := NewShardedPQueue(MAXPQ, 8)
+	assert.Nil(t, err)
+
+	used := make(map[*PQueue]bool)
+	for k := 0; k < 100; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		shard := sq.Shard(key)
+
+		for i := 0; i < 5; i++ {
+			assert.Nil(t, sq.Push(key, i, i))
+			assert.Same(t, sq.Shard(key), shard)
+		}
+		used[shard] = true
+	}
+
+	// Keys are spread over every shard
+	assert.Len(t, used, 8)
+	assert.Len(t, sq.Shards(), 8)
+
+	value, priority, ok := sq.PopShard("key-0")
+	assert.True(t, ok)
+	assert.Equal(t, priority, 4)
+	assert.Equal(t, value, 4)
+}
+
+func TestShardedPQueue_consistent_hashing(t *testing.T) {
+	// Growing the count of shards only moves keys to the new shards
+	for k := 0; k < 1000; k++ {
+		hash := fnvHash(fmt.Sprint(k))
+		before, after := jumpHash(hash, 8), jumpHash(hash, 9)
+		assert.True(t, after == before || after == 8, "key %d moved from %d to %d", k, before, after)
+	}
+}
+
+func TestShardedPQueue_concurrent_size(t *testing.T) {
+	sq, err := NewShardedPQueue(MAXPQ, 4)
+	assert.Nil(t, err)
+
+	const producers = 8
+	const items = 500
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+
+			for i := 0; i < items; i++ {
+				key := fmt.Sprintf("%d-%d", p, i%10)
+				sq.Push(key, i, i)
+
+				if i%3 == 0 {
+					sq.PopShard(key)
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	shardsSize := 0
+	for _, shard := range sq.Shards() {
+		shardsSize += shard.Size()
+	}
+
+	// Every producer popped one item out of three
+	assert.Equal(t, sq.Size(), producers*(items-(items+2)/3))
+	assert.Equal(t, sq.Size(), shardsSize)
+}
+
+func TestShardedPQueueDrain_globally_sorted(t *testing.T) {
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		sq, err := NewShardedPQueue(pqType, 5)
+		assert.Nil(t, err)
+
+		var priorities []int
+		for i := 0; i < 300; i++ {
+			priority := (i * 37) % 101
+			sq.Push(fmt.Sprint(i), i, priority)
+			priorities = append(priorities, priority)
+		}
+
+		sort.Ints(priorities)
+		if pqType == MAXPQ {
+			sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+		}
+
+		drained := sq.Drain()
+		assert.Len(t, drained, len(priorities))
+		for i, item := range drained {
+			assert.Equal(t, item.Priority, priorities[i])
+		}
+		assert.Equal(t, sq.Size(), 0)
+	}
+}
+
+func TestShardedPQueueDrain_ties_in_shard_order(t *testing.T) {
+	sq, err := NewShardedPQueue(MAXPQ, 3, WithStableOrder())
+	assert.Nil(t, err)
+
+	keys := make(map[*PQueue]string)
+	for k := 0; len(keys) < 3; k++ {
+		key := fmt.Sprint(k)
+		if _, ok := keys[sq.Shard(key)]; !ok {
+			keys[sq.Shard(key)] = key
+		}
+	}
+
+	for i := 2; i >= 0; i-- {
+		shard := sq.Shards()[i]
+		sq.Push(keys[shard], fmt.Sprintf("%d-a", i), 1)
+		sq.Push(keys[shard], fmt.Sprintf("%d-b", i), 1)
+	}
+
+	var values []interface{}
+	for _, item := range sq.Drain() {
+		values = append(values, item.Value)
+	}
+	assert.Equal(t, values, []interface{}{"0-a", "0-b", "1-a", "1-b", "2-a", "2-b"})
+}
@@ -0,0 +1,112 @@
+package lane
+
+import "fmt"
+
+// shrinkPolicy decides when the heap backing array is shrunk, see
+// WithShrinkPolicy. It is guarded by the queue write lock.
+type shrinkPolicy struct {
+	threshold float64
+	patience  int
+
+	// below is the count of consecutive mutating operations which left
+	// the queue length below the threshold.
+	below int
+}
+
+// WithInitialCapacity preallocates the queue heap for n items. The heap
+// never shrinks below that capacity, see WithShrinkPolicy and Compact.
+func WithInitialCapacity(n int) PQueueOption {
+	return func(pq *PQueue) error {
+		if n < 0 {
+			return fmt.Errorf("%w: initial capacity must not be negative, got %d", ErrInvalidOption, n)
+		}
+
+		pq.capacityHint = n
+		pq.items = make([]*item, 1, n+1)
+		return nil
+	}
+}
+
+// WithShrinkPolicy makes the queue shrink its heap backing array once
+// the queue length stayed below threshold times its capacity for
+// patience consecutive mutating operations, such as pushes and pops. The
+// array is reallocated with a capacity of twice the queue length, if
+// lower than its current capacity, so that a queue whose length
+// oscillates doesn't reallocate it every cycle. The heap doesn't shrink
+// by default.
+//
+// threshold must be between 0 and 1, excluded, and patience positive.
+// Stats reports the count of reallocations.
+func WithShrinkPolicy(threshold float64, patience int) PQueueOption {
+	return func(pq *PQueue) error {
+		if !(threshold > 0 && threshold < 1) {
+			return fmt.Errorf("%w: shrink threshold must be between 0 and 1, got %v", ErrInvalidOption, threshold)
+		}
+
+		if patience < 1 {
+			return fmt.Errorf("%w: shrink patience must be positive, got %d", ErrInvalidOption, patience)
+		}
+
+		pq.shrink = &shrinkPolicy{threshold: threshold, patience: patience}
+		return nil
+	}
+}
+
+// Compact reallocates the heap backing array to exactly fit the queued
+// items, or the initial capacity if larger, see WithInitialCapacity, so
+// that a queue known to have reached its steady state doesn't retain
+// more memory than it needs. Items staged in a write buffer are merged
+// first.
+func (pq *PQueue) Compact() {
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+	pq.lazyInit()
+	pq.reallocate(pq.elemsCount)
+}
+
+// checkShrink shrinks the heap backing array according to the shrink
+// policy, if any. The caller must hold the write lock.
+func (pq *PQueue) checkShrink() {
+	s := pq.shrink
+	if s == nil {
+		return
+	}
+
+	capacity := cap(pq.items) - 1
+	if capacity <= pq.capacityHint || float64(pq.elemsCount) >= s.threshold*float64(capacity) {
+		s.below = 0
+		return
+	}
+
+	if s.below++; s.below < s.patience {
+		return
+	}
+	s.below = 0
+
+	// High thresholds leave no room for twice the queue length
+	if target := 2 * pq.elemsCount; target < capacity {
+		pq.reallocate(target)
+	} else {
+		pq.reallocate(pq.elemsCount)
+	}
+}
+
+// reallocate moves the heap into a new backing array of capacity items,
+// or of the initial capacity if larger, unless its capacity already is
+// that one. The caller must hold the write lock.
+func (pq *PQueue) reallocate(capacity int) {
+	if capacity < pq.capacityHint {
+		capacity = pq.capacityHint
+	}
+
+	if cap(pq.items)-1 == capacity {
+		return
+	}
+
+	items := make([]*item, pq.elemsCount+1, capacity+1)
+	copy(items, pq.items)
+	pq.items = items
+	pq.reallocations++
+}
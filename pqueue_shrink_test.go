@@ -0,0 +1,136 @@
+package lane
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPQueueWithOptions_invalid_shrink_policy(t *testing.T) {
+	for _, option := range []PQueueOption{
+		WithInitialCapacity(-1),
+		WithShrinkPolicy(0, 10),
+		WithShrinkPolicy(1, 10),
+		WithShrinkPolicy(0.25, 0),
+	} {
+		_, err := NewPQueueWithOptions(MAXPQ, option)
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+	}
+}
+
+// oscillate pushes the queue up to high items and pops it down to low
+// items, cycles times.
+func oscillate(pqueue *PQueue, high, low, cycles int) {
+	for c := 0; c < cycles; c++ {
+		for pqueue.Size() < high {
+			pqueue.Push(c, pqueue.Size())
+		}
+
+		for pqueue.Size() > low {
+			pqueue.Pop()
+		}
+	}
+}
+
+func TestPQueueShrinkPolicy_oscillating_queue_does_not_thrash(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithShrinkPolicy(0.25, 1000))
+	assert.Nil(t, err)
+
+	oscillate(pqueue, 1000, 600, 10)
+	assert.Equal(t, pqueue.Stats().Reallocations, uint64(0))
+
+	// Brief dips below the threshold don't shrink the heap either
+	oscillate(pqueue, 1000, 200, 10)
+	assert.Equal(t, pqueue.Stats().Reallocations, uint64(0))
+
+	// Whereas an impatient policy reallocates every cycle
+	impatient, err := NewPQueueWithOptions(MAXPQ, WithShrinkPolicy(0.7, 1))
+	assert.Nil(t, err)
+
+	oscillate(impatient, 1000, 600, 10)
+	assert.True(t, impatient.Stats().Reallocations >= 10)
+}
+
+func TestPQueueShrinkPolicy_shrinks_once_patient(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithShrinkPolicy(0.25, 100))
+	assert.Nil(t, err)
+
+	oscillate(pqueue, 10000, 100, 1)
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+		pqueue.Pop()
+	}
+
+	assert.True(t, pqueue.Stats().Reallocations > 0)
+	assert.True(t, pqueue.Diagnostics().Cap <= 400)
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+
+	previous := 10000
+	for pqueue.Size() > 0 {
+		_, priority := pqueue.Pop()
+		assert.True(t, priority <= previous)
+		previous = priority
+	}
+}
+
+func TestPQueueShrinkPolicy_keeps_initial_capacity(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithInitialCapacity(1000), WithShrinkPolicy(0.25, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, pqueue.Diagnostics().Cap, 1000)
+
+	oscillate(pqueue, 5000, 0, 1)
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+		pqueue.Pop()
+	}
+
+	assert.Equal(t, pqueue.Diagnostics().Cap, 1000)
+	assert.True(t, pqueue.Stats().Reallocations > 0)
+}
+
+func TestPQueueCompact(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+	}
+	for i := 0; i < 90; i++ {
+		pqueue.Pop()
+	}
+
+	pqueue.Compact()
+	assert.Equal(t, pqueue.Diagnostics().Cap, 10)
+	assert.Equal(t, pqueue.Stats().Reallocations, uint64(1))
+
+	// Capacity already fits the queued items
+	pqueue.Compact()
+	assert.Equal(t, pqueue.Stats().Reallocations, uint64(1))
+
+	for i := 90; i < 100; i++ {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value, i)
+	}
+
+	pqueue.Compact()
+	assert.Equal(t, pqueue.Diagnostics().Cap, 0)
+
+	var zero PQueue
+	zero.Compact()
+	assert.Equal(t, zero.Size(), 0)
+}
+
+func TestPQueueCompact_keeps_initial_capacity(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithInitialCapacity(50))
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i)
+	}
+	for i := 0; i < 90; i++ {
+		pqueue.Pop()
+	}
+
+	pqueue.Compact()
+	assert.Equal(t, pqueue.Diagnostics().Cap, 50)
+	assert.Equal(t, pqueue.Clone().Diagnostics().Cap, 50)
+}
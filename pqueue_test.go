@@ -1,8 +1,14 @@
 package lane
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMaxPQueue_init(t *testing.T) {
@@ -11,7 +17,7 @@ func TestMaxPQueue_init(t *testing.T) {
 	assert.Equal(t, len(pqueue.items), 1)
 	assert.Equal(t, pqueue.Size(), 0)
 	assert.Nil(t, pqueue.items[0])
-	assert.Equal(t, pqueue.comparator, max)
+	assert.Equal(t, reflect.ValueOf(pqueue.comparator).Pointer(), reflect.ValueOf(max).Pointer())
 }
 
 func TestMinPQueue_init(t *testing.T) {
@@ -20,7 +26,7 @@ func TestMinPQueue_init(t *testing.T) {
 	assert.Equal(t, len(pqueue.items), 1)
 	assert.Equal(t, pqueue.Size(), 0)
 	assert.Nil(t, pqueue.items[0])
-	assert.Equal(t, pqueue.comparator, min)
+	assert.Equal(t, reflect.ValueOf(pqueue.comparator).Pointer(), reflect.ValueOf(min).Pointer())
 }
 
 func TestMaxPQueuePush_protects_max_order(t *testing.T) {
@@ -35,19 +41,19 @@ func TestMaxPQueuePush_protects_max_order(t *testing.T) {
 	firstItemValue, ok := firstItem.value.(string)
 	assert.True(t, ok)
 	assert.Equal(t, firstItemValue, "3")
-	assert.Equal(t, firstItem.priority, 3)
+	assert.Equal(t, firstItem.priority, int64(3))
 
 	firstItem = pqueue.items[2]
 	firstItemValue, ok = firstItem.value.(string)
 	assert.True(t, ok)
 	assert.Equal(t, firstItemValue, "1")
-	assert.Equal(t, firstItem.priority, 1)
+	assert.Equal(t, firstItem.priority, int64(1))
 
 	firstItem = pqueue.items[3]
 	firstItemValue, ok = firstItem.value.(string)
 	assert.True(t, ok)
 	assert.Equal(t, firstItemValue, "2")
-	assert.Equal(t, firstItem.priority, 2)
+	assert.Equal(t, firstItem.priority, int64(2))
 }
 
 func TestMinPQueuePush_protects_min_order(t *testing.T) {
@@ -62,19 +68,19 @@ func TestMinPQueuePush_protects_min_order(t *testing.T) {
 	firstItemValue, ok := firstItem.value.(string)
 	assert.True(t, ok)
 	assert.Equal(t, firstItemValue, "1")
-	assert.Equal(t, firstItem.priority, 1)
+	assert.Equal(t, firstItem.priority, int64(1))
 
 	firstItem = pqueue.items[2]
 	firstItemValue, ok = firstItem.value.(string)
 	assert.True(t, ok)
 	assert.Equal(t, firstItemValue, "2")
-	assert.Equal(t, firstItem.priority, 2)
+	assert.Equal(t, firstItem.priority, int64(2))
 
 	firstItem = pqueue.items[3]
 	firstItemValue, ok = firstItem.value.(string)
 	assert.True(t, ok)
 	assert.Equal(t, firstItemValue, "3")
-	assert.Equal(t, firstItem.priority, 3)
+	assert.Equal(t, firstItem.priority, int64(3))
 }
 
 func TestMaxPQueuePop_protects_max_order(t *testing.T) {
@@ -158,3 +164,500 @@ func TestMinPQueueHead_returns_min_element(t *testing.T) {
 	assert.Equal(t, priority, 1)
 	assert.Equal(t, pqueue.Size(), 3)
 }
+
+func TestNewPQueueWithOptions_stable_and_random_are_incompatible(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder(), WithRandomTieBreak(42))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+}
+
+func TestPQueueStableOrder_pops_equal_priorities_in_push_order(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i%3)
+	}
+
+	for _, priority := range []int{2, 1, 0} {
+		for i := priority; i < 100; i += 3 {
+			value, p := pqueue.Pop()
+			assert.Equal(t, value, i)
+			assert.Equal(t, p, priority)
+		}
+	}
+}
+
+func TestPQueueRandomTieBreak_is_reproducible(t *testing.T) {
+	popAll := func(seed int64) []interface{} {
+		pqueue, err := NewPQueueWithOptions(MINPQ, WithRandomTieBreak(seed))
+		assert.Nil(t, err)
+
+		for i := 0; i < 100; i++ {
+			pqueue.Push(i, 1)
+		}
+
+		values := make([]interface{}, 0, 100)
+		for pqueue.Size() > 0 {
+			value, _ := pqueue.Pop()
+			values = append(values, value)
+		}
+
+		return values
+	}
+
+	assert.Equal(t, popAll(7), popAll(7))
+	assert.NotEqual(t, popAll(7), popAll(8))
+}
+
+func TestPQueueRandomTieBreak_spreads_producers_uniformly(t *testing.T) {
+	const producers = 4
+	const pushes = 1000
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithRandomTieBreak(1))
+	assert.Nil(t, err)
+
+	// Producers push their whole batch one after the other, which
+	// is the worst case for a deterministic tie break.
+	for p := 0; p < producers; p++ {
+		for i := 0; i < pushes; i++ {
+			pqueue.Push(p, 10)
+		}
+	}
+
+	var positions [producers]int
+	for position := 0; pqueue.Size() > 0; position++ {
+		value, _ := pqueue.Pop()
+		positions[value.(int)] += position
+	}
+
+	// Popping positions are uniformly distributed, so each producer's
+	// mean position should be close to the overall mean
+	// (standard deviation is around 36 positions).
+	expectedMean := float64(producers*pushes-1) / 2
+	for p := 0; p < producers; p++ {
+		mean := float64(positions[p]) / pushes
+		assert.InDelta(t, expectedMean, mean, 200, "producer %d", p)
+	}
+}
+
+// assertHeapInvariant checks that no item of the queue heap has a
+// higher precedence than its parent, and that items know their index.
+func assertHeapInvariant(t *testing.T, pqueue *PQueue) bool {
+	pqueue.RLock()
+	defer pqueue.RUnlock()
+
+	for k := 1; k <= pqueue.elemsCount; k++ {
+		if !assert.Equal(t, pqueue.items[k].index, k) {
+			return false
+		}
+
+		if k > 1 && !assert.False(t, pqueue.less(k/2, k), "item %d has precedence over its parent", k) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type rankedTask struct {
+	name string
+	rank int
+}
+
+func lowerRank(a, b interface{}) bool {
+	return a.(*rankedTask).rank < b.(*rankedTask).rank
+}
+
+func TestPQueueValueComparator_orders_by_value(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithValueComparator(lowerRank))
+	assert.Nil(t, err)
+
+	pqueue.Push(&rankedTask{"b", 2}, 0)
+	pqueue.Push(&rankedTask{"c", 3}, 0)
+	pqueue.Push(&rankedTask{"a", 1}, 0)
+
+	for _, name := range []string{"a", "b", "c"} {
+		value, _ := pqueue.Pop()
+		assert.Equal(t, value.(*rankedTask).name, name)
+	}
+}
+
+func TestPQueueFix(t *testing.T) {
+	newQueue := func() (*PQueue, map[string]*ItemRef) {
+		pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueComparator(lowerRank))
+		assert.Nil(t, err)
+
+		refs := make(map[string]*ItemRef)
+		for i, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+			refs[name], _ = pqueue.PushRef(&rankedTask{name, i}, 0)
+		}
+
+		return pqueue, refs
+	}
+
+	popNames := func(pqueue *PQueue) string {
+		names := ""
+		for pqueue.Size() > 0 {
+			value, _ := pqueue.Pop()
+			names += value.(*rankedTask).name
+		}
+
+		return names
+	}
+
+	t.Run("priority up", func(t *testing.T) {
+		pqueue, refs := newQueue()
+		refs["a"].Value().(*rankedTask).rank = 10
+
+		assert.True(t, pqueue.Fix(refs["a"]))
+		assertHeapInvariant(t, pqueue)
+		assert.Equal(t, popNames(pqueue), "agfedcb")
+	})
+
+	t.Run("priority down", func(t *testing.T) {
+		pqueue, refs := newQueue()
+		refs["g"].Value().(*rankedTask).rank = -1
+
+		assert.True(t, pqueue.Fix(refs["g"]))
+		assertHeapInvariant(t, pqueue)
+		assert.Equal(t, popNames(pqueue), "fedcbag")
+	})
+
+	t.Run("priority unchanged", func(t *testing.T) {
+		pqueue, refs := newQueue()
+
+		for _, ref := range refs {
+			assert.True(t, pqueue.Fix(ref))
+		}
+		assertHeapInvariant(t, pqueue)
+		assert.Equal(t, popNames(pqueue), "gfedcba")
+	})
+
+	t.Run("popped item", func(t *testing.T) {
+		pqueue, refs := newQueue()
+		pqueue.Pop()
+
+		assert.False(t, pqueue.Fix(refs["g"]))
+		assert.True(t, pqueue.Fix(refs["f"]))
+	})
+}
+
+func TestPQueueFix_is_safe_for_concurrent_usage(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+
+	refs := make([]*ItemRef, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		ref, _ := pqueue.PushRef(i, i%17)
+		refs = append(refs, ref)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for _, ref := range refs {
+			pqueue.Fix(ref)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			pqueue.Pop()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			pqueue.Push(i, i%13)
+		}
+	}()
+	wg.Wait()
+
+	assert.Equal(t, pqueue.Size(), 1000)
+	assertHeapInvariant(t, pqueue)
+}
+
+func TestPQueuePopPriorityGroup_empty_queue(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	priority, values, ok := pqueue.PopPriorityGroup()
+	assert.False(t, ok)
+	assert.Equal(t, priority, 0)
+	assert.Nil(t, values)
+}
+
+func TestPQueuePopPriorityGroup_single_priority(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithStableOrder())
+	assert.Nil(t, err)
+
+	expected := make([]interface{}, 0, 50)
+	for i := 0; i < 50; i++ {
+		pqueue.Push(i, 7)
+		expected = append(expected, i)
+	}
+
+	priority, values, ok := pqueue.PopPriorityGroup()
+	assert.True(t, ok)
+	assert.Equal(t, priority, 7)
+	assert.Equal(t, values, expected)
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueuePopPriorityGroup_distinct_priorities(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	for _, priority := range []int{4, 1, 3, 2} {
+		pqueue.Push(priority*10, priority)
+	}
+
+	for expected := 4; expected > 0; expected-- {
+		priority, values, ok := pqueue.PopPriorityGroup()
+		assert.True(t, ok)
+		assert.Equal(t, priority, expected)
+		assert.Equal(t, values, []interface{}{expected * 10})
+	}
+}
+
+func TestPQueuePopPriorityGroup_mixed_priorities(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+
+	pqueue.Push("a", 1)
+	pqueue.Push("b", 2)
+	pqueue.Push("c", 1)
+	pqueue.Push("d", 2)
+	pqueue.Push("e", 3)
+
+	priority, values, _ := pqueue.PopPriorityGroup()
+	assert.Equal(t, priority, 3)
+	assert.Equal(t, values, []interface{}{"e"})
+
+	priority, values, _ = pqueue.PopPriorityGroup()
+	assert.Equal(t, priority, 2)
+	assert.Equal(t, values, []interface{}{"b", "d"})
+
+	priority, values, _ = pqueue.PopPriorityGroup()
+	assert.Equal(t, priority, 1)
+	assert.Equal(t, values, []interface{}{"a", "c"})
+}
+
+func TestPQueue_zero_value(t *testing.T) {
+	var pqueue PQueue
+
+	assert.Equal(t, pqueue.Size(), 0)
+
+	value, priority := pqueue.Pop()
+	assert.Nil(t, value)
+	assert.Equal(t, priority, 0)
+
+	value, priority = pqueue.Head()
+	assert.Nil(t, value)
+	assert.Equal(t, priority, 0)
+
+	assert.Equal(t, pqueue.RemoveWhere(func(interface{}, int) bool { return true }), 0)
+
+	for _, priority := range []int{2, 5, 1, 4, 3} {
+		assert.Nil(t, pqueue.Push(priority, priority))
+	}
+
+	assert.Equal(t, pqueue.Size(), 5)
+	assert.True(t, assertHeapInvariant(t, &pqueue))
+
+	// The zero value is a max priority queue
+	for expected := 5; expected > 0; expected-- {
+		value, priority := pqueue.Pop()
+		assert.Equal(t, value, expected)
+		assert.Equal(t, priority, expected)
+	}
+}
+
+func TestPQueue_zero_value_clone(t *testing.T) {
+	var pqueue PQueue
+	clone := pqueue.Clone()
+
+	clone.Push("a", 1)
+	clone.Push("b", 2)
+
+	value, _ := clone.Pop()
+	assert.Equal(t, value, "b")
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueue_embedded(t *testing.T) {
+	type byValue struct {
+		PQueue
+		name string
+	}
+
+	type byPointer struct {
+		*PQueue
+		name string
+	}
+
+	embedded := &byValue{name: "value"}
+	embedded.Push("a", 1)
+	embedded.Push("b", 2)
+
+	value, _ := embedded.Pop()
+	assert.Equal(t, value, "b")
+	assert.Equal(t, embedded.Size(), 1)
+
+	pointer := byPointer{PQueue: NewPQueue(MINPQ), name: "pointer"}
+	pointer.Push("a", 1)
+	pointer.Push("b", 2)
+
+	// Copying the struct shares the queue through its pointer
+	shared := pointer
+	value, _ = shared.Pop()
+	assert.Equal(t, value, "a")
+	assert.Equal(t, pointer.Size(), 1)
+}
+
+func TestPQueuePopRelease(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("1", 1)
+	pqueue.Push("2", 2)
+
+	value, priority, ok := pqueue.PopRelease()
+	assert.True(t, ok)
+	assert.Equal(t, value, "2")
+	assert.Equal(t, priority, 2)
+
+	value, priority, ok = pqueue.PopRelease()
+	assert.True(t, ok)
+	assert.Equal(t, value, "1")
+	assert.Equal(t, priority, 1)
+
+	value, priority, ok = pqueue.PopRelease()
+	assert.False(t, ok)
+	assert.Nil(t, value)
+	assert.Equal(t, priority, 0)
+}
+
+// largeBuffer is a value whose collection can be observed with a
+// finalizer.
+type largeBuffer struct {
+	data []byte
+}
+
+func TestPQueuePopRelease_retains_no_reference(t *testing.T) {
+	testCases := map[string][]PQueueOption{
+		"default":    nil,
+		"arena":      {WithArena(4)},
+		"stable":     {WithStableOrder()},
+		"random":     {WithRandomTieBreak(1)},
+		"limits":     {WithMaxItems(16), WithMaxBytes(1 << 24), WithSizeEstimator(func(value interface{}) int { return 1 })},
+		"dedup":      {WithRecentDedup(time.Hour, func(value interface{}) string { return "key" })},
+		"buffer":     {WithWriteBuffer(4, time.Hour)},
+		"contention": {WithContentionProfiling()},
+	}
+
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pqueue, err := NewPQueueWithOptions(MAXPQ, options...)
+			assert.Nil(t, err)
+
+			pqueue.Push(&largeBuffer{data: make([]byte, 1<<20)}, 3)
+			pqueue.Push("1", 1)
+			pqueue.Push("2", 2)
+
+			collected := make(chan struct{})
+			func() {
+				value, _, ok := pqueue.PopRelease()
+				assert.True(t, ok)
+				runtime.SetFinalizer(value.(*largeBuffer), func(*largeBuffer) { close(collected) })
+			}()
+
+			stats := pqueue.Stats()
+			assert.Greater(t, stats.Size, 0)
+
+			deadline := time.After(5 * time.Second)
+			for done := false; !done; {
+				runtime.GC()
+
+				select {
+				case <-collected:
+					done = true
+				case <-deadline:
+					t.Fatal("popped value was not collected")
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+
+			// The queue still holds the other items
+			assert.Equal(t, pqueue.Size(), stats.Size)
+			runtime.KeepAlive(pqueue)
+		})
+	}
+}
+
+func TestPQueuePop_releases_stale_refs_value(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	ref, err := pqueue.PushRef("1", 1)
+	assert.Nil(t, err)
+
+	pqueue.Pop()
+	assert.Nil(t, ref.item.value)
+	assert.Equal(t, ref.Value(), "1")
+	assert.False(t, pqueue.Fix(ref))
+}
+
+func TestPQueuePush64_orders_priorities_beyond_int32(t *testing.T) {
+	// Millisecond timestamps overflow 32 bits integers
+	priorities := []int64{1 << 40, math.MinInt64, 1<<31 + 1, -(1 << 33), 1 << 31, math.MaxInt64, 0}
+
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		pqueue := NewPQueue(pqType)
+		for _, priority := range priorities {
+			assert.Nil(t, pqueue.Push64(priority, priority))
+		}
+
+		head, headPriority := pqueue.Head64()
+		assert.Equal(t, head, headPriority)
+
+		var popped []int64
+		for pqueue.Size() > 0 {
+			value, priority := pqueue.Pop64()
+			assert.Equal(t, value, priority)
+			popped = append(popped, priority)
+		}
+
+		expected := []int64{math.MaxInt64, 1 << 40, 1<<31 + 1, 1 << 31, 0, -(1 << 33), math.MinInt64}
+		if pqType == MINPQ {
+			for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+				expected[i], expected[j] = expected[j], expected[i]
+			}
+		}
+
+		assert.Equal(t, popped, expected)
+		assert.Equal(t, headPriority, expected[0])
+	}
+}
+
+func TestPQueueSize_concurrent_with_push(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			pqueue.Push(i, i)
+		}
+	}()
+
+	// Run with -race: Size reads the published size gauge, which only
+	// grows while items are pushed
+	for previous := 0; previous < 1000; {
+		size := pqueue.Size()
+		assert.True(t, size >= previous)
+		previous = size
+	}
+	wg.Wait()
+
+	assert.Equal(t, pqueue.Size(), 1000)
+	assert.Equal(t, pqueue.Stats().Size, 1000)
+	assert.Equal(t, pqueue.MetricsSnapshot().Size, int64(1000))
+}
@@ -0,0 +1,188 @@
+package lane
+
+import (
+	"fmt"
+	"sync"
+)
+
+// worstPopper is implemented by the priority queues which can pop their
+// lowest precedence item, such as PQueue.
+type worstPopper interface {
+	PopWorst() (interface{}, int, bool)
+}
+
+// TieredPQueue is a priority queue composed of a small primary queue,
+// and of a larger secondary queue holding the items which don't fit in
+// the primary one. Items are popped in priority order across both
+// queues.
+type TieredPQueue struct {
+	sync.Mutex
+	primary   PriorityQueue
+	secondary PriorityQueue
+	pqType    PQType
+	high      int
+	low       int
+}
+
+// NewTieredPQueue creates a new tiered priority queue with the provided
+// pqtype ordering type, which both queues must share.
+//
+// The primary queue holds up to high items: when it is full, pushing an
+// item spills the lowest precedence item of the primary queue to the
+// secondary queue, or the pushed item itself if the primary queue can't
+// pop its lowest precedence item (see PQueue.PopWorst). When popping
+// makes the primary queue hold less than low items, it is refilled from
+// the secondary queue up to high items.
+func NewTieredPQueue(pqType PQType, primary, secondary PriorityQueue, high, low int) (*TieredPQueue, error) {
+	if high < 1 {
+		return nil, fmt.Errorf("%w: high watermark must be positive, got %d", ErrInvalidOption, high)
+	}
+
+	if low < 0 || low > high {
+		return nil, fmt.Errorf("%w: low watermark must be between 0 and %d, got %d", ErrInvalidOption, high, low)
+	}
+
+	return &TieredPQueue{
+		primary:   primary,
+		secondary: secondary,
+		pqType:    pqType,
+		high:      high,
+		low:       low,
+	}, nil
+}
+
+// Push the value item into the priority queue with provided priority.
+// An error is returned if neither queue accepts the item, or if the
+// item takes the place of a primary queue item which the secondary
+// queue doesn't accept: no item is ever dropped.
+func (tq *TieredPQueue) Push(value interface{}, priority int) error {
+	tq.Lock()
+	defer tq.Unlock()
+
+	if tq.primary.Size() < tq.high {
+		if err := tq.primary.Push(value, priority); err == nil {
+			return nil
+		}
+
+		return tq.secondary.Push(value, priority)
+	}
+
+	popper, ok := tq.primary.(worstPopper)
+	if !ok {
+		return tq.secondary.Push(value, priority)
+	}
+
+	worst, worstPriority, ok := popper.PopWorst()
+	if !ok {
+		return tq.secondary.Push(value, priority)
+	}
+
+	// The worst item just left the primary queue, which has room to
+	// take it back.
+	if !tq.precedes(priority, worstPriority) {
+		if err := tq.primary.Push(worst, worstPriority); err != nil {
+			return err
+		}
+
+		return tq.secondary.Push(value, priority)
+	}
+
+	// The pushed item is rejected when the secondary queue rejects the
+	// one it would have spilled.
+	if spillErr := tq.secondary.Push(worst, worstPriority); spillErr != nil {
+		if err := tq.primary.Push(worst, worstPriority); err != nil {
+			return err
+		}
+
+		return spillErr
+	}
+
+	return tq.primary.Push(value, priority)
+}
+
+// Pop and returns the highest/lowest priority item (depending on whether
+// you're using a MINPQ or MAXPQ) from both queues.
+func (tq *TieredPQueue) Pop() (interface{}, int) {
+	tq.Lock()
+	defer tq.Unlock()
+
+	var value interface{}
+	var priority int
+
+	if tq.secondaryFirst() {
+		value, priority = tq.secondary.Pop()
+	} else {
+		value, priority = tq.primary.Pop()
+	}
+
+	tq.refill()
+
+	return value, priority
+}
+
+// Head returns the highest/lowest priority item (depending on whether
+// you're using a MINPQ or MAXPQ) from both queues.
+func (tq *TieredPQueue) Head() (interface{}, int) {
+	tq.Lock()
+	defer tq.Unlock()
+
+	if tq.secondaryFirst() {
+		return tq.secondary.Head()
+	}
+
+	return tq.primary.Head()
+}
+
+// Size returns the elements present in both queues count
+func (tq *TieredPQueue) Size() int {
+	tq.Lock()
+	defer tq.Unlock()
+
+	return tq.primary.Size() + tq.secondary.Size()
+}
+
+// secondaryFirst reports whether the secondary queue head precedes the
+// primary queue head. The caller must hold the lock.
+func (tq *TieredPQueue) secondaryFirst() bool {
+	if tq.secondary.Size() == 0 {
+		return false
+	}
+
+	if tq.primary.Size() == 0 {
+		return true
+	}
+
+	_, primary := tq.primary.Head()
+	_, secondary := tq.secondary.Head()
+
+	return tq.precedes(secondary, primary)
+}
+
+// precedes reports whether an item of priority a is popped before an
+// item of priority b.
+func (tq *TieredPQueue) precedes(a, b int) bool {
+	if tq.pqType == MINPQ {
+		return a < b
+	}
+
+	return a > b
+}
+
+// refill moves the secondary queue head items to the primary queue once
+// it holds less than the low watermark. The caller must hold the lock.
+func (tq *TieredPQueue) refill() {
+	if tq.primary.Size() >= tq.low {
+		return
+	}
+
+	// Items are only popped from the secondary queue once the primary
+	// queue accepted them, so that a rejected one stays where it is.
+	for tq.primary.Size() < tq.high && tq.secondary.Size() > 0 {
+		value, priority := tq.secondary.Head()
+		if tq.primary.Push(value, priority) != nil {
+			return
+		}
+
+		tq.secondary.Pop()
+	}
+}
@@ -0,0 +1,190 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ PriorityQueue = (*PQueue)(nil)
+var _ PriorityQueue = (*TieredPQueue)(nil)
+
+// sizeOnly hides the optional methods of a priority queue
+type sizeOnly struct {
+	PriorityQueue
+}
+
+func newTieredPQueue(t *testing.T, pqType PQType, primary PriorityQueue, high, low int) *TieredPQueue {
+	tiered, err := NewTieredPQueue(pqType, primary, NewPQueue(pqType), high, low)
+	assert.Nil(t, err)
+
+	return tiered
+}
+
+func TestNewTieredPQueue_invalid_watermarks(t *testing.T) {
+	for _, watermarks := range [][2]int{{0, 0}, {4, -1}, {4, 5}} {
+		tiered, err := NewTieredPQueue(MAXPQ, NewPQueue(MAXPQ), NewPQueue(MAXPQ), watermarks[0], watermarks[1])
+		assert.Nil(t, tiered)
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+	}
+}
+
+func TestTieredPQueue_spills_worst_items(t *testing.T) {
+	primary := NewPQueue(MAXPQ)
+	tiered := newTieredPQueue(t, MAXPQ, primary, 3, 1)
+
+	for _, priority := range []int{5, 1, 9, 7, 3} {
+		assert.Nil(t, tiered.Push(priority, priority))
+	}
+
+	assert.Equal(t, tiered.Size(), 5)
+	assert.Equal(t, primary.Size(), 3)
+	assert.Equal(t, tiered.secondary.Size(), 2)
+
+	_, worst := primary.Head()
+	assert.Equal(t, worst, 9)
+	_, spilled := tiered.secondary.Head()
+	assert.Equal(t, spilled, 3)
+
+	value, priority := tiered.Head()
+	assert.Equal(t, value, 9)
+	assert.Equal(t, priority, 9)
+}
+
+func TestTieredPQueue_refills_below_low_watermark(t *testing.T) {
+	primary := NewPQueue(MINPQ)
+	tiered := newTieredPQueue(t, MINPQ, primary, 4, 2)
+
+	for priority := 1; priority <= 10; priority++ {
+		tiered.Push(priority, priority)
+	}
+
+	tiered.Pop()
+	tiered.Pop()
+	assert.Equal(t, primary.Size(), 2)
+
+	// Dropping below the low watermark refills up to the high one
+	tiered.Pop()
+	assert.Equal(t, primary.Size(), 4)
+	assert.Equal(t, tiered.Size(), 7)
+}
+
+func TestTieredPQueue_matches_flat_queue(t *testing.T) {
+	for _, pqType := range []PQType{MAXPQ, MINPQ} {
+		for name, primary := range map[string]PriorityQueue{
+			"pqueue":    NewPQueue(pqType),
+			"size only": sizeOnly{NewPQueue(pqType)},
+		} {
+			t.Run(name, func(t *testing.T) {
+				flat := NewPQueue(pqType)
+				tiered := newTieredPQueue(t, pqType, primary, 8, 3)
+				random := rand.New(rand.NewSource(3))
+
+				// Priorities are distinct so that the pop order is unique
+				priorities := random.Perm(2000)
+				for i := 0; i < 4000; i++ {
+					if random.Intn(3) > 0 && len(priorities) > 0 {
+						flat.Push(priorities[0], priorities[0])
+						tiered.Push(priorities[0], priorities[0])
+						priorities = priorities[1:]
+						continue
+					}
+
+					expectedValue, expectedPriority := flat.Head()
+					value, priority := tiered.Head()
+					assert.Equal(t, value, expectedValue)
+					assert.Equal(t, priority, expectedPriority)
+
+					expectedValue, expectedPriority = flat.Pop()
+					value, priority = tiered.Pop()
+					assert.Equal(t, value, expectedValue)
+					assert.Equal(t, priority, expectedPriority)
+					assert.Equal(t, tiered.Size(), flat.Size())
+				}
+
+				for flat.Size() > 0 {
+					expected, _ := flat.Pop()
+					value, _ := tiered.Pop()
+					assert.Equal(t, value, expected)
+				}
+				assert.Equal(t, tiered.Size(), 0)
+			})
+		}
+	}
+}
+
+func TestTieredPQueue_primary_limits(t *testing.T) {
+	primary, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(2))
+	assert.Nil(t, err)
+
+	// The primary queue is full before reaching the high watermark
+	tiered := newTieredPQueue(t, MAXPQ, primary, 4, 1)
+	for priority := 1; priority <= 4; priority++ {
+		assert.Nil(t, tiered.Push(priority, priority))
+	}
+
+	assert.Equal(t, primary.Size(), 2)
+	for expected := 4; expected > 0; expected-- {
+		value, _ := tiered.Pop()
+		assert.Equal(t, value, expected)
+	}
+}
+
+func TestTieredPQueue_keeps_items_the_secondary_rejects(t *testing.T) {
+	secondary, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(1))
+	assert.Nil(t, err)
+	primary := NewPQueue(MAXPQ)
+	tiered, err := NewTieredPQueue(MAXPQ, primary, secondary, 2, 0)
+	assert.Nil(t, err)
+
+	for _, priority := range []int{5, 3, 1} {
+		assert.Nil(t, tiered.Push(priority, priority))
+	}
+
+	// The secondary queue is full: the spilled item is kept, and the
+	// pushed ones are rejected whether they would have been spilled or
+	// would have spilled another item.
+	assert.True(t, errors.Is(tiered.Push(9, 9), ErrFull))
+	assert.True(t, errors.Is(tiered.Push(0, 0), ErrFull))
+	assert.Equal(t, tiered.Size(), 3)
+
+	secondary.Close()
+	assert.True(t, errors.Is(tiered.Push(7, 7), ErrClosed))
+	assert.Equal(t, primary.Size(), 2)
+
+	popped := []interface{}{}
+	for primary.Size() > 0 {
+		value, _ := tiered.Pop()
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{5, 3})
+	assert.Equal(t, secondary.Size(), 1)
+}
+
+func TestTieredPQueue_refill_keeps_items_the_primary_rejects(t *testing.T) {
+	primary, err := NewPQueueWithOptions(MAXPQ, WithMaxItems(1))
+	assert.Nil(t, err)
+	secondary, err := NewPQueueWithOptions(MAXPQ, WithRecentDedup(time.Hour, func(value interface{}) string {
+		return fmt.Sprint(value)
+	}))
+	assert.Nil(t, err)
+	tiered, err := NewTieredPQueue(MAXPQ, primary, secondary, 4, 2)
+	assert.Nil(t, err)
+
+	for priority := 1; priority <= 4; priority++ {
+		assert.Nil(t, tiered.Push(priority, priority))
+	}
+
+	// Refilling stops at the secondary queue items the primary queue
+	// rejects, which could not be pushed back once popped.
+	popped := []interface{}{}
+	for tiered.Size() > 0 {
+		value, _ := tiered.Pop()
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{4, 3, 2, 1})
+}
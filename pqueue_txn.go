@@ -0,0 +1,254 @@
+package lane
+
+import "sync/atomic"
+
+// PQueueTxn stages pops and pushes against a priority queue, applied
+// at once when the transaction commits, see Txn.
+type PQueueTxn struct {
+	pq *PQueue
+
+	// frontier holds the heap indexes of the items which may be popped
+	// next: the popped items form the top of the heap, the frontier
+	// being their children.
+	frontier  []int
+	popped    []*item
+	pushed    []*item
+	cancelled []*item
+}
+
+// Txn calls fn with a transaction staging pops and pushes against the
+// priority queue, all of it while holding the queue write lock: other
+// goroutines see the queue as it was until the transaction commits, and
+// concurrent transactions are serialized.
+//
+// If fn returns nil, the staged pops and pushes are applied at once, the
+// popped items being removed and the pushed items queued. If fn returns
+// an error or panics, they are discarded, the queue being left
+// untouched, and the error is returned or the panic propagated. ErrFull
+// is returned, and the transaction discarded, if the queue would not fit
+// in its limits once committed, whatever its overflow policy. The
+// staged pops and pushes are recorded before the queue is touched, and
+// the transaction is discarded if recording them fails, see WithWAL.
+//
+// The transaction pops the items in the queue order, regardless of the
+// priority floor and ceiling, rate limit and coalescing options. fn must
+// only reach the queue through the transaction: calling the queue
+// methods from fn, or using the transaction once fn has returned,
+// panics, see ErrReentrantCall.
+func (pq *PQueue) Txn(fn func(tx *PQueueTxn) error) error {
+	pq.lock()
+	pq.mergeStaged()
+	pq.dropExpired()
+
+	tx := &PQueueTxn{pq: pq}
+	if pq.elemsCount > 0 {
+		tx.frontier = append(tx.frontier, 1)
+	}
+
+	applied := false
+	defer func() {
+		atomic.StoreInt64(&pq.editor, 0)
+		tx.pq = nil
+
+		// Items are released holding the lock, see ItemRef
+		var values []interface{}
+		if applied {
+			// Cancelled pushes were popped too
+			for _, popped := range append(tx.popped, tx.cancelled...) {
+				values = append(values, popped.value)
+				pq.release(popped)
+			}
+		} else {
+			for _, pushed := range append(tx.pushed, tx.cancelled...) {
+				pq.release(pushed)
+			}
+		}
+		pq.unlock()
+
+		for _, value := range values {
+			pq.recordPopped(value)
+		}
+	}()
+
+	atomic.StoreInt64(&pq.editor, goroutineID())
+	if err := fn(tx); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&pq.editor, 0)
+
+	if !tx.fits() {
+		return pq.newError("commit", ErrFull)
+	}
+
+	if err := tx.record(); err != nil {
+		return pq.newError("commit", err)
+	}
+
+	applied = true
+	tx.apply()
+
+	return nil
+}
+
+// Push stages the push of the value item with the priority. ErrDuplicate
+// is returned if the value was recently popped, see WithRecentDedup, and
+// ErrClosed if the queue is closed.
+func (tx *PQueueTxn) Push(value interface{}, priority int) error {
+	pq := tx.queue()
+
+	if pq.closed {
+		return pq.newError("push", ErrClosed)
+	}
+
+	if err := pq.checkRecent(value); err != nil {
+		return err
+	}
+
+	tx.pushed = append(tx.pushed, pq.newItem(value, int64(priority)))
+
+	return nil
+}
+
+// Pop stages the pop of the highest/lowest priority item (depending on
+// whether you're using a MINPQ or MAXPQ) of the queue, staged pushes
+// included, and returns it. The boolean is false if the transaction
+// popped every item.
+func (tx *PQueueTxn) Pop() (interface{}, int, bool) {
+	tx.queue()
+
+	next, k := tx.next()
+	if next == nil {
+		return nil, 0, false
+	}
+
+	if k < 0 {
+		// Popping a staged push cancels it
+		tx.pushed = append(tx.pushed[:-k-1], tx.pushed[-k:]...)
+		tx.cancelled = append(tx.cancelled, next)
+	} else {
+		tx.frontier = append(tx.frontier[:k], tx.frontier[k+1:]...)
+		for _, child := range []int{2 * next.index, 2*next.index + 1} {
+			if child <= tx.pq.elemsCount {
+				tx.frontier = append(tx.frontier, child)
+			}
+		}
+
+		tx.popped = append(tx.popped, next)
+	}
+
+	return next.value, int(next.priority), true
+}
+
+// Head returns the item Pop would pop next, without staging its pop.
+// The boolean is false if the transaction popped every item.
+func (tx *PQueueTxn) Head() (interface{}, int, bool) {
+	tx.queue()
+
+	next, _ := tx.next()
+	if next == nil {
+		return nil, 0, false
+	}
+
+	return next.value, int(next.priority), true
+}
+
+// Size returns the count of items the queue would hold once the
+// transaction commits.
+func (tx *PQueueTxn) Size() int {
+	return tx.queue().elemsCount - len(tx.popped) + len(tx.pushed)
+}
+
+func (tx *PQueueTxn) queue() *PQueue {
+	if tx.pq == nil {
+		panic("lane: PQueueTxn used after its Txn function returned")
+	}
+
+	return tx.pq
+}
+
+// next returns the highest precedence item of the frontier and staged
+// pushes, along with its position in the frontier, or -i-1 for the i-th
+// staged push.
+func (tx *PQueueTxn) next() (*item, int) {
+	pq := tx.pq
+
+	var best *item
+	position := 0
+
+	for k, index := range tx.frontier {
+		if candidate := pq.items[index]; best == nil || pq.lessItems(best, candidate) {
+			best, position = candidate, k
+		}
+	}
+
+	for i, candidate := range tx.pushed {
+		if best == nil || pq.lessItems(best, candidate) {
+			best, position = candidate, -i-1
+		}
+	}
+
+	return best, position
+}
+
+// fits reports whether the queue fits in its limits once the
+// transaction commits. The caller must hold the write lock.
+func (tx *PQueueTxn) fits() bool {
+	pq := tx.pq
+
+	bytes := pq.bytes
+	for _, popped := range tx.popped {
+		bytes -= int64(popped.size)
+	}
+	for _, pushed := range tx.pushed {
+		bytes += int64(pushed.size)
+	}
+
+	if pq.maxItems > 0 && tx.Size() > pq.maxItems {
+		return false
+	}
+
+	return pq.maxBytes < 1 || bytes <= pq.maxBytes
+}
+
+// record writes the write-ahead log records of the popped and pushed
+// items, all of their values being encoded first, and syncs them. The
+// caller must hold the write lock.
+func (tx *PQueueTxn) record() error {
+	pq := tx.pq
+
+	payloads, err := pq.walEncode(tx.pushed)
+	if err != nil || pq.wal == nil {
+		return err
+	}
+
+	for _, popped := range tx.popped {
+		pq.walDelete(popped)
+	}
+	for i, pushed := range tx.pushed {
+		pq.walPushEncoded(pushed, payloads[i])
+	}
+
+	if pq.wal.err != nil {
+		return pq.wal.err
+	}
+
+	return pq.syncWAL()
+}
+
+// apply removes the popped items and queues the pushed ones, once their
+// records are written. The caller must hold the write lock.
+func (tx *PQueueTxn) apply() {
+	pq := tx.pq
+
+	for _, popped := range tx.popped {
+		pq.unlink(popped.index)
+	}
+
+	for _, pushed := range tx.pushed {
+		pq.enqueue(pushed)
+	}
+	pq.restage()
+
+	// Cancelled pushes are counted as pushed, and popped
+	atomic.AddUint64(&pq.metrics.pushes, uint64(len(tx.cancelled)))
+}
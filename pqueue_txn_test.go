@@ -0,0 +1,254 @@
+package lane
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// txnValues pops the queue and returns its values in pop order
+func txnValues(pqueue *PQueue) []interface{} {
+	var values []interface{}
+	for _, item := range pqueue.Drain() {
+		values = append(values, item.Value)
+	}
+
+	return values
+}
+
+func TestPQueueTxn_commits_pops_and_pushes(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("parent", 5)
+	pqueue.Push("other", 3)
+
+	err := pqueue.Txn(func(tx *PQueueTxn) error {
+		value, priority, ok := tx.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, value, "parent")
+		assert.Equal(t, priority, 5)
+
+		assert.Nil(t, tx.Push("first child", 4))
+		assert.Nil(t, tx.Push("second child", 2))
+		assert.Equal(t, tx.Size(), 3)
+
+		value, _, _ = tx.Head()
+		assert.Equal(t, value, "first child")
+
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, txnValues(pqueue), []interface{}{"first child", "other", "second child"})
+}
+
+func TestPQueueTxn_pops_in_queue_order(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for _, priority := range []int{8, 3, 5, 1, 9, 2, 7, 4, 6} {
+		pqueue.Push(priority, priority)
+	}
+
+	var popped []interface{}
+	pqueue.Txn(func(tx *PQueueTxn) error {
+		assert.Nil(t, tx.Push(0, 0))
+		assert.Nil(t, tx.Push(10, 10))
+
+		for {
+			value, _, ok := tx.Pop()
+			if !ok {
+				return nil
+			}
+			popped = append(popped, value)
+		}
+	})
+
+	assert.Equal(t, popped, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueTxn_pops_like_the_queue(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	for i := 0; i < 500; i++ {
+		pqueue.Push(i, random.Intn(20))
+	}
+	clone := pqueue.Clone()
+
+	pqueue.Txn(func(tx *PQueueTxn) error {
+		for i := 0; i < 200; i++ {
+			expected, priority := clone.Pop()
+			value, txPriority, _ := tx.Pop()
+			assert.Equal(t, value, expected)
+			assert.Equal(t, txPriority, priority)
+		}
+		return nil
+	})
+
+	assert.Equal(t, txnValues(pqueue), txnValues(clone))
+}
+
+func TestPQueueTxn_error_rolls_back(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 2)
+	pqueue.Push("b", 1)
+
+	failure := errors.New("failure")
+	err := pqueue.Txn(func(tx *PQueueTxn) error {
+		tx.Pop()
+		tx.Push("c", 3)
+		return failure
+	})
+	assert.Equal(t, err, failure)
+	assert.Equal(t, txnValues(pqueue), []interface{}{"a", "b"})
+}
+
+func TestPQueueTxn_panic_rolls_back(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 2)
+	pqueue.Push("b", 1)
+
+	assert.PanicsWithValue(t, "boom", func() {
+		pqueue.Txn(func(tx *PQueueTxn) error {
+			tx.Pop()
+			tx.Push("c", 3)
+			panic("boom")
+		})
+	})
+
+	// The queue lock was released
+	assert.Equal(t, txnValues(pqueue), []interface{}{"a", "b"})
+}
+
+func TestPQueueTxn_exceeding_limits_fails(t *testing.T) {
+	pqueue, _ := NewPQueueWithOptions(MAXPQ, WithMaxItems(2), WithOverflowPolicy(EvictWhenFull))
+	pqueue.Push("a", 2)
+
+	err := pqueue.Txn(func(tx *PQueueTxn) error {
+		tx.Pop()
+		tx.Push("b", 3)
+		tx.Push("c", 3)
+		return tx.Push("d", 3)
+	})
+	assert.True(t, errors.Is(err, ErrFull))
+	assert.Equal(t, txnValues(pqueue), []interface{}{"a"})
+
+	// Popping makes room within the transaction
+	pqueue.Push("a", 2)
+	err = pqueue.Txn(func(tx *PQueueTxn) error {
+		tx.Pop()
+		tx.Push("b", 3)
+		return tx.Push("c", 3)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, txnValues(pqueue), []interface{}{"b", "c"})
+}
+
+func TestPQueueTxn_wal_failure_rolls_back(t *testing.T) {
+	var log bytes.Buffer
+	failing := errors.New("unencodable")
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(&log, func(value interface{}) ([]byte, error) {
+		if value == "d" {
+			return nil, failing
+		}
+		return []byte(value.(string)), nil
+	}))
+	assert.Nil(t, err)
+	pqueue.Push("a", 2)
+	pqueue.Push("b", 1)
+	recorded := log.Len()
+
+	err = pqueue.Txn(func(tx *PQueueTxn) error {
+		tx.Pop()
+		tx.Push("c", 3)
+		return tx.Push("d", 4)
+	})
+	assert.True(t, errors.Is(err, failing))
+	assert.Equal(t, log.Len(), recorded)
+	assert.Equal(t, txnValues(pqueue), []interface{}{"a", "b"})
+}
+
+func TestPQueueTxn_popping_staged_push_counts_it(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithArena(4))
+	assert.Nil(t, err)
+	pqueue.Push("a", 1)
+
+	err = pqueue.Txn(func(tx *PQueueTxn) error {
+		tx.Push("b", 2)
+		value, _, _ := tx.Pop()
+		assert.Equal(t, value, "b")
+		return nil
+	})
+	assert.Nil(t, err)
+
+	metrics := pqueue.MetricsSnapshot()
+	assert.Equal(t, metrics.Pushes, uint64(2))
+	assert.Equal(t, metrics.Pops, uint64(1))
+	assert.Equal(t, len(pqueue.arena.free), 1)
+	assert.Equal(t, txnValues(pqueue), []interface{}{"a"})
+}
+
+func TestPQueueTxn_reentrant_call_panics(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	assert.Panics(t, func() {
+		pqueue.Txn(func(tx *PQueueTxn) error {
+			pqueue.Push("a", 1)
+			return nil
+		})
+	})
+
+	// The queue lock was released
+	assert.Nil(t, pqueue.Push("a", 1))
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueTxn_used_after_return_panics(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	var leaked *PQueueTxn
+	pqueue.Txn(func(tx *PQueueTxn) error {
+		leaked = tx
+		return nil
+	})
+	assert.Panics(t, func() { leaked.Pop() })
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueTxn_concurrent_transactions_serialize(t *testing.T) {
+	const goroutines, increments = 16, 50
+
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push(0, 1)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < increments; i++ {
+				err := pqueue.Txn(func(tx *PQueueTxn) error {
+					value, _, ok := tx.Pop()
+					if !ok {
+						return errors.New("counter popped by another transaction")
+					}
+
+					return tx.Push(value.(int)+1, 1)
+				})
+				assert.Nil(t, err)
+			}
+		}()
+	}
+
+	// Concurrent operations never see the counter popped
+	for i := 0; i < 1000; i++ {
+		value, _ := pqueue.Head()
+		assert.NotNil(t, value)
+	}
+	wg.Wait()
+
+	assert.Equal(t, txnValues(pqueue), []interface{}{goroutines * increments})
+}
@@ -0,0 +1,158 @@
+package lane
+
+import "reflect"
+
+// Contains reports whether the value is queued.
+//
+// Using a value index, see WithValueIndex, values are looked up by key
+// in constant time. Otherwise, every queued item is compared to the
+// value with the == operator, in linear time, and values of
+// uncomparable types, or holding uncomparable values in their interface
+// fields, are never found. To remove or reprioritize items often, keep
+// the references PushRef returns and use RemoveRef and
+// UpdatePriorityRef, or use a value index.
+func (pq *PQueue) Contains(value interface{}) bool {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlock()
+	defer pq.RUnlock()
+
+	return len(pq.lookup(value)) > 0
+}
+
+// Remove removes every item holding the value from the priority queue,
+// and reports whether there was one. Values are looked up as Contains
+// does, in linear time without a value index, and each item is then
+// removed in logarithmic time.
+func (pq *PQueue) Remove(value interface{}) bool {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	items := pq.lookup(value)
+	for _, item := range items {
+		pq.removeAt(item.index)
+		pq.release(item)
+	}
+
+	return len(items) > 0
+}
+
+// UpdatePriority sets the priority of every item holding the value,
+// and reports whether there was one. Values are looked up as Contains
+// does, in linear time without a value index, and each item is then
+// moved to its new position in logarithmic time.
+func (pq *PQueue) UpdatePriority(value interface{}, priority int) bool {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	items := pq.lookup(value)
+	for _, item := range items {
+		pq.setPriority(item, int64(priority))
+	}
+
+	return len(items) > 0
+}
+
+// RemoveRef removes the referenced item from the priority queue in
+// logarithmic time, and reports whether it was still part of it.
+func (pq *PQueue) RemoveRef(ref *ItemRef) bool {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	if ref.item.gen != ref.gen || !pq.contains(ref.item) {
+		return false
+	}
+
+	item := ref.item
+	pq.removeAt(item.index)
+	pq.release(item)
+
+	return true
+}
+
+// UpdatePriorityRef sets the priority of the referenced item, moving it
+// to its new position in logarithmic time, and reports whether it was
+// still part of the priority queue.
+func (pq *PQueue) UpdatePriorityRef(ref *ItemRef, priority int) bool {
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.lock()
+	defer pq.unlock()
+
+	pq.mergeStaged()
+
+	if ref.item.gen != ref.gen || !pq.contains(ref.item) {
+		return false
+	}
+
+	pq.setPriority(ref.item, int64(priority))
+
+	return true
+}
+
+// setPriority sets the priority of the queued item, and moves it to its
+// new position. The caller must hold the lock.
+func (pq *PQueue) setPriority(item *item, priority int64) {
+	if item.priority == priority {
+		return
+	}
+
+	pq.logPriority(item.index, priority)
+	pq.walPriority(item, priority)
+	item.priority = priority
+	pq.logInt(opFix, item.index)
+	pq.fix(item.index)
+}
+
+// lookup returns the queued items holding the value. The returned slice
+// is not retained by the value index, see WithValueIndex, so the items
+// can be removed while iterating over it. The caller must hold the lock.
+func (pq *PQueue) lookup(value interface{}) []*item {
+	if pq.index != nil {
+		return append([]*item(nil), pq.index.items[pq.index.key(value)]...)
+	}
+
+	if !comparableValue(value) {
+		return nil
+	}
+
+	var items []*item
+	for k := 1; k <= pq.elemsCount; k++ {
+		if candidate := pq.items[k]; candidate.value == value {
+			items = append(items, candidate)
+		}
+	}
+
+	return items
+}
+
+// comparableValue reports whether the value can be compared with the ==
+// operator without panicking, including the values held by its
+// interface fields. Comparing it to any other value then never panics
+// either: the comparison only panics when both operands hold the same
+// uncomparable type.
+func comparableValue(value interface{}) bool {
+	return value == nil || reflect.ValueOf(value).Comparable()
+}
@@ -0,0 +1,277 @@
+package lane
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueContains(t *testing.T) {
+	indexed, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(func(value interface{}) string {
+		return fmt.Sprint(value)
+	}))
+	assert.Nil(t, err)
+
+	for _, pqueue := range []*PQueue{NewPQueue(MAXPQ), indexed} {
+		pqueue.Push("a", 1)
+		pqueue.Push("b", 2)
+
+		assert.True(t, pqueue.Contains("a"))
+		assert.True(t, pqueue.Contains("b"))
+		assert.False(t, pqueue.Contains("c"))
+
+		pqueue.Pop()
+		assert.False(t, pqueue.Contains("b"))
+	}
+}
+
+func TestPQueueContains_uncomparable_values(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push([]int{1}, 1)
+
+	assert.False(t, pqueue.Contains([]int{1}))
+	assert.False(t, pqueue.Remove([]int{1}))
+	assert.False(t, pqueue.UpdatePriority([]int{1}, 2))
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueContains_uncomparable_interface_fields(t *testing.T) {
+	type holder struct{ value interface{} }
+
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push(holder{[]int{1}}, 1)
+	pqueue.Push(holder{1}, 2)
+
+	assert.False(t, pqueue.Contains(holder{[]int{1}}))
+	assert.True(t, pqueue.Contains(holder{1}))
+	assert.True(t, pqueue.UpdatePriority(holder{1}, 0))
+	assert.True(t, pqueue.Remove(holder{1}))
+
+	_, found := pqueue.PriorityOf(holder{[]int{1}})
+	assert.False(t, found)
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueRemove(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, i)
+	}
+
+	assert.True(t, pqueue.Remove(0))
+	assert.True(t, pqueue.Remove(5))
+	assert.True(t, pqueue.Remove(9))
+	assert.False(t, pqueue.Remove(5))
+	assert.False(t, pqueue.Remove(42))
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+
+	var values []interface{}
+	for pqueue.Size() > 0 {
+		value, _ := pqueue.Pop()
+		values = append(values, value)
+	}
+	assert.Equal(t, values, []interface{}{1, 2, 3, 4, 6, 7, 8})
+}
+
+func TestPQueueRemove_duplicates(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("a", 1)
+	pqueue.Push("b", 2)
+	pqueue.Push("a", 3)
+
+	assert.True(t, pqueue.Remove("a"))
+	assert.Equal(t, pqueue.Size(), 1)
+
+	value, _ := pqueue.Head()
+	assert.Equal(t, value, "b")
+}
+
+func TestPQueueUpdatePriority(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for i := 0; i < 5; i++ {
+		pqueue.Push(i, i)
+	}
+
+	// The deadline of 4 moved forward, the one of 0 backward
+	assert.True(t, pqueue.UpdatePriority(4, -1))
+	assert.True(t, pqueue.UpdatePriority(0, 10))
+	assert.True(t, pqueue.UpdatePriority(2, 2))
+	assert.False(t, pqueue.UpdatePriority(42, 0))
+
+	priority, ok := pqueue.PriorityOf(0)
+	assert.True(t, ok)
+	assert.Equal(t, priority, 10)
+
+	var values []interface{}
+	var priorities []int
+	for pqueue.Size() > 0 {
+		value, priority := pqueue.Pop()
+		values = append(values, value)
+		priorities = append(priorities, priority)
+	}
+	assert.Equal(t, values, []interface{}{4, 1, 2, 3, 0})
+	assert.Equal(t, priorities, []int{-1, 1, 2, 3, 10})
+}
+
+func TestPQueueUpdatePriority_with_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(8, 0))
+	assert.Nil(t, err)
+	pqueue.Push("a", 1)
+	pqueue.Push("b", 2)
+
+	assert.True(t, pqueue.Contains("a"))
+	assert.True(t, pqueue.UpdatePriority("a", 3))
+
+	value, priority := pqueue.Pop()
+	assert.Equal(t, value, "a")
+	assert.Equal(t, priority, 3)
+}
+
+func TestPQueueRemoveRef(t *testing.T) {
+	for name, options := range map[string][]PQueueOption{
+		"default":      nil,
+		"arena":        {WithArena(4)},
+		"write buffer": {WithWriteBuffer(8, 0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pqueue, err := NewPQueueWithOptions(MINPQ, options...)
+			assert.Nil(t, err)
+
+			refs := make([]*ItemRef, 5)
+			for i := range refs {
+				refs[i], err = pqueue.PushRef(i, i)
+				assert.Nil(t, err)
+			}
+
+			assert.True(t, pqueue.RemoveRef(refs[0]))
+			assert.True(t, pqueue.RemoveRef(refs[3]))
+			assert.False(t, pqueue.RemoveRef(refs[3]))
+			assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+
+			var values []interface{}
+			for pqueue.Size() > 0 {
+				value, _ := pqueue.Pop()
+				values = append(values, value)
+			}
+			assert.Equal(t, values, []interface{}{1, 2, 4})
+			assert.False(t, pqueue.RemoveRef(refs[1]))
+		})
+	}
+}
+
+func TestPQueueUpdatePriorityRef(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+
+	refs := make([]*ItemRef, 5)
+	for i := range refs {
+		refs[i], _ = pqueue.PushRef(i, i)
+	}
+
+	assert.True(t, pqueue.UpdatePriorityRef(refs[4], -1))
+	assert.True(t, pqueue.UpdatePriorityRef(refs[0], 10))
+	assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+
+	var priorities []int
+	var values []interface{}
+	for pqueue.Size() > 0 {
+		value, priority := pqueue.Pop()
+		values = append(values, value)
+		priorities = append(priorities, priority)
+	}
+	assert.Equal(t, values, []interface{}{4, 1, 2, 3, 0})
+	assert.Equal(t, priorities, []int{-1, 1, 2, 3, 10})
+	assert.False(t, pqueue.UpdatePriorityRef(refs[4], 0))
+}
+
+// TestPQueueUpdate_random_operations compares random removals and
+// priority updates through a value index to a queue rebuilt from scratch.
+func TestPQueueUpdate_random_operations(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueIndex(func(value interface{}) string {
+		return fmt.Sprint(value)
+	}))
+	assert.Nil(t, err)
+
+	rng := rand.New(rand.NewSource(1))
+	priorities := make(map[int]int)
+	for i := 0; i < 2000; i++ {
+		value := rng.Intn(200)
+
+		switch rng.Intn(4) {
+		case 0:
+			_, queued := priorities[value]
+			assert.Equal(t, pqueue.Remove(value), queued)
+			delete(priorities, value)
+		case 1:
+			_, queued := priorities[value]
+			priority := rng.Intn(100)
+			assert.Equal(t, pqueue.UpdatePriority(value, priority), queued)
+			if queued {
+				priorities[value] = priority
+			}
+		default:
+			if !pqueue.Contains(value) {
+				priority := rng.Intn(100)
+				pqueue.Push(value, priority)
+				priorities[value] = priority
+			}
+		}
+
+		if i%100 == 0 {
+			assert.Equal(t, pqueue.Diagnostics().Violations, 0)
+			assertIndexConsistent(t, pqueue)
+		}
+	}
+
+	reference := NewPQueue(MAXPQ)
+	for value, priority := range priorities {
+		reference.Push(value, priority)
+	}
+
+	assert.Equal(t, pqueue.Size(), reference.Size())
+	for reference.Size() > 0 {
+		_, expected := reference.Pop()
+		value, priority := pqueue.Pop()
+		assert.Equal(t, priority, expected)
+		assert.Equal(t, priority, priorities[value.(int)])
+	}
+}
+
+func TestPQueueUpdate_recorded_by_wal(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		pqueue.Push(i, i)
+	}
+	pqueue.Remove(3)
+	pqueue.UpdatePriority(0, 10)
+	assert.Nil(t, pqueue.FlushWAL())
+
+	recovered, err := RecoverFromWAL(&log, decodeInt)
+	assert.Nil(t, err)
+
+	var values []interface{}
+	for recovered.Size() > 0 {
+		value, _ := recovered.Pop()
+		values = append(values, value)
+	}
+	assert.Equal(t, values, []interface{}{0, 4, 2, 1})
+}
+
+func BenchmarkPQueueUpdatePriority(b *testing.B) {
+	pqueue, _ := NewPQueueWithOptions(MINPQ, WithValueIndex(func(value interface{}) string {
+		return fmt.Sprint(value)
+	}))
+	for i := 0; i < 1<<14; i++ {
+		pqueue.Push(i, i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pqueue.UpdatePriority(i&(1<<14-1), i)
+	}
+}
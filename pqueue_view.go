@@ -0,0 +1,141 @@
+package lane
+
+// PQueueView is a view of the items of a priority queue whose priority
+// belongs to a band. Views share the items of their queue: an item
+// popped from a view is popped from the queue, and the other way
+// around.
+type PQueueView struct {
+	pq          *PQueue
+	minPriority int64
+	maxPriority int64
+}
+
+// View returns a view of the items whose priority is between the
+// minPriority and maxPriority bounds, both included.
+//
+// Unless the queue uses a value comparator, the view operations only
+// examine the items of the band, and the items ordered before the band.
+func (pq *PQueue) View(minPriority, maxPriority int) *PQueueView {
+	return &PQueueView{
+		pq:          pq,
+		minPriority: int64(minPriority),
+		maxPriority: int64(maxPriority),
+	}
+}
+
+// Pop pops and returns the highest/lowest priority item of the band
+// (depending on whether the queue is a MINPQ or MAXPQ).
+func (v *PQueueView) Pop() (interface{}, int) {
+	pq := v.pq
+
+	pq.lock()
+	pq.mergeStaged()
+
+	k := v.head()
+	if k == 0 {
+		pq.unlock()
+		return nil, 0
+	}
+
+	head := pq.removeAt(k)
+	value, priority := head.value, head.priority
+	pq.release(head)
+
+	pq.unlock()
+	pq.recordPopped(value)
+
+	return value, int(priority)
+}
+
+// Head returns the highest/lowest priority item of the band (depending
+// on whether the queue is a MINPQ or MAXPQ) without removing it.
+func (v *PQueueView) Head() (interface{}, int) {
+	pq := v.pq
+
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlock()
+	defer pq.RUnlock()
+
+	k := v.head()
+	if k == 0 {
+		return nil, 0
+	}
+
+	return pq.items[k].value, int(pq.items[k].priority)
+}
+
+// Size returns the count of items of the band
+func (v *PQueueView) Size() int {
+	pq := v.pq
+
+	if pq.buffer != nil {
+		pq.flush()
+	}
+
+	pq.rlock()
+	defer pq.RUnlock()
+
+	size := 0
+	v.walk(func(k int) bool {
+		size++
+		return true
+	})
+
+	return size
+}
+
+// head returns the heap index of the band best item, or 0 if the band
+// is empty. The caller must hold the lock.
+func (v *PQueueView) head() int {
+	pq := v.pq
+
+	best := 0
+	v.walk(func(k int) bool {
+		if best == 0 || pq.less(best, k) {
+			best = k
+		}
+
+		// An item precedes every item below it in the heap
+		return pq.valueLess != nil
+	})
+
+	return best
+}
+
+// walk calls visit with the heap index of the band items, in no
+// particular order, skipping the items below a band item for which
+// visit returns false. The caller must hold the lock.
+func (v *PQueueView) walk(visit func(k int) bool) {
+	pq := v.pq
+
+	// The band bound with the lowest precedence
+	worst := v.minPriority
+	if pq.pqType == MINPQ {
+		worst = v.maxPriority
+	}
+
+	stack := []int{1}
+	for len(stack) > 0 {
+		k := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if k > pq.elemsCount {
+			continue
+		}
+
+		priority := pq.items[k].priority
+		if priority >= v.minPriority && priority <= v.maxPriority {
+			if !visit(k) {
+				continue
+			}
+		} else if pq.valueLess == nil && pq.comparator(priority, worst) {
+			// Neither this item nor the ones below it belong to the band
+			continue
+		}
+
+		stack = append(stack, 2*k, 2*k+1)
+	}
+}
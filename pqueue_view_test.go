@@ -0,0 +1,140 @@
+package lane
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPQueueView(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	for _, priority := range []int{25, 3, 17, 11, 1, 29, 14, 7, 21} {
+		pqueue.Push(priority, priority)
+	}
+
+	interactive := pqueue.View(20, 29)
+	batch := pqueue.View(10, 19)
+	background := pqueue.View(0, 9)
+
+	assert.Equal(t, interactive.Size(), 3)
+	assert.Equal(t, batch.Size(), 3)
+	assert.Equal(t, background.Size(), 3)
+
+	value, priority := batch.Head()
+	assert.Equal(t, value, 17)
+	assert.Equal(t, priority, 17)
+
+	value, _ = background.Pop()
+	assert.Equal(t, value, 7)
+	value, _ = batch.Pop()
+	assert.Equal(t, value, 17)
+
+	// The queue sees the items popped by the views
+	value, _ = pqueue.Pop()
+	assert.Equal(t, value, 29)
+	assert.Equal(t, pqueue.Size(), 6)
+	assert.True(t, assertHeapInvariant(t, pqueue))
+
+	value, _ = interactive.Pop()
+	assert.Equal(t, value, 25)
+	value, _ = background.Pop()
+	assert.Equal(t, value, 3)
+	value, _ = background.Pop()
+	assert.Equal(t, value, 1)
+
+	value, priority = background.Pop()
+	assert.Nil(t, value)
+	assert.Equal(t, priority, 0)
+	assert.Equal(t, background.Size(), 0)
+
+	value, _ = pqueue.Pop()
+	assert.Equal(t, value, 21)
+}
+
+func TestPQueueView_min_ordering(t *testing.T) {
+	pqueue := NewPQueue(MINPQ)
+	for _, priority := range []int{8, 2, 6, 4, 9, 1} {
+		pqueue.Push(priority, priority)
+	}
+
+	view := pqueue.View(3, 8)
+	assert.Equal(t, view.Size(), 3)
+
+	for _, expected := range []int{4, 6, 8} {
+		value, _ := view.Pop()
+		assert.Equal(t, value, expected)
+	}
+
+	value, _ := pqueue.Pop()
+	assert.Equal(t, value, 1)
+}
+
+func TestPQueueView_value_comparator(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithValueComparator(func(a, b interface{}) bool {
+		return a.(string) < b.(string)
+	}))
+	assert.Nil(t, err)
+
+	pqueue.Push("d", 1)
+	pqueue.Push("c", 5)
+	pqueue.Push("b", 2)
+	pqueue.Push("a", 4)
+
+	view := pqueue.View(2, 5)
+	assert.Equal(t, view.Size(), 3)
+
+	value, _ := view.Pop()
+	assert.Equal(t, value, "c")
+	value, _ = view.Pop()
+	assert.Equal(t, value, "b")
+}
+
+func TestPQueueView_no_double_delivery(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	random := rand.New(rand.NewSource(7))
+
+	const items = 3000
+	for value := 0; value < items; value++ {
+		pqueue.Push(value, random.Intn(30))
+	}
+
+	consumers := []func() (interface{}, int){
+		pqueue.Pop,
+		pqueue.View(20, 29).Pop,
+		pqueue.View(10, 19).Pop,
+		pqueue.View(0, 9).Pop,
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	delivered := make(map[int]int)
+
+	for _, pop := range consumers {
+		wg.Add(1)
+		go func(pop func() (interface{}, int)) {
+			defer wg.Done()
+
+			for {
+				value, _ := pop()
+				if value == nil {
+					return
+				}
+
+				mu.Lock()
+				delivered[value.(int)]++
+				mu.Unlock()
+			}
+		}(pop)
+	}
+
+	wg.Wait()
+
+	// The views stop once their band is empty, the queue gets the rest
+	assert.Equal(t, pqueue.Size(), 0)
+	assert.Equal(t, len(delivered), items)
+	for _, count := range delivered {
+		assert.Equal(t, count, 1)
+	}
+}
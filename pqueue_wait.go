@@ -0,0 +1,367 @@
+package lane
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTooManyWaiters is the error returned by WaitPop when it would block
+// while the queue already has as many blocked consumers as allowed, see
+// WithMaxWaiters.
+var ErrTooManyWaiters = errors.New("lane: too many consumers waiting on priority queue")
+
+// waiter is a consumer blocked in WaitPop, items are handed to it
+// through its channel, which transfers their ownership.
+type waiter struct {
+	ch   chan *item
+	elem *list.Element
+}
+
+// WithWaitStrategy makes WaitPop spin, yielding the processor, for up to
+// spinIterations checks of the queue before blocking when it is empty.
+// Spinning avoids the cost of blocking and waking up when the queue is
+// only empty for brief periods, at the cost of CPU time. By default,
+// WaitPop blocks right away.
+func WithWaitStrategy(spinIterations int) PQueueOption {
+	return func(pq *PQueue) error {
+		if spinIterations < 0 {
+			return fmt.Errorf("%w: wait spin iterations must not be negative, got %d", ErrInvalidOption, spinIterations)
+		}
+
+		pq.waitSpins = spinIterations
+		return nil
+	}
+}
+
+// WithMaxWaiters limits the count of consumers blocked in WaitPop to n:
+// WaitPop returns ErrTooManyWaiters right away rather than blocking once
+// n consumers are blocked. The limit bounds the memory held by blocked
+// consumers when they pile up on an empty queue.
+func WithMaxWaiters(n int) PQueueOption {
+	return func(pq *PQueue) error {
+		if n < 1 {
+			return fmt.Errorf("%w: max waiters must be positive, got %d", ErrInvalidOption, n)
+		}
+
+		pq.maxWaiters = n
+		return nil
+	}
+}
+
+// WaitPop pops and returns the highest/lowest priority item (depending
+// on whether you're using a MINPQ or MAXPQ) from the priority queue,
+// blocking until one is available or the context is done.
+//
+// Blocked consumers are served in the order they started waiting: a
+// pushed item is handed over directly to the consumer which has been
+// waiting for the longest time.
+func (pq *PQueue) WaitPop(ctx context.Context) (interface{}, int, error) {
+	head, _, err := pq.waitPop(ctx, nil, "wait pop")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	popped := pq.claim(head)
+	return popped.Value, popped.Priority, nil
+}
+
+// PopOrRecv pops and returns the highest/lowest priority item (depending
+// on whether you're using a MINPQ or MAXPQ) from the priority queue, as
+// WaitPop does, unless a value is received from ctrl first: it blocks
+// until an item is available, ctrl is ready, or the context is done, and
+// reports exactly one of these outcomes. gotItem is true if an item was
+// popped, gotCtrl if a value was received from ctrl.
+//
+// An item is never lost nor popped twice: an item handed over to the
+// consumer while ctrl got ready is pushed back into the queue, with the
+// same priorities, as if pushed again. Items available when PopOrRecv is
+// called take precedence over ctrl.
+func (pq *PQueue) PopOrRecv(ctx context.Context, ctrl <-chan struct{}) (item Item, gotItem bool, gotCtrl bool, err error) {
+	head, got, err := pq.waitPop(ctx, ctrl, "pop or recv")
+	if err != nil || got {
+		return Item{}, false, got, err
+	}
+
+	return pq.claim(head), true, false, nil
+}
+
+// waitPop pops the queue head, blocking until one is available, a value
+// is received from ctrl, or the context is done. The boolean is true if a
+// value was received from ctrl, the item being nil. op names the
+// operation in the returned errors.
+//
+// The popped item is owned by the caller, which must either claim it or
+// requeue it.
+func (pq *PQueue) waitPop(ctx context.Context, ctrl <-chan struct{}, op string) (*item, bool, error) {
+	start := time.Now()
+	defer pq.countWait(start)
+
+	timing := pq.lockTimed()
+	pq.mergeStaged()
+
+	// Spin for a while before blocking, see WithWaitStrategy
+	for spin := 0; spin < pq.waitSpins && !pq.headEligible(); spin++ {
+		if pq.closed || ctx.Err() != nil {
+			break
+		}
+
+		pq.unlockTimed(popLock, timing)
+		runtime.Gosched()
+		timing = pq.lockTimed()
+		pq.mergeStaged()
+	}
+
+	// Consumers given a waiting slot keep it while woken up to wait for
+	// a pop token, see WithPopRateLimit.
+	admitted := false
+
+	for {
+		pq.dropExpired()
+
+		if pq.headEligible() && pq.takeToken() {
+			head := pq.detach(pq.popHeadItem())
+			pq.unlockTimed(popLock, timing)
+
+			return head, false, nil
+		}
+
+		if pq.closed && !pq.headEligible() {
+			err := pq.newError(op, ErrClosed)
+			pq.unlockTimed(popLock, timing)
+
+			return nil, false, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			err = pq.newError(op, err)
+			pq.unlockTimed(popLock, timing)
+
+			return nil, false, err
+		}
+
+		if !admitted && pq.maxWaiters > 0 && pq.blockedCount() >= pq.maxWaiters {
+			err := pq.newError(op, ErrTooManyWaiters)
+			pq.unlockTimed(popLock, timing)
+
+			return nil, false, err
+		}
+		admitted = true
+
+		// The head is eligible but no token is available, wait for the
+		// next one, see WithPopRateLimit.
+		if pq.headEligible() {
+			delay, wake := pq.rate.delay(pq.now()), pq.pacedWake()
+			pq.pacing++
+			pq.unlockTimed(popLock, timing)
+
+			received := false
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-wake:
+			case <-ctrl:
+				received = true
+			case <-ctx.Done():
+			}
+			timer.Stop()
+
+			timing = pq.lockTimed()
+			pq.pacing--
+			if received {
+				pq.unlockTimed(popLock, timing)
+				return nil, true, nil
+			}
+			pq.mergeStaged()
+			continue
+		}
+
+		w := pq.addWaiter()
+		wake := pq.pacedWake()
+		pq.unlockTimed(popLock, timing)
+
+		schedPoint("wait.block")
+
+		select {
+		case handed, ok := <-w.ch:
+			if !ok {
+				return nil, false, pq.lockedError(op, ErrClosed)
+			}
+
+			return handed, false, nil
+		case <-wake:
+			// An item was queued for lack of token, or the rate changed
+			timing = pq.lockTimed()
+			if w.elem != nil {
+				pq.removeWaiter(w)
+				pq.mergeStaged()
+				continue
+			}
+			pq.unlockTimed(popLock, timing)
+		case <-ctrl:
+			pq.lock()
+			if w.elem != nil {
+				pq.removeWaiter(w)
+				pq.unlock()
+
+				return nil, true, nil
+			}
+
+			// The item handed over meanwhile is left unclaimed
+			if handed, ok := <-w.ch; ok {
+				pq.requeue(handed)
+			}
+			pq.unlock()
+
+			return nil, true, nil
+		case <-ctx.Done():
+			pq.lock()
+			if w.elem != nil {
+				pq.removeWaiter(w)
+				err := pq.newError(op, ctx.Err())
+				pq.unlock()
+
+				return nil, false, err
+			}
+			pq.unlock()
+		}
+
+		// An item was handed over while the context was being cancelled,
+		// or while waking up, it must not be lost.
+		handed, ok := <-w.ch
+		if !ok {
+			return nil, false, pq.lockedError(op, ErrClosed)
+		}
+
+		return handed, false, nil
+	}
+}
+
+// claim returns the item popped by, or handed over to, the calling
+// consumer. The item was detached from the queue already, see detach.
+func (pq *PQueue) claim(popped *item) Item {
+	head := popped.export()
+	pq.recordPopped(head.Value)
+
+	return head
+}
+
+// detach returns a copy of the item, which must not be part of the
+// queue anymore, and releases the item. The copy is owned by the caller,
+// which can claim or requeue it without the lock, while the item may be
+// read by its references, see ItemRef, and recycled by the arena, see
+// WithArena. The caller must hold the write lock.
+func (pq *PQueue) detach(popped *item) *item {
+	owned := new(item)
+	*owned = *popped
+	pq.release(popped)
+
+	return owned
+}
+
+// requeue pushes the item popped by, or handed over to, a consumer
+// which didn't claim it back into the queue, as it was: its priorities,
+// deadline and stable order sequence are kept, and the queue limits it
+// was already admitted by are bypassed. The caller must hold the write
+// lock.
+func (pq *PQueue) requeue(unclaimed *item) {
+	// The error is kept for FlushWAL, and Push, to return
+	pq.walPush(unclaimed)
+	pq.enqueue(unclaimed)
+}
+
+// enqueue hands the item over to the oldest waiter if any, and inserts
+// it into the heap otherwise, during a batch, or if it doesn't meet the
+// priority bounds. The caller must hold the write lock.
+func (pq *PQueue) enqueue(item *item) {
+	atomic.AddUint64(&pq.metrics.pushes, 1)
+
+	if pq.waiters == nil || pq.waiters.Len() == 0 || pq.batches > 0 || !pq.bounds.admits(item.priority) {
+		pq.insert(item)
+		return
+	}
+
+	// Expired items are not handed over, see PushWithDeadline
+	if item.deadline != 0 && item.pastDeadline(pq.now().UnixNano()) {
+		pq.walDelete(item)
+		pq.expire(item)
+		return
+	}
+
+	if !pq.takeToken() {
+		pq.insert(item)
+		pq.wakePaced()
+		return
+	}
+
+	pq.handOff(item)
+}
+
+// handOff hands the item over to the oldest waiter. The caller must
+// hold the write lock, and make sure there is a waiter.
+func (pq *PQueue) handOff(item *item) {
+	w := pq.waiters.Front().Value.(*waiter)
+	pq.removeWaiter(w)
+	pq.walDelete(item)
+	w.ch <- pq.detach(item)
+	atomic.AddUint64(&pq.handoffs, 1)
+	schedPoint("wait.wake")
+}
+
+// serveWaiters hands the eligible queued items over to the waiters,
+// unless during a batch. Items are only queued while there are waiters
+// if they don't meet the priority bounds, see SetPriorityFloor, or for
+// lack of pop token, see WithPopRateLimit. The caller must hold the
+// write lock.
+func (pq *PQueue) serveWaiters() {
+	for pq.batches == 0 && pq.waiters != nil && pq.waiters.Len() > 0 {
+		if pq.dropExpired(); !pq.headEligible() {
+			return
+		}
+
+		if !pq.takeToken() {
+			pq.wakePaced()
+			return
+		}
+
+		// handOff records the removal
+		pq.handOff(pq.unlink(1))
+	}
+}
+
+// blockedCount returns the count of consumers blocked in WaitPop, either
+// waiting for an item or for a pop token. The caller must hold the lock.
+func (pq *PQueue) blockedCount() int {
+	blocked := pq.pacing
+	if pq.waiters != nil {
+		blocked += pq.waiters.Len()
+	}
+
+	return blocked
+}
+
+// addWaiter registers a new waiter at the back of the waiters list.
+// The caller must hold the write lock.
+func (pq *PQueue) addWaiter() *waiter {
+	if pq.waiters == nil {
+		pq.waiters = list.New()
+	}
+
+	w := &waiter{ch: make(chan *item, 1)}
+	w.elem = pq.waiters.PushBack(w)
+	atomic.AddInt32(&pq.waiting, 1)
+
+	return w
+}
+
+// removeWaiter unregisters the waiter. The caller must hold the
+// write lock.
+func (pq *PQueue) removeWaiter(w *waiter) {
+	pq.waiters.Remove(w.elem)
+	w.elem = nil
+	atomic.AddInt32(&pq.waiting, -1)
+}
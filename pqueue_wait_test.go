@@ -0,0 +1,548 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForWaiters blocks until n consumers are blocked in WaitPop
+func waitForWaiters(t *testing.T, pqueue *PQueue, n int32) {
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&pqueue.waiting) == n
+	}, 5*time.Second, 10*time.Microsecond)
+}
+
+func TestPQueueWaitPop_returns_available_item(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push("1", 1)
+	pqueue.Push("2", 2)
+
+	value, priority, err := pqueue.WaitPop(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, "2")
+	assert.Equal(t, priority, 2)
+}
+
+func TestPQueueWaitPop_blocks_until_push(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+
+	waitForWaiters(t, pqueue, 1)
+	pqueue.Push("1", 1)
+
+	assert.Equal(t, <-done, "1")
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueWaitPop_with_write_buffer(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWriteBuffer(64, time.Hour))
+	assert.Nil(t, err)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+
+	waitForWaiters(t, pqueue, 1)
+	pqueue.Push("1", 1)
+
+	assert.Equal(t, <-done, "1")
+}
+
+func TestPQueueWaitPop_cancellation_removes_waiter(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := pqueue.WaitPop(ctx)
+		done <- err
+	}()
+
+	waitForWaiters(t, pqueue, 1)
+	cancel()
+
+	assert.True(t, errors.Is(<-done, context.Canceled))
+	assert.Equal(t, pqueue.waiters.Len(), 0)
+
+	// With no waiter left, the item goes into the heap
+	pqueue.Push("1", 1)
+	assert.Equal(t, pqueue.Size(), 1)
+}
+
+func TestPQueueWaitPop_done_context(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := pqueue.WaitPop(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, atomic.LoadInt32(&pqueue.waiting), int32(0))
+}
+
+func TestPQueueWaitPop_cancellation_never_loses_items(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	const rounds = 2000
+	var received int64
+
+	var wg sync.WaitGroup
+	for i := 0; i < rounds; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := pqueue.WaitPop(ctx); err == nil {
+				atomic.AddInt64(&received, 1)
+			}
+		}()
+
+		// Race the hand-off against the cancellation
+		go cancel()
+		pqueue.Push(i, i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, atomic.LoadInt64(&received)+int64(pqueue.Size()), int64(rounds))
+	assert.Equal(t, atomic.LoadInt32(&pqueue.waiting), int32(0))
+}
+
+func TestPQueueWaitPop_serves_waiters_in_fifo_order(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	const consumers = 8
+	const items = 800
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var counts [consumers]int64
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for {
+				if _, _, err := pqueue.WaitPop(ctx); err != nil {
+					return
+				}
+				atomic.AddInt64(&counts[c], 1)
+			}
+		}(c)
+	}
+
+	for i := 0; i < items; i++ {
+		waitForWaiters(t, pqueue, consumers)
+		pqueue.Push(i, i)
+	}
+
+	waitForWaiters(t, pqueue, consumers)
+	cancel()
+	wg.Wait()
+
+	for c := 0; c < consumers; c++ {
+		assert.InDelta(t, items/consumers, atomic.LoadInt64(&counts[c]), 1, "consumer %d", c)
+	}
+}
+
+func TestNewPQueueWithOptions_invalid_wait_strategy(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWaitStrategy(-1))
+	assert.Nil(t, pqueue)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueueWaitPop_spins_before_blocking(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWaitStrategy(1000))
+	assert.Nil(t, err)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+
+	// Once done spinning, the consumer blocks and stops using the CPU
+	waitForWaiters(t, pqueue, 1)
+
+	pqueue.Push("1", 1)
+	assert.Equal(t, <-done, "1")
+}
+
+func TestPQueueWaitPop_spinning_sees_pushes(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWaitStrategy(1<<30))
+	assert.Nil(t, err)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	runtime.Gosched()
+
+	pqueue.Push("1", 1)
+	assert.Equal(t, <-done, "1")
+	assert.Equal(t, atomic.LoadInt32(&pqueue.waiting), int32(0))
+}
+
+func TestPQueueWaitPop_spinning_honors_context(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWaitStrategy(1<<30))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err = pqueue.WaitPop(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestNewPQueueWithOptions_invalid_max_waiters(t *testing.T) {
+	_, err := NewPQueueWithOptions(MAXPQ, WithMaxWaiters(0))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestPQueueWaitPop_max_waiters(t *testing.T) {
+	const admitted = 8
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxWaiters(admitted))
+	assert.Nil(t, err)
+
+	// Many consumers race for the waiting slots
+	var wg sync.WaitGroup
+	var rejected int32
+	popped := make(chan interface{}, 64)
+
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			value, _, err := pqueue.WaitPop(context.Background())
+			if errors.Is(err, ErrTooManyWaiters) {
+				atomic.AddInt32(&rejected, 1)
+				return
+			}
+
+			assert.Nil(t, err)
+			popped <- value
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&rejected) == 64-admitted
+	}, 5*time.Second, 10*time.Microsecond)
+	waitForWaiters(t, pqueue, admitted)
+	assert.Equal(t, pqueue.Stats().Waiters, admitted)
+
+	for i := 0; i < 2*admitted; i++ {
+		pqueue.Push(i, i)
+	}
+	wg.Wait()
+	close(popped)
+
+	served := 0
+	for value := range popped {
+		assert.True(t, value.(int) < admitted)
+		served++
+	}
+	assert.Equal(t, served, admitted)
+	assert.Equal(t, pqueue.Size(), admitted)
+	assert.Equal(t, pqueue.Stats().Waiters, 0)
+
+	// Slots are freed once the waiters return
+	value, _, err := pqueue.WaitPop(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, value, 2*admitted-1)
+}
+
+func TestPQueueWaitPop_max_waiters_counts_paced_waiters(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithMaxWaiters(1), WithPopRateLimit(0.001, 1))
+	assert.Nil(t, err)
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+	pqueue.Pop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := pqueue.WaitPop(ctx)
+		done <- err
+	}()
+
+	assert.Eventually(t, func() bool {
+		return pqueue.Stats().Waiters == 1
+	}, 5*time.Second, 10*time.Microsecond)
+
+	_, _, err = pqueue.WaitPop(context.Background())
+	assert.True(t, errors.Is(err, ErrTooManyWaiters))
+
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+	assert.Equal(t, pqueue.Stats().Waiters, 0)
+}
+
+func TestPQueuePopOrRecv_prefers_available_item(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	pqueue.Push2("1", 1, 7)
+
+	ctrl := make(chan struct{}, 1)
+	ctrl <- struct{}{}
+
+	item, gotItem, gotCtrl, err := pqueue.PopOrRecv(context.Background(), ctrl)
+	assert.Nil(t, err)
+	assert.Equal(t, item, Item{Value: "1", Priority: 1, Secondary: 7})
+	assert.True(t, gotItem)
+	assert.False(t, gotCtrl)
+	assert.Equal(t, len(ctrl), 1)
+}
+
+func TestPQueuePopOrRecv_receives_ctrl(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	ctrl := make(chan struct{})
+
+	type outcome struct {
+		gotItem, gotCtrl bool
+		err              error
+	}
+	done := make(chan outcome)
+	go func() {
+		_, gotItem, gotCtrl, err := pqueue.PopOrRecv(context.Background(), ctrl)
+		done <- outcome{gotItem, gotCtrl, err}
+	}()
+
+	waitForWaiters(t, pqueue, 1)
+	ctrl <- struct{}{}
+
+	assert.Equal(t, <-done, outcome{gotCtrl: true})
+	assert.Equal(t, pqueue.Stats().Waiters, 0)
+
+	// Pushed items are handed over to blocked consumers as by WaitPop
+	go func() {
+		_, gotItem, gotCtrl, err := pqueue.PopOrRecv(context.Background(), ctrl)
+		done <- outcome{gotItem, gotCtrl, err}
+	}()
+
+	waitForWaiters(t, pqueue, 1)
+	pqueue.Push("1", 1)
+
+	assert.Equal(t, <-done, outcome{gotItem: true})
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueuePopOrRecv_context_done(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	_, gotItem, gotCtrl, err := pqueue.PopOrRecv(ctx, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, gotItem)
+	assert.False(t, gotCtrl)
+	assert.Equal(t, pqueue.Stats().Waiters, 0)
+}
+
+func TestPQueuePopOrRecv_requeues_unclaimed_item(t *testing.T) {
+	pqueue := NewPQueue(MAXPQ)
+	requeued := 0
+
+	for i := 0; i < 20; i++ {
+		ctrl := make(chan struct{})
+
+		type outcome struct {
+			item             Item
+			gotItem, gotCtrl bool
+		}
+		done := make(chan outcome)
+		go func() {
+			item, gotItem, gotCtrl, _ := pqueue.PopOrRecv(context.Background(), ctrl)
+			done <- outcome{item, gotItem, gotCtrl}
+		}()
+
+		waitForWaiters(t, pqueue, 1)
+
+		// Make the control channel ready, then hand an item over, while
+		// the consumer waits for the lock to leave
+		pqueue.lock()
+		close(ctrl)
+		time.Sleep(time.Millisecond)
+		pqueue.enqueue(pqueue.newItem(i, int64(i)))
+		pqueue.unlock()
+
+		result := <-done
+		assert.True(t, result.gotItem != result.gotCtrl)
+
+		if result.gotItem {
+			assert.Equal(t, result.item.Value, i)
+			assert.Equal(t, pqueue.Size(), 0)
+			continue
+		}
+
+		requeued++
+		assert.Equal(t, pqueue.Size(), 1)
+		value, priority := pqueue.Pop()
+		assert.Equal(t, value, i)
+		assert.Equal(t, priority, i)
+	}
+
+	assert.True(t, requeued > 0)
+}
+
+func TestPQueuePopOrRecv_requeues_item_intact(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder())
+	assert.Nil(t, err)
+	deadline := time.Now().Add(time.Hour).UnixNano()
+
+	for i := 0; i < 50; i++ {
+		ctrl := make(chan struct{})
+		done := make(chan bool)
+		go func() {
+			_, _, gotCtrl, _ := pqueue.PopOrRecv(context.Background(), ctrl)
+			done <- gotCtrl
+		}()
+
+		waitForWaiters(t, pqueue, 1)
+
+		pqueue.lock()
+		close(ctrl)
+		time.Sleep(time.Millisecond)
+		handed := pqueue.newItem(i, 1<<40)
+		handed.deadline = deadline
+		seq := handed.seq
+		pqueue.enqueue(handed)
+		pqueue.unlock()
+
+		if !<-done {
+			continue
+		}
+
+		// The 64 bits priority, deadline and sequence are kept
+		pqueue.lock()
+		requeued := *pqueue.items[1]
+		pqueue.unlock()
+		assert.Equal(t, requeued.value, i)
+		assert.Equal(t, requeued.priority, int64(1<<40))
+		assert.Equal(t, requeued.deadline, deadline)
+		assert.Equal(t, requeued.seq, seq)
+		return
+	}
+
+	t.Skip("the item was claimed every time")
+}
+
+func TestPQueuePopOrRecv_no_loss_no_duplicate(t *testing.T) {
+	const (
+		consumers = 8
+		items     = 5000
+		messages  = 2000
+	)
+
+	pqueue := NewPQueue(MAXPQ)
+	ctrl := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var (
+		mu       sync.Mutex
+		popped   = make(map[interface{}]int)
+		received int64
+		wg       sync.WaitGroup
+	)
+
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				item, gotItem, gotCtrl, err := pqueue.PopOrRecv(ctx, ctrl)
+				switch {
+				case err != nil:
+					return
+				case gotItem:
+					mu.Lock()
+					popped[item.Value]++
+					mu.Unlock()
+				case gotCtrl:
+					atomic.AddInt64(&received, 1)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < items; i++ {
+			pqueue.Push(i, i%10)
+		}
+	}()
+
+	for m := 0; m < messages; m++ {
+		ctrl <- struct{}{}
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(popped) == items
+	}, 10*time.Second, time.Millisecond)
+
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, atomic.LoadInt64(&received), int64(messages))
+	assert.Equal(t, pqueue.Size(), 0)
+	for i := 0; i < items; i++ {
+		assert.Equal(t, popped[i], 1)
+	}
+}
+
+// benchmarkPQueueWaitPopBursty pushes bursts of items separated by
+// brief pauses, while a consumer pops them.
+func benchmarkPQueueWaitPopBursty(b *testing.B, pqueue *PQueue) {
+	const burst = 64
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < b.N; i++ {
+			pqueue.WaitPop(context.Background())
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pqueue.Push(i, i)
+		if i%burst == burst-1 {
+			runtime.Gosched()
+		}
+	}
+	<-done
+}
+
+func BenchmarkPQueueWaitPop_bursty(b *testing.B) {
+	benchmarkPQueueWaitPopBursty(b, NewPQueue(MAXPQ))
+}
+
+func BenchmarkPQueueWaitPop_bursty_spin(b *testing.B) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWaitStrategy(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkPQueueWaitPopBursty(b, pqueue)
+}
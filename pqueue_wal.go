@@ -0,0 +1,477 @@
+package lane
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// ErrInvalidWAL is the error returned when recovering from a write-ahead
+// log which isn't one, or whose header is truncated.
+var ErrInvalidWAL = errors.New("lane: invalid write-ahead log")
+
+// WALSyncPolicy tells when the write-ahead log records are written, see
+// WithWALSync.
+type WALSyncPolicy int
+
+const (
+	// SyncEachRecord makes every operation write, and sync, its records
+	// before returning.
+	SyncEachRecord WALSyncPolicy = iota
+	// SyncBatched buffers the records, which are written when the
+	// buffer is full, when the queue is closed, or when FlushWAL is
+	// called.
+	SyncBatched
+)
+
+// walMagic starts the write-ahead logs, followed by their format version,
+// see FormatVersion, and by the queue ordering.
+var walMagic = []byte("LANEWAL")
+
+// The write-ahead log records kinds
+const (
+	walPushed   = 'p'
+	walDeleted  = 'd'
+	walPriority = 'u'
+	walValue    = 'v'
+	walReset    = 'r'
+	walOrder    = 'o'
+)
+
+// walRecordHeaderSize is the size of a record kind, item id, priority,
+// secondary priority, deadline and payload length, which are followed by
+// the payload and a CRC-32 checksum of the record. Version 1 records have
+// no deadline.
+const (
+	walRecordHeaderSize   = 1 + 8 + 8 + 8 + 8 + 4
+	walRecordHeaderSizeV1 = walRecordHeaderSize - 8
+)
+
+// writeAheadLog writes the write-ahead log records. It is guarded by the
+// queue write lock.
+type writeAheadLog struct {
+	w      *bufio.Writer
+	dst    io.Writer
+	encode func(value interface{}) ([]byte, error)
+	policy WALSyncPolicy
+	record []byte
+	dirty  bool
+	err    error
+}
+
+// WithWAL makes the queue record its content into the w write-ahead log,
+// so that it can be recovered after a crash with RecoverFromWAL. Values
+// are encoded by encode.
+//
+// Push records the pushed item before inserting it, and fails if it
+// can't: by default, see WithWALSync, pushed items are on w once Push
+// returns. Removals, such as pops, and priority and value changes are
+// recorded as well. Recording stops on the first error, which Push and
+// FlushWAL return from then on. If w has a Sync() error method, such as
+// os.File, it is called after writing records.
+//
+// Values mutated in place, and repositioned with Fix, are not recorded
+// again. Compact the log from time to time with CompactWAL, so that it
+// doesn't grow forever.
+func WithWAL(w io.Writer, encode func(value interface{}) ([]byte, error)) PQueueOption {
+	return func(pq *PQueue) error {
+		if w == nil || encode == nil {
+			return fmt.Errorf("%w: nil write-ahead log writer or encoder", ErrInvalidOption)
+		}
+
+		policy := SyncEachRecord
+		if pq.wal != nil {
+			policy = pq.wal.policy
+		}
+
+		pq.wal = &writeAheadLog{w: bufio.NewWriter(w), dst: w, encode: encode, policy: policy}
+		return nil
+	}
+}
+
+// WithWALSync sets when the write-ahead log records are written, see
+// WithWAL. It defaults to SyncEachRecord.
+func WithWALSync(policy WALSyncPolicy) PQueueOption {
+	return func(pq *PQueue) error {
+		if policy != SyncEachRecord && policy != SyncBatched {
+			return fmt.Errorf("%w: unknown write-ahead log sync policy %d", ErrInvalidOption, policy)
+		}
+
+		if pq.wal == nil {
+			pq.wal = &writeAheadLog{}
+		}
+
+		pq.wal.policy = policy
+		return nil
+	}
+}
+
+// FlushWAL writes and syncs the buffered write-ahead log records, see
+// WithWAL, and returns the first error recording met, if any.
+func (pq *PQueue) FlushWAL() error {
+	pq.lock()
+	defer pq.unlock()
+
+	return pq.flushWAL()
+}
+
+// CompactWAL writes a snapshot of the queue content into w, which the
+// queue records into from then on, see WithWAL: once CompactWAL returns,
+// the previous log can be discarded. The previous log is kept if the
+// snapshot can't be written.
+func (pq *PQueue) CompactWAL(w io.Writer) error {
+	pq.lock()
+	defer pq.unlock()
+
+	if pq.wal == nil {
+		return pq.newError("compact wal", fmt.Errorf("%w: the queue has no write-ahead log", ErrInvalidOption))
+	}
+
+	pq.mergeStaged()
+
+	compacted := &writeAheadLog{w: bufio.NewWriter(w), dst: w, encode: pq.wal.encode, policy: pq.wal.policy}
+	compacted.header(pq.pqType)
+	for k := 1; k <= pq.elemsCount; k++ {
+		if err := compacted.pushed(pq.items[k]); err != nil {
+			return pq.newError("compact wal", err)
+		}
+	}
+
+	if err := compacted.flush(); err != nil {
+		return pq.newError("compact wal", err)
+	}
+
+	pq.wal = compacted
+
+	return nil
+}
+
+// startWAL writes the write-ahead log header, describing the queue
+// ordering.
+func (pq *PQueue) startWAL() error {
+	l := pq.wal
+	if l == nil {
+		return nil
+	}
+
+	if l.w == nil {
+		return fmt.Errorf("%w: write-ahead log sync policy without write-ahead log", ErrInvalidOption)
+	}
+
+	if pq.buffer != nil {
+		return fmt.Errorf("%w: write buffer and write-ahead log", ErrIncompatibleOptions)
+	}
+
+	l.header(pq.pqType)
+
+	return nil
+}
+
+// flushWAL writes and syncs the buffered write-ahead log records. The
+// caller must hold the write lock.
+func (pq *PQueue) flushWAL() error {
+	if pq.wal == nil {
+		return nil
+	}
+
+	return pq.wal.flush()
+}
+
+// syncWAL writes and syncs the records of the operation when recording
+// each of them. The caller must hold the write lock.
+func (pq *PQueue) syncWAL() error {
+	l := pq.wal
+	if l == nil || l.policy != SyncEachRecord || !l.dirty {
+		return nil
+	}
+
+	return l.flush()
+}
+
+// walPush records the item about to be pushed. The caller must hold the
+// write lock.
+func (pq *PQueue) walPush(item *item) error {
+	if pq.wal == nil {
+		return nil
+	}
+
+	return pq.wal.pushed(item)
+}
+
+// walEncode encodes the values of the items about to be pushed, so that
+// they can be recorded with walPushEncoded once none of them failed. It
+// returns nil payloads when the queue has no write-ahead log. The caller
+// must hold the write lock.
+func (pq *PQueue) walEncode(items []*item) ([][]byte, error) {
+	l := pq.wal
+	if l == nil {
+		return nil, nil
+	}
+
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	payloads := make([][]byte, len(items))
+	for i, item := range items {
+		payload, err := l.encode(item.value)
+		if err != nil {
+			return nil, err
+		}
+		payloads[i] = payload
+	}
+
+	return payloads, nil
+}
+
+// walPushEncoded records the item about to be pushed, whose value was
+// encoded into payload by walEncode. The caller must hold the write lock.
+func (pq *PQueue) walPushEncoded(item *item, payload []byte) {
+	if l := pq.wal; l != nil && l.err == nil {
+		l.write(walPushed, item.seq, item.priority, item.secondary, item.deadline, payload)
+	}
+}
+
+// walDelete records the removal of the item. The caller must hold the
+// write lock.
+func (pq *PQueue) walDelete(item *item) {
+	if l := pq.wal; l != nil && l.err == nil {
+		l.write(walDeleted, item.seq, 0, 0, 0, nil)
+	}
+}
+
+// walPriority records the priority change of the item. The caller must
+// hold the write lock.
+func (pq *PQueue) walPriority(item *item, priority int64) {
+	if l := pq.wal; l != nil && l.err == nil {
+		l.write(walPriority, item.seq, priority, item.secondary, 0, nil)
+	}
+}
+
+// walValue records the value change of the item. The caller must hold
+// the write lock.
+func (pq *PQueue) walValue(item *item, value interface{}) {
+	l := pq.wal
+	if l == nil || l.err != nil {
+		return
+	}
+
+	payload, err := l.encode(value)
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	l.write(walValue, item.seq, 0, 0, 0, payload)
+}
+
+// walReset records the queue was emptied, and its new ordering. The
+// caller must hold the write lock.
+func (pq *PQueue) walReset(pqType PQType) {
+	if l := pq.wal; l != nil && l.err == nil {
+		l.write(walReset, 0, int64(pqType), 0, 0, nil)
+	}
+}
+
+// walOrder records the queue ordering change. The caller must hold the
+// write lock.
+func (pq *PQueue) walOrder(pqType PQType) {
+	if l := pq.wal; l != nil && l.err == nil {
+		l.write(walOrder, 0, int64(pqType), 0, 0, nil)
+	}
+}
+
+func (l *writeAheadLog) header(pqType PQType) {
+	l.record = append(l.record[:0], walMagic...)
+	l.record = append(l.record, formatVersion, byte(pqType))
+
+	if _, err := l.w.Write(l.record); err != nil {
+		l.err = err
+	}
+	l.dirty = true
+}
+
+func (l *writeAheadLog) pushed(item *item) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	payload, err := l.encode(item.value)
+	if err != nil {
+		return err
+	}
+
+	l.write(walPushed, item.seq, item.priority, item.secondary, item.deadline, payload)
+
+	return l.err
+}
+
+func (l *writeAheadLog) write(kind byte, id uint64, priority, secondary, deadline int64, payload []byte) {
+	var header [walRecordHeaderSize]byte
+	header[0] = kind
+	binary.BigEndian.PutUint64(header[1:], id)
+	binary.BigEndian.PutUint64(header[9:], uint64(priority))
+	binary.BigEndian.PutUint64(header[17:], uint64(secondary))
+	binary.BigEndian.PutUint64(header[25:], uint64(deadline))
+	binary.BigEndian.PutUint32(header[33:], uint32(len(payload)))
+
+	l.record = append(l.record[:0], header[:]...)
+	l.record = append(l.record, payload...)
+
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(l.record))
+	l.record = append(l.record, checksum[:]...)
+
+	if _, err := l.w.Write(l.record); err != nil {
+		l.err = err
+	}
+	l.dirty = true
+}
+
+func (l *writeAheadLog) flush() error {
+	if l.err != nil {
+		return l.err
+	}
+
+	if l.err = l.w.Flush(); l.err != nil {
+		return l.err
+	}
+
+	if syncer, ok := l.dst.(interface{ Sync() error }); ok {
+		l.err = syncer.Sync()
+	}
+	l.dirty = false
+
+	return l.err
+}
+
+// walEntry is a live item of a recovered write-ahead log
+type walEntry struct {
+	priority  int64
+	secondary int64
+	deadline  int64
+	payload   []byte
+}
+
+// RecoverFromWAL creates a new priority queue holding the items of the
+// write-ahead log read from r, as recorded by WithWAL, values being
+// decoded by decode. The queue is created with the recorded ordering and
+// the provided options: when they record into a new write-ahead log, the
+// recovered items are recorded into it.
+//
+// Records torn or corrupted by a crash end the recovery: the queue holds
+// the items as recorded up to the last valid record. ErrInvalidWAL is
+// returned if r doesn't start with a write-ahead log header, and
+// ErrUnsupportedVersion if the log format version is neither the current
+// nor the previous one, see FormatVersion.
+func RecoverFromWAL(r io.Reader, decode func(data []byte) (interface{}, error), options ...PQueueOption) (*PQueue, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(walMagic)+2 || string(data[:len(walMagic)]) != string(walMagic) {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidWAL)
+	}
+
+	version := data[len(walMagic)]
+	headerSize := walRecordHeaderSize
+	switch version {
+	case formatVersion:
+	case 1:
+		headerSize = walRecordHeaderSizeV1
+	default:
+		return nil, fmt.Errorf("%w: write-ahead log version %d", ErrUnsupportedVersion, version)
+	}
+
+	pqType := PQType(data[len(walMagic)+1])
+	live := make(map[uint64]*walEntry)
+
+	for data = data[len(walMagic)+2:]; len(data) >= headerSize+4; {
+		kind := data[0]
+		id := binary.BigEndian.Uint64(data[1:])
+		priority := int64(binary.BigEndian.Uint64(data[9:]))
+		secondary := int64(binary.BigEndian.Uint64(data[17:]))
+
+		var deadline int64
+		if version > 1 {
+			deadline = int64(binary.BigEndian.Uint64(data[25:]))
+		}
+		length := uint64(binary.BigEndian.Uint32(data[headerSize-4:]))
+
+		end := uint64(headerSize) + length
+		if uint64(len(data)) < end+4 || crc32.ChecksumIEEE(data[:end]) != binary.BigEndian.Uint32(data[end:]) {
+			break
+		}
+		payload := data[headerSize:end]
+		data = data[end+4:]
+
+		switch kind {
+		case walPushed:
+			live[id] = &walEntry{priority: priority, secondary: secondary, deadline: deadline, payload: payload}
+		case walDeleted:
+			delete(live, id)
+		case walPriority:
+			if entry, ok := live[id]; ok {
+				entry.priority, entry.secondary = priority, secondary
+			}
+		case walValue:
+			if entry, ok := live[id]; ok {
+				entry.payload = payload
+			}
+		case walReset:
+			live = make(map[uint64]*walEntry)
+			pqType = PQType(priority)
+		case walOrder:
+			pqType = PQType(priority)
+		default:
+			return nil, fmt.Errorf("%w: unknown record kind %q", ErrInvalidWAL, kind)
+		}
+	}
+
+	pq, err := NewPQueueWithOptions(pqType, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Items are pushed in their original push order
+	ids := make([]uint64, 0, len(live))
+	for id := range live {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	pq.lock()
+	defer pq.unlock()
+
+	for _, id := range ids {
+		value, err := decode(live[id].payload)
+		if err != nil {
+			return nil, err
+		}
+
+		item := pq.newItem(value, live[id].priority)
+		item.secondary = live[id].secondary
+		item.deadline = live[id].deadline
+		item.seq = id
+		pq.advanceSequence(id)
+		if err := pq.admit(item); err != nil {
+			return nil, err
+		}
+
+		if err := pq.walPush(item); err != nil {
+			return nil, err
+		}
+		pq.insert(item)
+	}
+
+	if err := pq.flushWAL(); err != nil {
+		return nil, err
+	}
+
+	return pq, nil
+}
@@ -0,0 +1,339 @@
+package lane
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeInt(value interface{}) ([]byte, error) {
+	return []byte(strconv.Itoa(value.(int))), nil
+}
+
+func decodeInt(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+// syncBuffer is a bytes.Buffer counting its Sync calls
+type syncBuffer struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (b *syncBuffer) Sync() error {
+	b.syncs++
+	return nil
+}
+
+func TestNewPQueueWithOptions_invalid_wal(t *testing.T) {
+	for _, options := range [][]PQueueOption{
+		{WithWAL(nil, encodeInt)},
+		{WithWAL(io.Discard, nil)},
+		{WithWALSync(SyncBatched)},
+		{WithWAL(io.Discard, encodeInt), WithWALSync(WALSyncPolicy(42))},
+	} {
+		_, err := NewPQueueWithOptions(MAXPQ, options...)
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+	}
+
+	_, err := NewPQueueWithOptions(MAXPQ, WithWAL(io.Discard, encodeInt), WithWriteBuffer(8, 0))
+	assert.True(t, errors.Is(err, ErrIncompatibleOptions))
+}
+
+func TestRecoverFromWAL_round_trip(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder(), WithMaxItems(40), WithOverflowPolicy(EvictWhenFull), WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+
+	// Pushes rejected by a full queue are not recorded
+	for i := 0; i < 60; i++ {
+		pqueue.Push(i, i%7+1)
+	}
+	for i := 0; i < 5; i++ {
+		pqueue.Pop()
+	}
+	pqueue.PopWorst()
+	pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+		return value.(int)%5 == 0
+	})
+	pqueue.UpdatePriorities(func(value interface{}, priority int) int {
+		return priority + value.(int)%3
+	})
+	pqueue.MapValues(func(value interface{}) interface{} {
+		return value.(int) * 10
+	})
+	pqueue.Edit(func(c *Cursor) {
+		for c.Next() {
+			switch c.Value().(int) % 4 {
+			case 0:
+				c.Remove()
+			case 1:
+				c.SetPriority(c.Priority() * 2)
+			}
+		}
+	})
+
+	// Items handed over to blocked consumers are recorded as popped
+	done := make(chan struct{})
+	go func() {
+		pqueue.SetPriorityFloor(100)
+		pqueue.WaitPop(context.Background())
+		close(done)
+	}()
+	waitForWaiters(t, pqueue, 1)
+	pqueue.Push(1000, 100)
+	<-done
+	pqueue.ClearPriorityFloor()
+
+	recovered, err := RecoverFromWAL(bytes.NewReader(log.Bytes()), decodeInt, WithStableOrder())
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.pqType, MAXPQ)
+	assert.NotEqual(t, recovered.Size(), 0)
+	assert.Equal(t, recovered.Drain(), pqueue.Drain())
+}
+
+func TestRecoverFromWAL_reset(t *testing.T) {
+	var log bytes.Buffer
+	encode := func(value interface{}) ([]byte, error) {
+		return []byte(fmt.Sprint(value)), nil
+	}
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(&log, encode))
+	assert.Nil(t, err)
+	pqueue.Push(1, 1)
+
+	// JSON numbers are decoded as float64 values
+	assert.Nil(t, pqueue.UnmarshalJSON([]byte(`{"ordering":"min","items":[{"value":2,"priority":2},{"value":3,"priority":3}]}`)))
+
+	recovered, err := RecoverFromWAL(bytes.NewReader(log.Bytes()), func(data []byte) (interface{}, error) {
+		return strconv.ParseFloat(string(data), 64)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.pqType, MINPQ)
+	assert.Equal(t, recovered.Drain(), []Item{{Value: 2.0, Priority: 2}, {Value: 3.0, Priority: 3}})
+}
+
+func TestRecoverFromWAL_truncated_at_every_offset(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithStableOrder(), WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+	assert.Nil(t, pqueue.FlushWAL())
+
+	// The queue content once each record was written
+	type state struct {
+		end   int
+		items []Item
+	}
+	states := []state{{log.Len(), []Item{}}}
+
+	for i := 0; i < 30; i++ {
+		if i%3 == 2 {
+			pqueue.Pop()
+		} else {
+			assert.Nil(t, pqueue.Push(i*100, (i*7)%5))
+		}
+		states = append(states, state{log.Len(), pqueue.Clone().Drain()})
+	}
+
+	data := log.Bytes()
+	for offset := 0; offset <= len(data); offset++ {
+		recovered, err := RecoverFromWAL(bytes.NewReader(data[:offset]), decodeInt, WithStableOrder())
+		if offset < states[0].end {
+			assert.True(t, errors.Is(err, ErrInvalidWAL))
+			continue
+		}
+		assert.Nil(t, err)
+
+		expected := states[0].items
+		for _, s := range states {
+			if s.end <= offset {
+				expected = s.items
+			}
+		}
+
+		drained := recovered.Drain()
+		if drained == nil {
+			drained = []Item{}
+		}
+		assert.Equal(t, drained, expected, "truncated at %d", offset)
+	}
+}
+
+func TestRecoverFromWAL_corrupted_record(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+
+	pqueue.Push(1, 1)
+	end := log.Len()
+	pqueue.Push(2, 2)
+	pqueue.Push(3, 3)
+
+	data := append([]byte(nil), log.Bytes()...)
+	data[end+walRecordHeaderSize] ^= 0xff
+
+	recovered, err := RecoverFromWAL(bytes.NewReader(data), decodeInt)
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.Drain(), []Item{{Value: 1, Priority: 1}})
+
+	_, err = RecoverFromWAL(bytes.NewReader([]byte("not a log")), decodeInt)
+	assert.True(t, errors.Is(err, ErrInvalidWAL))
+}
+
+func TestPQueueWAL_sync_policies(t *testing.T) {
+	var each syncBuffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(&each, encodeInt))
+	assert.Nil(t, err)
+
+	pqueue.Push(1, 1)
+	written := each.Len()
+	assert.NotEqual(t, written, 0)
+	pqueue.Pop()
+	assert.True(t, each.Len() > written)
+	assert.Equal(t, each.syncs, 2)
+
+	var batched syncBuffer
+	pqueue, err = NewPQueueWithOptions(MAXPQ, WithWALSync(SyncBatched), WithWAL(&batched, encodeInt))
+	assert.Nil(t, err)
+
+	pqueue.Push(1, 1)
+	pqueue.Pop()
+	assert.Equal(t, batched.Len(), 0)
+
+	assert.Nil(t, pqueue.FlushWAL())
+	assert.NotEqual(t, batched.Len(), 0)
+	assert.Equal(t, batched.syncs, 1)
+
+	pqueue.Push(2, 2)
+	assert.Nil(t, pqueue.Close())
+	assert.Equal(t, batched.syncs, 2)
+
+	recovered, err := RecoverFromWAL(bytes.NewReader(batched.Bytes()), decodeInt)
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.Drain(), []Item{{Value: 2, Priority: 2}})
+}
+
+func TestPQueueWAL_write_errors_fail_pushes(t *testing.T) {
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(failingWriter{}, encodeInt))
+	assert.Nil(t, err)
+
+	assert.EqualError(t, pqueue.Push(1, 1), "lane: push on max priority queue (size 0): disk full")
+	assert.Equal(t, pqueue.Size(), 0)
+	assert.EqualError(t, pqueue.FlushWAL(), "disk full")
+
+	failing := errors.New("unencodable")
+	pqueue, err = NewPQueueWithOptions(MAXPQ, WithWAL(io.Discard, func(interface{}) ([]byte, error) {
+		return nil, failing
+	}))
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(pqueue.Push(1, 1), failing))
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueCompactWAL(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder(), WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		pqueue.Push(i, i%10)
+		if i%4 != 0 {
+			pqueue.Pop()
+		}
+	}
+
+	var compacted bytes.Buffer
+	assert.Nil(t, pqueue.CompactWAL(&compacted))
+	assert.True(t, compacted.Len() < log.Len()/4)
+
+	// The queue records into the compacted log from then on
+	logged := log.Len()
+	pqueue.Push(1000, 5)
+	assert.Equal(t, log.Len(), logged)
+
+	recovered, err := RecoverFromWAL(bytes.NewReader(compacted.Bytes()), decodeInt, WithStableOrder())
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.Drain(), pqueue.Clone().Drain())
+
+	err = NewPQueue(MAXPQ).CompactWAL(&compacted)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+}
+
+func TestRecoverFromWAL_into_new_log(t *testing.T) {
+	var log, next bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MINPQ, WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+
+	recovered, err := RecoverFromWAL(&log, decodeInt, WithWAL(&next, encodeInt))
+	assert.Nil(t, err)
+	recovered.Pop()
+
+	again, err := RecoverFromWAL(&next, decodeInt)
+	assert.Nil(t, err)
+	assert.Equal(t, again.Drain(), []Item{{Value: 2, Priority: 2}})
+}
+
+func TestRecoverFromWAL_keeps_stable_order(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithStableOrder(), WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, 1)
+	}
+	for i := 0; i < 3; i++ {
+		pqueue.Pop()
+	}
+
+	var next bytes.Buffer
+	recovered, err := RecoverFromWAL(&log, decodeInt, WithStableOrder(), WithWAL(&next, encodeInt))
+	assert.Nil(t, err)
+	for i := 10; i < 13; i++ {
+		recovered.Push(i, 1)
+	}
+	recovered.Pop()
+
+	// Recovering again keeps the pre and post recovery items order
+	again, err := RecoverFromWAL(&next, decodeInt, WithStableOrder())
+	assert.Nil(t, err)
+	again.Push(13, 1)
+
+	popped := []interface{}{}
+	for again.Size() > 0 {
+		value, _ := again.Pop()
+		popped = append(popped, value)
+	}
+	assert.Equal(t, popped, []interface{}{4, 5, 6, 7, 8, 9, 10, 11, 12, 13})
+}
+
+func TestPQueueWAL_served_waiter_records_one_delete(t *testing.T) {
+	var log bytes.Buffer
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWAL(&log, encodeInt))
+	assert.Nil(t, err)
+
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := pqueue.WaitPop(context.Background())
+		done <- value
+	}()
+	waitForWaiters(t, pqueue, 1)
+
+	// Items pushed during a batch are served once it ends
+	pqueue.BeginBatch()
+	pqueue.Push(1, 1)
+	written := log.Len()
+	pqueue.EndBatch()
+	assert.Equal(t, <-done, 1)
+	assert.Equal(t, log.Len()-written, walRecordHeaderSize+4)
+
+	recovered, err := RecoverFromWAL(bytes.NewReader(log.Bytes()), decodeInt)
+	assert.Nil(t, err)
+	assert.Equal(t, recovered.Size(), 0)
+}
@@ -0,0 +1,120 @@
+package lane
+
+import (
+	"fmt"
+	"sync"
+)
+
+// watermarks tracks the queue size crossing its high and low watermarks,
+// see WithWatermarks.
+type watermarks struct {
+	high   int
+	low    int
+	onHigh func(size int)
+	onLow  func(size int)
+
+	// above is set once the size reached the high watermark, until it
+	// falls back to the low one. It is guarded by the queue lock.
+	above bool
+
+	// pending holds the crossings whose callbacks are yet to be called,
+	// in crossing order. delivering is set while a goroutine calls them.
+	mu         sync.Mutex
+	pending    []watermarkCrossing
+	delivering bool
+}
+
+type watermarkCrossing struct {
+	up   bool
+	size int
+}
+
+// WithWatermarks makes the queue call onHigh when its size reaches the
+// high watermark, and then onLow when it falls back to the low one, and
+// so on. The callbacks are called once per crossing: the size moving
+// around a watermark doesn't call them again until the other one is
+// crossed. Either of them may be nil.
+//
+// The callbacks are called with the queue size right after the crossing,
+// once the queue lock is released, so that they can call the queue
+// methods. They are called one at a time, in crossing order, possibly by
+// another goroutine than the one whose operation crossed the watermark.
+func WithWatermarks(high, low int, onHigh, onLow func(size int)) PQueueOption {
+	return func(pq *PQueue) error {
+		if high < 1 {
+			return fmt.Errorf("%w: high watermark must be positive, got %d", ErrInvalidOption, high)
+		}
+
+		if low < 0 || low >= high {
+			return fmt.Errorf("%w: low watermark must be between 0 and %d, got %d", ErrInvalidOption, high-1, low)
+		}
+
+		pq.watermarks = &watermarks{high: high, low: low, onHigh: onHigh, onLow: onLow}
+		return nil
+	}
+}
+
+// checkWatermarks records the watermark the queue size just crossed, if
+// any. The caller must hold the write lock.
+func (pq *PQueue) checkWatermarks() {
+	w := pq.watermarks
+	if w == nil {
+		return
+	}
+
+	switch {
+	case !w.above && pq.elemsCount >= w.high:
+		w.above = true
+	case w.above && pq.elemsCount <= w.low:
+		w.above = false
+	default:
+		return
+	}
+
+	w.mu.Lock()
+	w.pending = append(w.pending, watermarkCrossing{up: w.above, size: pq.elemsCount})
+	w.mu.Unlock()
+}
+
+// notifyWatermarks calls the callbacks of the recorded crossings, unless
+// another goroutine is already calling them. It must be called without
+// holding the lock.
+func (pq *PQueue) notifyWatermarks() {
+	w := pq.watermarks
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.delivering {
+		return
+	}
+
+	w.delivering = true
+	defer func() { w.delivering = false }()
+
+	for len(w.pending) > 0 {
+		crossing := w.pending[0]
+		w.pending = w.pending[1:]
+
+		callback := w.onLow
+		if crossing.up {
+			callback = w.onHigh
+		}
+
+		if callback != nil {
+			w.call(callback, crossing.size)
+		}
+	}
+}
+
+// call calls the callback without holding the watermarks lock. The
+// caller must hold it.
+func (w *watermarks) call(callback func(size int), size int) {
+	w.mu.Unlock()
+	defer w.mu.Lock()
+
+	callback(size)
+}
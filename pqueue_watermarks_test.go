@@ -0,0 +1,187 @@
+package lane
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// watermarkRecorder records the watermark callbacks calls
+type watermarkRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *watermarkRecorder) onHigh(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, fmt.Sprintf("high %d", size))
+}
+
+func (r *watermarkRecorder) onLow(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, fmt.Sprintf("low %d", size))
+}
+
+func (r *watermarkRecorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.calls...)
+}
+
+func newWatermarkPQueue(t *testing.T, high, low int) (*PQueue, *watermarkRecorder) {
+	recorder := &watermarkRecorder{}
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWatermarks(high, low, recorder.onHigh, recorder.onLow))
+	assert.Nil(t, err)
+
+	return pqueue, recorder
+}
+
+func TestNewPQueueWithOptions_invalid_watermarks(t *testing.T) {
+	for _, watermarks := range [][2]int{{0, 0}, {5, -1}, {5, 5}, {5, 6}} {
+		pqueue, err := NewPQueueWithOptions(MAXPQ, WithWatermarks(watermarks[0], watermarks[1], nil, nil))
+		assert.Nil(t, pqueue)
+		assert.True(t, errors.Is(err, ErrInvalidOption), "watermarks %v", watermarks)
+	}
+}
+
+func TestPQueueWatermarks_hysteresis(t *testing.T) {
+	pqueue, recorder := newWatermarkPQueue(t, 8, 3)
+
+	for i := 0; i < 7; i++ {
+		pqueue.Push(i, i)
+	}
+	assert.Empty(t, recorder.Calls())
+
+	pqueue.Push(7, 7)
+	assert.Equal(t, recorder.Calls(), []string{"high 8"})
+
+	// Moving around the high watermark doesn't call onHigh again
+	for i := 0; i < 10; i++ {
+		pqueue.Pop()
+		pqueue.Push(i, i)
+	}
+	pqueue.Push(8, 8)
+	assert.Equal(t, recorder.Calls(), []string{"high 8"})
+
+	for pqueue.Size() > 4 {
+		pqueue.Pop()
+	}
+	assert.Equal(t, recorder.Calls(), []string{"high 8"})
+
+	pqueue.Pop()
+	assert.Equal(t, recorder.Calls(), []string{"high 8", "low 3"})
+
+	// Neither does moving around the low watermark call onLow again
+	for i := 0; i < 10; i++ {
+		pqueue.Push(i, i)
+		pqueue.Pop()
+	}
+	pqueue.Drain()
+	assert.Equal(t, recorder.Calls(), []string{"high 8", "low 3"})
+}
+
+func TestPQueueWatermarks_bulk_operations(t *testing.T) {
+	pqueue, recorder := newWatermarkPQueue(t, 8, 3)
+
+	other := NewPQueue(MAXPQ)
+	for i := 0; i < 10; i++ {
+		other.Push(i, i)
+	}
+
+	assert.Nil(t, pqueue.Merge(other))
+	assert.Equal(t, recorder.Calls(), []string{"high 8"})
+
+	pqueue.RemoveWhere(func(value interface{}, priority int) bool {
+		return priority%2 == 0
+	})
+	assert.Equal(t, recorder.Calls(), []string{"high 8"})
+
+	pqueue.Drain()
+	assert.Equal(t, recorder.Calls(), []string{"high 8", "low 3"})
+}
+
+func TestPQueueWatermarks_callbacks_may_use_the_queue(t *testing.T) {
+	var pqueue *PQueue
+	var sizes []int
+
+	pqueue, err := NewPQueueWithOptions(MAXPQ, WithWatermarks(2, 0, func(size int) {
+		sizes = append(sizes, pqueue.Size())
+
+		// Shedding load from the callback crosses the low watermark
+		pqueue.Drain()
+	}, func(size int) {
+		sizes = append(sizes, size)
+	}))
+	assert.Nil(t, err)
+
+	pqueue.Push(1, 1)
+	pqueue.Push(2, 2)
+	assert.Equal(t, sizes, []int{2, 0})
+	assert.Equal(t, pqueue.Size(), 0)
+}
+
+func TestPQueueWatermarks_concurrent_ramps(t *testing.T) {
+	const (
+		high    = 50
+		low     = 10
+		workers = 8
+		ramps   = 20
+	)
+
+	pqueue, recorder := newWatermarkPQueue(t, high, low)
+
+	var expected []string
+	for ramp := 0; ramp < ramps; ramp++ {
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 2*high/workers; i++ {
+					pqueue.Push(i, i)
+					runtime.Gosched()
+				}
+			}()
+		}
+		wg.Wait()
+
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 2*high/workers; i++ {
+					pqueue.Pop()
+					runtime.Gosched()
+				}
+			}()
+		}
+		wg.Wait()
+
+		expected = append(expected, fmt.Sprintf("high %d", high), fmt.Sprintf("low %d", low))
+		assert.Equal(t, recorder.Calls(), expected, "ramp %d", ramp)
+	}
+}
+
+func TestPQueueWatermarks_clone(t *testing.T) {
+	pqueue, recorder := newWatermarkPQueue(t, 4, 1)
+	for i := 0; i < 4; i++ {
+		pqueue.Push(i, i)
+	}
+
+	clone := pqueue.Clone()
+	clone.Push(4, 4)
+	assert.Equal(t, recorder.Calls(), []string{"high 4"})
+
+	clone.Drain()
+	assert.Equal(t, recorder.Calls(), []string{"high 4", "low 1"})
+}
@@ -1,5 +1,7 @@
 package lane
 
+import "container/list"
+
 // Queue is a FIFO (First in first out) data structure implementation.
 // It is based on a deque container and focuses its API on core
 // functionalities: Enqueue, Dequeue, Head, Size, Empty. Every operations time complexity
@@ -32,3 +34,45 @@ func (q *Queue) Dequeue() interface{} {
 func (q *Queue) Head() interface{} {
 	return q.Last()
 }
+
+// EnqueueBatch adds the items at the back of the queue holding the lock
+// once, as successive Enqueue calls would.
+func (q *Queue) EnqueueBatch(items []interface{}) {
+	q.PrependBatch(items)
+}
+
+// DequeueN removes up to n items from the front of the queue holding
+// the lock once, and returns them in dequeue order.
+func (q *Queue) DequeueN(n int) []interface{} {
+	return q.PopN(n)
+}
+
+// Drain removes every item of the queue at once, and returns them in
+// dequeue order.
+func (q *Queue) Drain() []interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.removeN(q.container.Len(), (*list.List).Back)
+}
+
+// ToSlice returns a snapshot of the queue items, in dequeue order
+func (q *Queue) ToSlice() []interface{} {
+	items := q.Deque.ToSlice()
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+
+	return items
+}
+
+// Iter calls fn with the items of a snapshot of the queue, in dequeue
+// order, until fn returns false. As fn is called once the queue is
+// unlocked, it may call the queue methods.
+func (q *Queue) Iter(fn func(item interface{}) bool) {
+	for _, item := range q.ToSlice() {
+		if !fn(item) {
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package lane
+
+import "context"
+
+// BoundedQueue is a FIFO (First in first out) data structure
+// implementation holding up to a fixed capacity of items. It is based
+// on a bounded deque container, whose blocking operations let
+// producers wait for room and consumers wait for items: EnqueueWait and
+// DequeueWait. Every operations time complexity is O(1).
+//
+// As it is implemented using a BoundedDeque container, every operations
+// over an instiated BoundedQueue are synchronized and safe for
+// concurrent usage.
+type BoundedQueue struct {
+	*BoundedDeque
+}
+
+// NewBoundedQueue creates a new bounded queue holding up to capacity
+// items. It panics if capacity is not positive.
+func NewBoundedQueue(capacity int) *BoundedQueue {
+	return &BoundedQueue{
+		BoundedDeque: NewBoundedDeque(capacity),
+	}
+}
+
+// Enqueue adds an item at the back of the queue, and reports whether it
+// had room for it.
+func (q *BoundedQueue) Enqueue(item interface{}) bool {
+	return q.Prepend(item)
+}
+
+// EnqueueWait adds an item at the back of the queue, blocking until it
+// has room for it or the context is done.
+func (q *BoundedQueue) EnqueueWait(ctx context.Context, item interface{}) error {
+	return q.PrependWait(ctx, item)
+}
+
+// Dequeue removes and returns the front queue item
+func (q *BoundedQueue) Dequeue() interface{} {
+	return q.Pop()
+}
+
+// DequeueWait removes and returns the front queue item, blocking until
+// there is one or the context is done.
+func (q *BoundedQueue) DequeueWait(ctx context.Context) (interface{}, error) {
+	return q.PopWait(ctx)
+}
+
+// Head returns the front queue item
+func (q *BoundedQueue) Head() interface{} {
+	return q.Last()
+}
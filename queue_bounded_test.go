@@ -0,0 +1,53 @@
+package lane
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundedQueueEnqueue_full(t *testing.T) {
+	queue := NewBoundedQueue(2)
+
+	assert.True(t, queue.Enqueue("1"))
+	assert.True(t, queue.Enqueue("2"))
+	assert.False(t, queue.Enqueue("3"))
+
+	assert.Equal(t, queue.Head(), "1")
+	assert.Equal(t, queue.Dequeue(), "1")
+	assert.Equal(t, queue.Dequeue(), "2")
+	assert.Nil(t, queue.Dequeue())
+}
+
+func TestBoundedQueueWait_fifo_order(t *testing.T) {
+	queue := NewBoundedQueue(2)
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, queue.EnqueueWait(context.Background(), i))
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		value, err := queue.DequeueWait(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, value, i)
+	}
+}
+
+func TestBoundedQueueWait_context_done(t *testing.T) {
+	queue := NewBoundedQueue(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := queue.DequeueWait(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	queue.Enqueue("1")
+	assert.True(t, errors.Is(queue.EnqueueWait(ctx, "2"), context.DeadlineExceeded))
+	assert.Equal(t, queue.Head(), "1")
+}
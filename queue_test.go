@@ -62,3 +62,27 @@ func TestQueueHead_empty(t *testing.T) {
 	assert.Equal(t, item, nil)
 	assert.Equal(t, queue.Size(), 0)
 }
+
+func TestQueueEnqueueBatch(t *testing.T) {
+	queue := NewQueue()
+	queue.Enqueue("0")
+	queue.EnqueueBatch([]interface{}{"1", "2", "3"})
+
+	assert.Equal(t, queue.ToSlice(), []interface{}{"0", "1", "2", "3"})
+	assert.Equal(t, queue.DequeueN(2), []interface{}{"0", "1"})
+	assert.Equal(t, queue.Drain(), []interface{}{"2", "3"})
+	assert.True(t, queue.Empty())
+}
+
+func TestQueueIter(t *testing.T) {
+	queue := NewQueue()
+	queue.EnqueueBatch([]interface{}{1, 2, 3})
+
+	var items []interface{}
+	queue.Iter(func(item interface{}) bool {
+		items = append(items, item)
+		return item.(int) < 2
+	})
+	assert.Equal(t, items, []interface{}{1, 2})
+	assert.Equal(t, queue.Size(), 3)
+}
@@ -0,0 +1,8 @@
+//go:build !lanesched
+
+package lane
+
+// schedPoint marks a point where the deterministic scheduler of the
+// lanesched tests may interleave goroutines. It is a no-op unless the
+// lanesched build tag is set.
+func schedPoint(name string) {}
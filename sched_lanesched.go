@@ -0,0 +1,30 @@
+//go:build lanesched
+
+package lane
+
+import "sync"
+
+var (
+	schedMu   sync.RWMutex
+	schedHook func(name string)
+)
+
+// schedPoint marks a point where the deterministic scheduler of the
+// lanesched tests may interleave goroutines: it calls the scheduler
+// hook, if any.
+func schedPoint(name string) {
+	schedMu.RLock()
+	hook := schedHook
+	schedMu.RUnlock()
+
+	if hook != nil {
+		hook(name)
+	}
+}
+
+// setSchedHook sets the function schedPoint calls, nil disabling it.
+func setSchedHook(hook func(name string)) {
+	schedMu.Lock()
+	schedHook = hook
+	schedMu.Unlock()
+}
@@ -0,0 +1,325 @@
+//go:build lanesched
+
+package lane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type threadState int
+
+const (
+	threadRunning threadState = iota
+	threadPaused
+	threadBlocked
+	threadDone
+)
+
+type schedEvent struct {
+	thread int
+	state  threadState
+	wake   bool
+	point  string
+}
+
+// explorer runs goroutines, the threads, one at a time: every thread
+// pauses at each schedPoint, and the explorer resumes one of the paused
+// threads, drawn from a seeded generator, once every other one paused,
+// finished, or blocked in WaitPop. Schedules are thus reproducible from
+// their seed.
+//
+// The scheduling points are all outside of the queue critical sections,
+// so that a resumed thread never waits for a paused thread lock.
+type explorer struct {
+	random  *rand.Rand
+	mu      sync.Mutex
+	ids     map[int64]int
+	resume  []chan struct{}
+	events  chan schedEvent
+	states  []threadState
+	wakes   int
+	history []string
+}
+
+// runSchedule runs the threads under the schedule drawn from seed, and
+// returns the schedule history, or an error if the threads got stuck.
+func runSchedule(seed int64, threads ...func()) ([]string, error) {
+	e := &explorer{
+		random: rand.New(rand.NewSource(seed)),
+		ids:    make(map[int64]int),
+		events: make(chan schedEvent),
+		states: make([]threadState, len(threads)),
+	}
+
+	setSchedHook(e.point)
+	defer setSchedHook(nil)
+
+	for i, thread := range threads {
+		e.resume = append(e.resume, make(chan struct{}))
+
+		go func(i int, thread func()) {
+			e.mu.Lock()
+			e.ids[goroutineID()] = i
+			e.mu.Unlock()
+
+			schedPoint("start")
+			thread()
+
+			e.events <- schedEvent{thread: i, state: threadDone}
+		}(i, thread)
+	}
+
+	for {
+		if err := e.settle(); err != nil {
+			return e.history, err
+		}
+
+		var paused []int
+		blocked := 0
+		for i, state := range e.states {
+			switch state {
+			case threadPaused:
+				paused = append(paused, i)
+			case threadBlocked:
+				blocked++
+			}
+		}
+
+		if len(paused) == 0 {
+			if blocked > 0 {
+				return e.history, fmt.Errorf("%d threads blocked forever", blocked)
+			}
+
+			return e.history, nil
+		}
+
+		next := paused[e.random.Intn(len(paused))]
+		e.states[next] = threadRunning
+		e.resume[next] <- struct{}{}
+	}
+}
+
+// settle processes the threads events until no thread is running or
+// about to be woken up.
+func (e *explorer) settle() error {
+	for {
+		running := 0
+		for _, state := range e.states {
+			if state == threadRunning {
+				running++
+			}
+		}
+
+		if running == 0 && e.wakes == 0 {
+			return nil
+		}
+
+		select {
+		case event := <-e.events:
+			if event.wake {
+				e.wakes++
+				continue
+			}
+
+			if e.states[event.thread] == threadBlocked {
+				e.wakes--
+			}
+
+			e.states[event.thread] = event.state
+			if event.point != "" {
+				e.history = append(e.history, fmt.Sprintf("%d:%s", event.thread, event.point))
+			}
+		case <-time.After(5 * time.Second):
+			return errors.New("threads stuck outside of scheduling points")
+		}
+	}
+}
+
+// point is the schedPoint hook
+func (e *explorer) point(name string) {
+	e.mu.Lock()
+	thread, ok := e.ids[goroutineID()]
+	e.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	switch name {
+	case "wait.wake":
+		e.events <- schedEvent{thread: thread, wake: true}
+	case "wait.block":
+		e.events <- schedEvent{thread: thread, state: threadBlocked, point: name}
+	default:
+		e.events <- schedEvent{thread: thread, state: threadPaused, point: name}
+		<-e.resume[thread]
+	}
+}
+
+// explore runs the scenario under the schedules drawn from seeds 0 to
+// n-1. The scenario returns the threads to run on fresh state, and a
+// check to call once they are done. explore returns the seeds of the
+// schedules whose check failed.
+func explore(t *testing.T, n int, scenario func() ([]func(), func() error)) []int64 {
+	var failed []int64
+
+	for seed := int64(0); seed < int64(n); seed++ {
+		threads, check := scenario()
+
+		history, err := runSchedule(seed, threads...)
+		if err == nil {
+			err = check()
+		}
+
+		if err != nil {
+			t.Logf("seed %d: %s: %v", seed, strings.Join(history, " "), err)
+			failed = append(failed, seed)
+		}
+	}
+
+	return failed
+}
+
+// racyPop is Pop as it was before its emptiness check moved under the
+// lock: the queue may be emptied between the check and the removal.
+func racyPop(pq *PQueue) (value interface{}, priority int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	if pq.Size() < 1 {
+		return nil, 0, nil
+	}
+	schedPoint("pop.checked")
+
+	pq.lock()
+	defer pq.Unlock()
+
+	head := pq.removeAt(1)
+
+	return head.value, int(head.priority), nil
+}
+
+// popScenario has two threads pop a single item
+func popScenario(pop func(pq *PQueue) (interface{}, int, error)) func() ([]func(), func() error) {
+	return func() ([]func(), func() error) {
+		pqueue := NewPQueue(MAXPQ)
+		pqueue.Push("a", 1)
+
+		var mu sync.Mutex
+		var popped []interface{}
+		var errs []error
+
+		popper := func() {
+			value, _, err := pop(pqueue)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, err)
+			} else if value != nil {
+				popped = append(popped, value)
+			}
+		}
+
+		check := func() error {
+			if len(errs) > 0 {
+				return errs[0]
+			}
+
+			if len(popped) != 1 {
+				return fmt.Errorf("popped %v", popped)
+			}
+
+			return nil
+		}
+
+		return []func(){popper, popper}, check
+	}
+}
+
+func TestSched_reproduces_racy_pop(t *testing.T) {
+	failed := explore(t, 50, popScenario(racyPop))
+	assert.NotEmpty(t, failed)
+
+	// Schedules are reproducible from their seed
+	assert.Equal(t, explore(t, 50, popScenario(racyPop)), failed)
+}
+
+func TestSched_pop(t *testing.T) {
+	failed := explore(t, 200, popScenario(func(pq *PQueue) (interface{}, int, error) {
+		value, priority := pq.Pop()
+		return value, priority, nil
+	}))
+	assert.Empty(t, failed)
+}
+
+func TestSched_push_wait_pop_close(t *testing.T) {
+	failed := explore(t, 300, func() ([]func(), func() error) {
+		pqueue := NewPQueue(MAXPQ)
+
+		var mu sync.Mutex
+		pushed := make(map[interface{}]int)
+		popped := make(map[interface{}]int)
+
+		consumer := func() {
+			for {
+				value, _, err := pqueue.WaitPop(context.Background())
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+
+				mu.Lock()
+				popped[value]++
+				mu.Unlock()
+			}
+		}
+
+		producer := func() {
+			for i := 0; i < 3; i++ {
+				if pqueue.Push(i, i) == nil {
+					mu.Lock()
+					pushed[i]++
+					mu.Unlock()
+				}
+			}
+
+			pqueue.Pop()
+		}
+
+		closer := func() {
+			pqueue.Head()
+			pqueue.Close()
+		}
+
+		check := func() error {
+			// The producer Pop may have taken one item
+			for value, count := range popped {
+				if count != 1 || pushed[value] != 1 {
+					return fmt.Errorf("pushed %v, popped %v", pushed, popped)
+				}
+			}
+
+			if len(pushed)-len(popped) > 1 || pqueue.Size() != 0 {
+				return fmt.Errorf("pushed %v, popped %v, left %d", pushed, popped, pqueue.Size())
+			}
+
+			return nil
+		}
+
+		return []func(){consumer, consumer, producer, closer}, check
+	})
+	assert.Empty(t, failed)
+}
@@ -32,3 +32,15 @@ func (s *Stack) Pop() interface{} {
 func (s *Stack) Head() interface{} {
 	return s.First()
 }
+
+// PushBatch adds the items on the top of the Stack holding the lock
+// once, as successive Push calls would: the last item ends up on top.
+func (s *Stack) PushBatch(items []interface{}) {
+	s.PrependBatch(items)
+}
+
+// PopN removes up to n items from the top of the Stack holding the lock
+// once, and returns them in pop order.
+func (s *Stack) PopN(n int) []interface{} {
+	return s.ShiftN(n)
+}
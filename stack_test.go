@@ -77,3 +77,15 @@ func TestStackEmpty_empty_queue(t *testing.T) {
 	stack := NewStack()
 	assert.True(t, stack.Empty())
 }
+
+func TestStackPushBatch(t *testing.T) {
+	stack := NewStack()
+	stack.Push("0")
+	stack.PushBatch([]interface{}{"1", "2", "3"})
+
+	assert.Equal(t, stack.Head(), "3")
+	assert.Equal(t, stack.ToSlice(), []interface{}{"3", "2", "1", "0"})
+	assert.Equal(t, stack.PopN(2), []interface{}{"3", "2"})
+	assert.Equal(t, stack.Drain(), []interface{}{"1", "0"})
+	assert.True(t, stack.Empty())
+}
@@ -0,0 +1,13 @@
+// Package copylocks copies a PQueue by value on purpose: go vet's
+// copylocks checker must report it.
+package copylocks
+
+import "github.com/oleiade/lane"
+
+func size(pqueue lane.PQueue) int {
+	return pqueue.Size()
+}
+
+func Copy() int {
+	return size(*lane.NewPQueue(lane.MAXPQ))
+}